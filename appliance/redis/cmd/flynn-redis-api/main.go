@@ -250,7 +250,7 @@ func (h *Handler) servePostCluster(w http.ResponseWriter, req *http.Request, _ h
 	h.Logger.Info("deploying app release", "release.ID", release.ID)
 	timeoutCh := make(chan struct{})
 	time.AfterFunc(5*time.Minute, func() { close(timeoutCh) })
-	if err := h.ControllerClient.DeployAppRelease(app.ID, release.ID, timeoutCh); err != nil {
+	if _, err := h.ControllerClient.DeployAppRelease(app.ID, release.ID, timeoutCh); err != nil {
 		h.Logger.Error("error deploying release", "err", err)
 		httphelper.Error(w, err)
 		return
@@ -108,5 +108,6 @@ func (a *DeployAppAction) Run(s *State) error {
 
 	timeoutCh := make(chan struct{})
 	time.AfterFunc(5*time.Minute, func() { close(timeoutCh) })
-	return client.DeployAppRelease(a.App.ID, a.Release.ID, timeoutCh)
+	_, err = client.DeployAppRelease(a.App.ID, a.Release.ID, timeoutCh)
+	return err
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// actionLogger reports the actions taken by a command, either as human
+// readable log messages or, when json is set, as JSON lines on stdout so
+// that they can be parsed by log aggregators. When quiet is set, nothing is
+// reported and only the ID passed to Result is printed.
+type actionLogger struct {
+	json  bool
+	quiet bool
+	app   string
+}
+
+type actionLogEntry struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	App        string    `json:"app"`
+	ReleaseID  string    `json:"release_id,omitempty"`
+	ArtifactID string    `json:"artifact_id,omitempty"`
+	Duration   float64   `json:"duration,omitempty"`
+}
+
+// Log reports action for the given release and artifact IDs (either may be
+// empty). If started is not zero, the duration since then is included in
+// JSON entries. In human readable mode, format and v are passed to log.Printf
+// unless format is empty.
+func (l *actionLogger) Log(action, releaseID, artifactID string, started time.Time, format string, v ...interface{}) {
+	if l.quiet {
+		return
+	}
+	if !l.json {
+		if format != "" {
+			log.Printf(format, v...)
+		}
+		return
+	}
+	entry := &actionLogEntry{
+		Time:       time.Now().UTC(),
+		Action:     action,
+		App:        l.app,
+		ReleaseID:  releaseID,
+		ArtifactID: artifactID,
+	}
+	if !started.IsZero() {
+		entry.Duration = time.Since(started).Seconds()
+	}
+	json.NewEncoder(os.Stdout).Encode(entry)
+}
+
+// Result prints the ID of the release resulting from the command on stdout
+// when quiet is set, so that it can be captured by scripts.
+func (l *actionLogger) Result(releaseID string) {
+	if l.quiet {
+		fmt.Println(releaseID)
+	}
+}
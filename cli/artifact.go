@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	register("artifact", runArtifact, `
+usage: flynn artifact gc [--dry-run] [-y]
+
+Manage artifacts.
+
+Options:
+	--dry-run  print the artifacts which would be deleted without deleting them
+	-y, --yes  skip the confirmation prompt
+
+Commands:
+	gc  delete artifacts which no release uses
+
+		Deletes the artifacts of the cluster (e.g. Docker images) which
+		aren't used by any release, such as those left behind by deleted
+		releases, printing the number deleted. File artifacts (e.g. slugs)
+		are deleted along with their releases so are rarely left behind,
+		and their files aren't deleted.
+
+		Artifacts created in the last hour are kept, as they may be about
+		to be used by a release which is being created. The controller
+		refuses to delete artifacts which are still used by a release (for
+		example one created since the artifacts were listed), which are
+		skipped.
+
+Examples:
+
+	$ flynn artifact gc --dry-run
+	Would delete artifact 6c9ebe6c-8fd3-4e5f-8a82-3c7e6a5c3a1b (docker https://example.com?name=app&id=1)
+	Would delete artifact 0f3a2d4e-1b6c-4f7e-9a8d-2c5b7e9f1a3c (docker https://example.com?name=app&id=2)
+	2 artifacts would be deleted.
+
+	$ flynn artifact gc -y
+	Deleted artifact 6c9ebe6c-8fd3-4e5f-8a82-3c7e6a5c3a1b (docker https://example.com?name=app&id=1)
+	Deleted artifact 0f3a2d4e-1b6c-4f7e-9a8d-2c5b7e9f1a3c (docker https://example.com?name=app&id=2)
+	Deleted 2 artifacts.
+`)
+}
+
+func runArtifact(args *docopt.Args, client controller.Client) error {
+	return runArtifactGC(args, client)
+}
+
+// artifactGCMinAge is how old an artifact must be to be garbage collected,
+// so that artifacts created for a release which is still being created
+// aren't deleted.
+const artifactGCMinAge = time.Hour
+
+func runArtifactGC(args *docopt.Args, client controller.Client) error {
+	artifacts, err := client.ArtifactList()
+	if err != nil {
+		return err
+	}
+	releases, err := client.ReleaseList()
+	if err != nil {
+		return err
+	}
+	unused := unusedArtifacts(artifacts, releases, time.Now().Add(-artifactGCMinAge))
+	if len(unused) == 0 {
+		fmt.Println("No artifacts to delete.")
+		return nil
+	}
+
+	if args.Bool["--dry-run"] {
+		for _, a := range unused {
+			fmt.Printf("Would delete artifact %s (%s %s)\n", a.ID, a.Type, a.URI)
+		}
+		fmt.Printf("%d artifacts would be deleted.\n", len(unused))
+		return nil
+	}
+	if !args.Bool["--yes"] {
+		if !promptYesNo(fmt.Sprintf("Are you sure you want to delete %d unused artifacts?", len(unused))) {
+			return nil
+		}
+	}
+
+	var deleted, skipped int
+	for _, a := range unused {
+		if err := client.DeleteArtifact(a.ID); controller.IsConflict(err) {
+			// a release has started using the artifact since it was
+			// listed
+			fmt.Printf("Skipping artifact %s (used by a release)\n", a.ID)
+			skipped++
+			continue
+		} else if err == controller.ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		fmt.Printf("Deleted artifact %s (%s %s)\n", a.ID, a.Type, a.URI)
+		deleted++
+	}
+	if skipped > 0 {
+		fmt.Printf("Deleted %d artifacts (skipped %d used by releases).\n", deleted, skipped)
+	} else {
+		fmt.Printf("Deleted %d artifacts.\n", deleted)
+	}
+	return nil
+}
+
+// unusedArtifacts returns the artifacts created before the given time which
+// aren't used by any of releases, oldest first.
+func unusedArtifacts(artifacts []*ct.Artifact, releases []*ct.Release, before time.Time) []*ct.Artifact {
+	used := make(map[string]struct{})
+	for _, r := range releases {
+		for _, id := range r.ArtifactIDs {
+			used[id] = struct{}{}
+		}
+	}
+	var unused []*ct.Artifact
+	for _, a := range artifacts {
+		if _, ok := used[a.ID]; ok {
+			continue
+		}
+		if a.CreatedAt == nil || !a.CreatedAt.Before(before) {
+			continue
+		}
+		unused = append(unused, a)
+	}
+	sort.Sort(artifactsByCreatedAt(unused))
+	return unused
+}
+
+type artifactsByCreatedAt []*ct.Artifact
+
+func (a artifactsByCreatedAt) Len() int           { return len(a) }
+func (a artifactsByCreatedAt) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a artifactsByCreatedAt) Less(i, j int) bool { return a[i].CreatedAt.Before(*a[j].CreatedAt) }
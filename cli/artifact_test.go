@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/flynn/flynn/controller/client"
+	"github.com/flynn/flynn/controller/client/fake"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/host/types"
+	. "github.com/flynn/go-check"
+	"github.com/flynn/go-docopt"
+)
+
+func runArtifactCommand(c *C, client controller.Client, argv ...string) error {
+	cmd := commands["artifact"]
+	args, err := docopt.Parse(cmd.usage, append([]string{"artifact"}, argv...), false, "", cmd.optsFirst)
+	c.Assert(err, IsNil)
+	return runArtifact(args, client)
+}
+
+func (S) TestArtifactGC(c *C) {
+	client := fake.NewClient()
+	old := time.Now().Add(-2 * artifactGCMinAge)
+	newArtifact := func(id int, createdAt time.Time) *ct.Artifact {
+		a := &ct.Artifact{
+			Type:      host.ArtifactTypeDocker,
+			URI:       fmt.Sprintf("https://example.com?name=test&id=%d", id),
+			CreatedAt: &createdAt,
+		}
+		c.Assert(client.CreateArtifact(a), IsNil)
+		return a
+	}
+	used := newArtifact(1, old)
+	unused := newArtifact(2, old)
+	recent := newArtifact(3, time.Now())
+	c.Assert(client.CreateRelease(&ct.Release{ArtifactIDs: []string{used.ID}}), IsNil)
+
+	// only old artifacts which no release uses are deleted
+	out := captureStdout(c, func() {
+		c.Assert(runArtifactCommand(c, client, "gc", "--dry-run"), IsNil)
+	})
+	c.Assert(out, Equals, fmt.Sprintf("Would delete artifact %s (docker %s)\n1 artifacts would be deleted.\n", unused.ID, unused.URI))
+	_, err := client.GetArtifact(unused.ID)
+	c.Assert(err, IsNil)
+
+	out = captureStdout(c, func() {
+		c.Assert(runArtifactCommand(c, client, "gc", "-y"), IsNil)
+	})
+	c.Assert(out, Equals, fmt.Sprintf("Deleted artifact %s (docker %s)\nDeleted 1 artifacts.\n", unused.ID, unused.URI))
+	_, err = client.GetArtifact(unused.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+	for _, a := range []*ct.Artifact{used, recent} {
+		_, err := client.GetArtifact(a.ID)
+		c.Assert(err, IsNil)
+	}
+
+	// artifacts which a release starts using after releases are listed
+	// are skipped
+	raced := newArtifact(4, old)
+	releases, err := client.ReleaseList()
+	c.Assert(err, IsNil)
+	c.Assert(client.CreateRelease(&ct.Release{ArtifactIDs: []string{raced.ID}}), IsNil)
+	out = captureStdout(c, func() {
+		c.Assert(runArtifactCommand(c, &staleReleasesClient{client, releases}, "gc", "-y"), IsNil)
+	})
+	c.Assert(out, Equals, fmt.Sprintf("Skipping artifact %s (used by a release)\nDeleted 0 artifacts (skipped 1 used by releases).\n", raced.ID))
+	_, err = client.GetArtifact(raced.ID)
+	c.Assert(err, IsNil)
+
+	out = captureStdout(c, func() {
+		c.Assert(runArtifactCommand(c, client, "gc", "-y"), IsNil)
+	})
+	c.Assert(out, Equals, "No artifacts to delete.\n")
+}
+
+// staleReleasesClient lists releases from before some were created.
+type staleReleasesClient struct {
+	*fake.Client
+	releases []*ct.Release
+}
+
+func (c *staleReleasesClient) ReleaseList() ([]*ct.Release, error) {
+	return c.releases, nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/BurntSushi/toml"
 	"github.com/flynn/flynn/controller/client"
+	"github.com/flynn/flynn/pkg/httpclient"
 	"github.com/mitchellh/go-homedir"
 )
 
@@ -27,6 +29,12 @@ type Cluster struct {
 }
 
 func (c *Cluster) Client() (controller.Client, error) {
+	return c.ClientWithTransport(nil)
+}
+
+// ClientWithTransport returns a controller client for the cluster which, if
+// wrap is not nil, sends requests via the transport it returns.
+func (c *Cluster) ClientWithTransport(wrap func(http.RoundTripper) http.RoundTripper) (controller.Client, error) {
 	var pin []byte
 	if c.TLSPin != "" {
 		var err error
@@ -35,7 +43,17 @@ func (c *Cluster) Client() (controller.Client, error) {
 			return nil, fmt.Errorf("error decoding tls pin: %s", err)
 		}
 	}
-	return controller.NewClientWithConfig(c.ControllerURL, c.Key, controller.Config{Pin: pin})
+	return controller.NewClientWithConfig(c.ControllerURL, c.Key, controller.Config{
+		Pin:           pin,
+		TokenProvider: c.TokenProvider(),
+		WrapTransport: wrap,
+	})
+}
+
+// TokenProvider returns the provider of the key used to authenticate with
+// the cluster's controller, which is the static key from the config file.
+func (c *Cluster) TokenProvider() httpclient.TokenProvider {
+	return httpclient.StaticToken(c.Key)
 }
 
 func (c *Cluster) DockerPushHost() (string, error) {
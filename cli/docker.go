@@ -223,6 +223,7 @@ func runDockerPush(args *docopt.Args, client controller.Client) error {
 		ArtifactIDs: []string{artifact.ID},
 		Processes:   prevRelease.Processes,
 		Env:         prevRelease.Env,
+		SecretEnv:   prevRelease.SecretEnv,
 		Meta:        prevRelease.Meta,
 	}
 
@@ -263,10 +264,11 @@ func runDockerPush(args *docopt.Args, client controller.Client) error {
 	}
 	release.Meta["docker-receive"] = "true"
 
+	setAuditMeta(release, "")
 	if err := client.CreateRelease(release); err != nil {
 		return err
 	}
-	if err := client.DeployAppRelease(mustApp(), release.ID, nil); err != nil {
+	if _, err := client.DeployAppRelease(mustApp(), release.ID, nil); err != nil {
 		return err
 	}
 	log.Printf("flynn: image deployed, scale it with 'flynn scale app=N'")
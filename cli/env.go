@@ -160,10 +160,28 @@ func setEnv(client controller.Client, proc string, env map[string]*string) (stri
 		return "", err
 	}
 
+	if err := applyEnv(release, proc, env); err != nil {
+		return "", err
+	}
+
+	release.ID = ""
+	setAuditMeta(release, "")
+	if err := client.CreateRelease(release); err != nil {
+		return "", err
+	}
+	if _, err := client.DeployAppRelease(mustApp(), release.ID, nil); err != nil {
+		return "", err
+	}
+	return release.ID, nil
+}
+
+// applyEnv sets the given vars in the env of release (or of the given process
+// type), deleting those with a nil value.
+func applyEnv(release *ct.Release, proc string, env map[string]*string) error {
 	var dest map[string]string
 	if proc != "" {
 		if _, ok := release.Processes[proc]; !ok {
-			return "", fmt.Errorf("process %q in release %s not found", proc, release.ID)
+			return fmt.Errorf("process %q in release %s not found", proc, release.ID)
 		}
 		if release.Processes[proc].Env == nil {
 			p := release.Processes[proc]
@@ -176,6 +194,10 @@ func setEnv(client controller.Client, proc string, env map[string]*string) (stri
 			release.Env = make(map[string]string, len(env))
 		}
 		dest = release.Env
+		// vars set or unset in the env no longer refer to secrets
+		for k := range env {
+			delete(release.SecretEnv, k)
+		}
 	}
 	for k, v := range env {
 		if v == nil {
@@ -184,13 +206,5 @@ func setEnv(client controller.Client, proc string, env map[string]*string) (stri
 			dest[k] = *v
 		}
 	}
-
-	release.ID = ""
-	if err := client.CreateRelease(release); err != nil {
-		return "", err
-	}
-	if err := client.DeployAppRelease(mustApp(), release.ID, nil); err != nil {
-		return "", err
-	}
-	return release.ID, nil
+	return nil
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/flynn/flynn/controller/client"
+	"github.com/flynn/flynn/pkg/httphelper"
+)
+
+// jsonError is printed instead of the plain error message when the
+// --json-errors flag is set.
+type jsonError struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// exitCodes maps error codes to the exit code of the CLI so that scripts can
+// distinguish between different types of failures.
+var exitCodes = map[string]int{
+	string(httphelper.UnknownErrorCode):      1,
+	string(httphelper.NotFoundErrorCode):     3,
+	string(httphelper.ConflictErrorCode):     4,
+	string(httphelper.UnauthorizedErrorCode): 5,
+}
+
+// errorCode classifies err using the controller client's typed errors,
+// falling back to a generic error code.
+func errorCode(err error) string {
+	switch {
+	case controller.IsNotFound(err):
+		return string(httphelper.NotFoundErrorCode)
+	case controller.IsConflict(err):
+		return string(httphelper.ConflictErrorCode)
+	case controller.IsUnauthorized(err):
+		return string(httphelper.UnauthorizedErrorCode)
+	default:
+		return string(httphelper.UnknownErrorCode)
+	}
+}
@@ -131,10 +131,11 @@ func runLimitSet(args *docopt.Args, client controller.Client) error {
 	release.Processes[proc] = t
 
 	release.ID = ""
+	setAuditMeta(release, "")
 	if err := client.CreateRelease(release); err != nil {
 		return err
 	}
-	if err := client.DeployAppRelease(mustApp(), release.ID, nil); err != nil {
+	if _, err := client.DeployAppRelease(mustApp(), release.ID, nil); err != nil {
 		return err
 	}
 	fmt.Printf("Created release %s\n", release.ID)
@@ -23,6 +23,7 @@ import (
 var (
 	flagCluster = os.Getenv("FLYNN_CLUSTER")
 	flagApp     string
+	flagTrace   bool
 )
 
 func main() {
@@ -31,12 +32,14 @@ func main() {
 	log.SetFlags(0)
 
 	usage := `
-usage: flynn [-a <app>] [-c <cluster>] <command> [<args>...]
+usage: flynn [-a <app>] [-c <cluster>] [--json-errors] [--trace] <command> [<args>...]
 
 Options:
 	-a <app>
 	-c <cluster>
 	-h, --help
+	--json-errors  print errors as JSON objects with "error" and "code" keys
+	--trace        print a JSON span for each request to the controller to stderr
 
 Commands:
 	help        show usage for a specific command
@@ -52,6 +55,7 @@ Commands:
 	scale       change formation
 	run         run a job
 	env         manage env variables
+	secret      manage app secrets
 	limit       manage resource limits
 	meta        manage app metadata
 	route       manage routes
@@ -63,12 +67,19 @@ Commands:
 	remote      manage git remotes
 	resource    provision a new resource
 	release     manage app releases
+	artifact    manage artifacts
 	deployment  list deployments
 	export      export app data
 	import      create app from exported data
 	version     show flynn version
 
 See 'flynn help <command>' for more information on a specific command.
+
+Exit codes:
+	1  generic error
+	3  resource not found
+	4  conflict with an existing resource
+	5  unauthorized
 `[1:]
 	args, _ := docopt.Parse(usage, nil, true, version.String(), true)
 
@@ -113,6 +124,7 @@ See 'flynn help <command>' for more information on a specific command.
 	}
 
 	flagApp = args.String["-a"]
+	flagTrace = args.Bool["--trace"]
 	if flagApp != "" {
 		if err := readConfig(); err != nil {
 			shutdown.Fatal(err)
@@ -125,8 +137,13 @@ See 'flynn help <command>' for more information on a specific command.
 	}
 
 	if err := runCommand(cmd, cmdArgs); err != nil {
-		log.Println(err)
-		shutdown.ExitWithCode(1)
+		code := errorCode(err)
+		if args.Bool["--json-errors"] {
+			json.NewEncoder(os.Stderr).Encode(jsonError{Error: err.Error(), Code: code})
+		} else {
+			log.Println(err)
+		}
+		shutdown.ExitWithCode(exitCodes[code])
 		return
 	}
 }
@@ -213,6 +230,9 @@ func getClusterClient() (controller.Client, error) {
 	if err != nil {
 		return nil, err
 	}
+	if flagTrace {
+		return cluster.ClientWithTransport(newTraceTransport(os.Stderr))
+	}
 	return cluster.Client()
 }
 
@@ -292,6 +312,48 @@ func humanTime(ts *time.Time) string {
 	return units.HumanDuration(time.Now().UTC().Sub(*ts)) + " ago"
 }
 
+// timeFormat is a format for printing times, given by a --time-format flag
+// or $FLYNN_TIME_FORMAT.
+type timeFormat string
+
+const (
+	// timeFormatRelative prints times relative to now, e.g. "5 minutes ago"
+	timeFormatRelative timeFormat = "relative"
+	// timeFormatRFC3339 prints times in UTC in RFC 3339 format
+	timeFormatRFC3339 timeFormat = "rfc3339"
+	// timeFormatLocal prints times in the local timezone
+	timeFormatLocal timeFormat = "local"
+)
+
+// parseTimeFormat returns the time format given by s, or by
+// $FLYNN_TIME_FORMAT if s is empty. It returns an empty format if neither is
+// set so that callers can use their own default.
+func parseTimeFormat(s string) (timeFormat, error) {
+	if s == "" {
+		s = os.Getenv("FLYNN_TIME_FORMAT")
+	}
+	switch f := timeFormat(strings.ToLower(s)); f {
+	case "", timeFormatRelative, timeFormatRFC3339, timeFormatLocal:
+		return f, nil
+	}
+	return "", fmt.Errorf("Invalid time format %q, expected relative, rfc3339 or local.", s)
+}
+
+// Format formats ts, using relative times if f is empty.
+func (f timeFormat) Format(ts *time.Time) string {
+	if ts == nil || ts.IsZero() {
+		return ""
+	}
+	switch f {
+	case timeFormatRFC3339:
+		return ts.UTC().Format(time.RFC3339)
+	case timeFormatLocal:
+		return ts.Local().Format("2006-01-02 15:04:05 MST")
+	default:
+		return humanTime(ts)
+	}
+}
+
 func listRec(w io.Writer, a ...interface{}) {
 	for i, x := range a {
 		fmt.Fprint(w, x)
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	cfg "github.com/flynn/flynn/cli/config"
+)
+
+// registryCredentials are the credentials used to authenticate with a
+// Docker registry when verifying that an image exists.
+type registryCredentials struct {
+	Username string
+	Password string
+
+	// IdentityToken, if set, is an OAuth2 refresh token exchanged for a
+	// bearer token instead of using the username and password (as stored
+	// by 'docker login' for some registries).
+	IdentityToken string
+}
+
+// dockerHubHosts are the hosts of the Docker Hub registry, whose
+// credentials 'docker login' stores under dockerHubAuthKey.
+var dockerHubHosts = map[string]struct{}{
+	"docker.io":               {},
+	"index.docker.io":         {},
+	"registry.hub.docker.com": {},
+	"registry-1.docker.io":    {},
+}
+
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// dockerConfig is the subset of the Docker CLI's config.json which stores
+// registry credentials.
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// registryCredentialsFor returns the credentials for the registry with the
+// given host, from $FLYNN_DOCKER_USERNAME and $FLYNN_DOCKER_PASSWORD if
+// set, otherwise from the Docker CLI's config.json in $DOCKER_CONFIG or
+// ~/.docker (including credential helpers). It returns nil if there are
+// none.
+func registryCredentialsFor(host string) (*registryCredentials, error) {
+	if username := os.Getenv("FLYNN_DOCKER_USERNAME"); username != "" {
+		return &registryCredentials{Username: username, Password: os.Getenv("FLYNN_DOCKER_PASSWORD")}, nil
+	}
+
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		dir = filepath.Join(cfg.HomeDir(), ".docker")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var config dockerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("error decoding Docker config %s: %s", filepath.Join(dir, "config.json"), err)
+	}
+
+	key := host
+	if _, ok := dockerHubHosts[host]; ok {
+		key = dockerHubAuthKey
+	}
+	if helper, ok := config.CredHelpers[host]; ok {
+		return credentialHelperCredentials(helper, key)
+	}
+	for server, auth := range config.Auths {
+		if server != key && registryHost(server) != host {
+			continue
+		}
+		creds := &registryCredentials{IdentityToken: auth.IdentityToken}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding Docker credentials for %s: %s", server, err)
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("error decoding Docker credentials for %s: expected username:password", server)
+			}
+			creds.Username, creds.Password = parts[0], parts[1]
+		}
+		if creds.Username != "" || creds.IdentityToken != "" {
+			return creds, nil
+		}
+	}
+	if config.CredsStore != "" {
+		return credentialHelperCredentials(config.CredsStore, key)
+	}
+	return nil, nil
+}
+
+// registryHost returns the host of a server in the Docker config's auths,
+// which may be a host or a URL.
+func registryHost(server string) string {
+	if !strings.Contains(server, "://") {
+		server = "https://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// credentialHelperCredentials gets the credentials for server from the
+// docker-credential-<helper> program, returning nil if it has none.
+func credentialHelperCredentials(helper, server string) (*registryCredentials, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// helpers report missing credentials on stdout
+		if strings.Contains(stdout.String(), "credentials not found") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting Docker credentials from docker-credential-%s: %s %s", helper, err, strings.TrimSpace(stderr.String()))
+	}
+	var res struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &res); err != nil {
+		return nil, fmt.Errorf("error decoding Docker credentials from docker-credential-%s: %s", helper, err)
+	}
+	if res.Username == "<token>" {
+		return &registryCredentials{IdentityToken: res.Secret}, nil
+	}
+	return &registryCredentials{Username: res.Username, Password: res.Secret}, nil
+}
+
+var errRegistryAuthRequired = errors.New("authentication required")
+
+// authorize sets the Authorization header of req in response to the
+// WWW-Authenticate challenge of a 401 response, using basic auth or
+// exchanging the credentials for a bearer token. It returns
+// errRegistryAuthRequired if the registry requires credentials and there
+// are none.
+func (c *registryCredentials) authorize(client *http.Client, req *http.Request, challenge string) error {
+	scheme, params := parseAuthChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c == nil || c.Username == "" {
+			return errRegistryAuthRequired
+		}
+		req.SetBasicAuth(c.Username, c.Password)
+		return nil
+	case "bearer":
+		token, err := c.bearerToken(client, params)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	default:
+		return fmt.Errorf("unsupported registry authentication scheme %q", scheme)
+	}
+}
+
+// bearerToken requests a token from the realm of a bearer challenge,
+// anonymously if c is nil (which public images on registries such as Docker
+// Hub require).
+func (c *registryCredentials) bearerToken(client *http.Client, params map[string]string) (string, error) {
+	realm := params["realm"]
+	if realm == "" {
+		return "", errors.New("registry bearer challenge has no realm")
+	}
+	q := url.Values{}
+	for _, k := range []string{"service", "scope"} {
+		if v := params[k]; v != "" {
+			q.Set(k, v)
+		}
+	}
+
+	var req *http.Request
+	var err error
+	if c != nil && c.IdentityToken != "" {
+		q.Set("grant_type", "refresh_token")
+		q.Set("refresh_token", c.IdentityToken)
+		q.Set("client_id", "flynn")
+		req, err = http.NewRequest("POST", realm, strings.NewReader(q.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		req, err = http.NewRequest("GET", realm+"?"+q.Encode(), nil)
+		if err == nil && c != nil && c.Username != "" {
+			req.SetBasicAuth(c.Username, c.Password)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusUnauthorized && (c == nil || c.Username == "" && c.IdentityToken == ""):
+		return "", errRegistryAuthRequired
+	case res.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("registry token request failed with status %d", res.StatusCode)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("error decoding registry token: %s", err)
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	if token.AccessToken != "" {
+		return token.AccessToken, nil
+	}
+	return "", errors.New("registry token response has no token")
+}
+
+// parseAuthChallenge parses a WWW-Authenticate header such as
+// `Bearer realm="https://auth.example.com/token",service="registry"` into
+// its scheme and parameters.
+func parseAuthChallenge(header string) (string, map[string]string) {
+	params := make(map[string]string)
+	header = strings.TrimSpace(header)
+	i := strings.IndexByte(header, ' ')
+	if i == -1 {
+		return header, params
+	}
+	scheme, rest := header[:i], header[i+1:]
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq == -1 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = strings.TrimSpace(rest[eq+1:])
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end == -1 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.IndexByte(rest, ','); comma != -1 {
+			value, rest = rest[:comma], rest[comma:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return scheme, params
+}
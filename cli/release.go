@@ -1,70 +1,404 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/cupcake/jsonschema"
+	"github.com/docker/docker/pkg/term"
 	"github.com/flynn/flynn/controller/client"
 	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/host/resource"
 	"github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/attempt"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/random"
 	"github.com/flynn/go-docopt"
 )
 
 func init() {
 	register("release", runRelease, `
-usage: flynn release [-q|--quiet]
-       flynn release add [-t <type>] [-f <file>] <uri>
-       flynn release update <file> [<id>] [--clean]
-       flynn release show [--json] [<id>]
-       flynn release delete [-y] <id>
-       flynn release rollback [-y] [<id>]
+usage: flynn release [-q|--quiet] [--watch] [--no-color] [--time-format=<format>]
+       flynn release --audit [--time-format=<format>]
+       flynn release add [-t <type>] [-f <file>] [--values=<file>] [--inherit] [-e <var=val>...] [--verify] [--require-digest] [--scale=<scale>] [--wait-ready [--timeout=<duration>]] [--plan] [--force] [--author=<name>] [-q | --log-json] <uri>
+       flynn release update [--clean] [--values=<file>] [--scale=<scale>] [--wait-ready [--timeout=<duration>]] [--force] [--author=<name>] [-q | --log-json]
+       flynn release update <file> [<id>] [--clean] [--values=<file>] [--scale=<scale>] [--wait-ready [--timeout=<duration>]] [--force] [--author=<name>] [-q | --log-json]
+       flynn release update --patch=<file> [<id>] [--scale=<scale>] [--wait-ready [--timeout=<duration>]] [--force] [--author=<name>] [-q | --log-json]
+       flynn release update --proc=<type> [--cmd=<cmd>] [--entrypoint=<cmd>] [--add-port=<port>...] [--omni | --no-omni] [--resurrect | --no-resurrect] [<id>] [--scale=<scale>] [--wait-ready [--timeout=<duration>]] [--force] [--author=<name>] [-q | --log-json]
+       flynn release env get [--process-type=<proc>] [<var>]
+       flynn release env set [--process-type=<proc>] [--scale=<scale>] [--force] [--author=<name>] [--log-json] <var>=<val>...
+       flynn release env unset [--process-type=<proc>] [--scale=<scale>] [--force] [--author=<name>] [--log-json] <var>...
+       flynn release show [-q | --json | --env-file] [--redact] [--full] [--show-resources] [--process=<type>...] [--time-format=<format>] [--previous | <id>]
+       flynn release current [-v] [--time-format=<format>]
+       flynn release count [--json]
+       flynn release diff [--json] <id> [<other-id>]
+       flynn release history [--json] [--time-format=<format>] <process>
+       flynn release schema
+       flynn release validate [--values=<file>] [<file>]
+       flynn release export [-o <path>] [<id>]
+       flynn release export --all -o <dir>
+       flynn release import [-q] <path>
+       flynn release restore [--force] [-q | --log-json] <file>
+       flynn release delete [-y] [--log-json] <id>
+       flynn release delete --match=<selector> [--dry-run] [--concurrency=<n>] [-y] [--log-json]
+       flynn release gc --keep=<n> [--keep-days=<days>] [--dry-run] [--concurrency=<n>] [-y] [--log-json]
+       flynn release rollback [-y] [--to-meta=<key=value>] [--force] [--dry-run] [-q | --log-json] [<id>]
+       flynn release annotate [--build-url=<url>] [--commit=<sha>] [<id>]
+       flynn release lock [--author=<name>]
+       flynn release unlock
 
 Manage app releases.
 
 Options:
-	-q, --quiet        only print release IDs
-	-t <type>          type of the release. Currently only 'docker' is supported. [default: docker]
-	-f, --file=<file>  release configuration file
-	--json             print release configuration in JSON format
-	--clean            update from a clean slate (ignoring prior config)
-	-y, --yes          skip the confirmation prompt when deleting a release
+	-q, --quiet             only print release IDs (with add, update and rollback, the ID of the resulting release)
+	-v, --verbose           with current, also print a short summary of the release
+	--watch                 keep running and print releases as they are deployed
+	--no-color              don't colorize output (also disabled by setting $NO_COLOR, or when output isn't a terminal)
+	-t <type>               type of the release, either 'docker' or 'file'. [default: docker]
+	-f, --file=<file>       release configuration file (defaults to $FLYNN_RELEASE_FILE or flynn.json)
+	--inherit               start from the env, meta and processes of the current release
+	-e, --env=<var=val>     set an env var in the new release (may be repeated)
+	--values=<file>         render the release configuration file as a template using the JSON values in file
+	--audit                 list who created each release and how
+	--json                  print release configuration (or count, diff or history) in JSON format
+	--redact                mask the values of env vars which look like secrets
+	--env-file              print the release env as a .env file
+	--full                  show env values containing newlines or other control characters in full
+	--previous              show the previous release (the one rollback would deploy)
+	--show-resources        show the CPU and memory requested by each process type and their total
+	--process=<type>        show details of the given process type (may be repeated)
+	--time-format=<format>  print times as relative, rfc3339 or local (defaults to $FLYNN_TIME_FORMAT)
+	--clean                 update from a clean slate (ignoring prior config)
+	--patch=<file>          update by applying a JSON Patch (RFC 6902) document to the release
+	--proc=<type>           update the given process type using --cmd, --entrypoint, --add-port, --omni and --resurrect
+	--cmd=<cmd>             set the command of the process type
+	--entrypoint=<cmd>      set the entrypoint of the process type
+	--add-port=<port>       add a port to the process type, either <proto> or <port>/<proto> (may be repeated)
+	--omni                  run one job of the process type on every host, multiplied by its scale
+	--no-omni               stop running the process type on every host
+	--resurrect             restart jobs of the process type after the whole cluster restarts
+	--no-resurrect          don't restart jobs of the process type after the whole cluster restarts
+	--process-type=<proc>   get or edit the env of the given process type
+	--force                 deploy the updated release even if the app's release changed during the update
+	                        or the app's releases are locked (or with rollback, roll back to a release
+	                        marked as failed)
+	--verify                check that a Docker image exists in its registry before creating the release
+	--require-digest        reject Docker image URIs which aren't pinned by a content digest
+	--scale=<scale>         scale process types after deploying (e.g. web=3,worker=2)
+	--wait-ready            after deploying, wait until every process type has its scale of jobs up
+	--timeout=<duration>    how long --wait-ready waits before failing, e.g. 10m (defaults to 5m)
+	--plan                  print the deployment plan for the release without creating or deploying it
+	--author=<name>         record name as the creator of the release, or who locked releases (defaults to $USER)
+	--log-json              log each action as a JSON line with action, app, release_id and duration fields
+	-y, --yes               skip the confirmation prompt when deleting releases
+	--keep=<n>              number of most recent releases to keep when garbage collecting
+	--keep-days=<days>      also keep releases created within this many days when garbage collecting
+	--dry-run               print the releases which would be deleted without deleting them (or with
+	                        rollback, the release which would be deployed and how it differs)
+	--to-meta=<key=value>   rollback to the most recent release with the given meta value
+	--match=<selector>      delete releases matching meta.<key>=<glob> or id=<glob>
+	--concurrency=<n>       with delete --match and gc, delete up to n releases at once (defaults to 1)
+	-o, --output=<path>     file to export the release to (defaults to stdout), or with --all, the directory
+	--all                   export every release of the app, to a file per release
+	--build-url=<url>       with annotate, the URL of the CI build or deploy which produced the release
+	--commit=<sha>          with annotate, the source commit the release was built from
 
 Commands:
-	With no arguments, shows a list of releases associated with the app.
+	With no arguments, shows a list of releases associated with the app,
+	marking the current release and which releases can be rolled back to.
+	With --watch, releases deployed to the app are appended to the list as
+	they become current. With --audit, the list instead shows who created
+	each release and via what (e.g. cli, api or dashboard), read from the
+	created_by and created_via meta keys, with "unknown" shown for releases
+	which don't have them.
 
 	add	add a new release
 
-		Create a new release from a Docker image.
+		Create a new release from a Docker image, or from a file artifact (e.g.
+		a slug) which is run using the Docker image of the current release.
 
 		The optional file argument takes a path to a file containing release
 		configuration in a JSON format. It's primarily used for specifying the
 		release environment and processes (similar to a Procfile). It can take any
-		of the arguments the controller Release type can take.
+		of the arguments the controller Release type can take. If not given,
+		$FLYNN_RELEASE_FILE or flynn.json in the current directory is used if
+		it exists. Process resources may use human readable units, for example
+		{"memory": {"limit": "512MB"}, "cpu": {"limit": "250m"}}.
+
+		Docker image URIs must include the name and id query parameters. With
+		--verify, the image is checked to exist in the registry before the
+		release is created. With --require-digest, the id must be a content
+		digest (e.g. id=sha256:...) rather than a tag or image ID which could
+		later refer to a different image.
+
+		When verifying, private registries are authenticated with using
+		credentials in the URI, $FLYNN_DOCKER_USERNAME and
+		$FLYNN_DOCKER_PASSWORD, or those stored by 'docker login' in
+		~/.docker/config.json (or $DOCKER_CONFIG), including credential
+		helpers.
+
+		With --inherit, the env, meta and processes of the current release
+		are used as the base of the new release, so a new build can be
+		deployed with the same config. Any configuration file is then merged
+		on top in the same way as update, followed by env vars given with -e.
+
+		With --values, the configuration file is a Go template which is
+		rendered with the JSON values in the given file as dot before being
+		decoded (e.g. "env": {"DOMAIN": "{{.domain}}"}), so one config can be
+		deployed to several environments. Referring to a value which isn't
+		set is an error. This also applies to update.
+
+		Env values in the configuration file can refer to app secrets rather
+		than containing secret values, e.g. "env": {"DATABASE_PASSWORD":
+		{"secretRef": "db-password"}}, which the controller resolves when
+		jobs are started (see 'flynn help secret'). The secrets must exist.
+		This also applies to update.
+
+		With --scale, the given process types of the new release are scaled
+		once it has been deployed (this also applies to update).
+
+		With --wait-ready, the command then waits until each process type of
+		the release has as many jobs up as its scale, exiting with an error
+		listing the process types which aren't if that takes longer than
+		--timeout, so scripts can tell when the release is live. Omni
+		process types are considered ready once they have at least their
+		scale of jobs up. This also applies to update.
+
+		With -q, progress messages are suppressed and only the ID of the
+		created release is printed, for example ID=$(flynn release add -q
+		<uri>). This also applies to update, and to rollback which prints the
+		ID of the release rolled back to.
+
+		The user creating the release (from --author or $USER) is recorded in
+		the created_by meta key, and created_via is set to "cli", which
+		update does too. These are shown by list and show.
+
+		With --plan, the steps deploying the release would take (which jobs
+		are started and stopped, in what order) are printed without creating
+		the release or its artifact, so nothing is changed.
 
 	show	show information about a release
 
-		Omit the ID to show information about the current release.
+		Omit the ID to show information about the current release, or use
+		--previous to show the release before it. Use --process to show the
+		command, ports, resources etc. of specific process types. With -q,
+		only the ID of the release is printed, for use in scripts.
+
+		With --redact, the values of env vars with names matching *_KEY,
+		*_SECRET, *_TOKEN or *PASSWORD* (ignoring case), or any of the
+		comma separated patterns in $FLYNN_REDACT_PATTERNS, are replaced with
+		****, in both the default and --json output.
+
+		With --env-file, the release env is printed as sorted KEY=VALUE lines
+		suitable for a .env file, with values containing spaces, quotes, =
+		or other special characters double quoted and escaped.
+
+		Env values containing newlines, tabs or other control characters
+		(e.g. PEM encoded keys) are shown quoted and escaped so they don't
+		break the output, and are truncated to their first 40 bytes with the
+		total length indicated unless --full is given.
+
+		With --show-resources, the CPU (in milliCPU) and memory requested by
+		each process type (or the default, if not set) multiplied by its
+		scale are listed along with their total, to check the release will
+		fit on the cluster. The scale is that of the release's formation, or
+		if it hasn't been deployed, that of the current release.
 
 	update	update an existing release
 
 		Takes a path to a file containing release configuration in a JSON format.
 		It can take any of the arguments the controller Release type can take, and
 		will override existing config with any values set thus. Omit the ID to
-		update the current release.
+		update the current release. Omit the file to use $FLYNN_RELEASE_FILE or
+		flynn.json in the current directory.
+
+		With --patch, the given JSON Patch document is applied to the JSON of
+		the existing release instead, which allows values to be removed or
+		replaced precisely (e.g. to remove a port, use
+		[{"op": "remove", "path": "/processes/web/ports/1"}]). Use "-" to read
+		the patch from stdin.
+
+		With --proc, a single process type is updated from flags instead of a
+		file. The --cmd and --entrypoint values are split on whitespace, or
+		may be given as a JSON array (e.g. '["bin/server", "--port", "80"]').
+		Ports added with --add-port which only give the protocol are
+		allocated a port when the process runs. --omni and --resurrect (and
+		their --no- negations) set the omni and resurrect options of the
+		process type, which otherwise require an update file.
+
+		If the app's current release changes while the update is running (for
+		example because someone else updated it at the same time), the update
+		fails rather than discarding the other change, and should be re-run.
+		Use --force to deploy the updated release anyway.
+
+	env  get or edit the release environment
+
+		With get, prints the value of the given variable, or every variable
+		as KEY=VALUE lines, from the current release. With --process-type,
+		the process type's env is included (overriding the release env).
+
+		With set or unset, a new release with the given variables set or
+		removed is created from the current release and deployed, in the same
+		way as update (so --scale, --force and --log-json also apply).
+
+	current  print the ID of the current release
+
+		Prints the ID of the app's current release, for use in scripts and
+		shell prompts, the same as 'flynn release show -q'. With -v, when
+		it was created, by whom and the scale of each process type are also
+		printed.
+
+	count  show the number of releases
+
+		Shows the number of releases associated with the app, the current
+		release and when the oldest and newest releases were created.
+
+	diff  show the differences between two releases
+
+		Shows the env, meta, process type and artifact changes from release
+		<id> to <other-id>, or to the current release if <other-id> is omitted.
+
+	history  show how a process type changed over time
+
+		Shows each of the app's releases which added or removed the given
+		process type or changed its command, entrypoint, ports or resource
+		limits, newest first, along with the old and new values. Releases
+		which left them unchanged are skipped.
+
+	schema  print the JSON Schema of release configuration
+
+		Prints a JSON Schema (draft 4) describing release configuration files,
+		generated from the controller's release type, for validating them in
+		editors or CI.
+
+	validate  check a release configuration file
+
+		Checks the given release configuration file (or $FLYNN_RELEASE_FILE
+		or flynn.json) against the release JSON Schema, and that it can be
+		decoded into a release with valid ports, printing each problem found.
+		With --values, the file is rendered as a template first, as with add.
+
+	export  export releases
+
+		Exports the current release, or the given release id, along with its
+		artifacts as JSON which can be imported with 'flynn release import'.
+		With --all, every release of the app is exported to a file named
+		after its ID in the --output directory.
+
+		Exports start with fields describing them (a _comment along with the
+		_app, _cluster, _exported_at time and _cli_version they were
+		exported with), which import ignores as it does any top-level field
+		beginning with an underscore.
+
+		Exported files are only readable by the current user, as release env
+		often contains secrets.
+
+	import  import exported releases
+
+		Creates the release exported to the given file, or every release
+		exported to the given directory (oldest first), keeping their IDs.
+		Artifacts which no longer exist are recreated and releases which
+		already exist are skipped. Imported releases are not deployed.
+
+	restore  recreate and deploy an exported release
+
+		Recreates the release exported to the given file (for example one
+		deleted by mistake) and deploys it, the inverse of delete. Artifacts
+		which still exist are reused and deleted ones are recreated, which is
+		reported for each artifact. As the IDs of deleted releases and
+		artifacts can't be reused, recreated ones are given new IDs. If the
+		release still exists it is deployed as is.
+
+		File artifacts stored in the blobstore (e.g. slugs) are deleted along
+		with their release, so releases using them can only be restored if
+		the files are uploaded again.
 
 	delete  delete a release
 
-		Any associated file artifacts (e.g. slugs) will also be deleted.
+		Any associated file artifacts (e.g. slugs) will also be deleted, with
+		progress printed as each file is deleted. If deleting the files fails
+		partway (e.g. due to a storage error), the remaining files are
+		retried in the background, and running delete again retries them
+		immediately without deleting the others again. Other artifacts
+		(e.g. Docker images) are kept, and once no release uses them can be
+		deleted with 'flynn artifact gc'.
+
+		With --match, deletes every release whose meta value or ID matches the
+		given glob pattern (e.g. --match 'meta.version=1.3.*'), other than the
+		current release and releases also used by other apps. Use --dry-run
+		to list the releases which would be deleted.
+
+		With --concurrency, up to the given number of releases are deleted
+		at once, which is faster for many releases. Each release is reported
+		in the same order regardless. A release which fails to be deleted
+		doesn't stop the others from being deleted, and the command fails
+		once they have all been tried, listing the failed releases. This
+		also applies to gc.
+
+	gc  delete old releases
+
+		Deletes releases other than the current release and the --keep most
+		recent releases (and, with --keep-days, any created within that many
+		days), along with their file artifacts. Releases which are also used
+		by other apps are skipped. Use --dry-run to list the releases which
+		would be deleted.
 
 	rollback  rollback to a previous release
 
 		Deploys the previous release or the given release id.
 
+		With --to-meta, deploys the most recent release whose meta has the
+		given key set to the given value (e.g. --to-meta version=1.4.2).
+
+		Releases marked as known to be bad with the meta failed=true are not
+		rolled back to: without an id, the most recent release before the
+		current one which isn't marked is deployed, and otherwise rollback
+		fails unless --force is given.
+
+		With --dry-run, prints the release which would be deployed and its
+		differences from the current release without deploying it.
+
+	annotate  link a release to the build which produced it
+
+		Records the URL of the CI build or deploy which produced the release
+		(or the current release if the ID is omitted) and the commit it was
+		built from in the build_url and commit keys of the release meta, so
+		that along with who created it (see --audit) a release can be traced
+		back to its source. These are shown by show and list --audit.
+
+		The URL must be an absolute http or https URL and the commit a hex
+		SHA of at least 7 characters. As the build of a new release may
+		differ, they aren't copied to releases created from an annotated
+		one (e.g. by update or env set).
+
+	lock  prevent releases from being deployed
+
+		Marks the app's releases as locked (e.g. during a change freeze), in
+		the app meta. While locked, add, update, env and rollback refuse to
+		deploy unless --force is given, and report who locked the releases
+		and when.
+
+	unlock  allow releases to be deployed again
+
 Examples:
 
 	Release an echo server using the flynn/slugbuilder image as a base, running socat.
@@ -82,17 +416,28 @@ Examples:
 		}
 	}
 	$ flynn release add -f config.json https://registry.hub.docker.com?name=flynn/slugbuilder&id=15d72b7f573b
-	Created release 989ce4a8-0088-444c-8379-caddded4b957.
+	Created release 989ce4a8-0088-444c-8379-caddded4b957 at 2015-05-06 21:58:12.751741 +0000 UTC.
+
+	Release a new slug, run using the Docker image of the current release.
+
+	$ flynn release add -t file http://blobstore.discoverd/slugs/app.tgz
+	Created release 5e1ad2c3-6c5b-4d5f-a1b2-0b1b0e5b9a3c at 2015-05-06 22:01:40.209383 +0000 UTC.
+
+	Release a new build with the config of the current release.
+
+	$ flynn release add --inherit -e GIT_SHA=3f2a1b9 https://registry.hub.docker.com?name=flynn/slugbuilder&id=2c8f7e0d1a4b
+	Created release 7d0c6a2e-3b1f-4e8a-9c5d-1f2e3a4b5c6d at 2015-05-06 22:04:15.518842 +0000 UTC.
 
 	$ flynn release
-	ID                                Created
-	989ce4a8-0088-444c-8379-caddded4b957  11 seconds ago
+	ID                                    Current  Rollback  Created         Created By
+	989ce4a8-0088-444c-8379-caddded4b957  *        no        11 seconds ago  alice (cli)
 
 	$ flynn release show
 	ID:             989ce4a8-0088-444c-8379-caddded4b957
 	Artifact:       docker+https://registry.hub.docker.com?name=flynn/slugbuilder&id=15d72b7f573b
 	Process Types:  echo
 	Created At:     2015-05-06 21:58:12.751741 +0000 UTC
+	Created By:     alice (cli)
 	ENV[MY_VAR]:    Hello World, this will be available in all process types.
 
 	$ cat update.json
@@ -104,10 +449,38 @@ Examples:
 		}
 	}
 	$ flynn release update update.json
-	Created release 1a270395-8d31-4ec1-953a-0683b4f12635.
+	Created release 1a270395-8d31-4ec1-953a-0683b4f12635 at 2015-05-06 22:06:53.027114 +0000 UTC.
+
+	$ flynn release env set LOG_LEVEL=debug
+	Created release 2b1e8a4c-5d9f-4a3e-8c7b-9f0e1d2c3b4a at 2015-05-06 22:08:21.88473 +0000 UTC.
+
+	$ flynn release env get LOG_LEVEL
+	debug
 
 	$ flynn release delete --yes c6b7f512-ef49-46f7-bb57-dd39e97bfb09
+	Deleted file 1/1: http://blobstore.discoverd/slugs/c6b7f512.tgz
 	Deleted release c6b7f512-ef49-46f7-bb57-dd39e97bfb09 (deleted 1 files)
+
+	$ flynn release delete --match 'meta.version=1.3.*' --yes
+	Deleted release 4d5e6f70-1a2b-4c3d-8e9f-0a1b2c3d4e5f (deleted 0 files)
+	Deleted release 5e6f7081-2b3c-4d4e-9f0a-1b2c3d4e5f60 (deleted 0 files)
+	Deleted 2 releases (deleted 0 files)
+
+	$ flynn release export --all -o releases
+	Exported 3 releases to releases.
+
+	$ flynn release annotate --build-url https://ci.example.com/builds/42 --commit 3f2a1b9 989ce4a8-0088-444c-8379-caddded4b957
+	Annotated release 989ce4a8-0088-444c-8379-caddded4b957.
+
+	$ flynn -a restored-app release import releases
+	Imported release 989ce4a8-0088-444c-8379-caddded4b957.
+	Imported release 1a270395-8d31-4ec1-953a-0683b4f12635.
+	Imported release 2b1e8a4c-5d9f-4a3e-8c7b-9f0e1d2c3b4a.
+
+	$ flynn release restore releases/1a270395-8d31-4ec1-953a-0683b4f12635.json
+	Reusing artifact 8e3a9c1f-2b4d-4e6f-8a0b-1c2d3e4f5a6b which still exists.
+	Recreated release 1a270395-8d31-4ec1-953a-0683b4f12635 as 3c4d5e6f-7a8b-4c9d-8e0f-1a2b3c4d5e6f.
+	Restored and deployed release 3c4d5e6f-7a8b-4c9d-8e0f-1a2b3c4d5e6f.
 `)
 }
 
@@ -116,30 +489,87 @@ func runRelease(args *docopt.Args, client controller.Client) error {
 		return runReleaseShow(args, client)
 	}
 	if args.Bool["add"] {
-		if args.String["-t"] == "docker" {
-			return runReleaseAddDocker(args, client)
-		} else {
-			return fmt.Errorf("Release type %s not supported.", args.String["-t"])
-		}
+		return runReleaseAdd(args, client)
 	}
 	if args.Bool["update"] {
 		return runReleaseUpdate(args, client)
 	}
+	if args.Bool["env"] {
+		return runReleaseEnv(args, client)
+	}
+	if args.Bool["current"] {
+		return runReleaseCurrent(args, client)
+	}
+	if args.Bool["count"] {
+		return runReleaseCount(args, client)
+	}
+	if args.Bool["diff"] {
+		return runReleaseDiff(args, client)
+	}
+	if args.Bool["history"] {
+		return runReleaseHistory(args, client)
+	}
+	if args.Bool["schema"] {
+		return runReleaseSchema(args, client)
+	}
+	if args.Bool["validate"] {
+		return runReleaseValidate(args, client)
+	}
+	if args.Bool["export"] {
+		return runReleaseExport(args, client)
+	}
+	if args.Bool["import"] {
+		return runReleaseImport(args, client)
+	}
+	if args.Bool["restore"] {
+		return runReleaseRestore(args, client)
+	}
 	if args.Bool["delete"] {
 		return runReleaseDelete(args, client)
 	}
+	if args.Bool["gc"] {
+		return runReleaseGC(args, client)
+	}
 	if args.Bool["rollback"] {
 		return runReleaseRollback(args, client)
 	}
+	if args.Bool["annotate"] {
+		return runReleaseAnnotate(args, client)
+	}
+	if args.Bool["lock"] {
+		return runReleaseLock(args, client)
+	}
+	if args.Bool["unlock"] {
+		return runReleaseUnlock(args, client)
+	}
 	return runReleaseList(args, client)
 }
 
 func runReleaseList(args *docopt.Args, client controller.Client) error {
+	format, err := parseTimeFormat(args.String["--time-format"])
+	if err != nil {
+		return err
+	}
+	if args.Bool["--watch"] {
+		return watchReleaseList(client, args.Bool["--quiet"], colorEnabled(args), format)
+	}
+
 	list, err := client.AppReleaseList(mustApp())
 	if err != nil {
 		return err
 	}
 
+	if args.Bool["--audit"] {
+		currentID, err := currentReleaseID(client)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+		defer w.Flush()
+		writeReleaseAudit(w, list, currentID, format)
+		return nil
+	}
+
 	if args.Bool["--quiet"] {
 		for _, r := range list {
 			fmt.Println(r.ID)
@@ -147,19 +577,206 @@ func runReleaseList(args *docopt.Args, client controller.Client) error {
 		return nil
 	}
 
-	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	currentID, err := currentReleaseID(client)
+	if err != nil {
+		return err
+	}
+
+	w := newReleaseListWriter(tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0), false, colorEnabled(args), format)
 	defer w.Flush()
-	listRec(w, "ID", "Created")
+	w.Header()
 	for _, r := range list {
-		listRec(w, r.ID, humanTime(r.CreatedAt))
+		w.Row(r, currentID)
 	}
 	return nil
 }
 
+// writeReleaseAudit writes a table of who created each release in list and
+// via what, marking the current release.
+func writeReleaseAudit(w io.Writer, list []*ct.Release, currentID string, format timeFormat) {
+	listRec(w, "ID", "Current", "Created", "Created By", "Created Via", "Commit", "Build")
+	for _, r := range list {
+		marker := ""
+		if r.ID == currentID {
+			marker = "*"
+		}
+		by, via := r.Meta["created_by"], r.Meta["created_via"]
+		if by == "" {
+			by = "unknown"
+		}
+		if via == "" {
+			via = "unknown"
+		}
+		listRec(w, r.ID, marker, format.Format(r.CreatedAt), by, via, r.Meta[releaseCommitMeta], r.Meta[releaseBuildURLMeta])
+	}
+}
+
+// currentReleaseID returns the ID of the app's current release, or an empty
+// string if the app has no release.
+func currentReleaseID(client controller.Client) (string, error) {
+	current, err := client.GetAppRelease(mustApp())
+	if err == controller.ErrNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return current.ID, nil
+}
+
+// releaseListWriter writes rows of the release list.
+type releaseListWriter struct {
+	*tabwriter.Writer
+	quiet  bool
+	format timeFormat
+
+	// colorize is whether to highlight the current release (see
+	// colorEnabled). Every row (including the header) starts with a color
+	// code of the same length so that the columns stay aligned.
+	colorize bool
+}
+
+func newReleaseListWriter(w *tabwriter.Writer, quiet, color bool, format timeFormat) *releaseListWriter {
+	return &releaseListWriter{
+		Writer:   w,
+		quiet:    quiet,
+		format:   format,
+		colorize: !quiet && color,
+	}
+}
+
+func (w *releaseListWriter) Header() {
+	if w.quiet {
+		return
+	}
+	id := "ID"
+	if w.colorize {
+		id = colorDefault + id
+	}
+	listRec(w, id, "Current", "Rollback", "Created", "Created By")
+}
+
+func (w *releaseListWriter) Row(r *ct.Release, currentID string) {
+	if w.quiet {
+		fmt.Fprintln(w, r.ID)
+		return
+	}
+	id, marker, rollback, created, author := r.ID, "", "no", w.format.Format(r.CreatedAt), releaseAuthor(r)
+	if r.ID == currentID {
+		marker = "*"
+	} else if len(r.ArtifactIDs) > 0 && !releaseFailed(r) {
+		rollback = "yes"
+	}
+	if w.colorize {
+		if r.ID == currentID {
+			id = colorGreen + id
+		} else {
+			id = colorDefault + id
+		}
+		author += colorReset
+	}
+	listRec(w, id, marker, rollback, created, author)
+}
+
+// watchReconnectDelay is how long to wait before reconnecting to the
+// controller event stream when watching releases.
+const watchReconnectDelay = 2 * time.Second
+
+// watchReleaseList prints the app's releases and then streams app release
+// events, printing each release as it becomes current. If the event stream
+// is interrupted, it reconnects and prints any releases which were missed.
+func watchReleaseList(client controller.Client, quiet, color bool, format timeFormat) error {
+	// rows are flushed individually, so use a minimum cell width wide
+	// enough for the header so that the columns stay aligned
+	w := newReleaseListWriter(tabwriter.NewWriter(os.Stdout, 10, 2, 2, ' ', 0), quiet, color, format)
+	w.Header()
+
+	seen := make(map[string]bool)
+	var currentID string
+	printRelease := func(r *ct.Release, current bool) {
+		if seen[r.ID] && (!current || r.ID == currentID) {
+			return
+		}
+		seen[r.ID] = true
+		if current {
+			currentID = r.ID
+		}
+		w.Row(r, currentID)
+		w.Flush()
+	}
+
+	for {
+		events := make(chan *ct.Event)
+		stream, err := client.StreamEvents(ct.StreamEventsOptions{
+			AppID:       mustApp(),
+			ObjectTypes: []ct.EventType{ct.EventTypeAppRelease},
+		}, events)
+		if err != nil {
+			return err
+		}
+
+		// list the releases after subscribing so that none are missed,
+		// printing the oldest first
+		list, err := client.AppReleaseList(mustApp())
+		if err != nil {
+			stream.Close()
+			return err
+		}
+		id, err := currentReleaseID(client)
+		if err != nil {
+			stream.Close()
+			return err
+		}
+		for i := len(list) - 1; i >= 0; i-- {
+			printRelease(list[i], list[i].ID == id)
+		}
+
+		for event := range events {
+			var e ct.AppRelease
+			if err := json.Unmarshal(event.Data, &e); err != nil {
+				stream.Close()
+				return err
+			}
+			if e.Release != nil {
+				printRelease(e.Release, true)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "event stream closed unexpectedly (%s), reconnecting...\n", stream.Err())
+		stream.Close()
+		time.Sleep(watchReconnectDelay)
+	}
+}
+
+const (
+	colorGreen   = "\x1b[32m"
+	colorDefault = "\x1b[39m"
+	colorReset   = "\x1b[0m"
+)
+
+// stdoutIsTerminal returns whether stdout is a terminal, and is a variable
+// so that tests can simulate one.
+var stdoutIsTerminal = func() bool { return term.IsTerminal(os.Stdout.Fd()) }
+
+// colorEnabled returns whether release commands may colorize their output,
+// which they don't if --no-color is given, $NO_COLOR is set (see
+// https://no-color.org) or stdout isn't a terminal (e.g. it is piped to
+// another program). Every release subcommand which colorizes output should
+// check it.
+func colorEnabled(args *docopt.Args) bool {
+	if args.Bool["--no-color"] || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return stdoutIsTerminal()
+}
+
 func runReleaseShow(args *docopt.Args, client controller.Client) error {
+	format, err := parseTimeFormat(args.String["--time-format"])
+	if err != nil {
+		return err
+	}
 	var release *ct.Release
-	var err error
-	if args.String["<id>"] != "" {
+	if args.Bool["--previous"] {
+		release, err = previousRelease(client)
+	} else if args.String["<id>"] != "" {
 		release, err = client.GetRelease(args.String["<id>"])
 	} else {
 		release, err = client.GetAppRelease(mustApp())
@@ -167,9 +784,31 @@ func runReleaseShow(args *docopt.Args, client controller.Client) error {
 	if err != nil {
 		return err
 	}
+	if args.Bool["--quiet"] {
+		fmt.Println(release.ID)
+		return nil
+	}
+	procs := args.All["--process"].([]string)
+	if len(procs) > 0 {
+		filtered := make(map[string]ct.ProcessType, len(procs))
+		for _, typ := range procs {
+			proc, ok := release.Processes[typ]
+			if !ok {
+				return fmt.Errorf("Release %s has no %q process type.", release.ID, typ)
+			}
+			filtered[typ] = proc
+		}
+		release.Processes = filtered
+	}
+	if args.Bool["--redact"] {
+		redactRelease(release, redactPatterns())
+	}
 	if args.Bool["--json"] {
 		return json.NewEncoder(os.Stdout).Encode(release)
 	}
+	if args.Bool["--env-file"] {
+		return writeEnvFile(os.Stdout, release.Env)
+	}
 	var artifacts []string
 	for _, id := range release.ArtifactIDs {
 		artifact, err := client.GetArtifact(id)
@@ -189,187 +828,1764 @@ func runReleaseShow(args *docopt.Args, client controller.Client) error {
 		listRec(w, fmt.Sprintf("Artifact[%d]:", i), artifact)
 	}
 	listRec(w, "Process Types:", strings.Join(types, ", "))
-	listRec(w, "Created At:", release.CreatedAt)
+	listRec(w, "Created At:", formatCreatedAt(release, format))
+	if author := releaseAuthor(release); author != "" {
+		listRec(w, "Created By:", author)
+	}
+	if commit := release.Meta[releaseCommitMeta]; commit != "" {
+		listRec(w, "Commit:", commit)
+	}
+	if buildURL := release.Meta[releaseBuildURLMeta]; buildURL != "" {
+		listRec(w, "Build:", buildURL)
+	}
 	for k, v := range release.Env {
-		listRec(w, fmt.Sprintf("ENV[%s]", k), v)
+		listRec(w, fmt.Sprintf("ENV[%s]", k), formatEnvValue(v, args.Bool["--full"]))
 	}
-	return nil
-}
-
-func runReleaseAddDocker(args *docopt.Args, client controller.Client) error {
-	release := &ct.Release{}
-	if args.String["--file"] != "" {
-		data, err := ioutil.ReadFile(args.String["--file"])
+	for k, name := range release.SecretEnv {
+		listRec(w, fmt.Sprintf("ENV[%s]", k), fmt.Sprintf("(secret %s)", name))
+	}
+	for _, typ := range procs {
+		listProcessType(w, typ, release.Processes[typ])
+	}
+	if args.Bool["--show-resources"] {
+		scale, err := releaseScale(client, release)
 		if err != nil {
 			return err
 		}
-		if err := json.Unmarshal(data, release); err != nil {
-			return err
-		}
+		listResources(w, release.Processes, scale)
 	}
+	return nil
+}
 
-	artifact := &ct.Artifact{
-		Type: host.ArtifactTypeDocker,
-		URI:  args.String["<uri>"],
-	}
-	if err := client.CreateArtifact(artifact); err != nil {
-		return err
-	}
+// maxEnvValueLen is the number of bytes of env values containing control
+// characters which "release show" shows unless --full is given.
+const maxEnvValueLen = 40
 
-	release.ArtifactIDs = []string{artifact.ID}
-	if err := client.CreateRelease(release); err != nil {
-		return err
+// formatEnvValue formats an env value for a line of "release show" output.
+// Values containing control characters (e.g. the newlines of a PEM encoded
+// key) would break the line, so are quoted, and unless full is set are
+// truncated with an indication of their total length.
+func formatEnvValue(v string, full bool) string {
+	if strings.IndexFunc(v, unicode.IsControl) == -1 {
+		return v
 	}
-
-	if err := client.DeployAppRelease(mustApp(), release.ID, nil); err != nil {
-		return err
+	if full || len(v) <= maxEnvValueLen {
+		return strconv.Quote(v)
 	}
-
-	log.Printf("Created release %s.", release.ID)
-
-	return nil
+	n := maxEnvValueLen
+	for n > 0 && !utf8.RuneStart(v[n]) {
+		n--
+	}
+	return fmt.Sprintf("%s... (%d bytes, use --full to show all)", strconv.Quote(v[:n]), len(v))
 }
 
-func runReleaseUpdate(args *docopt.Args, client controller.Client) error {
-	var release *ct.Release
-	var err error
-	if args.String["<id>"] != "" {
-		release, err = client.GetRelease(args.String["<id>"])
-	} else {
-		release, err = client.GetAppRelease(mustApp())
+// writeEnvFile writes env to w in the dotenv format, sorted by key.
+func writeEnvFile(w io.Writer, env map[string]string) error {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
 	}
-	if err != nil {
-		return err
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, err := fmt.Fprintf(w, "%s=%s\n", k, dotenvQuote(env[k])); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	updates := &ct.Release{}
-	data, err := ioutil.ReadFile(args.String["<file>"])
-	if err != nil {
-		return err
+// dotenvQuote returns v unchanged if it only contains characters which are
+// safe unquoted in a .env file, and otherwise double quotes it, escaping
+// backslashes, quotes, dollar signs (to prevent interpolation) and
+// newlines.
+func dotenvQuote(v string) string {
+	safe := v != ""
+	for _, r := range v {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:@,+%", r)) {
+			safe = false
+			break
+		}
 	}
-	if err := json.Unmarshal(data, updates); err != nil {
-		return err
+	if safe {
+		return v
 	}
-
-	// Basically, there's no way to merge JSON that can reliably knock out set values.
-	// Instead, throw the --clean flag to start from a largely empty Release.
-	if args.Bool["--clean"] {
-		updates.ArtifactIDs = release.ArtifactIDs
-		release = updates
-	} else {
-		release.ID = ""
-		for key, value := range updates.Env {
-			release.Env[key] = value
-		}
-		for key, value := range updates.Meta {
-			release.Meta[key] = value
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, r := range v {
+		switch r {
+		case '\\', '"', '$', '`':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		default:
+			buf.WriteRune(r)
 		}
-		for procKey, procUpdate := range updates.Processes {
-			procRelease, ok := release.Processes[procKey]
-			if !ok {
-				release.Processes[procKey] = procUpdate
-				continue
-			}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
 
-			if len(procUpdate.Cmd) > 0 {
-				procRelease.Cmd = procUpdate.Cmd
-			}
-			if len(procUpdate.Entrypoint) > 0 {
-				procRelease.Entrypoint = procUpdate.Entrypoint
-			}
-			for key, value := range procUpdate.Env {
-				procRelease.Env[key] = value
-			}
-			if len(procUpdate.Ports) > 0 {
-				procRelease.Ports = procUpdate.Ports
-			}
-			if procUpdate.Data {
-				procRelease.Data = true
-			}
-			if procUpdate.Omni {
-				procRelease.Omni = true
-			}
-			if procUpdate.HostNetwork {
-				procRelease.HostNetwork = true
-			}
-			if len(procUpdate.Service) > 0 {
-				procRelease.Service = procUpdate.Service
-			}
-			if procUpdate.Resurrect {
-				procRelease.Resurrect = true
-			}
-			for resKey, resValue := range procUpdate.Resources {
-				procRelease.Resources[resKey] = resValue
-			}
+// totalResourceTypes are the resource types which "release show
+// --show-resources" totals, those which are reserved on hosts when
+// processes are scheduled.
+var totalResourceTypes = []resource.Type{resource.TypeCPU, resource.TypeMemory}
 
-			release.Processes[procKey] = procRelease
+// processResources returns the amount of each of totalResourceTypes
+// requested by a single process of the given type, which is its limit if
+// it has no request and the default if neither is set.
+func processResources(proc ct.ProcessType) map[resource.Type]int64 {
+	resources := make(resource.Resources, len(proc.Resources))
+	for typ, spec := range proc.Resources {
+		resources[typ] = spec
+	}
+	resource.SetDefaults(&resources)
+	res := make(map[resource.Type]int64, len(totalResourceTypes))
+	for _, typ := range totalResourceTypes {
+		spec := resources[typ]
+		if spec.Request != nil {
+			res[typ] = *spec.Request
+		} else if spec.Limit != nil {
+			res[typ] = *spec.Limit
 		}
 	}
+	return res
+}
 
-	if err := client.CreateRelease(release); err != nil {
-		return err
+// releaseScale returns the number of processes of each type the release
+// runs with, which is its formation if it has one, otherwise that of the
+// app's current release (which deploying the release would keep). It
+// returns nil if there is neither.
+func releaseScale(client controller.Client, release *ct.Release) (map[string]int, error) {
+	formation, err := client.GetFormation(mustApp(), release.ID)
+	if controller.IsNotFound(err) {
+		current, err := client.GetAppRelease(mustApp())
+		if controller.IsNotFound(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		formation, err = client.GetFormation(mustApp(), current.ID)
+		if controller.IsNotFound(err) {
+			return nil, nil
+		}
 	}
-
-	if err := client.DeployAppRelease(mustApp(), release.ID, nil); err != nil {
-		return err
+	if err != nil {
+		return nil, err
 	}
-
-	log.Printf("Created release %s.", release.ID)
-
-	return nil
+	return formation.Processes, nil
 }
 
-func runReleaseDelete(args *docopt.Args, client controller.Client) error {
-	releaseID := args.String["<id>"]
-	if !args.Bool["--yes"] {
-		if !promptYesNo(fmt.Sprintf("Are you sure you want to delete release %q?", releaseID)) {
-			return nil
+// listResources lists the resources requested by each process type and
+// their total, multiplying by the number of processes of each type in
+// scale or, if scale is nil, counting a single process of each type.
+func listResources(w io.Writer, processes map[string]ct.ProcessType, scale map[string]int) {
+	types := make([]string, 0, len(processes))
+	for typ := range processes {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	format := func(amounts map[resource.Type]int64) string {
+		s := make([]string, len(totalResourceTypes))
+		for i, typ := range totalResourceTypes {
+			s[i] = fmt.Sprintf("%s=%s", typ, resource.FormatLimit(typ, amounts[typ]))
 		}
+		return strings.Join(s, ", ")
 	}
-	res, err := client.DeleteRelease(mustApp(), releaseID)
-	if err != nil {
-		return err
+	total := make(map[resource.Type]int64, len(totalResourceTypes))
+	for _, typ := range types {
+		n := 1
+		if scale != nil {
+			n = scale[typ]
+		}
+		amounts := processResources(processes[typ])
+		for res, amount := range amounts {
+			total[res] += amount * int64(n)
+		}
+		listRec(w, fmt.Sprintf("Resources[%s]:", typ), fmt.Sprintf("%s (x%d)", format(amounts), n))
 	}
-	if len(res.RemainingApps) > 0 {
-		log.Printf("Release scaled down for app but not fully deleted (still associated with %d other apps)", len(res.RemainingApps))
+	if scale == nil {
+		listRec(w, "Total Resources:", format(total)+" (one process of each type, the app has no formation)")
 	} else {
-		log.Printf("Deleted release %s (deleted %d files)", releaseID, len(res.DeletedFiles))
+		listRec(w, "Total Resources:", format(total))
 	}
-	return nil
 }
 
-func runReleaseRollback(args *docopt.Args, client controller.Client) error {
+func listProcessType(w io.Writer, typ string, proc ct.ProcessType) {
+	prefix := fmt.Sprintf("Process[%s] ", typ)
+	listRec(w, prefix+"Cmd:", strings.Join(proc.Cmd, " "))
+	if len(proc.Entrypoint) > 0 {
+		listRec(w, prefix+"Entrypoint:", strings.Join(proc.Entrypoint, " "))
+	}
+	if len(proc.Ports) > 0 {
+		listRec(w, prefix+"Ports:", formatPorts(proc.Ports))
+	}
+	if proc.Service != "" {
+		listRec(w, prefix+"Service:", proc.Service)
+	}
+	if len(proc.Resources) > 0 {
+		listRec(w, prefix+"Resources:", formatResourceLimits(proc.Resources))
+	}
+	var flags []string
+	for flag, set := range map[string]bool{
+		"data":         proc.Data,
+		"omni":         proc.Omni,
+		"host_network": proc.HostNetwork,
+		"resurrect":    proc.Resurrect,
+	} {
+		if set {
+			flags = append(flags, flag)
+		}
+	}
+	if len(flags) > 0 {
+		sort.Strings(flags)
+		listRec(w, prefix+"Flags:", strings.Join(flags, ", "))
+	}
+	for k, v := range proc.Env {
+		listRec(w, fmt.Sprintf("%sENV[%s]", prefix, k), v)
+	}
+}
+
+// formatPorts formats ports as a list of <port>/<proto>.
+func formatPorts(ports []ct.Port) string {
+	s := make([]string, len(ports))
+	for i, port := range ports {
+		s[i] = fmt.Sprintf("%d/%s", port.Port, port.Proto)
+	}
+	return strings.Join(s, ", ")
+}
+
+// formatResourceLimits formats the limits of resources as a list of
+// <type>=<limit>, sorted by type.
+func formatResourceLimits(resources resource.Resources) string {
+	limits := make([]string, 0, len(resources))
+	for typ, spec := range resources {
+		if spec.Limit != nil {
+			limits = append(limits, fmt.Sprintf("%s=%s", typ, resource.FormatLimit(typ, *spec.Limit)))
+		}
+	}
+	sort.Strings(limits)
+	return strings.Join(limits, ", ")
+}
+
+// redactedValue replaces the values of redacted env vars.
+const redactedValue = "****"
+
+// defaultRedactPatterns match the names of env vars which commonly contain
+// secrets.
+var defaultRedactPatterns = []string{"*_KEY", "*_SECRET", "*_TOKEN", "*PASSWORD*"}
+
+// redactPatterns returns the default redaction patterns along with any in
+// $FLYNN_REDACT_PATTERNS.
+func redactPatterns() []string {
+	patterns := append([]string{}, defaultRedactPatterns...)
+	for _, p := range strings.Split(os.Getenv("FLYNN_REDACT_PATTERNS"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// redactRelease masks the values of release and process type env vars with
+// names matching any of the given glob patterns.
+func redactRelease(release *ct.Release, patterns []string) {
+	release.Env = redactEnv(release.Env, patterns)
+	for typ, proc := range release.Processes {
+		proc.Env = redactEnv(proc.Env, patterns)
+		release.Processes[typ] = proc
+	}
+}
+
+func redactEnv(env map[string]string, patterns []string) map[string]string {
+	if env == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(env))
+	for k, v := range env {
+		if matchesRedactPattern(k, patterns) {
+			v = redactedValue
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
+
+func matchesRedactPattern(key string, patterns []string) bool {
+	key = strings.ToUpper(key)
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToUpper(p), key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseArtifacts maps the artifact types supported by "release add" to a
+// function which returns the artifact IDs of a release for a newly created
+// artifact of that type.
+var releaseArtifacts = map[host.ArtifactType]func(controller.Client, *ct.Artifact) ([]string, error){
+	host.ArtifactTypeDocker: func(_ controller.Client, artifact *ct.Artifact) ([]string, error) {
+		return []string{artifact.ID}, nil
+	},
+	host.ArtifactTypeFile: func(client controller.Client, artifact *ct.Artifact) ([]string, error) {
+		// file artifacts are run using the image of the current release
+		current, err := client.GetAppRelease(mustApp())
+		if err == controller.ErrNotFound {
+			return nil, errors.New("A file release requires an existing release to take the Docker image from.")
+		} else if err != nil {
+			return nil, err
+		}
+		for _, id := range current.ArtifactIDs {
+			image, err := client.GetArtifact(id)
+			if err != nil {
+				return nil, err
+			}
+			if image.Type == host.ArtifactTypeDocker {
+				return []string{image.ID, artifact.ID}, nil
+			}
+		}
+		return nil, fmt.Errorf("Current release %s has no Docker image artifact.", current.ID)
+	},
+}
+
+func runReleaseAdd(args *docopt.Args, client controller.Client) error {
+	typ := host.ArtifactType(args.String["-t"])
+	artifactIDs, ok := releaseArtifacts[typ]
+	if !ok {
+		return fmt.Errorf("Release type %s not supported.", typ)
+	}
+	if !args.Bool["--plan"] && !args.Bool["--force"] {
+		if err := checkReleaseLock(client); err != nil {
+			return err
+		}
+	}
+
+	if typ == host.ArtifactTypeDocker {
+		ref, err := parseDockerURI(args.String["<uri>"])
+		if err != nil {
+			return err
+		}
+		if args.Bool["--require-digest"] {
+			if err := ref.requireDigest(); err != nil {
+				return err
+			}
+		}
+		if args.Bool["--verify"] {
+			if err := ref.verify(); err != nil {
+				return err
+			}
+		}
+	}
+
+	env, err := parseReleaseEnv(args.All["--env"].([]string))
+	if err != nil {
+		return err
+	}
+
+	release := &ct.Release{}
+	if args.Bool["--inherit"] {
+		current, err := client.GetAppRelease(mustApp())
+		if err == controller.ErrNotFound {
+			return errors.New("--inherit requires the app to have an existing release.")
+		} else if err != nil {
+			return err
+		}
+		release = &ct.Release{
+			Env:       current.Env,
+			SecretEnv: current.SecretEnv,
+			Meta:      current.Meta,
+			Processes: current.Processes,
+		}
+	}
+	path, isDefault := releaseFile(args.String["--file"])
+	data, err := readReleaseConfig(path, args.String["--values"])
+	if err == nil {
+		updates, err := decodeReleaseConfig(data, false)
+		if err != nil {
+			return err
+		}
+		if args.Bool["--inherit"] {
+			mergeRelease(release, updates)
+		} else {
+			release = updates
+		}
+	} else if !isDefault || args.String["--values"] != "" || !os.IsNotExist(err) {
+		return err
+	}
+	if len(env) > 0 {
+		if release.Env == nil {
+			release.Env = make(map[string]string, len(env))
+		}
+		for key, value := range env {
+			release.Env[key] = value
+			delete(release.SecretEnv, key)
+		}
+	}
+	if err := checkReleaseSecrets(client, release); err != nil {
+		return err
+	}
+	scale, err := parseReleaseScale(args.String["--scale"], release)
+	if err != nil {
+		return err
+	}
+	readyTimeout, err := parseTimeout(args, "--timeout", defaultReadyTimeout)
+	if err != nil {
+		return err
+	}
+
+	if args.Bool["--plan"] {
+		// plan the release as it would be created, without creating it
+		// or its artifact
+		plan, err := client.PlanDeployment(mustApp(), release)
+		if err != nil {
+			return fmt.Errorf("Failed to plan the deployment: %s", err)
+		}
+		printDeploymentPlan(os.Stdout, plan)
+		fmt.Println("The release was not created.")
+		return nil
+	}
+
+	artifact := &ct.Artifact{
+		Type: typ,
+		URI:  args.String["<uri>"],
+	}
+	if typ == host.ArtifactTypeFile && strings.HasPrefix(artifact.URI, "http://blobstore.discoverd/") {
+		// mark the file as deletable along with the release
+		artifact.Meta = map[string]string{"blobstore": "true"}
+	}
+	l := &actionLogger{json: args.Bool["--log-json"], quiet: args.Bool["--quiet"], app: mustApp()}
+	if err := createArtifact(client, artifact); err != nil {
+		return err
+	}
+	l.Log("artifact_created", "", artifact.ID, time.Time{}, "")
+
+	release.ArtifactIDs, err = artifactIDs(client, artifact)
+	if err != nil {
+		return deleteOrphanedArtifact(client, artifact, err)
+	}
+	setAuditMeta(release, args.String["--author"])
+	if err := createRelease(client, release); err != nil {
+		return deleteOrphanedArtifact(client, artifact, err)
+	}
+	l.Log("release_created", release.ID, "", time.Time{}, "")
+
+	res, err := deployRelease(client, l, release.ID)
+	if err != nil {
+		// the release references the artifact so it can't be deleted,
+		// but let the user know which release wasn't deployed
+		return fmt.Errorf("Created release %s but failed to deploy it: %s", release.ID, err)
+	}
+
+	l.Log("release_added", release.ID, "", time.Time{}, "%s.", createdMessage(release))
+	l.Log("deploy_summary", release.ID, "", time.Time{}, "%s.", res.Summary())
+
+	if err := scaleRelease(client, l, release, scale); err != nil {
+		return err
+	}
+	if args.Bool["--wait-ready"] {
+		if err := waitReady(client, l, release, readyTimeout); err != nil {
+			return err
+		}
+	}
+	l.Result(release.ID)
+	return nil
+}
+
+// deleteOrphanedArtifact deletes an artifact which was created for a release
+// which then failed to be created, returning err annotated with the outcome.
+// Artifacts which are already referenced by other releases are left as is.
+func deleteOrphanedArtifact(client controller.Client, artifact *ct.Artifact, err error) error {
+	if derr := client.DeleteArtifact(artifact.ID); controller.IsConflict(derr) {
+		return err
+	} else if derr != nil {
+		return fmt.Errorf("%s (failed to delete artifact %s: %s)", err, artifact.ID, derr)
+	}
+	return fmt.Errorf("%s (deleted artifact %s)", err, artifact.ID)
+}
+
+// createAttempts is the retry strategy used when creating artifacts and
+// releases, which is safe as the client generated ID is used by the
+// controller as an idempotency key.
+var createAttempts = attempt.Strategy{
+	Min:   3,
+	Total: 30 * time.Second,
+	Delay: time.Second,
+}
+
+// isRetryableCreateError returns whether err is a transient error (e.g. a
+// timeout) rather than an error response from the controller.
+func isRetryableCreateError(err error) bool {
+	if _, ok := err.(httphelper.JSONError); ok {
+		return false
+	}
+	return err != controller.ErrNotFound && err != controller.ErrUnauthorized
+}
+
+// createArtifact creates artifact, retrying transient errors with the same
+// artifact ID so that retries do not create duplicate artifacts.
+func createArtifact(client controller.Client, artifact *ct.Artifact) error {
+	if artifact.ID == "" {
+		artifact.ID = random.UUID()
+	}
+	return createAttempts.RunWithValidator(func() error {
+		return client.CreateArtifact(artifact)
+	}, isRetryableCreateError)
+}
+
+// setAuditMeta records who created release, and that it was created using
+// the CLI, in its meta. The author defaults to $USER and is omitted if that
+// is not set.
+func setAuditMeta(release *ct.Release, author string) {
+	if author == "" {
+		author = os.Getenv("USER")
+	}
+	if release.Meta == nil {
+		release.Meta = make(map[string]string, 2)
+	}
+	if author != "" {
+		release.Meta["created_by"] = author
+	} else {
+		// don't carry over the author of the release this one was copied from
+		delete(release.Meta, "created_by")
+	}
+	release.Meta["created_via"] = "cli"
+	// nor the build annotations, which it may not have been built by
+	delete(release.Meta, releaseBuildURLMeta)
+	delete(release.Meta, releaseCommitMeta)
+}
+
+// releaseAuthor returns a description of who created release from its audit
+// meta, or an empty string if it isn't known.
+func releaseAuthor(release *ct.Release) string {
+	by, via := release.Meta["created_by"], release.Meta["created_via"]
+	switch {
+	case by != "" && via != "":
+		return fmt.Sprintf("%s (%s)", by, via)
+	case by != "":
+		return by
+	case via != "":
+		return "(" + via + ")"
+	}
+	return ""
+}
+
+// Release meta keys linking a release to the build which produced it, set
+// by annotate.
+const (
+	releaseBuildURLMeta = "build_url"
+	releaseCommitMeta   = "commit"
+)
+
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-fA-F]{7,64}$`)
+
+func runReleaseAnnotate(args *docopt.Args, client controller.Client) error {
+	buildURL, commit := args.String["--build-url"], args.String["--commit"]
+	if buildURL == "" && commit == "" {
+		return errors.New("At least one of --build-url and --commit must be given.")
+	}
+	if buildURL != "" {
+		u, err := url.Parse(buildURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("Invalid --build-url %q, expected an http or https URL.", buildURL)
+		}
+	}
+	if commit != "" && !commitSHAPattern.MatchString(commit) {
+		return fmt.Errorf("Invalid --commit %q, expected a hex SHA of at least 7 characters.", commit)
+	}
+
+	release := &ct.Release{ID: args.String["<id>"], Meta: make(map[string]string, 2)}
+	if release.ID == "" {
+		current, err := client.GetAppRelease(mustApp())
+		if err != nil {
+			return err
+		}
+		release.ID = current.ID
+	}
+	// only send the keys being set, which the controller merges into the
+	// release's meta
+	if buildURL != "" {
+		release.Meta[releaseBuildURLMeta] = buildURL
+	}
+	if commit != "" {
+		release.Meta[releaseCommitMeta] = strings.ToLower(commit)
+	}
+	if err := client.UpdateReleaseMeta(release); err != nil {
+		return err
+	}
+	log.Printf("Annotated release %s.", release.ID)
+	return nil
+}
+
+// formatCreatedAt formats the creation time of release, using the default
+// format of time.Time if format is empty.
+func formatCreatedAt(release *ct.Release, format timeFormat) string {
+	if format != "" {
+		return format.Format(release.CreatedAt)
+	}
+	if release.CreatedAt == nil {
+		return ""
+	}
+	return release.CreatedAt.String()
+}
+
+// createdMessage returns the message reporting that release was created,
+// including the creation time assigned by the controller formatted as
+// "release show" would (though as an absolute time, even if
+// $FLYNN_TIME_FORMAT is relative).
+func createdMessage(release *ct.Release) string {
+	if release.CreatedAt == nil {
+		return fmt.Sprintf("Created release %s", release.ID)
+	}
+	// an invalid $FLYNN_TIME_FORMAT is reported by the commands which
+	// take --time-format, so fall back to the default here
+	format, _ := parseTimeFormat("")
+	if format == timeFormatRelative {
+		format = ""
+	}
+	return fmt.Sprintf("Created release %s at %s", release.ID, formatCreatedAt(release, format))
+}
+
+// createRelease creates release, retrying transient errors with the same
+// release ID so that retries do not create duplicate releases. On success,
+// release is updated with the fields assigned by the controller, such as
+// CreatedAt.
+func createRelease(client controller.Client, release *ct.Release) error {
+	if release.ID == "" {
+		release.ID = random.UUID()
+	}
+	return createAttempts.RunWithValidator(func() error {
+		return client.CreateRelease(release)
+	}, isRetryableCreateError)
+}
+
+func runReleaseUpdate(args *docopt.Args, client controller.Client) error {
+	if !args.Bool["--force"] {
+		if err := checkReleaseLock(client); err != nil {
+			return err
+		}
+	}
+	var release *ct.Release
+	var err error
+	// currentID is the app's release when the update started, which is used
+	// to detect concurrent updates when deploying
+	var currentID string
+	if args.String["<id>"] != "" {
+		release, err = client.GetRelease(args.String["<id>"])
+		if err == nil && !args.Bool["--force"] {
+			var current *ct.Release
+			current, err = client.GetAppRelease(mustApp())
+			if err == nil {
+				currentID = current.ID
+			} else if controller.IsNotFound(err) {
+				err = nil
+			}
+		}
+	} else {
+		release, err = client.GetAppRelease(mustApp())
+		if err == nil {
+			currentID = release.ID
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if proc := args.String["--proc"]; proc != "" {
+		if err := updateProcessType(release, proc, args); err != nil {
+			return err
+		}
+		return createAndDeployRelease(args, client, release, currentID)
+	}
+
+	if patchFile := args.String["--patch"]; patchFile != "" {
+		release, err = patchRelease(release, patchFile)
+		if err != nil {
+			return err
+		}
+		return createAndDeployRelease(args, client, release, currentID)
+	}
+
+	path, _ := releaseFile(args.String["<file>"])
+	data, err := readReleaseConfig(path, args.String["--values"])
+	if err != nil {
+		return err
+	}
+	updates, err := decodeReleaseConfig(data, false)
+	if err != nil {
+		return err
+	}
+
+	// Basically, there's no way to merge JSON that can reliably knock out set values.
+	// Instead, throw the --clean flag to start from a largely empty Release.
+	if args.Bool["--clean"] {
+		updates.ArtifactIDs = release.ArtifactIDs
+		release = updates
+	} else {
+		release.ID = ""
+		mergeRelease(release, updates)
+	}
+
+	return createAndDeployRelease(args, client, release, currentID)
+}
+
+// parseReleaseEnv parses env vars given as <var>=<val> pairs.
+func parseReleaseEnv(pairs []string) (map[string]string, error) {
+	env := make(map[string]string, len(pairs))
+	for _, s := range pairs {
+		v := strings.SplitN(s, "=", 2)
+		if len(v) != 2 || v[0] == "" {
+			return nil, fmt.Errorf("invalid var format: %q", s)
+		}
+		env[v[0]] = v[1]
+	}
+	return env, nil
+}
+
+// mergeRelease merges the env, meta and processes of updates into release,
+// keeping any existing values which updates doesn't set. Env vars set in
+// updates replace those in release whether or not either refers to a
+// secret.
+func mergeRelease(release, updates *ct.Release) {
+	// maps which are empty in the existing release are omitted by the
+	// controller, so are nil
+	if release.Env == nil {
+		release.Env = make(map[string]string, len(updates.Env))
+	}
+	if release.SecretEnv == nil && len(updates.SecretEnv) > 0 {
+		release.SecretEnv = make(map[string]string, len(updates.SecretEnv))
+	}
+	if release.Meta == nil {
+		release.Meta = make(map[string]string, len(updates.Meta))
+	}
+	if release.Processes == nil {
+		release.Processes = make(map[string]ct.ProcessType, len(updates.Processes))
+	}
+	for key, value := range updates.Env {
+		release.Env[key] = value
+		delete(release.SecretEnv, key)
+	}
+	for key, name := range updates.SecretEnv {
+		release.SecretEnv[key] = name
+		delete(release.Env, key)
+	}
+	for key, value := range updates.Meta {
+		release.Meta[key] = value
+	}
+	for procKey, procUpdate := range updates.Processes {
+		procRelease, ok := release.Processes[procKey]
+		if !ok {
+			release.Processes[procKey] = procUpdate
+			continue
+		}
+
+		if len(procUpdate.Cmd) > 0 {
+			procRelease.Cmd = procUpdate.Cmd
+		}
+		if len(procUpdate.Entrypoint) > 0 {
+			procRelease.Entrypoint = procUpdate.Entrypoint
+		}
+		if procRelease.Env == nil && len(procUpdate.Env) > 0 {
+			procRelease.Env = make(map[string]string, len(procUpdate.Env))
+		}
+		for key, value := range procUpdate.Env {
+			procRelease.Env[key] = value
+		}
+		if len(procUpdate.Ports) > 0 {
+			procRelease.Ports = procUpdate.Ports
+		}
+		if procUpdate.Data {
+			procRelease.Data = true
+		}
+		if procUpdate.Omni {
+			procRelease.Omni = true
+		}
+		if procUpdate.HostNetwork {
+			procRelease.HostNetwork = true
+		}
+		if len(procUpdate.Service) > 0 {
+			procRelease.Service = procUpdate.Service
+		}
+		if procUpdate.Resurrect {
+			procRelease.Resurrect = true
+		}
+		if procRelease.Resources == nil && len(procUpdate.Resources) > 0 {
+			procRelease.Resources = make(resource.Resources, len(procUpdate.Resources))
+		}
+		for resKey, resValue := range procUpdate.Resources {
+			procRelease.Resources[resKey] = resValue
+		}
+
+		release.Processes[procKey] = procRelease
+	}
+}
+
+// updateProcessType applies the --cmd, --entrypoint, --add-port, --omni and
+// --resurrect flags (and their negations) to the given process type of
+// release, which must exist.
+func updateProcessType(release *ct.Release, typ string, args *docopt.Args) error {
+	proc, ok := release.Processes[typ]
+	if !ok {
+		return fmt.Errorf("Release %s has no %q process type.", release.ID, typ)
+	}
+	var updated bool
+	if s := args.String["--cmd"]; s != "" {
+		cmd, err := parseCommandFlag(s)
+		if err != nil {
+			return fmt.Errorf("invalid --cmd: %s", err)
+		}
+		proc.Cmd, updated = cmd, true
+	}
+	if s := args.String["--entrypoint"]; s != "" {
+		entrypoint, err := parseCommandFlag(s)
+		if err != nil {
+			return fmt.Errorf("invalid --entrypoint: %s", err)
+		}
+		proc.Entrypoint, updated = entrypoint, true
+	}
+	for _, s := range args.All["--add-port"].([]string) {
+		port, err := parsePortFlag(s)
+		if err != nil {
+			return err
+		}
+		proc.Ports, updated = append(proc.Ports, port), true
+	}
+	if args.Bool["--omni"] || args.Bool["--no-omni"] {
+		proc.Omni, updated = args.Bool["--omni"], true
+	}
+	if args.Bool["--resurrect"] || args.Bool["--no-resurrect"] {
+		proc.Resurrect, updated = args.Bool["--resurrect"], true
+	}
+	if !updated {
+		return errors.New("At least one of --cmd, --entrypoint, --add-port, --omni, --resurrect or their negations must be given with --proc.")
+	}
+	release.Processes[typ] = proc
+	return nil
+}
+
+// parseCommandFlag parses a command given either as a JSON array or as
+// whitespace separated words.
+func parseCommandFlag(s string) ([]string, error) {
+	if strings.HasPrefix(strings.TrimSpace(s), "[") {
+		var cmd []string
+		if err := json.Unmarshal([]byte(s), &cmd); err != nil {
+			return nil, err
+		}
+		return cmd, nil
+	}
+	return strings.Fields(s), nil
+}
+
+// parsePortFlag parses a port given as either <proto> or <port>/<proto>.
+func parsePortFlag(s string) (ct.Port, error) {
+	var port ct.Port
+	proto := s
+	if i := strings.Index(s, "/"); i >= 0 {
+		n, err := strconv.Atoi(s[:i])
+		if err != nil || n < 1 || n > 65535 {
+			return port, fmt.Errorf("invalid port in --add-port %q", s)
+		}
+		port.Port, proto = n, s[i+1:]
+	}
+	if proto != "tcp" && proto != "udp" {
+		return port, fmt.Errorf("invalid protocol in --add-port %q, must be tcp or udp", s)
+	}
+	port.Proto = proto
+	return port, nil
+}
+
+// createAndDeployRelease creates the updated release, deploys it (unless the
+// app's release is no longer currentID, or --force is given) and applies any
+// --scale argument.
+func createAndDeployRelease(args *docopt.Args, client controller.Client, release *ct.Release, currentID string) error {
+	scale, err := parseReleaseScale(args.String["--scale"], release)
+	if err != nil {
+		return err
+	}
+	readyTimeout, err := parseTimeout(args, "--timeout", defaultReadyTimeout)
+	if err != nil {
+		return err
+	}
+
+	if err := checkReleaseSecrets(client, release); err != nil {
+		return err
+	}
+
+	// always create a new release, even if the release file has an ID
+	release.ID = ""
+	l := &actionLogger{json: args.Bool["--log-json"], quiet: args.Bool["--quiet"], app: mustApp()}
+	setAuditMeta(release, args.String["--author"])
+	if err := createRelease(client, release); err != nil {
+		return err
+	}
+	l.Log("release_created", release.ID, "", time.Time{}, "")
+
+	var res *ct.DeployResult
+	if args.Bool["--force"] {
+		res, err = deployRelease(client, l, release.ID)
+	} else {
+		res, err = deployReleaseIfCurrent(client, l, release.ID, currentID)
+	}
+	if err != nil {
+		return err
+	}
+
+	l.Log("release_updated", release.ID, "", time.Time{}, "%s.", createdMessage(release))
+	l.Log("deploy_summary", release.ID, "", time.Time{}, "%s.", res.Summary())
+
+	if err := scaleRelease(client, l, release, scale); err != nil {
+		return err
+	}
+	if args.Bool["--wait-ready"] {
+		if err := waitReady(client, l, release, readyTimeout); err != nil {
+			return err
+		}
+	}
+	l.Result(release.ID)
+	return nil
+}
+
+func runReleaseEnv(args *docopt.Args, client controller.Client) error {
+	proc := args.String["--process-type"]
+	if args.Bool["get"] {
+		return runReleaseEnvGet(args, client, proc)
+	}
+
+	var env map[string]*string
+	if args.Bool["set"] {
+		pairs := args.All["<var>=<val>"].([]string)
+		env = make(map[string]*string, len(pairs))
+		for _, s := range pairs {
+			v := strings.SplitN(s, "=", 2)
+			if len(v) != 2 || v[0] == "" {
+				return fmt.Errorf("invalid var format: %q", s)
+			}
+			env[v[0]] = &v[1]
+		}
+	} else {
+		vars := args.All["<var>"].([]string)
+		env = make(map[string]*string, len(vars))
+		for _, s := range vars {
+			env[s] = nil
+		}
+	}
+
+	if !args.Bool["--force"] {
+		if err := checkReleaseLock(client); err != nil {
+			return err
+		}
+	}
+	release, err := client.GetAppRelease(mustApp())
+	if err == controller.ErrNotFound {
+		return errors.New("no app release found")
+	} else if err != nil {
+		return err
+	}
+	currentID := release.ID
+	if err := applyEnv(release, proc, env); err != nil {
+		return err
+	}
+	return createAndDeployRelease(args, client, release, currentID)
+}
+
+func runReleaseEnvGet(args *docopt.Args, client controller.Client, proc string) error {
+	release, err := client.GetAppRelease(mustApp())
+	if err == controller.ErrNotFound {
+		return errors.New("no app release found")
+	} else if err != nil {
+		return err
+	}
+	env := make(map[string]string, len(release.Env))
+	for k, v := range release.Env {
+		env[k] = v
+	}
+	if proc != "" {
+		t, ok := release.Processes[proc]
+		if !ok {
+			return fmt.Errorf("process type %q not found in release %s", proc, release.ID)
+		}
+		for k, v := range t.Env {
+			env[k] = v
+		}
+	}
+
+	if vars := args.All["<var>"].([]string); len(vars) > 0 {
+		v, ok := env[vars[0]]
+		if !ok {
+			return fmt.Errorf("var %q not found in release %s", vars[0], release.ID)
+		}
+		fmt.Println(v)
+		return nil
+	}
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("%s=%s\n", k, env[k])
+	}
+	return nil
+}
+
+// deployRelease deploys the given release to the app, logging when the
+// deploy starts and finishes, and returns the result of the deployment.
+func deployRelease(client controller.Client, l *actionLogger, releaseID string) (*ct.DeployResult, error) {
+	start := time.Now()
+	l.Log("deploy_started", releaseID, "", time.Time{}, "")
+	res, err := client.DeployAppRelease(mustApp(), releaseID, nil)
+	if err != nil {
+		return nil, err
+	}
+	l.Log("deploy_finished", releaseID, "", start, "")
+	return res, nil
+}
+
+// deployReleaseIfCurrent is like deployRelease, but fails if the app's
+// current release is no longer currentReleaseID.
+func deployReleaseIfCurrent(client controller.Client, l *actionLogger, releaseID, currentReleaseID string) (*ct.DeployResult, error) {
+	start := time.Now()
+	l.Log("deploy_started", releaseID, "", time.Time{}, "")
+	res, err := client.DeployAppReleaseIfCurrent(mustApp(), releaseID, currentReleaseID, nil)
+	if err != nil {
+		if controller.IsConflict(err) {
+			return nil, fmt.Errorf("%s\nThe app's release changed while updating it, re-run the update to apply it to the new release (or use --force to deploy release %s anyway).", err, releaseID)
+		}
+		return nil, err
+	}
+	l.Log("deploy_finished", releaseID, "", start, "")
+	return res, nil
+}
+
+// parseReleaseScale parses a --scale value of the form "web=3,worker=2",
+// checking that each process type exists in the release.
+func parseReleaseScale(s string, release *ct.Release) (map[string]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	scale := make(map[string]int)
+	for _, spec := range strings.Split(s, ",") {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Invalid --scale value %q, expected <type>=<count>.", spec)
+		}
+		count, err := strconv.Atoi(kv[1])
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("Invalid process count in --scale value %q.", spec)
+		}
+		if _, ok := release.Processes[kv[0]]; !ok {
+			return nil, fmt.Errorf("Unknown process type %q in --scale value.", kv[0])
+		}
+		scale[kv[0]] = count
+	}
+	return scale, nil
+}
+
+// scaleRelease scales the given process types of a deployed release, waiting
+// for the resulting jobs and then printing the formation.
+func scaleRelease(client controller.Client, l *actionLogger, release *ct.Release, scale map[string]int) error {
+	if len(scale) == 0 {
+		return nil
+	}
+	app := mustApp()
+	formation, err := client.GetFormation(app, release.ID)
+	if err == controller.ErrNotFound {
+		formation = &ct.Formation{AppID: app, ReleaseID: release.ID}
+	} else if err != nil {
+		return err
+	}
+	current := formation.Processes
+	processes := make(map[string]int, len(current)+len(scale))
+	for typ, count := range current {
+		processes[typ] = count
+	}
+	for typ, count := range scale {
+		processes[typ] = count
+	}
+	formation.Processes = processes
+
+	start := time.Now()
+	watcher, err := client.WatchJobEvents(app, release.ID)
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := client.PutFormation(formation); err != nil {
+		return err
+	}
+	expected := client.ExpectedScalingEvents(current, processes, release.Processes, 1)
+	if err := watcher.WaitFor(expected, scaleTimeout, nil); err != nil {
+		return err
+	}
+
+	types := make([]string, 0, len(processes))
+	for typ := range processes {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	scaled := make([]string, len(types))
+	for i, typ := range types {
+		scaled[i] = fmt.Sprintf("%s=%d", typ, processes[typ])
+	}
+	l.Log("release_scaled", release.ID, "", start, "Scaled release %s: %s", release.ID, strings.Join(scaled, " "))
+	return nil
+}
+
+func runReleaseCurrent(args *docopt.Args, client controller.Client) error {
+	format, err := parseTimeFormat(args.String["--time-format"])
+	if err != nil {
+		return err
+	}
+	release, err := client.GetAppRelease(mustApp())
+	if err != nil {
+		return err
+	}
+	if !args.Bool["--verbose"] {
+		fmt.Println(release.ID)
+		return nil
+	}
+	scale, err := releaseScale(client, release)
+	if err != nil {
+		return err
+	}
+	types := make([]string, 0, len(release.Processes))
+	for typ := range release.Processes {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	processes := make([]string, len(types))
+	for i, typ := range types {
+		processes[i] = fmt.Sprintf("%s=%d", typ, scale[typ])
+	}
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	defer w.Flush()
+	listRec(w, "ID:", release.ID)
+	listRec(w, "Created At:", formatCreatedAt(release, format))
+	if author := releaseAuthor(release); author != "" {
+		listRec(w, "Created By:", author)
+	}
+	listRec(w, "Processes:", strings.Join(processes, ", "))
+	return nil
+}
+
+func runReleaseCount(args *docopt.Args, client controller.Client) error {
+	summary, err := client.AppReleaseSummary(mustApp())
+	if err != nil {
+		return err
+	}
+	if args.Bool["--json"] {
+		return json.NewEncoder(os.Stdout).Encode(summary)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	defer w.Flush()
+	listRec(w, "Releases:", summary.Count)
+	listRec(w, "Current:", summary.CurrentReleaseID)
+	listRec(w, "Oldest:", humanTime(summary.OldestCreatedAt))
+	listRec(w, "Newest:", humanTime(summary.NewestCreatedAt))
+	return nil
+}
+
+func runReleaseDiff(args *docopt.Args, client controller.Client) error {
+	from, err := client.GetRelease(args.String["<id>"])
+	if err != nil {
+		return err
+	}
+	var to *ct.Release
+	if id := args.String["<other-id>"]; id != "" {
+		to, err = client.GetRelease(id)
+	} else {
+		to, err = client.GetAppRelease(mustApp())
+	}
+	if err != nil {
+		return err
+	}
+
+	diff := ct.DiffReleases(from, to)
+	if args.Bool["--json"] {
+		return json.NewEncoder(os.Stdout).Encode(diff)
+	}
+	printReleaseDiff(diff)
+	return nil
+}
+
+// printReleaseDiff prints the differences between two releases, grouped by
+// env, meta, processes and artifacts.
+func printReleaseDiff(diff ct.ReleaseDiff) {
+	if diff.Empty() {
+		fmt.Println("No differences.")
+		return
+	}
+	printMapDiff("Env", "", diff.Env)
+	printMapDiff("Meta", "", diff.Meta)
+	if !diff.Processes.Empty() {
+		fmt.Println("Processes:")
+		for _, typ := range diff.Processes.Added {
+			fmt.Printf("  + %s\n", typ)
+		}
+		for _, typ := range diff.Processes.Removed {
+			fmt.Printf("  - %s\n", typ)
+		}
+		types := make([]string, 0, len(diff.Processes.Changed))
+		for typ := range diff.Processes.Changed {
+			types = append(types, typ)
+		}
+		sort.Strings(types)
+		for _, typ := range types {
+			procDiff := diff.Processes.Changed[typ]
+			fmt.Printf("  ~ %s", typ)
+			if len(procDiff.Fields) > 0 {
+				fmt.Printf(": %s", strings.Join(procDiff.Fields, ", "))
+			}
+			fmt.Println()
+			printMapDiff("", "      ", procDiff.Env)
+		}
+	}
+	if !diff.Artifacts.Empty() {
+		fmt.Println("Artifacts:")
+		for _, id := range diff.Artifacts.Added {
+			fmt.Printf("  + %s\n", id)
+		}
+		for _, id := range diff.Artifacts.Removed {
+			fmt.Printf("  - %s\n", id)
+		}
+		if diff.Artifacts.Reordered {
+			fmt.Println("  ~ reordered")
+		}
+	}
+}
+
+// printMapDiff prints the added, removed and changed keys of diff in key
+// order, preceded by title if it is set.
+func printMapDiff(title, indent string, diff ct.MapDiff) {
+	if diff.Empty() {
+		return
+	}
+	if title != "" {
+		fmt.Printf("%s:\n", title)
+		indent = "  "
+	}
+	keys := make([]string, 0, len(diff.Added)+len(diff.Removed)+len(diff.Changed))
+	for k := range diff.Added {
+		keys = append(keys, k)
+	}
+	for k := range diff.Removed {
+		keys = append(keys, k)
+	}
+	for k := range diff.Changed {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if v, ok := diff.Added[k]; ok {
+			fmt.Printf("%s+ %s=%s\n", indent, k, v)
+		} else if v, ok := diff.Removed[k]; ok {
+			fmt.Printf("%s- %s=%s\n", indent, k, v)
+		} else {
+			change := diff.Changed[k]
+			fmt.Printf("%s~ %s: %s -> %s\n", indent, k, change.Old, change.New)
+		}
+	}
+}
+
+func runReleaseRollback(args *docopt.Args, client controller.Client) error {
+	if !args.Bool["--force"] {
+		if err := checkReleaseLock(client); err != nil {
+			return err
+		}
+	}
 	currentRelease, err := client.GetAppRelease(mustApp())
 	if err != nil {
 		return err
 	}
 	releaseID := args.String["<id>"]
-	if releaseID == "" {
+	if meta := args.String["--to-meta"]; meta != "" {
+		if releaseID != "" {
+			return errors.New("Cannot specify both a release id and --to-meta.")
+		}
+		kv := strings.SplitN(meta, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("Invalid --to-meta value %q, expected key=value.", meta)
+		}
 		releases, err := client.AppReleaseList(mustApp())
 		if err != nil {
 			return err
 		}
-		if len(releases) < 2 {
-			return fmt.Errorf("Not enough releases to perform a rollback.")
+		// releases are listed newest first
+		for _, r := range releases {
+			if v, ok := r.Meta[kv[0]]; ok && v == kv[1] {
+				releaseID = r.ID
+				break
+			}
+		}
+		if releaseID == "" {
+			return fmt.Errorf("No release found with meta %s=%s.", kv[0], kv[1])
+		}
+		if releaseID == currentRelease.ID {
+			return fmt.Errorf("Release %s with meta %s=%s is the current release.", releaseID, kv[0], kv[1])
+		}
+	} else if releaseID == "" {
+		release, skipped, err := findRollbackTarget(client)
+		if err != nil {
+			return err
+		}
+		if !args.Bool["--quiet"] {
+			for _, r := range skipped {
+				log.Printf("Skipping release %s which is marked as failed.", r.ID)
+			}
 		}
-		releaseID = releases[1].ID
+		releaseID = release.ID
 	} else if releaseID == currentRelease.ID {
 		return fmt.Errorf("Release id given is the current release.")
 	}
 
+	dryRun := args.Bool["--dry-run"]
+	if !args.Bool["--force"] || dryRun {
+		release, err := client.GetRelease(releaseID)
+		if err != nil {
+			return err
+		}
+		if !args.Bool["--force"] && releaseFailed(release) {
+			return fmt.Errorf("Release %s is marked as failed (meta failed=true), use --force to roll back to it anyway.", releaseID)
+		}
+		if dryRun {
+			if args.Bool["--quiet"] {
+				fmt.Println(releaseID)
+				return nil
+			}
+			fmt.Printf("Would roll back to release %s from %s.\n", releaseID, currentRelease.ID)
+			printReleaseDiff(ct.DiffReleases(currentRelease, release))
+			return nil
+		}
+	}
+
 	if !args.Bool["--yes"] {
 		if !promptYesNo(fmt.Sprintf("Are you sure you want to rollback to release %q?", releaseID)) {
 			return nil
 		}
 	}
 
-	log.Printf("Rolling back to release %s from %s.\n", releaseID, currentRelease.ID)
+	l := &actionLogger{json: args.Bool["--log-json"], quiet: args.Bool["--quiet"], app: mustApp()}
+	l.Log("rollback_started", releaseID, "", time.Time{}, "Rolling back to release %s from %s.\n", releaseID, currentRelease.ID)
+
+	if _, err := deployRelease(client, l, releaseID); err != nil {
+		return err
+	}
+
+	l.Log("rollback_finished", releaseID, "", time.Time{}, "Successfully rolled back to release %s.\n", releaseID)
+	l.Result(releaseID)
+
+	return nil
+}
+
+// App meta keys recording that an app's releases are locked.
+const (
+	releaseLockedMeta   = "release_locked"
+	releaseLockedByMeta = "release_locked_by"
+	releaseLockedAtMeta = "release_locked_at"
+)
+
+func runReleaseLock(args *docopt.Args, client controller.Client) error {
+	app, err := client.GetApp(mustApp())
+	if err != nil {
+		return err
+	}
+	author := args.String["--author"]
+	if author == "" {
+		author = os.Getenv("USER")
+	}
+	if app.Meta == nil {
+		app.Meta = make(map[string]string, 3)
+	}
+	app.Meta[releaseLockedMeta] = "true"
+	app.Meta[releaseLockedAtMeta] = time.Now().UTC().Format(time.RFC3339)
+	if author != "" {
+		app.Meta[releaseLockedByMeta] = author
+	} else {
+		delete(app.Meta, releaseLockedByMeta)
+	}
+	if err := client.UpdateAppMeta(app); err != nil {
+		return err
+	}
+	log.Printf("Locked releases of %s, use 'flynn release unlock' to unlock them.", app.Name)
+	return nil
+}
+
+func runReleaseUnlock(args *docopt.Args, client controller.Client) error {
+	app, err := client.GetApp(mustApp())
+	if err != nil {
+		return err
+	}
+	if app.Meta[releaseLockedMeta] != "true" {
+		log.Printf("Releases of %s are not locked.", app.Name)
+		return nil
+	}
+	delete(app.Meta, releaseLockedMeta)
+	delete(app.Meta, releaseLockedByMeta)
+	delete(app.Meta, releaseLockedAtMeta)
+	if err := client.UpdateAppMeta(app); err != nil {
+		return err
+	}
+	log.Printf("Unlocked releases of %s.", app.Name)
+	return nil
+}
+
+// checkReleaseLock returns an error describing who locked the app's releases
+// and when if they are locked.
+func checkReleaseLock(client controller.Client) error {
+	app, err := client.GetApp(mustApp())
+	if err != nil {
+		return err
+	}
+	if app.Meta[releaseLockedMeta] != "true" {
+		return nil
+	}
+	msg := "Releases of " + app.Name + " are locked"
+	if by := app.Meta[releaseLockedByMeta]; by != "" {
+		msg += " by " + by
+	}
+	if at := app.Meta[releaseLockedAtMeta]; at != "" {
+		msg += " since " + at
+	}
+	return errors.New(msg + ", use 'flynn release unlock' to unlock them or --force to deploy anyway.")
+}
+
+// previousRelease returns the most recent release of the app before the
+// latest one which is not marked as failed, which is the release deployed by
+// a rollback without an id.
+func previousRelease(client controller.Client) (*ct.Release, error) {
+	release, _, err := findRollbackTarget(client)
+	return release, err
+}
+
+// rollbackListCount is the number of recent releases fetched to find the
+// release to roll back to, so that rolling back apps with thousands of
+// releases doesn't transfer all of them.
+const rollbackListCount = 10
+
+// findRollbackTarget returns the release to roll back to along with the
+// failed releases skipped to find it, only listing every release of the
+// app if all of the most recent ones are marked as failed.
+func findRollbackTarget(client controller.Client) (*ct.Release, []*ct.Release, error) {
+	releases, err := client.AppReleaseListCount(mustApp(), rollbackListCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	release, skipped, err := rollbackTarget(releases)
+	if err != nil && len(releases) >= rollbackListCount {
+		if releases, err = client.AppReleaseList(mustApp()); err != nil {
+			return nil, nil, err
+		}
+		return rollbackTarget(releases)
+	}
+	return release, skipped, err
+}
+
+// rollbackTarget returns the release a rollback without an id deploys from
+// releases (which are listed newest first), along with the releases skipped
+// because they are marked as failed.
+func rollbackTarget(releases []*ct.Release) (*ct.Release, []*ct.Release, error) {
+	if len(releases) < 2 {
+		return nil, nil, fmt.Errorf("Not enough releases to perform a rollback.")
+	}
+	var skipped []*ct.Release
+	for _, r := range releases[1:] {
+		if !releaseFailed(r) {
+			return r, skipped, nil
+		}
+		skipped = append(skipped, r)
+	}
+	return nil, skipped, fmt.Errorf("No release to roll back to, all %d previous releases are marked as failed.", len(skipped))
+}
+
+// releaseFailed returns whether release has been marked as known to be bad
+// with the meta failed=true.
+func releaseFailed(release *ct.Release) bool {
+	return release.Meta["failed"] == "true"
+}
+
+// defaultReleaseFile is the release configuration file used when no file is
+// given and FLYNN_RELEASE_FILE is not set.
+const defaultReleaseFile = "flynn.json"
+
+// releaseFile returns path if set, otherwise the default release
+// configuration file, along with whether the default was used.
+func releaseFile(path string) (string, bool) {
+	if path != "" {
+		return path, false
+	}
+	if path := os.Getenv("FLYNN_RELEASE_FILE"); path != "" {
+		return path, true
+	}
+	return defaultReleaseFile, true
+}
+
+// readReleaseConfig reads the release configuration file at path, which if
+// valuesPath is set is first rendered as a Go template with the JSON values
+// in valuesPath as dot, failing if the template refers to a missing value.
+func readReleaseConfig(path, valuesPath string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || valuesPath == "" {
+		return data, err
+	}
+	valuesData, err := ioutil.ReadFile(valuesPath)
+	if err != nil {
+		return nil, err
+	}
+	var values interface{}
+	if err := json.Unmarshal(valuesData, &values); err != nil {
+		return nil, fmt.Errorf("error decoding values file %s: %s", valuesPath, err)
+	}
+	tmpl, err := template.New(filepath.Base(path)).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing release template: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, values); err != nil {
+		return nil, fmt.Errorf("error rendering release template: %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func runReleaseSchema(args *docopt.Args, client controller.Client) error {
+	data, err := json.MarshalIndent(ct.ReleaseSchema(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(os.Stdout, string(data))
+	return err
+}
 
-	if err := client.DeployAppRelease(mustApp(), releaseID, nil); err != nil {
+func runReleaseValidate(args *docopt.Args, client controller.Client) error {
+	path, _ := releaseFile(args.String["<file>"])
+	data, err := readReleaseConfig(path, args.String["--values"])
+	if err != nil {
+		return err
+	}
+	problems, err := validateReleaseConfig(data)
+	if err != nil {
 		return err
 	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, p)
+		}
+		return fmt.Errorf("%s is not a valid release configuration.", path)
+	}
+	fmt.Printf("%s is a valid release configuration.\n", path)
+	return nil
+}
+
+// validateReleaseConfig checks release configuration against the release
+// JSON Schema, then that it decodes strictly into a release with valid
+// ports, returning a description of each problem found. The error is only
+// set if the configuration isn't JSON at all.
+func validateReleaseConfig(data []byte) ([]string, error) {
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("error decoding release configuration: %s", err)
+	}
+	schemaData, err := json.Marshal(ct.ReleaseSchema())
+	if err != nil {
+		return nil, err
+	}
+	schema, err := jsonschema.Parse(bytes.NewReader(schemaData), false)
+	if err != nil {
+		return nil, err
+	}
+	var problems []string
+	for _, e := range schema.Validate(nil, doc) {
+		field := e.DotNotation()
+		if field == "" {
+			field = "(root)"
+		}
+		problems = append(problems, fmt.Sprintf("%s: %s", field, e.Description))
+	}
+	sort.Strings(problems)
+
+	release, err := decodeReleaseConfig(data, true)
+	if err != nil {
+		return append(problems, err.Error()), nil
+	}
+	if err := checkReleasePorts(release); err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems, nil
+}
+
+// decodeReleaseConfig decodes release configuration, in which env values
+// are either strings or references to app secrets of the form
+// {"secretRef": "<name>"}, which are moved to the SecretEnv of the release
+// so that the controller adds the values of the secrets when jobs are
+// started. If strict is set, unknown fields are an error.
+func decodeReleaseConfig(data []byte, strict bool) (*ct.Release, error) {
+	// the env of config takes precedence over that of the embedded release
+	config := struct {
+		*ct.Release
+		Env map[string]json.RawMessage `json:"env,omitempty"`
+	}{Release: &ct.Release{}}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(&config); err != nil {
+		return nil, err
+	}
+	release := config.Release
+	for key, raw := range config.Env {
+		var value string
+		if err := json.Unmarshal(raw, &value); err == nil {
+			if release.Env == nil {
+				release.Env = make(map[string]string, len(config.Env))
+			}
+			release.Env[key] = value
+			continue
+		}
+		var ref struct {
+			SecretRef string `json:"secretRef"`
+		}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&ref); err != nil || ref.SecretRef == "" {
+			return nil, fmt.Errorf(`invalid value for env var %s, expected a string or {"secretRef": "<name>"}`, key)
+		}
+		if release.SecretEnv == nil {
+			release.SecretEnv = make(map[string]string, len(config.Env))
+		}
+		release.SecretEnv[key] = ref.SecretRef
+	}
+	return release, nil
+}
 
-	log.Printf("Successfully rolled back to release %s.\n", releaseID)
+// dockerImageRef is a reference to an image in a Docker registry, parsed from
+// a Docker artifact URI.
+type dockerImageRef struct {
+	Registry *url.URL
+	Name     string
+	ID       string
+}
+
+// dockerDigestPattern matches a content addressable image digest, for example
+// sha256:<hex>.
+var dockerDigestPattern = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-fA-F0-9]{32,}$`)
 
+// requireDigest returns an error unless the reference is pinned to a
+// content digest, as tags (and names with tags) are mutable.
+func (r *dockerImageRef) requireDigest() error {
+	if i := strings.LastIndex(r.Name, ":"); i > strings.LastIndex(r.Name, "/") {
+		return fmt.Errorf("Docker image name %q includes the mutable tag %q, use id=<digest> instead.", r.Name, r.Name[i+1:])
+	}
+	if !dockerDigestPattern.MatchString(r.ID) {
+		return fmt.Errorf("Docker image id %q is not a digest, expected id=sha256:<digest>.", r.ID)
+	}
 	return nil
 }
+
+// parseDockerURI parses a Docker artifact URI of the form
+// https://registry.example.com?name=<repo>&id=<image-id>.
+func parseDockerURI(uri string) (*dockerImageRef, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Docker image URI %q: %s", uri, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Invalid Docker image URI %q: scheme must be http or https", uri)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("Invalid Docker image URI %q: missing registry host", uri)
+	}
+	q := u.Query()
+	ref := &dockerImageRef{Name: q.Get("name"), ID: q.Get("id")}
+	if ref.Name == "" {
+		return nil, fmt.Errorf("Invalid Docker image URI %q: missing name parameter", uri)
+	}
+	if ref.ID == "" {
+		return nil, fmt.Errorf("Invalid Docker image URI %q: missing id parameter", uri)
+	}
+	ref.Registry = &url.URL{Scheme: u.Scheme, Host: u.Host, User: u.User}
+	return ref, nil
+}
+
+// manifestURL returns the registry URL which is requested to check the image
+// exists, using the v2 API for content digests and the v1 API otherwise.
+func (r *dockerImageRef) manifestURL() string {
+	u := *r.Registry
+	u.User = nil
+	if strings.Contains(r.ID, ":") {
+		u.Path = fmt.Sprintf("/v2/%s/manifests/%s", r.Name, r.ID)
+	} else {
+		u.Path = fmt.Sprintf("/v1/images/%s/json", r.ID)
+	}
+	return u.String()
+}
+
+// verify checks that the image exists in the registry. If the registry
+// requires authentication, the credentials in the URI or otherwise those
+// returned by registryCredentialsFor are used, either directly or exchanged
+// for a bearer token.
+func (r *dockerImageRef) verify() error {
+	var creds *registryCredentials
+	if user := r.Registry.User; user != nil {
+		password, _ := user.Password()
+		creds = &registryCredentials{Username: user.Username(), Password: password}
+	}
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequest("HEAD", r.manifestURL(), nil)
+		if err == nil && creds != nil && creds.Username != "" {
+			req.SetBasicAuth(creds.Username, creds.Password)
+		}
+		return req, err
+	}
+	req, err := newRequest()
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error checking Docker image %s exists (run without --verify to skip): %s", r.ID, err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode == http.StatusUnauthorized {
+		if creds == nil {
+			creds, err = registryCredentialsFor(r.Registry.Host)
+			if err != nil {
+				return fmt.Errorf("Error checking Docker image %s exists (run without --verify to skip): %s", r.ID, err)
+			}
+		}
+		if req, err = newRequest(); err != nil {
+			return err
+		}
+		if err := creds.authorize(client, req, res.Header.Get("Www-Authenticate")); err == errRegistryAuthRequired {
+			return r.authRequiredError()
+		} else if err != nil {
+			return fmt.Errorf("Error authenticating with the Docker registry %s (run without --verify to skip): %s", r.Registry.Host, err)
+		}
+		if res, err = client.Do(req); err != nil {
+			return fmt.Errorf("Error checking Docker image %s exists (run without --verify to skip): %s", r.ID, err)
+		}
+		res.Body.Close()
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("Docker image %s not found in %s", r.ID, r.Name)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		if creds == nil {
+			return r.authRequiredError()
+		}
+		return fmt.Errorf("Access denied to Docker image %s in %s with the configured credentials (run without --verify to skip)", r.ID, r.Name)
+	default:
+		return fmt.Errorf("Error checking Docker image %s exists (run without --verify to skip): unexpected status %d", r.ID, res.StatusCode)
+	}
+}
+
+func (r *dockerImageRef) authRequiredError() error {
+	return fmt.Errorf("Authentication required to check Docker image %s exists in %s: log in with 'docker login %s' or set $FLYNN_DOCKER_USERNAME and $FLYNN_DOCKER_PASSWORD (or run without --verify to skip)", r.ID, r.Name, r.Registry.Host)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/go-docopt"
+)
+
+func runReleaseDelete(args *docopt.Args, client controller.Client) error {
+	if selector := args.String["--match"]; selector != "" {
+		return runReleaseDeleteMatch(args, client, selector)
+	}
+	releaseID := args.String["<id>"]
+	if !args.Bool["--yes"] {
+		if !promptYesNo(fmt.Sprintf("Are you sure you want to delete release %q?", releaseID)) {
+			return nil
+		}
+	}
+	l := &actionLogger{json: args.Bool["--log-json"], app: mustApp()}
+	start := time.Now()
+	res, err := client.DeleteReleaseWithProgress(mustApp(), releaseID, func(p *ct.ReleaseDeletionProgress) {
+		l.Log("release_file_deleted", releaseID, "", time.Time{}, "Deleted file %d/%d: %s", p.Deleted, p.Total, p.File)
+	})
+	if err != nil {
+		return releaseDeletionErr(releaseID, err)
+	}
+	if len(res.RemainingApps) > 0 {
+		l.Log("release_scaled_down", releaseID, "", start, "Release scaled down for app but not fully deleted (still associated with %d other apps)", len(res.RemainingApps))
+	} else {
+		l.Log("release_deleted", releaseID, "", start, "Deleted release %s (deleted %d files)", releaseID, len(res.DeletedFiles))
+	}
+	return nil
+}
+
+// releaseDeletionErr describes err, explaining how to finish deleting the
+// files of the release if deleting them failed partway.
+func releaseDeletionErr(releaseID string, err error) error {
+	if e, ok := err.(*ct.ReleaseDeletionError); ok {
+		return fmt.Errorf("Error deleting the files of release %s: %s, deleted %d of %d files; re-run 'flynn release delete %s' to finish.", releaseID, e.Err, len(e.Deletion.DeletedFiles), e.Deletion.TotalFiles, releaseID)
+	}
+	return err
+}
+
+func runReleaseGC(args *docopt.Args, client controller.Client) error {
+	keep, err := strconv.Atoi(args.String["--keep"])
+	if err != nil || keep < 0 {
+		return fmt.Errorf("Invalid --keep value %q, expected a number of releases.", args.String["--keep"])
+	}
+	var keepDays int
+	if s := args.String["--keep-days"]; s != "" {
+		keepDays, err = strconv.Atoi(s)
+		if err != nil || keepDays < 0 {
+			return fmt.Errorf("Invalid --keep-days value %q, expected a number of days.", s)
+		}
+	}
+
+	app := mustApp()
+	releases, err := client.AppReleaseList(app)
+	if err != nil {
+		return err
+	}
+	currentID, err := currentReleaseID(client)
+	if err != nil {
+		return err
+	}
+	candidates := gcReleases(releases, currentID, keep, keepDays, time.Now())
+	if len(candidates) == 0 {
+		fmt.Println("No releases to delete.")
+		return nil
+	}
+
+	return deleteReleases(args, client, app, candidates)
+}
+
+// releaseSelector matches releases for "flynn release delete --match".
+type releaseSelector struct {
+	metaKey string // empty when matching the release ID
+	pattern string
+}
+
+// parseReleaseSelector parses a selector of the form meta.<key>=<glob> or
+// id=<glob>.
+func parseReleaseSelector(s string) (*releaseSelector, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return nil, fmt.Errorf("Invalid --match value %q, expected meta.<key>=<glob> or id=<glob>.", s)
+	}
+	field, pattern := s[:i], s[i+1:]
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("Invalid --match pattern %q: %s", pattern, err)
+	}
+	switch {
+	case field == "id":
+		return &releaseSelector{pattern: pattern}, nil
+	case strings.HasPrefix(field, "meta.") && len(field) > len("meta."):
+		return &releaseSelector{metaKey: strings.TrimPrefix(field, "meta."), pattern: pattern}, nil
+	}
+	return nil, fmt.Errorf("Invalid --match field %q, expected meta.<key> or id.", field)
+}
+
+func (s *releaseSelector) Match(r *ct.Release) bool {
+	value := r.ID
+	if s.metaKey != "" {
+		var ok bool
+		if value, ok = r.Meta[s.metaKey]; !ok {
+			return false
+		}
+	}
+	matched, _ := path.Match(s.pattern, value)
+	return matched
+}
+
+func runReleaseDeleteMatch(args *docopt.Args, client controller.Client, selector string) error {
+	sel, err := parseReleaseSelector(selector)
+	if err != nil {
+		return err
+	}
+	app := mustApp()
+	releases, err := client.AppReleaseList(app)
+	if err != nil {
+		return err
+	}
+	currentID, err := currentReleaseID(client)
+	if err != nil {
+		return err
+	}
+	var candidates []*ct.Release
+	for _, r := range releases {
+		if r.ID != currentID && sel.Match(r) {
+			candidates = append(candidates, r)
+		}
+	}
+	if len(candidates) == 0 {
+		fmt.Println("No releases to delete.")
+		return nil
+	}
+	return deleteReleases(args, client, app, candidates)
+}
+
+// deleteReleases deletes the given releases of app other than those also
+// used by other apps, or with --dry-run prints the releases which would be
+// deleted. Releases which fail to be deleted are reported and returned as an
+// error once the others have been deleted.
+func deleteReleases(args *docopt.Args, client controller.Client, app string, candidates []*ct.Release) error {
+	concurrency := 1
+	if s := args.String["--concurrency"]; s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return fmt.Errorf("Invalid --concurrency value %q, expected a positive number.", s)
+		}
+		concurrency = n
+	}
+	shared, err := releasesUsedByOtherApps(client, app)
+	if err != nil {
+		return err
+	}
+	var deletable []*ct.Release
+	for _, r := range candidates {
+		if shared[r.ID] {
+			fmt.Printf("Skipping release %s (still associated with other apps)\n", r.ID)
+			continue
+		}
+		deletable = append(deletable, r)
+	}
+	if len(deletable) == 0 {
+		fmt.Println("No releases to delete.")
+		return nil
+	}
+
+	if args.Bool["--dry-run"] {
+		for _, r := range deletable {
+			fmt.Printf("Would delete release %s (created %s)\n", r.ID, humanTime(r.CreatedAt))
+		}
+		return nil
+	}
+	if !args.Bool["--yes"] {
+		if !promptYesNo(fmt.Sprintf("Are you sure you want to delete %d releases?", len(deletable))) {
+			return nil
+		}
+	}
+
+	l := &actionLogger{json: args.Bool["--log-json"], app: app}
+	var deleted, files int
+	var failed []string
+	deletions := startReleaseDeletions(client, app, deletable, concurrency)
+	for i, r := range deletable {
+		d := deletions[i]
+		<-d.done
+		// report the time the deletion itself took rather than including
+		// the time spent waiting for earlier releases to be reported
+		start := time.Now().Add(-d.duration)
+		if d.err != nil {
+			failed = append(failed, r.ID)
+			msg := fmt.Sprintf("Error deleting release %s: %s", r.ID, d.err)
+			if _, ok := d.err.(*ct.ReleaseDeletionError); ok {
+				msg = releaseDeletionErr(r.ID, d.err).Error()
+			}
+			l.Log("release_delete_failed", r.ID, "", start, "%s", msg)
+			continue
+		}
+		if len(d.res.RemainingApps) > 0 {
+			// the release was associated with another app after we
+			// checked, so it has only been scaled down for this app
+			l.Log("release_scaled_down", r.ID, "", start, "Release %s scaled down for app but not fully deleted (still associated with %d other apps)", r.ID, len(d.res.RemainingApps))
+			continue
+		}
+		deleted++
+		files += len(d.res.DeletedFiles)
+		l.Log("release_deleted", r.ID, "", start, "Deleted release %s (deleted %d files)", r.ID, len(d.res.DeletedFiles))
+	}
+	if !l.json {
+		fmt.Printf("Deleted %d releases (deleted %d files)\n", deleted, files)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Failed to delete %d releases: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// releaseDeletion is the outcome of deleting one of a batch of releases.
+type releaseDeletion struct {
+	res      *ct.ReleaseDeletion
+	err      error
+	duration time.Duration
+
+	// done is closed once the release has been deleted (or failed to be)
+	done chan struct{}
+}
+
+// startReleaseDeletions starts deleting releases of app using up to
+// concurrency requests at once, returning their deletions in the same
+// order as releases.
+func startReleaseDeletions(client controller.Client, app string, releases []*ct.Release, concurrency int) []*releaseDeletion {
+	deletions := make([]*releaseDeletion, len(releases))
+	for i := range deletions {
+		deletions[i] = &releaseDeletion{done: make(chan struct{})}
+	}
+	next := make(chan int)
+	go func() {
+		for i := range releases {
+			next <- i
+		}
+		close(next)
+	}()
+	for n := 0; n < concurrency && n < len(releases); n++ {
+		go func() {
+			for i := range next {
+				d := deletions[i]
+				start := time.Now()
+				d.res, d.err = client.DeleteRelease(app, releases[i].ID)
+				d.duration = time.Since(start)
+				close(d.done)
+			}
+		}()
+	}
+	return deletions
+}
+
+// gcReleases returns the releases which should be garbage collected, given
+// releases sorted newest first. The current release, the keep most recent
+// releases and, if keepDays is non-zero, releases created within keepDays of
+// now are never returned.
+func gcReleases(releases []*ct.Release, currentID string, keep, keepDays int, now time.Time) []*ct.Release {
+	var cutoff time.Time
+	if keepDays > 0 {
+		cutoff = now.AddDate(0, 0, -keepDays)
+	}
+	var res []*ct.Release
+	for i, r := range releases {
+		if i < keep || r.ID == currentID {
+			continue
+		}
+		if !cutoff.IsZero() && r.CreatedAt != nil && r.CreatedAt.After(cutoff) {
+			continue
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// releasesUsedByOtherApps returns the IDs of releases which are associated
+// with apps other than the given one, which DeleteRelease would only scale
+// down rather than delete.
+func releasesUsedByOtherApps(client controller.Client, appName string) (map[string]bool, error) {
+	app, err := client.GetApp(appName)
+	if err != nil {
+		return nil, err
+	}
+	apps, err := client.AppList()
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool)
+	for _, a := range apps {
+		if a.ID == app.ID {
+			continue
+		}
+		releases, err := client.AppReleaseList(a.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range releases {
+			used[r.ID] = true
+		}
+	}
+	return used, nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/host/types"
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestGCReleases(c *C) {
+	now := time.Date(2016, 1, 10, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(n int) *time.Time {
+		t := now.AddDate(0, 0, -n)
+		return &t
+	}
+	// releases are sorted newest first
+	releases := []*ct.Release{
+		{ID: "r6", CreatedAt: daysAgo(0)},
+		{ID: "r5", CreatedAt: daysAgo(1)},
+		{ID: "r4", CreatedAt: daysAgo(2)},
+		{ID: "r3", CreatedAt: daysAgo(5)},
+		{ID: "r2", CreatedAt: daysAgo(8)},
+		{ID: "r1", CreatedAt: daysAgo(9)},
+	}
+	ids := func(releases []*ct.Release) []string {
+		res := make([]string, len(releases))
+		for i, r := range releases {
+			res[i] = r.ID
+		}
+		return res
+	}
+
+	for _, t := range []struct {
+		current  string
+		keep     int
+		keepDays int
+		expected []string
+	}{
+		{current: "r6", keep: 2, expected: []string{"r4", "r3", "r2", "r1"}},
+		// the current release is never deleted, even if it is old
+		{current: "r2", keep: 2, expected: []string{"r4", "r3", "r1"}},
+		{current: "r6", keep: 0, expected: []string{"r5", "r4", "r3", "r2", "r1"}},
+		{current: "r6", keep: 6, expected: []string{}},
+		// releases newer than keepDays are kept beyond the keep count
+		{current: "r6", keep: 2, keepDays: 7, expected: []string{"r2", "r1"}},
+	} {
+		c.Assert(ids(gcReleases(releases, t.current, t.keep, t.keepDays, now)), DeepEquals, t.expected,
+			Commentf("current = %s, keep = %d, keepDays = %d", t.current, t.keep, t.keepDays))
+	}
+}
+
+func (S) TestReleaseDeleteProgress(c *C) {
+	client, app := newFakeApp(c, &ct.Release{})
+	first, err := client.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	slug := &ct.Artifact{
+		Type: host.ArtifactTypeFile,
+		URI:  "http://blobstore.discoverd/slugs/1.tgz",
+		Meta: map[string]string{"blobstore": "true"},
+	}
+	c.Assert(client.CreateArtifact(slug), IsNil)
+	release := &ct.Release{ArtifactIDs: []string{first.ArtifactIDs[0], slug.ID}}
+	c.Assert(client.CreateRelease(release), IsNil)
+	mustDeploy(c, client, app.ID, release.ID)
+	mustDeploy(c, client, app.ID, first.ID)
+
+	var progress []*ct.ReleaseDeletionProgress
+	res, err := client.DeleteReleaseWithProgress(app.ID, release.ID, func(p *ct.ReleaseDeletionProgress) {
+		progress = append(progress, p)
+	})
+	c.Assert(err, IsNil)
+	c.Assert(res.DeletedFiles, DeepEquals, []string{slug.URI})
+	c.Assert(progress, DeepEquals, []*ct.ReleaseDeletionProgress{
+		{AppID: app.ID, ReleaseID: release.ID, File: slug.URI, Deleted: 1, Total: 1},
+	})
+
+	// the command deletes releases with progress
+	other := &ct.Release{ArtifactIDs: release.ArtifactIDs}
+	c.Assert(client.CreateRelease(other), IsNil)
+	mustDeploy(c, client, app.ID, other.ID)
+	mustDeploy(c, client, app.ID, first.ID)
+	c.Assert(runReleaseCommand(c, client, app.Name, "delete", "-y", other.ID), IsNil)
+	c.Assert(client.DeletedReleases(), DeepEquals, []string{release.ID, other.ID})
+}
+
+func (S) TestReleaseDeleteResume(c *C) {
+	client, app := newFakeApp(c, &ct.Release{})
+	first, err := client.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	release := &ct.Release{ArtifactIDs: []string{first.ArtifactIDs[0]}}
+	var slugs []string
+	for i := 1; i <= 3; i++ {
+		slug := &ct.Artifact{
+			Type: host.ArtifactTypeFile,
+			URI:  fmt.Sprintf("http://blobstore.discoverd/slugs/%d.tgz", i),
+			Meta: map[string]string{"blobstore": "true"},
+		}
+		c.Assert(client.CreateArtifact(slug), IsNil)
+		release.ArtifactIDs = append(release.ArtifactIDs, slug.ID)
+		slugs = append(slugs, slug.URI)
+	}
+	c.Assert(client.CreateRelease(release), IsNil)
+	mustDeploy(c, client, app.ID, release.ID)
+	mustDeploy(c, client, app.ID, first.ID)
+
+	// a storage error partway reports the progress made
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	client.FileDeleteErrs = map[string]error{slugs[1]: errors.New("storage unavailable")}
+	err = runReleaseCommand(c, client, app.Name, "delete", "-y", release.ID)
+	c.Assert(err, ErrorMatches, fmt.Sprintf(`Error deleting the files of release %s: storage unavailable, deleted 1 of 3 files; re-run 'flynn release delete %[1]s' to finish.`, release.ID))
+	c.Assert(strings.Contains(logs.String(), "Deleted file 1/3: "+slugs[0]), Equals, true)
+	c.Assert(strings.Contains(logs.String(), "Deleted file 2/3"), Equals, false)
+
+	// re-running only deletes the remaining files
+	delete(client.FileDeleteErrs, slugs[1])
+	var progress []string
+	res, err := client.DeleteReleaseWithProgress(app.ID, release.ID, func(p *ct.ReleaseDeletionProgress) {
+		progress = append(progress, fmt.Sprintf("%d/%d %s", p.Deleted, p.Total, p.File))
+	})
+	c.Assert(err, IsNil)
+	c.Assert(progress, DeepEquals, []string{"2/3 " + slugs[1], "3/3 " + slugs[2]})
+	c.Assert(res.DeletedFiles, DeepEquals, slugs)
+	c.Assert(res.TotalFiles, Equals, 3)
+	c.Assert(client.DeletedReleases(), DeepEquals, []string{release.ID})
+
+	// once finished, the release is gone
+	c.Assert(runReleaseCommand(c, client, app.Name, "delete", "-y", release.ID), Equals, controller.ErrNotFound)
+}
+
+func (S) TestReleaseDeleteMatch(c *C) {
+	client, app := newFakeApp(c, &ct.Release{Meta: map[string]string{"version": "1.3.0"}})
+	first, err := client.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	deploy := func(version string) *ct.Release {
+		r := &ct.Release{ArtifactIDs: first.ArtifactIDs, Meta: map[string]string{"version": version}}
+		c.Assert(client.CreateRelease(r), IsNil)
+		mustDeploy(c, client, app.ID, r.ID)
+		return r
+	}
+	second := deploy("1.3.1")
+	deploy("1.4.0")
+	deploy("1.3.2")
+
+	for _, selector := range []string{"version=1.3.*", "meta.=1.3.*", "meta.version", "id=[", "env.FOO=1"} {
+		err := runReleaseCommand(c, client, app.Name, "delete", "--match", selector, "-y")
+		c.Assert(err, ErrorMatches, "Invalid --match .*", Commentf("%s", selector))
+	}
+
+	// a dry run doesn't delete anything
+	c.Assert(runReleaseCommand(c, client, app.Name, "delete", "--match", "meta.version=1.3.*", "--dry-run"), IsNil)
+	c.Assert(client.DeletedReleases(), HasLen, 0)
+
+	// the current release is never deleted
+	c.Assert(runReleaseCommand(c, client, app.Name, "delete", "--match", "meta.version=1.3.*", "-y"), IsNil)
+	c.Assert(client.DeletedReleases(), DeepEquals, []string{second.ID, first.ID})
+
+	sel, err := parseReleaseSelector("id=" + second.ID[:8] + "*")
+	c.Assert(err, IsNil)
+	c.Assert(sel.Match(second), Equals, true)
+	c.Assert(sel.Match(first), Equals, false)
+}
+
+func (S) TestReleaseGCConcurrency(c *C) {
+	client, app := newFakeApp(c, &ct.Release{})
+	first, err := client.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	ids := []string{first.ID}
+	var failing string
+	for i := 0; i < 6; i++ {
+		r := &ct.Release{ArtifactIDs: first.ArtifactIDs}
+		if i == 2 {
+			slug := &ct.Artifact{
+				Type: host.ArtifactTypeFile,
+				URI:  "http://blobstore.discoverd/slugs/failing.tgz",
+				Meta: map[string]string{"blobstore": "true"},
+			}
+			c.Assert(client.CreateArtifact(slug), IsNil)
+			r.ArtifactIDs = append([]string{first.ArtifactIDs[0]}, slug.ID)
+		}
+		c.Assert(client.CreateRelease(r), IsNil)
+		if i == 2 {
+			failing = r.ID
+		}
+		mustDeploy(c, client, app.ID, r.ID)
+		ids = append(ids, r.ID)
+	}
+	client.FileDeleteErrs = map[string]error{"http://blobstore.discoverd/slugs/failing.tgz": errors.New("storage unavailable")}
+
+	err = runReleaseCommand(c, client, app.Name, "gc", "--keep=1", "--concurrency=0", "-y")
+	c.Assert(err, ErrorMatches, `Invalid --concurrency value "0", .*`)
+
+	// every release other than the current one is tried, with the failure
+	// reported once the others have been deleted and the output in the
+	// order of the releases
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	log.SetFlags(0)
+	defer log.SetFlags(log.LstdFlags)
+	out := captureStdout(c, func() {
+		err = runReleaseCommand(c, client, app.Name, "gc", "--keep=1", "--concurrency=3", "-y")
+	})
+	c.Assert(err, ErrorMatches, fmt.Sprintf("Failed to delete 1 releases: %s", failing))
+	c.Assert(out, Equals, "Deleted 5 releases (deleted 0 files)\n")
+	var expected []string
+	for i := len(ids) - 2; i >= 0; i-- {
+		if ids[i] == failing {
+			expected = append(expected, fmt.Sprintf("Error deleting the files of release %s: storage unavailable, deleted 0 of 1 files; re-run 'flynn release delete %[1]s' to finish.", failing))
+			continue
+		}
+		expected = append(expected, fmt.Sprintf("Deleted release %s (deleted 0 files)", ids[i]))
+	}
+	c.Assert(strings.Split(strings.TrimSpace(logs.String()), "\n"), DeepEquals, expected)
+	deleted := client.DeletedReleases()
+	sort.Strings(deleted)
+	expected = append([]string(nil), ids[:len(ids)-1]...)
+	sort.Strings(expected)
+	c.Assert(deleted, DeepEquals, expected)
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/version"
+	"github.com/flynn/go-docopt"
+)
+
+// releaseBundle is the document written by release export, a release along
+// with its artifacts so that it can be recreated by release import.
+//
+// The fields beginning with an underscore describe the export for people
+// reading it, and are ignored by import so that they can be freely edited.
+type releaseBundle struct {
+	Comment    string     `json:"_comment,omitempty"`
+	App        string     `json:"_app,omitempty"`
+	Cluster    string     `json:"_cluster,omitempty"`
+	ExportedAt *time.Time `json:"_exported_at,omitempty"`
+	CLIVersion string     `json:"_cli_version,omitempty"`
+
+	Release   *ct.Release    `json:"release"`
+	Artifacts []*ct.Artifact `json:"artifacts"`
+}
+
+const releaseBundleComment = "A Flynn release and its artifacts, exported with 'flynn release export'. Recreate it with 'flynn release import' or 'flynn release restore'. Fields beginning with an underscore are ignored on import."
+
+func newReleaseBundle(client controller.Client, release *ct.Release) (*releaseBundle, error) {
+	now := time.Now().UTC()
+	bundle := &releaseBundle{
+		Comment:    releaseBundleComment,
+		ExportedAt: &now,
+		CLIVersion: version.String(),
+		Release:    release,
+		Artifacts:  make([]*ct.Artifact, 0, len(release.ArtifactIDs)),
+	}
+	if name, err := app(); err == nil {
+		bundle.App = name
+	}
+	if cluster, err := getCluster(); err == nil {
+		bundle.Cluster = cluster.Name
+	}
+	for _, id := range release.ArtifactIDs {
+		artifact, err := client.GetArtifact(id)
+		if err != nil {
+			return nil, fmt.Errorf("error getting artifact %s of release %s: %s", id, release.ID, err)
+		}
+		bundle.Artifacts = append(bundle.Artifacts, artifact)
+	}
+	return bundle, nil
+}
+
+func runReleaseExport(args *docopt.Args, client controller.Client) error {
+	output := args.String["--output"]
+	if args.Bool["--all"] {
+		releases, err := client.AppReleaseList(mustApp())
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(output, 0700); err != nil {
+			return err
+		}
+		for _, release := range releases {
+			bundle, err := newReleaseBundle(client, release)
+			if err != nil {
+				return err
+			}
+			if err := writeReleaseBundle(filepath.Join(output, release.ID+".json"), bundle); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Exported %d releases to %s.\n", len(releases), output)
+		return nil
+	}
+
+	var release *ct.Release
+	var err error
+	if id := args.String["<id>"]; id != "" {
+		release, err = client.GetRelease(id)
+	} else {
+		release, err = client.GetAppRelease(mustApp())
+	}
+	if err != nil {
+		return err
+	}
+	bundle, err := newReleaseBundle(client, release)
+	if err != nil {
+		return err
+	}
+	if output == "" || output == "-" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(bundle)
+	}
+	return writeReleaseBundle(output, bundle)
+}
+
+// writeReleaseBundle writes bundle to path, making it only accessible by
+// the current user as release env often contains secrets.
+func writeReleaseBundle(path string, bundle *releaseBundle) error {
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// an existing file keeps its permissions when opened, so restrict them
+	if err := f.Chmod(0600); err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func readReleaseBundle(path string) (*releaseBundle, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// drop the annotations before decoding so that editing them (e.g.
+	// rewriting _exported_at in another format) can't break the import
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %s", path, err)
+	}
+	for k := range doc {
+		if strings.HasPrefix(k, "_") {
+			delete(doc, k)
+		}
+	}
+	if data, err = json.Marshal(doc); err != nil {
+		return nil, err
+	}
+	bundle := &releaseBundle{}
+	if err := json.Unmarshal(data, bundle); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %s", path, err)
+	}
+	if bundle.Release == nil {
+		return nil, fmt.Errorf("%s is not a release export, it has no release", path)
+	}
+	return bundle, nil
+}
+
+// readReleaseBundles reads the release export at path, or if path is a
+// directory, every release export in it, ordered oldest first.
+func readReleaseBundles(path string) ([]*releaseBundle, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{path}
+	if info.IsDir() {
+		paths, err = filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	bundles := make([]*releaseBundle, 0, len(paths))
+	for _, p := range paths {
+		bundle, err := readReleaseBundle(p)
+		if err != nil {
+			return nil, err
+		}
+		bundles = append(bundles, bundle)
+	}
+	sort.Stable(bundlesByCreatedAt(bundles))
+	return bundles, nil
+}
+
+type bundlesByCreatedAt []*releaseBundle
+
+func (b bundlesByCreatedAt) Len() int      { return len(b) }
+func (b bundlesByCreatedAt) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b bundlesByCreatedAt) Less(i, j int) bool {
+	ti, tj := b[i].Release.CreatedAt, b[j].Release.CreatedAt
+	if ti == nil || tj == nil {
+		return tj != nil
+	}
+	return ti.Before(*tj)
+}
+
+// importRelease creates the release in bundle along with any of its
+// artifacts which don't exist, keeping their IDs. It returns false without
+// creating anything if the release already exists.
+func importRelease(client controller.Client, bundle *releaseBundle) (bool, error) {
+	release := bundle.Release
+	if release.ID != "" {
+		if _, err := client.GetRelease(release.ID); err == nil {
+			return false, nil
+		} else if !controller.IsNotFound(err) {
+			return false, err
+		}
+	}
+	for _, artifact := range bundle.Artifacts {
+		if _, err := client.GetArtifact(artifact.ID); controller.IsNotFound(err) {
+			if err := client.CreateArtifact(artifact); err != nil {
+				return false, fmt.Errorf("error creating artifact %s: %s", artifact.ID, err)
+			}
+		} else if err != nil {
+			return false, err
+		}
+	}
+	if err := client.CreateRelease(release); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func runReleaseImport(args *docopt.Args, client controller.Client) error {
+	bundles, err := readReleaseBundles(args.String["<path>"])
+	if err != nil {
+		return err
+	}
+	quiet := args.Bool["--quiet"]
+	for _, bundle := range bundles {
+		created, err := importRelease(client, bundle)
+		if err != nil {
+			return err
+		}
+		switch {
+		case quiet:
+			if created {
+				fmt.Println(bundle.Release.ID)
+			}
+		case created:
+			fmt.Printf("Imported release %s.\n", bundle.Release.ID)
+		default:
+			fmt.Printf("Skipped release %s which already exists.\n", bundle.Release.ID)
+		}
+	}
+	return nil
+}
+
+func runReleaseRestore(args *docopt.Args, client controller.Client) error {
+	if !args.Bool["--force"] {
+		if err := checkReleaseLock(client); err != nil {
+			return err
+		}
+	}
+	bundle, err := readReleaseBundle(args.String["<file>"])
+	if err != nil {
+		return err
+	}
+	l := &actionLogger{json: args.Bool["--log-json"], quiet: args.Bool["--quiet"], app: mustApp()}
+
+	release, err := client.GetRelease(bundle.Release.ID)
+	if err == nil {
+		l.Log("release_exists", release.ID, "", time.Time{}, "Release %s still exists, deploying it.\n", release.ID)
+	} else if controller.IsNotFound(err) {
+		if release, err = restoreRelease(client, l, bundle); err != nil {
+			return err
+		}
+	} else {
+		return err
+	}
+
+	if _, err := deployRelease(client, l, release.ID); err != nil {
+		return err
+	}
+	l.Log("restore_finished", release.ID, "", time.Time{}, "Restored and deployed release %s.\n", release.ID)
+	l.Result(release.ID)
+	return nil
+}
+
+// restoreRelease recreates the deleted release in bundle with a new ID,
+// reusing its artifacts which still exist and recreating the rest (also
+// with new IDs, as the IDs of deleted artifacts can't be reused).
+func restoreRelease(client controller.Client, l *actionLogger, bundle *releaseBundle) (*ct.Release, error) {
+	artifacts := make(map[string]*ct.Artifact, len(bundle.Artifacts))
+	for _, a := range bundle.Artifacts {
+		artifacts[a.ID] = a
+	}
+	old := bundle.Release
+	release := &ct.Release{
+		Env:       old.Env,
+		SecretEnv: old.SecretEnv,
+		Meta:      old.Meta,
+		Processes: old.Processes,
+	}
+	for _, id := range old.ArtifactIDs {
+		if _, err := client.GetArtifact(id); err == nil {
+			l.Log("artifact_reused", old.ID, id, time.Time{}, "Reusing artifact %s which still exists.\n", id)
+			release.ArtifactIDs = append(release.ArtifactIDs, id)
+			continue
+		} else if !controller.IsNotFound(err) {
+			return nil, err
+		}
+		exported, ok := artifacts[id]
+		if !ok {
+			return nil, fmt.Errorf("artifact %s of release %s no longer exists and isn't in the export", id, old.ID)
+		}
+		artifact := &ct.Artifact{Type: exported.Type, URI: exported.URI, Meta: exported.Meta}
+		if err := client.CreateArtifact(artifact); err != nil {
+			return nil, fmt.Errorf("error recreating artifact %s: %s", id, err)
+		}
+		l.Log("artifact_recreated", old.ID, artifact.ID, time.Time{}, "Recreated artifact %s as %s.\n", id, artifact.ID)
+		if artifact.Blobstore() {
+			l.Log("artifact_file_warning", release.ID, artifact.ID, time.Time{}, "Warning: artifact %s is a blobstore file (%s) which may have been deleted with the release.\n", artifact.ID, artifact.URI)
+		}
+		release.ArtifactIDs = append(release.ArtifactIDs, artifact.ID)
+	}
+	if err := client.CreateRelease(release); err != nil {
+		return nil, err
+	}
+	l.Log("release_restored", release.ID, "", time.Time{}, "Recreated release %s as %s.\n", old.ID, release.ID)
+	return release, nil
+}
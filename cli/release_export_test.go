@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/flynn/flynn/controller/client/fake"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/version"
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestReleaseExportImport(c *C) {
+	client, app := newFakeApp(c, &ct.Release{Env: map[string]string{"SECRET": "1"}})
+	c.Assert(runReleaseCommand(c, client, app.Name, "update", writeTempFile(c, `{"env": {"SECRET": "2"}}`)), IsNil)
+	releases := client.CreatedReleases()
+	c.Assert(releases, HasLen, 2)
+
+	// a single release is exported to a file only the user can read
+	dir := c.MkDir()
+	file := filepath.Join(dir, "release.json")
+	c.Assert(ioutil.WriteFile(file, []byte("old"), 0644), IsNil)
+	c.Assert(runReleaseCommand(c, client, app.Name, "export", "-o", file, releases[0].ID), IsNil)
+	info, err := os.Stat(file)
+	c.Assert(err, IsNil)
+	c.Assert(info.Mode().Perm(), Equals, os.FileMode(0600))
+	bundle, err := readReleaseBundle(file)
+	c.Assert(err, IsNil)
+	c.Assert(bundle.Release.ID, Equals, releases[0].ID)
+	c.Assert(bundle.Release.Env, DeepEquals, map[string]string{"SECRET": "1"})
+	c.Assert(bundle.Artifacts, HasLen, 1)
+	c.Assert(bundle.Artifacts[0].ID, Equals, releases[0].ArtifactIDs[0])
+
+	// the export describes itself, and import ignores its annotations
+	var doc map[string]interface{}
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, IsNil)
+	c.Assert(json.Unmarshal(data, &doc), IsNil)
+	c.Assert(doc["_comment"], Equals, releaseBundleComment)
+	c.Assert(doc["_app"], Equals, app.Name)
+	c.Assert(doc["_cli_version"], Equals, version.String())
+	exportedAt, err := time.Parse(time.RFC3339Nano, doc["_exported_at"].(string))
+	c.Assert(err, IsNil)
+	c.Assert(time.Since(exportedAt) < time.Minute, Equals, true)
+	doc["_exported_at"] = "yesterday"
+	doc["_note"] = "rotate SECRET before importing"
+	data, err = json.Marshal(doc)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(file, data, 0600), IsNil)
+	bundle, err = readReleaseBundle(file)
+	c.Assert(err, IsNil)
+	c.Assert(bundle.Release.ID, Equals, releases[0].ID)
+	c.Assert(bundle.ExportedAt, IsNil)
+
+	// --all exports a file per release
+	exportDir := filepath.Join(dir, "releases")
+	c.Assert(runReleaseCommand(c, client, app.Name, "export", "--all", "-o", exportDir), IsNil)
+	for _, r := range releases {
+		info, err := os.Stat(filepath.Join(exportDir, r.ID+".json"))
+		c.Assert(err, IsNil)
+		c.Assert(info.Mode().Perm(), Equals, os.FileMode(0600))
+	}
+
+	// importing into another cluster recreates the releases and artifacts
+	other := fake.NewClient()
+	otherApp := &ct.App{Name: "restored"}
+	c.Assert(other.CreateApp(otherApp), IsNil)
+	out := captureStdout(c, func() {
+		c.Assert(runReleaseCommand(c, other, otherApp.Name, "import", exportDir), IsNil)
+	})
+	c.Assert(out, Equals, fmt.Sprintf("Imported release %s.\nImported release %s.\n", releases[0].ID, releases[1].ID))
+	for _, r := range releases {
+		imported, err := other.GetRelease(r.ID)
+		c.Assert(err, IsNil)
+		c.Assert(imported.Env, DeepEquals, r.Env)
+		c.Assert(imported.ArtifactIDs, DeepEquals, r.ArtifactIDs)
+	}
+	// both releases use the same artifact, which is only created once
+	c.Assert(other.CreatedArtifacts(), HasLen, 1)
+
+	// importing again skips the existing releases
+	out = captureStdout(c, func() {
+		c.Assert(runReleaseCommand(c, other, otherApp.Name, "import", "-q", exportDir), IsNil)
+	})
+	c.Assert(out, Equals, "")
+	c.Assert(other.CreatedReleases(), HasLen, 2)
+}
+
+func (S) TestReleaseRestore(c *C) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	client, app := newFakeApp(c, &ct.Release{})
+	first, err := client.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	file := &ct.Artifact{Type: host.ArtifactTypeFile, URI: "https://example.com/slug.tgz"}
+	c.Assert(client.CreateArtifact(file), IsNil)
+	deleted := &ct.Release{ArtifactIDs: []string{first.ArtifactIDs[0], file.ID}, Env: map[string]string{"A": "1"}}
+	c.Assert(client.CreateRelease(deleted), IsNil)
+	mustDeploy(c, client, app.ID, deleted.ID)
+
+	// export the release, then roll back and delete it along with its file
+	export := filepath.Join(c.MkDir(), "release.json")
+	c.Assert(runReleaseCommand(c, client, app.Name, "export", "-o", export), IsNil)
+	c.Assert(runReleaseCommand(c, client, app.Name, "rollback", "-y", first.ID), IsNil)
+	_, err = client.DeleteRelease(app.ID, deleted.ID)
+	c.Assert(err, IsNil)
+	c.Assert(client.DeleteArtifact(file.ID), IsNil)
+
+	// restoring reuses the image artifact, recreates the file artifact and
+	// deploys the recreated release
+	out := captureStdout(c, func() {
+		c.Assert(runReleaseCommand(c, client, app.Name, "restore", "-q", export), IsNil)
+	})
+	current, err := client.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, current.ID+"\n")
+	c.Assert(current.ID, Not(Equals), deleted.ID)
+	c.Assert(current.Env, DeepEquals, deleted.Env)
+	c.Assert(current.ArtifactIDs, HasLen, 2)
+	c.Assert(current.ArtifactIDs[0], Equals, first.ArtifactIDs[0])
+	c.Assert(current.ArtifactIDs[1], Not(Equals), file.ID)
+	recreated, err := client.GetArtifact(current.ArtifactIDs[1])
+	c.Assert(err, IsNil)
+	c.Assert(recreated.URI, Equals, file.URI)
+
+	// without -q, whether each artifact was reused or recreated is reported
+	c.Assert(runReleaseCommand(c, client, app.Name, "rollback", "-y", first.ID), IsNil)
+	_, err = client.DeleteRelease(app.ID, current.ID)
+	c.Assert(err, IsNil)
+	c.Assert(client.DeleteArtifact(recreated.ID), IsNil)
+	logs.Reset()
+	c.Assert(runReleaseCommand(c, client, app.Name, "restore", export), IsNil)
+	c.Assert(logs.String(), Matches, fmt.Sprintf("(?s).*Reusing artifact %s which still exists.*Recreated artifact %s as .*Recreated release %s as .*Restored and deployed release .*", first.ArtifactIDs[0], file.ID, deleted.ID))
+
+	// a release which still exists is just deployed
+	current, err = client.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(runReleaseCommand(c, client, app.Name, "rollback", "-y", first.ID), IsNil)
+	bundle, err := newReleaseBundle(client, current)
+	c.Assert(err, IsNil)
+	existing := filepath.Join(c.MkDir(), "existing.json")
+	c.Assert(writeReleaseBundle(existing, bundle), IsNil)
+	releases := len(client.CreatedReleases())
+	c.Assert(runReleaseCommand(c, client, app.Name, "restore", existing), IsNil)
+	c.Assert(client.CreatedReleases(), HasLen, releases)
+	release, err := client.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(release.ID, Equals, current.ID)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/go-docopt"
+)
+
+func runReleaseHistory(args *docopt.Args, client controller.Client) error {
+	format, err := parseTimeFormat(args.String["--time-format"])
+	if err != nil {
+		return err
+	}
+	typ := args.String["<process>"]
+	releases, err := client.AppReleaseList(mustApp())
+	if err != nil {
+		return err
+	}
+	history := processHistory(releases, typ)
+	if len(history) == 0 {
+		return fmt.Errorf("No releases of the app have a %q process type.", typ)
+	}
+	if args.Bool["--json"] {
+		return json.NewEncoder(os.Stdout).Encode(history)
+	}
+	for i, change := range history {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s  %s", change.ReleaseID, format.Format(change.CreatedAt))
+		if change.Author != "" {
+			fmt.Printf("  %s", change.Author)
+		}
+		fmt.Println()
+		switch {
+		case change.Added:
+			fmt.Printf("  + %s added\n", typ)
+		case change.Removed:
+			fmt.Printf("  - %s removed\n", typ)
+		}
+		for _, field := range processHistoryFields {
+			c, ok := change.Changes[field.name]
+			if !ok {
+				continue
+			}
+			if change.Added {
+				fmt.Printf("    %s: %s\n", field.name, c.New)
+			} else {
+				fmt.Printf("  ~ %s: %s -> %s\n", field.name, orNone(c.Old), orNone(c.New))
+			}
+		}
+	}
+	return nil
+}
+
+// processHistoryFields are the fields of process types which release
+// history shows changes to, in the order it shows them, along with how
+// their values are formatted.
+var processHistoryFields = []struct {
+	name   string
+	format func(ct.ProcessType) string
+}{
+	{"cmd", func(p ct.ProcessType) string { return strings.Join(p.Cmd, " ") }},
+	{"entrypoint", func(p ct.ProcessType) string { return strings.Join(p.Entrypoint, " ") }},
+	{"ports", func(p ct.ProcessType) string { return formatPorts(p.Ports) }},
+	{"resources", func(p ct.ProcessType) string { return formatResourceLimits(p.Resources) }},
+}
+
+// processChange is a release which added or removed a process type, or
+// changed one of its processHistoryFields. Changes maps the names of the
+// fields which changed to their formatted old and new values.
+type processChange struct {
+	ReleaseID string                    `json:"release_id"`
+	CreatedAt *time.Time                `json:"created_at,omitempty"`
+	Author    string                    `json:"author,omitempty"`
+	Added     bool                      `json:"added,omitempty"`
+	Removed   bool                      `json:"removed,omitempty"`
+	Changes   map[string]ct.ValueChange `json:"changes,omitempty"`
+}
+
+// processHistory returns the changes to the process type typ made by
+// releases, which are ordered newest first as listed by the controller, so
+// the changes are also newest first.
+func processHistory(releases []*ct.Release, typ string) []*processChange {
+	var history []*processChange
+	// prev is the process type in the previous release, or nil if it
+	// didn't have one
+	var prev *ct.ProcessType
+	for i := len(releases) - 1; i >= 0; i-- {
+		r := releases[i]
+		change := &processChange{
+			ReleaseID: r.ID,
+			CreatedAt: r.CreatedAt,
+			Author:    releaseAuthor(r),
+		}
+		proc, ok := r.Processes[typ]
+		switch {
+		case !ok && prev == nil:
+			continue
+		case !ok:
+			change.Removed = true
+		case prev == nil:
+			change.Added = true
+			change.Changes = processFieldChanges(ct.ProcessType{}, proc)
+		default:
+			change.Changes = processFieldChanges(*prev, proc)
+			if len(change.Changes) == 0 {
+				prev = &proc
+				continue
+			}
+		}
+		if ok {
+			prev = &proc
+		} else {
+			prev = nil
+		}
+		history = append([]*processChange{change}, history...)
+	}
+	return history
+}
+
+// processFieldChanges returns the formatted old and new values of the
+// processHistoryFields which differ between a and b, determined using the
+// same comparison as release diff.
+func processFieldChanges(a, b ct.ProcessType) map[string]ct.ValueChange {
+	diff := ct.DiffProcessTypes(a, b)
+	changed := make(map[string]bool, len(diff.Fields))
+	for _, name := range diff.Fields {
+		changed[name] = true
+	}
+	var changes map[string]ct.ValueChange
+	for _, field := range processHistoryFields {
+		if !changed[field.name] {
+			continue
+		}
+		// skip changes which don't show in the formatted values (e.g.
+		// of resource requests rather than limits)
+		from, to := field.format(a), field.format(b)
+		if from == to {
+			continue
+		}
+		if changes == nil {
+			changes = make(map[string]ct.ValueChange)
+		}
+		changes[field.name] = ct.ValueChange{Old: from, New: to}
+	}
+	return changes
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
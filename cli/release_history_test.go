@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestReleaseHistory(c *C) {
+	web := ct.ProcessType{Cmd: []string{"bin/web"}, Ports: []ct.Port{{Port: 8080, Proto: "tcp"}}}
+	first := &ct.Release{
+		Meta:      map[string]string{"created_by": "alice"},
+		Processes: map[string]ct.ProcessType{"web": web},
+	}
+	client, app := newFakeApp(c, first)
+	deploy := func(procs map[string]ct.ProcessType, env map[string]string) *ct.Release {
+		release := &ct.Release{ArtifactIDs: first.ArtifactIDs, Env: env, Processes: procs}
+		c.Assert(client.CreateRelease(release), IsNil)
+		mustDeploy(c, client, app.ID, release.ID)
+		return release
+	}
+	worker := ct.ProcessType{Cmd: []string{"bin/worker"}}
+
+	// changing the env or other process types doesn't change web
+	deploy(map[string]ct.ProcessType{"web": web, "worker": worker}, map[string]string{"A": "1"})
+	web.Cmd = []string{"bin/web", "--workers", "4"}
+	changedCmd := deploy(map[string]ct.ProcessType{"web": web, "worker": worker}, nil)
+	removed := deploy(map[string]ct.ProcessType{"worker": worker}, nil)
+	deploy(map[string]ct.ProcessType{"worker": {Cmd: []string{"bin/worker", "-v"}}}, nil)
+	web.Ports = append(web.Ports, ct.Port{Port: 9090, Proto: "tcp"})
+	web.Entrypoint = []string{"/bin/sh", "-c"}
+	readded := deploy(map[string]ct.ProcessType{"web": web}, nil)
+	web.Ports = web.Ports[:1]
+	web.Entrypoint = nil
+	changedPorts := deploy(map[string]ct.ProcessType{"web": web}, nil)
+
+	out := captureStdout(c, func() {
+		c.Assert(runReleaseCommand(c, client, app.Name, "history", "--time-format=rfc3339", "web"), IsNil)
+	})
+	c.Assert(out, Equals, fmt.Sprintf(`%s  %s
+  ~ entrypoint: /bin/sh -c -> (none)
+  ~ ports: 8080/tcp, 9090/tcp -> 8080/tcp
+
+%s  %s
+  + web added
+    cmd: bin/web --workers 4
+    entrypoint: /bin/sh -c
+    ports: 8080/tcp, 9090/tcp
+
+%s  %s
+  - web removed
+
+%s  %s
+  ~ cmd: bin/web -> bin/web --workers 4
+
+%s  %s  alice
+  + web added
+    cmd: bin/web
+    ports: 8080/tcp
+`,
+		changedPorts.ID, changedPorts.CreatedAt.Format(time.RFC3339),
+		readded.ID, readded.CreatedAt.Format(time.RFC3339),
+		removed.ID, removed.CreatedAt.Format(time.RFC3339),
+		changedCmd.ID, changedCmd.CreatedAt.Format(time.RFC3339),
+		first.ID, first.CreatedAt.Format(time.RFC3339),
+	))
+
+	out = captureStdout(c, func() {
+		c.Assert(runReleaseCommand(c, client, app.Name, "history", "--json", "web"), IsNil)
+	})
+	var history []*processChange
+	c.Assert(json.Unmarshal([]byte(out), &history), IsNil)
+	c.Assert(history, HasLen, 5)
+	c.Assert(history[3].ReleaseID, Equals, changedCmd.ID)
+	c.Assert(history[3].Changes, DeepEquals, map[string]ct.ValueChange{
+		"cmd": {Old: "bin/web", New: "bin/web --workers 4"},
+	})
+
+	err := runReleaseCommand(c, client, app.Name, "history", "db")
+	c.Assert(err, ErrorMatches, `No releases of the app have a "db" process type.`)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/jsonpatch"
+)
+
+// patchRelease returns a copy of release with the JSON Patch document in the
+// given file (or stdin if the file is "-") applied.
+func patchRelease(release *ct.Release, file string) (*ct.Release, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	patch, err := jsonpatch.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := json.Marshal(release)
+	if err != nil {
+		return nil, err
+	}
+	if doc, err = patch.Apply(doc); err != nil {
+		return nil, err
+	}
+
+	// decode strictly so that a patch which adds a misspelt field fails
+	// rather than being silently ignored
+	patched := &ct.Release{}
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(patched); err != nil {
+		return nil, fmt.Errorf("patched release is invalid: %s", err)
+	}
+	if patched.ImageArtifactID() == "" {
+		return nil, errors.New("patched release is invalid: it has no artifacts")
+	}
+	if err := checkReleasePorts(patched); err != nil {
+		return nil, fmt.Errorf("patched release is invalid: %s", err)
+	}
+	return patched, nil
+}
+
+// checkReleasePorts returns an error if a port of any process type of the
+// release has an unknown protocol.
+func checkReleasePorts(release *ct.Release) error {
+	for typ, proc := range release.Processes {
+		for _, port := range proc.Ports {
+			if port.Proto != "" && port.Proto != "tcp" && port.Proto != "udp" {
+				return fmt.Errorf("process type %q has a port with unknown protocol %q", typ, port.Proto)
+			}
+		}
+	}
+	return nil
+}
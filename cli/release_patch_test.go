@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	ct "github.com/flynn/flynn/controller/types"
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestPatchRelease(c *C) {
+	release := &ct.Release{
+		ID:          "release1",
+		ArtifactIDs: []string{"artifact1"},
+		Env:         map[string]string{"A": "1", "B": "2"},
+		Processes: map[string]ct.ProcessType{
+			"web": {
+				Cmd:   []string{"web"},
+				Ports: []ct.Port{{Port: 80, Proto: "tcp"}, {Port: 443, Proto: "tcp"}},
+			},
+		},
+	}
+	patch := func(doc string) (*ct.Release, error) {
+		f, err := ioutil.TempFile("", "patch")
+		c.Assert(err, IsNil)
+		defer os.Remove(f.Name())
+		_, err = f.WriteString(doc)
+		c.Assert(err, IsNil)
+		f.Close()
+		return patchRelease(release, f.Name())
+	}
+
+	patched, err := patch(`[
+		{"op": "test", "path": "/processes/web/ports/1/port", "value": 443},
+		{"op": "remove", "path": "/processes/web/ports/1"},
+		{"op": "remove", "path": "/env/B"},
+		{"op": "replace", "path": "/processes/web/cmd/0", "value": "server"}
+	]`)
+	c.Assert(err, IsNil)
+	c.Assert(patched.Env, DeepEquals, map[string]string{"A": "1"})
+	c.Assert(patched.Processes["web"].Cmd, DeepEquals, []string{"server"})
+	c.Assert(patched.Processes["web"].Ports, DeepEquals, []ct.Port{{Port: 80, Proto: "tcp"}})
+	c.Assert(patched.ArtifactIDs, DeepEquals, []string{"artifact1"})
+
+	// the original release is not modified
+	c.Assert(release.Env, DeepEquals, map[string]string{"A": "1", "B": "2"})
+
+	_, err = patch(`[{"op": "test", "path": "/env/A", "value": "2"}]`)
+	c.Assert(err, ErrorMatches, ".*test failed")
+	_, err = patch(`[{"op": "add", "path": "/processes/web/comand", "value": ["x"]}]`)
+	c.Assert(err, ErrorMatches, "patched release is invalid: .*unknown field.*")
+	_, err = patch(`[{"op": "remove", "path": "/artifacts"}]`)
+	c.Assert(err, ErrorMatches, "patched release is invalid: it has no artifacts")
+	_, err = patch(`[{"op": "add", "path": "/processes/web/ports/-", "value": {"port": 53, "proto": "sctp"}}]`)
+	c.Assert(err, ErrorMatches, `.*unknown protocol "sctp"`)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	ct "github.com/flynn/flynn/controller/types"
+)
+
+// printDeploymentPlan prints the steps "release add --plan" would take to
+// deploy a release, and the services whose backends would change. Steps of
+// a release which hasn't been created have no release ID.
+func printDeploymentPlan(out io.Writer, plan *ct.DeploymentPlan) {
+	fmt.Fprintf(out, "Deployment plan (%s strategy):\n", plan.Strategy)
+	if len(plan.Steps) == 0 {
+		fmt.Fprintln(out, "  No jobs are running, the release will be set immediately.")
+	}
+	short := func(id string) string {
+		if id == "" {
+			return "new"
+		}
+		if len(id) > 8 {
+			return id[:8]
+		}
+		return id
+	}
+	for i, step := range plan.Steps {
+		action := "start"
+		if step.Action == ct.DeploymentStepScaleDown {
+			action = "stop"
+		}
+		jobs := "jobs"
+		if step.Count == 1 {
+			jobs = "job"
+		}
+		perHost := ""
+		if step.Omni {
+			perHost = " per host"
+		}
+		fmt.Fprintf(out, "  %d. %s %d %s %s%s (release %s)\n", i+1, action, step.Count, step.JobType, jobs, perHost, short(step.ReleaseID))
+	}
+	if len(plan.Services) > 0 {
+		types := make([]string, 0, len(plan.Services))
+		for typ := range plan.Services {
+			types = append(types, typ)
+		}
+		sort.Strings(types)
+		fmt.Fprintln(out, "Services with changing backends:")
+		for _, typ := range types {
+			fmt.Fprintf(out, "  %s (%s)\n", plan.Services[typ], typ)
+		}
+	}
+	for _, note := range plan.Notes {
+		fmt.Fprintf(out, "Note: %s\n", note)
+	}
+}
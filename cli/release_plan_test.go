@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+
+	ct "github.com/flynn/flynn/controller/types"
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestReleaseAddPlan(c *C) {
+	client, app := newFakeApp(c, &ct.Release{Processes: map[string]ct.ProcessType{"web": {}}})
+	current, err := client.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(client.PutFormation(&ct.Formation{AppID: app.ID, ReleaseID: current.ID, Processes: map[string]int{"web": 2}}), IsNil)
+	artifacts, err := client.ArtifactList()
+	c.Assert(err, IsNil)
+	releases := client.CreatedReleases()
+
+	var cmdErr error
+	out := captureStdout(c, func() {
+		cmdErr = runReleaseCommand(c, client, app.Name, "add", "--plan", "--inherit", "https://example.com?name=test&id=2")
+	})
+	c.Assert(cmdErr, IsNil)
+	c.Assert(strings.Contains(out, "1. start 2 web jobs (release new)"), Equals, true, Commentf("output: %s", out))
+	c.Assert(strings.Contains(out, "2. stop 2 web jobs (release "+current.ID[:8]+")"), Equals, true, Commentf("output: %s", out))
+	c.Assert(strings.Contains(out, "The release was not created."), Equals, true, Commentf("output: %s", out))
+
+	// planning leaves no artifact, release or deployment behind
+	after, err := client.ArtifactList()
+	c.Assert(err, IsNil)
+	c.Assert(after, HasLen, len(artifacts))
+	c.Assert(client.CreatedReleases(), HasLen, len(releases))
+	got, err := client.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(got.ID, Equals, current.ID)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+)
+
+// defaultReadyTimeout is how long --wait-ready waits by default.
+const defaultReadyTimeout = 5 * time.Minute
+
+// readyPollInterval is how often --wait-ready checks the app's jobs.
+var readyPollInterval = time.Second
+
+// waitReady waits until each process type of the release's formation has as
+// many jobs up as its scale, failing with the process types which don't if
+// that takes longer than timeout.
+func waitReady(client controller.Client, l *actionLogger, release *ct.Release, timeout time.Duration) error {
+	app := mustApp()
+	start := time.Now()
+	for {
+		pending, err := unreadyProcesses(client, app, release)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			l.Log("release_ready", release.ID, "", start, "Release %s is ready.", release.ID)
+			return nil
+		}
+		if time.Since(start) >= timeout {
+			return fmt.Errorf("Timed out after %s waiting for release %s to be ready: %s.", timeout, release.ID, strings.Join(pending, ", "))
+		}
+		time.Sleep(readyPollInterval)
+	}
+}
+
+// unreadyProcesses returns the process types of the release's formation
+// which have fewer jobs up than their scale, along with how many are up (e.g.
+// "web 1/3 up"). Omni process types run a job per host, so are only checked
+// to have at least their scale up.
+func unreadyProcesses(client controller.Client, app string, release *ct.Release) ([]string, error) {
+	formation, err := client.GetFormation(app, release.ID)
+	if err == controller.ErrNotFound {
+		// the release isn't scaled, so has no jobs to wait for
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	jobs, err := client.JobList(app)
+	if err != nil {
+		return nil, err
+	}
+	up := make(map[string]int)
+	for _, job := range jobs {
+		if job.ReleaseID == release.ID && job.State == ct.JobStateUp {
+			up[job.Type]++
+		}
+	}
+	types := make([]string, 0, len(formation.Processes))
+	for typ := range formation.Processes {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	var pending []string
+	for _, typ := range types {
+		if n := formation.Processes[typ]; up[typ] < n {
+			pending = append(pending, fmt.Sprintf("%s %d/%d up", typ, up[typ], n))
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	ct "github.com/flynn/flynn/controller/types"
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestReleaseWaitReady(c *C) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	defer func(prev time.Duration) { readyPollInterval = prev }(readyPollInterval)
+	readyPollInterval = time.Millisecond
+
+	client, app := newFakeApp(c, &ct.Release{Processes: map[string]ct.ProcessType{"web": {}, "worker": {}}})
+	current, err := client.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(client.PutFormation(&ct.Formation{AppID: app.ID, ReleaseID: current.ID, Processes: map[string]int{"web": 2, "worker": 1}}), IsNil)
+
+	// the deploy's jobs are all up
+	c.Assert(runReleaseCommand(c, client, app.Name, "add", "--wait-ready", "https://example.com?name=test&id=2"), IsNil)
+	released := client.CreatedReleases()
+	c.Assert(released, HasLen, 2)
+	c.Assert(strings.Contains(out.String(), fmt.Sprintf("Release %s is ready.\n", released[1].ID)), Equals, true, Commentf("output: %s", out.String()))
+
+	// a job which doesn't finish starting times out
+	client.StartingJobs = map[string]int{"web": 1}
+	update := writeTempFile(c, `{"env": {"A": "1"}}`)
+	err = runReleaseCommand(c, client, app.Name, "update", "--wait-ready", "--timeout=10ms", update)
+	released = client.CreatedReleases()
+	c.Assert(released, HasLen, 3)
+	c.Assert(err, ErrorMatches, fmt.Sprintf(`Timed out after 10ms waiting for release %s to be ready: web 1/2 up\.`, released[2].ID))
+
+	c.Assert(runReleaseCommand(c, client, app.Name, "update", "--wait-ready", "--timeout=soon", update), ErrorMatches, `invalid timeout "soon"`)
+
+	// an invalid timeout fails release add before an artifact is created
+	artifacts, err := client.ArtifactList()
+	c.Assert(err, IsNil)
+	c.Assert(runReleaseCommand(c, client, app.Name, "add", "--wait-ready", "--timeout=soon", "https://example.com?name=test&id=3"), ErrorMatches, `invalid timeout "soon"`)
+	after, err := client.ArtifactList()
+	c.Assert(err, IsNil)
+	c.Assert(after, HasLen, len(artifacts))
+}
@@ -2,55 +2,132 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/router/types"
 	"github.com/flynn/go-docopt"
 )
 
 func init() {
 	register("route", runRoute, `
-usage: flynn route
-       flynn route add http [-s <service>] [-c <tls-cert> -k <tls-key>] [--sticky] [--leader] [--no-leader] <domain>
+usage: flynn route [--time-format=<format>]
+       flynn route add http [-s <service>] [-c <tls-cert> [-k <tls-key>]] [--external-key] [--sticky] [--leader] [--no-leader] [--access-log] [--idle-timeout=<timeout>] [--request-timeout=<timeout>] [--max-request-body-size=<bytes>] [--max-response-body-size=<bytes>] [--health-check=<path> [--health-check-interval=<interval>] [--health-check-threshold=<n>]] [--compress] [--retries=<n> [--retry-non-idempotent]] [--backend-host=<host> [--forwarded-host]] [--load-balancing=<algorithm>] [--alias=<domain>...] <domain>
        flynn route add tcp [-s <service>] [-p <port>] [--leader]
-       flynn route update <id> [-s <service>] [-c <tls-cert> -k <tls-key>] [--sticky] [--no-sticky] [--leader] [--no-leader]
+       flynn route update <id> [-s <service>] [-c <tls-cert> [-k <tls-key>]] [--external-key] [--no-external-key] [--sticky] [--no-sticky] [--leader] [--no-leader] [--access-log] [--no-access-log] [--idle-timeout=<timeout>] [--request-timeout=<timeout>] [--max-request-body-size=<bytes>] [--max-response-body-size=<bytes>] [--health-check=<path>] [--health-check-interval=<interval>] [--health-check-threshold=<n>] [--no-health-check] [--compress] [--no-compress] [--retries=<n>] [--retry-non-idempotent] [--no-retry-non-idempotent] [--backend-host=<host>] [--no-backend-host] [--forwarded-host] [--no-forwarded-host] [--load-balancing=<algorithm>] [--alias=<domain>...] [--remove-alias=<domain>...]
        flynn route remove <id>
+       flynn route test [--time-format=<format>] <domain>
+       flynn route import --from=<format> [--dry-run] <file>
 
 Manage routes for application.
 
 Options:
-	-s, --service=<service>    service name to route domain to (defaults to APPNAME-web)
-	-c, --tls-cert=<tls-cert>  path to PEM encoded certificate for TLS, - for stdin (http only)
-	-k, --tls-key=<tls-key>    path to PEM encoded private key for TLS, - for stdin (http only)
-	--sticky                   enable cookie-based sticky routing (http only)
-	--no-sticky                disable cookie-based sticky routing (update http only)
-	--leader                   enable leader-only routing mode
-	--no-leader                disable leader-only routing mode (update only)
-	-p, --port=<port>          port to accept traffic on (tcp only)
+	-s, --service=<service>             service name to route domain to (defaults to APPNAME-web)
+	-c, --tls-cert=<tls-cert>           path to PEM encoded certificate for TLS, - for stdin (http only)
+	-k, --tls-key=<tls-key>             path to PEM encoded private key for TLS, - for stdin (http only)
+	--external-key                      the private key is managed outside the router (e.g. in an HSM), so only give -c (http only)
+	--no-external-key                   the private key is given with -k (update http only)
+	--sticky                            enable cookie-based sticky routing (http only)
+	--no-sticky                         disable cookie-based sticky routing (update http only)
+	--leader                            enable leader-only routing mode
+	--no-leader                         disable leader-only routing mode (update only)
+	--access-log                        log each request with its status, latency and backend (http only)
+	--no-access-log                     disable access logging (update http only)
+	--idle-timeout=<timeout>            close WebSocket and other upgraded connections after being idle for this long, e.g. 5m, 0 for no limit (http only)
+	--request-timeout=<timeout>         respond with a 504 if a backend doesn't start responding within this long, e.g. 30s, 0 for no limit (http only)
+	--max-request-body-size=<bytes>     reject request bodies larger than this many bytes with a 413, 0 for no limit (http only)
+	--max-response-body-size=<bytes>    fail responses larger than this many bytes with a 502, 0 for no limit (http only)
+	--health-check=<path>               only send requests to backends which respond to GET <path> with a status below 400 (http only)
+	--health-check-interval=<interval>  time between health checks of each backend, e.g. 5s, defaults to 10s (http only)
+	--health-check-threshold=<n>        number of consecutive failed health checks before a backend is removed, defaults to 3 (http only)
+	--no-health-check                   disable health checks (update http only)
+	--compress                          gzip encode responses for clients which accept it (http only)
+	--no-compress                       disable response compression (update http only)
+	--retries=<n>                       retry requests on up to n other backends if the connection fails after sending them (http only)
+	--retry-non-idempotent              also retry requests with non-idempotent methods such as POST (http only)
+	--no-retry-non-idempotent           only retry requests with idempotent methods (update http only)
+	--backend-host=<host>               send requests to backends with this Host header rather than the requested domain (http only)
+	--no-backend-host                   send requests to backends with the requested domain as the Host header (update http only)
+	--forwarded-host                    set X-Forwarded-Host to the requested domain when using --backend-host (http only)
+	--no-forwarded-host                 don't set X-Forwarded-Host (update http only)
+	--load-balancing=<algorithm>        how to choose the backend of each request: round-robin (default), least-conn or ip-hash (http only)
+	--alias=<domain>                    also route this domain (e.g. another name of the certificate) to the service (http only, may be repeated)
+	--remove-alias=<domain>             stop routing this alias (update http only, may be repeated)
+	-p, --port=<port>                   port to accept traffic on (tcp only)
+	--time-format=<format>              print times as relative, rfc3339 or local (defaults to $FLYNN_TIME_FORMAT)
+	--from=<format>                     format of the config to import routes from, currently only nginx
+	--dry-run                           print the routes which would be imported without creating them
 
 Commands:
-	With no arguments, shows a list of routes.
+	With no arguments, shows a list of routes, including when each was last
+	updated.
 
 	add     adds a route to an app
 	remove  removes a route
 
+	test    shows how requests for a domain are routed
+
+		Shows the route of the app which the router sends requests for the
+		given domain (optionally with a path, e.g. example.com/api/users)
+		to, matching aliases and wildcard domains as the router does, along
+		with the SHA-256 fingerprint and expiry of its TLS certificate, its
+		health check and the running jobs of the app which serve its
+		service. Backends are only sent requests once they are up and
+		passing any health checks.
+
+	import  creates routes from another proxy's config
+
+		Creates an HTTP route for each location of each server block in an
+		nginx config which uses proxy_pass, to ease moving from nginx. The
+		route's domain is the first server_name, with any others as its
+		aliases, and its service is the host of the proxy_pass URL (e.g.
+		the name of an upstream block), or APPNAME-web if that is an IP
+		address. The files of ssl_certificate and ssl_certificate_key are
+		used as the route's TLS certificate and key, and client_max_body_size
+		and proxy_set_header Host and X-Forwarded-Host are also imported.
+
+		Other directives of server and location blocks, and locations which
+		don't match a path prefix, are reported as warnings and not
+		imported, so the routes should be checked before relying on them.
+		Includes aren't followed.
+
 Examples:
 
 	$ flynn route add http example.com
 
 	$ flynn route add http example.com/path/
 
+	$ flynn route add http --alias www.example.com example.com
+
 	$ flynn route add tcp
 
 	$ flynn route add tcp --leader
+
+	$ flynn route import --from nginx /etc/nginx/sites-enabled/example.com
+	Warning: /etc/nginx/sites-enabled/example.com:12: ignoring unsupported directive "gzip"
+	Created route http/1ba949d1-654b-4e3b-8fdd-d6dc4c2b6d5b for example.com to example-web
+
+	$ flynn route test example.com/api/users
+	Route:         http/1ba949d1-654b-4e3b-8fdd-d6dc4c2b6d5b
+	Domain:        example.com/api/
+	Service:       example-api
+	TLS:           SHA-256 1f2e4b..., expires 2027-01-01T00:00:00Z
+	Health Check:  GET /status every 10s, removed after 3 failures
+	Backends:      1
+	               host-0a1b2c3d-6fd9-4ad6-b8c4-7b8e1e4f0c7a  api  9d27d2c1-a2d6-4ec1-9a2e-56b6d8d0d0b3  up
 `)
 }
 
@@ -76,8 +153,16 @@ func runRoute(args *docopt.Args, client controller.Client) error {
 		}
 	} else if args.Bool["remove"] {
 		return runRouteRemove(args, client)
+	} else if args.Bool["test"] {
+		return runRouteTest(args, client)
+	} else if args.Bool["import"] {
+		return runRouteImport(args, client)
 	}
 
+	format, err := parseTimeFormat(args.String["--time-format"])
+	if err != nil {
+		return err
+	}
 	routes, err := client.RouteList(mustApp())
 	if err != nil {
 		return err
@@ -87,7 +172,7 @@ func runRoute(args *docopt.Args, client controller.Client) error {
 	defer w.Flush()
 
 	var route, protocol, service, sticky, path string
-	listRec(w, "ROUTE", "SERVICE", "ID", "STICKY", "LEADER", "PATH")
+	listRec(w, "ROUTE", "SERVICE", "ID", "STICKY", "LEADER", "PATH", "UPDATED")
 	for _, k := range routes {
 		switch k.Type {
 		case "tcp":
@@ -96,6 +181,9 @@ func runRoute(args *docopt.Args, client controller.Client) error {
 			service = k.TCPRoute().Service
 		case "http":
 			route = k.HTTPRoute().Domain
+			if len(k.Aliases) > 0 {
+				route += " (" + strings.Join(k.Aliases, ", ") + ")"
+			}
 			service = k.TCPRoute().Service
 			httpRoute := k.HTTPRoute()
 			if httpRoute.Certificate == nil && httpRoute.LegacyTLSCert == "" {
@@ -106,7 +194,7 @@ func runRoute(args *docopt.Args, client controller.Client) error {
 			sticky = fmt.Sprintf("%t", k.Sticky)
 			path = k.HTTPRoute().Path
 		}
-		listRec(w, protocol+":"+route, service, k.FormattedID(), sticky, k.Leader, path)
+		listRec(w, protocol+":"+route, service, k.FormattedID(), sticky, k.Leader, path, format.Format(&k.UpdatedAt))
 	}
 	return nil
 }
@@ -147,7 +235,7 @@ func runRouteAddHTTP(args *docopt.Args, client controller.Client) error {
 		service = mustApp() + "-web"
 	}
 
-	tlsCert, tlsKey, err := parseTLSCert(args)
+	tlsCert, tlsKey, err := parseTLSCert(args, args.Bool["--external-key"])
 	if err != nil {
 		return err
 	}
@@ -157,6 +245,32 @@ func runRouteAddHTTP(args *docopt.Args, client controller.Client) error {
 		return fmt.Errorf("Failed to parse %s as URL", args.String["<domain>"])
 	}
 
+	idleTimeout, err := parseTimeout(args, "--idle-timeout", 0)
+	if err != nil {
+		return err
+	}
+	requestTimeout, err := parseTimeout(args, "--request-timeout", 0)
+	if err != nil {
+		return err
+	}
+
+	maxRequestSize, err := parseBodySize(args, "--max-request-body-size", 0)
+	if err != nil {
+		return err
+	}
+	maxResponseSize, err := parseBodySize(args, "--max-response-body-size", 0)
+	if err != nil {
+		return err
+	}
+	retries, err := parseRetries(args, 0)
+	if err != nil {
+		return err
+	}
+	healthCheck, err := parseHealthCheck(args, nil)
+	if err != nil {
+		return err
+	}
+
 	hr := &router.HTTPRoute{
 		Service:       service,
 		Domain:        u.Host,
@@ -165,6 +279,23 @@ func runRouteAddHTTP(args *docopt.Args, client controller.Client) error {
 		Sticky:        args.Bool["--sticky"],
 		Leader:        args.Bool["--leader"],
 		Path:          u.Path,
+		AccessLog:     args.Bool["--access-log"],
+		IdleTimeout:   idleTimeout,
+
+		RequestTimeout:      requestTimeout,
+		MaxRequestBodySize:  maxRequestSize,
+		MaxResponseBodySize: maxResponseSize,
+		HealthCheck:         healthCheck,
+		Compress:            args.Bool["--compress"],
+		ExternalKey:         args.Bool["--external-key"],
+		Retries:             retries,
+		RetryNonIdempotent:  args.Bool["--retry-non-idempotent"],
+		BackendHost:         args.String["--backend-host"],
+		ForwardedHost:       args.Bool["--forwarded-host"],
+		LoadBalancing:       args.String["--load-balancing"],
+	}
+	if aliases := args.All["--alias"].([]string); len(aliases) > 0 {
+		hr.Aliases = aliases
 	}
 	route := hr.ToRoute()
 	if err := client.CreateRoute(mustApp(), route); err != nil {
@@ -203,6 +334,29 @@ func runRouteUpdateTCP(args *docopt.Args, client controller.Client) error {
 	return nil
 }
 
+// updateAliases returns aliases with add appended (if not already present)
+// and remove removed, ignoring case.
+func updateAliases(aliases, add, remove []string) []string {
+	removed := make(map[string]struct{}, len(remove))
+	for _, alias := range remove {
+		removed[strings.ToLower(alias)] = struct{}{}
+	}
+	var res []string
+	seen := make(map[string]struct{}, len(aliases)+len(add))
+	for _, alias := range append(aliases, add...) {
+		key := strings.ToLower(alias)
+		if _, ok := removed[key]; ok {
+			continue
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		res = append(res, alias)
+	}
+	return res
+}
+
 func runRouteUpdateHTTP(args *docopt.Args, client controller.Client) error {
 	id := args.String["<id>"]
 	appName := mustApp()
@@ -216,8 +370,16 @@ func runRouteUpdateHTTP(args *docopt.Args, client controller.Client) error {
 		route.Service = service
 	}
 
+	if args.Bool["--external-key"] {
+		route.ExternalKey = true
+	} else if args.Bool["--no-external-key"] {
+		route.ExternalKey = false
+	}
+
+	route.Aliases = updateAliases(route.Aliases, args.All["--alias"].([]string), args.All["--remove-alias"].([]string))
+
 	route.Certificate = nil
-	route.LegacyTLSCert, route.LegacyTLSKey, err = parseTLSCert(args)
+	route.LegacyTLSCert, route.LegacyTLSKey, err = parseTLSCert(args, route.ExternalKey)
 	if err != nil {
 		return err
 	}
@@ -234,6 +396,59 @@ func runRouteUpdateHTTP(args *docopt.Args, client controller.Client) error {
 		route.Leader = false
 	}
 
+	if args.Bool["--access-log"] {
+		route.AccessLog = true
+	} else if args.Bool["--no-access-log"] {
+		route.AccessLog = false
+	}
+
+	if route.IdleTimeout, err = parseTimeout(args, "--idle-timeout", route.IdleTimeout); err != nil {
+		return err
+	}
+	if route.RequestTimeout, err = parseTimeout(args, "--request-timeout", route.RequestTimeout); err != nil {
+		return err
+	}
+
+	if route.MaxRequestBodySize, err = parseBodySize(args, "--max-request-body-size", route.MaxRequestBodySize); err != nil {
+		return err
+	}
+	if route.MaxResponseBodySize, err = parseBodySize(args, "--max-response-body-size", route.MaxResponseBodySize); err != nil {
+		return err
+	}
+	if route.HealthCheck, err = parseHealthCheck(args, route.HealthCheck); err != nil {
+		return err
+	}
+
+	if args.Bool["--compress"] {
+		route.Compress = true
+	} else if args.Bool["--no-compress"] {
+		route.Compress = false
+	}
+
+	if route.Retries, err = parseRetries(args, route.Retries); err != nil {
+		return err
+	}
+	if args.Bool["--retry-non-idempotent"] {
+		route.RetryNonIdempotent = true
+	} else if args.Bool["--no-retry-non-idempotent"] {
+		route.RetryNonIdempotent = false
+	}
+
+	if host := args.String["--backend-host"]; host != "" {
+		route.BackendHost = host
+	} else if args.Bool["--no-backend-host"] {
+		route.BackendHost = ""
+		route.ForwardedHost = false
+	}
+	if args.Bool["--forwarded-host"] {
+		route.ForwardedHost = true
+	} else if args.Bool["--no-forwarded-host"] {
+		route.ForwardedHost = false
+	}
+	if lb := args.String["--load-balancing"]; lb != "" {
+		route.LoadBalancing = lb
+	}
+
 	if err := client.UpdateRoute(appName, id, route); err != nil {
 		return err
 	}
@@ -241,11 +456,119 @@ func runRouteUpdateHTTP(args *docopt.Args, client controller.Client) error {
 	return nil
 }
 
-func parseTLSCert(args *docopt.Args) (string, string, error) {
+// parseTimeout parses the duration given by the flag, returning def if it is
+// not set.
+func parseTimeout(args *docopt.Args, flag string, def time.Duration) (time.Duration, error) {
+	s := args.String[flag]
+	if s == "" {
+		return def, nil
+	}
+	timeout, err := time.ParseDuration(s)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid %s %q", strings.Replace(strings.TrimPrefix(flag, "--"), "-", " ", -1), s)
+	}
+	return timeout, nil
+}
+
+// parseBodySize parses the size in bytes given by the flag, returning def if
+// it is not set.
+func parseBodySize(args *docopt.Args, flag string, def int64) (int64, error) {
+	s := args.String[flag]
+	if s == "" {
+		return def, nil
+	}
+	size, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid %s %q", strings.TrimPrefix(flag, "--"), s)
+	}
+	return size, nil
+}
+
+// parseRetries parses the --retries flag, returning def if it is not set.
+func parseRetries(args *docopt.Args, def int) (int, error) {
+	s := args.String["--retries"]
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid retries %q", s)
+	}
+	return n, nil
+}
+
+// parseHealthCheck returns the health check given by the --health-check
+// flags, updating a copy of hc if it is set.
+func parseHealthCheck(args *docopt.Args, hc *router.HealthCheck) (*router.HealthCheck, error) {
+	if args.Bool["--no-health-check"] {
+		return nil, nil
+	}
+	path := args.String["--health-check"]
+	interval := args.String["--health-check-interval"]
+	threshold := args.String["--health-check-threshold"]
+	if hc == nil {
+		if path == "" {
+			if interval != "" || threshold != "" {
+				return nil, errors.New("--health-check must be given to configure a health check")
+			}
+			return nil, nil
+		}
+		hc = &router.HealthCheck{}
+	} else {
+		updated := *hc
+		hc = &updated
+	}
+	if path != "" {
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid health check path %q, it must start with /", path)
+		}
+		hc.Path = path
+	}
+	if interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid health check interval %q", interval)
+		}
+		hc.Interval = d
+	}
+	if threshold != "" {
+		n, err := strconv.Atoi(threshold)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid health check threshold %q", threshold)
+		}
+		hc.UnhealthyThreshold = n
+	}
+	return hc, nil
+}
+
+// parseTLSCert reads the certificate and key given by --tls-cert and
+// --tls-key. If externalKey is set, only a certificate may be given.
+func parseTLSCert(args *docopt.Args, externalKey bool) (string, string, error) {
 	tlsCertPath := args.String["--tls-cert"]
 	tlsKeyPath := args.String["--tls-key"]
 	var tlsCert []byte
 	var tlsKey []byte
+	if externalKey {
+		if tlsKeyPath != "" {
+			return "", "", errors.New("The TLS private key can't be given for a route with an external key")
+		}
+		if tlsCertPath == "" {
+			return "", "", nil
+		}
+		var stdin []byte
+		if tlsCertPath == "-" {
+			var err error
+			stdin, err = ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				return "", "", fmt.Errorf("Failed to read from stdin: %s", err)
+			}
+		}
+		tlsCert, err := readPEM("CERTIFICATE", tlsCertPath, stdin)
+		if err != nil {
+			return "", "", fmt.Errorf("Failed to read TLS cert: %s", err)
+		}
+		return string(tlsCert), "", nil
+	}
 	if tlsCertPath != "" && tlsKeyPath != "" {
 		var stdin []byte
 
@@ -267,7 +590,7 @@ func parseTLSCert(args *docopt.Args) (string, string, error) {
 			return "", "", fmt.Errorf("Failed to read TLS key: %s", err)
 		}
 	} else if tlsCertPath != "" || tlsKeyPath != "" {
-		return "", "", errors.New("Both the TLS certificate AND private key need to be specified")
+		return "", "", errors.New("Both the TLS certificate AND private key need to be specified (or use --external-key if the key is managed externally)")
 	}
 	return string(tlsCert), string(tlsKey), nil
 }
@@ -302,3 +625,205 @@ func runRouteRemove(args *docopt.Args, client controller.Client) error {
 	fmt.Printf("Route %s removed.\n", routeID)
 	return nil
 }
+
+func runRouteTest(args *docopt.Args, client controller.Client) error {
+	format, err := parseTimeFormat(args.String["--time-format"])
+	if err != nil {
+		return err
+	}
+	appName := mustApp()
+	host, path := splitRouteURL(args.String["<domain>"])
+	routes, err := client.RouteList(appName)
+	if err != nil {
+		return err
+	}
+	route := findHTTPRoute(routes, host, path)
+	if route == nil {
+		return fmt.Errorf("No route of %s matches %s%s.", appName, host, path)
+	}
+	backends, err := routeBackends(client, appName, route.Service)
+	if err != nil {
+		return err
+	}
+
+	w := tabWriter()
+	defer w.Flush()
+	routePath := route.Path
+	if routePath == "" {
+		routePath = "/"
+	}
+	listRec(w, "Route:", route.FormattedID())
+	listRec(w, "Domain:", route.Domain+routePath)
+	if route.Leader {
+		listRec(w, "Service:", route.Service+" (leader only)")
+	} else {
+		listRec(w, "Service:", route.Service)
+	}
+	if route.BackendHost != "" {
+		if route.ForwardedHost {
+			listRec(w, "Backend Host:", route.BackendHost+" (original in X-Forwarded-Host)")
+		} else {
+			listRec(w, "Backend Host:", route.BackendHost)
+		}
+	}
+	if route.LoadBalancing != "" {
+		listRec(w, "Load Balancing:", route.LoadBalancing)
+	}
+
+	tlsCert := route.LegacyTLSCert
+	if route.Certificate != nil {
+		tlsCert = route.Certificate.Cert
+	}
+	if tlsCert == "" {
+		listRec(w, "TLS:", "none")
+	} else if fingerprint, expiry, err := certFingerprint(tlsCert); err != nil {
+		listRec(w, "TLS:", fmt.Sprintf("invalid certificate: %s", err))
+	} else {
+		expires := "expires"
+		if expiry.Before(time.Now()) {
+			expires = "EXPIRED"
+		}
+		listRec(w, "TLS:", fmt.Sprintf("SHA-256 %s, %s %s", fingerprint, expires, format.Format(&expiry)))
+	}
+
+	if hc := route.HealthCheck; hc == nil {
+		listRec(w, "Health Check:", "none")
+	} else {
+		interval, threshold := hc.Interval, hc.UnhealthyThreshold
+		if interval == 0 {
+			interval = 10 * time.Second
+		}
+		if threshold == 0 {
+			threshold = 3
+		}
+		listRec(w, "Health Check:", fmt.Sprintf("GET %s every %s, removed after %d failures", hc.Path, interval, threshold))
+	}
+
+	if len(backends) == 0 {
+		listRec(w, "Backends:", fmt.Sprintf("none (no running jobs of %s serve %s)", appName, route.Service))
+		return nil
+	}
+	listRec(w, "Backends:", len(backends))
+	for _, job := range backends {
+		id := job.ID
+		if id == "" {
+			id = job.UUID
+		}
+		listRec(w, "", id, job.Type, job.ReleaseID, job.State)
+	}
+	return nil
+}
+
+// splitRouteURL splits a domain with an optional scheme, port and path (e.g.
+// https://example.com/api/users) into the host and path which the router
+// matches routes against.
+func splitRouteURL(s string) (string, string) {
+	if i := strings.Index(s, "://"); i != -1 {
+		s = s[i+3:]
+	}
+	host, path := s, "/"
+	if i := strings.Index(s, "/"); i != -1 {
+		host, path = s[:i], s[i:]
+	}
+	host = strings.ToLower(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host, path
+}
+
+// findHTTPRoute returns the route which the router sends requests for host
+// and path to, or nil if there is none. As in the router, the domain is
+// matched exactly (including aliases) and then against wildcard domains
+// from most to least specific, and the route on that domain with the
+// longest matching path is used.
+func findHTTPRoute(routes []*router.Route, host, path string) *router.Route {
+	// domains maps each domain and alias to the domain of its routes, as
+	// path based routes apply to the aliases of their domain
+	domains := make(map[string]string)
+	for _, r := range routes {
+		if r.Type != "http" {
+			continue
+		}
+		domains[strings.ToLower(r.Domain)] = r.Domain
+		for _, alias := range r.Aliases {
+			domains[strings.ToLower(alias)] = r.Domain
+		}
+	}
+	domain, ok := domains[host]
+	if !ok {
+		d := strings.SplitN(host, ".", 5)
+		for i := len(d); i > 0 && !ok; i-- {
+			domain, ok = domains["*."+strings.Join(d[len(d)-i:], ".")]
+		}
+	}
+	if !ok {
+		return nil
+	}
+	var match *router.Route
+	for _, r := range routes {
+		if r.Type != "http" || r.Domain != domain || !routePathMatches(r.Path, path) {
+			continue
+		}
+		if match == nil || len(strings.Trim(r.Path, "/")) > len(strings.Trim(match.Path, "/")) {
+			match = r
+		}
+	}
+	return match
+}
+
+// routePathMatches returns whether path is routed by a route with the given
+// path, which matches whole path segments.
+func routePathMatches(routePath, path string) bool {
+	prefix := strings.Trim(routePath, "/")
+	if prefix == "" {
+		return true
+	}
+	path = strings.Trim(path, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// certFingerprint returns the SHA-256 fingerprint and expiry time of the
+// first certificate in PEM encoded data.
+func certFingerprint(data string) (string, time.Time, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", time.Time{}, errors.New("no PEM encoded certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:]), cert.NotAfter, nil
+}
+
+// routeBackends returns the running jobs of the app whose process type
+// registers service, which are the backends of routes to it.
+func routeBackends(client controller.Client, appName, service string) ([]*ct.Job, error) {
+	jobs, err := client.JobList(appName)
+	if err != nil {
+		return nil, err
+	}
+	releases := make(map[string]*ct.Release)
+	var backends []*ct.Job
+	for _, job := range jobs {
+		if job.State != ct.JobStateStarting && job.State != ct.JobStateUp {
+			continue
+		}
+		release, ok := releases[job.ReleaseID]
+		if !ok {
+			if release, err = client.GetRelease(job.ReleaseID); err != nil {
+				return nil, err
+			}
+			releases[job.ReleaseID] = release
+		}
+		for _, port := range release.Processes[job.Type].Ports {
+			if port.Service != nil && port.Service.Name == service {
+				backends = append(backends, job)
+				break
+			}
+		}
+	}
+	return backends, nil
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/flynn/flynn/controller/client"
+	"github.com/flynn/flynn/router/types"
+	"github.com/flynn/go-docopt"
+)
+
+func runRouteImport(args *docopt.Args, client controller.Client) error {
+	if from := args.String["--from"]; from != "nginx" {
+		return fmt.Errorf("Unsupported config format %q, only nginx is supported.", from)
+	}
+	path := args.String["<file>"]
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	config, err := parseNginxConfig(data)
+	if err != nil {
+		return fmt.Errorf("Error parsing %s: %s", path, err)
+	}
+	routes, warnings, err := nginxRoutes(config, filepath.Dir(path), mustApp()+"-web")
+	if err != nil {
+		return err
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s:%s\n", path, w)
+	}
+	if len(routes) == 0 {
+		return fmt.Errorf("No routes found in %s.", path)
+	}
+
+	for _, r := range routes {
+		domain := r.Domain + r.Path
+		if args.Bool["--dry-run"] {
+			fmt.Printf("Would create route for %s to %s\n", domain, r.Service)
+			continue
+		}
+		if err := client.CreateRoute(mustApp(), r); err != nil {
+			return fmt.Errorf("Error creating route for %s: %s", domain, err)
+		}
+		fmt.Printf("Created route %s for %s to %s\n", r.FormattedID(), domain, r.Service)
+	}
+	return nil
+}
+
+// nginxDirective is a directive of an nginx config, along with the
+// directives in its block if it has one.
+type nginxDirective struct {
+	Name  string
+	Args  []string
+	Line  int
+	Block []*nginxDirective
+}
+
+// parseNginxConfig parses the directives of an nginx config file. Includes
+// and variables aren't expanded.
+func parseNginxConfig(data []byte) ([]*nginxDirective, error) {
+	p := &nginxParser{data: data, line: 1}
+	return p.parseBlock(false)
+}
+
+type nginxParser struct {
+	data []byte
+	pos  int
+	line int
+
+	// tok is the last token read, and quoted is whether it was quoted
+	// (so isn't ; { or } even if it looks like it)
+	tok    string
+	quoted bool
+	eof    bool
+}
+
+// parseBlock parses directives up to the end of the input, or if nested, up
+// to the closing } of the current block.
+func (p *nginxParser) parseBlock(nested bool) ([]*nginxDirective, error) {
+	directives := []*nginxDirective{}
+	var d *nginxDirective
+	for {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.eof {
+			if nested || d != nil {
+				return nil, fmt.Errorf("line %d: unexpected end of file", p.line)
+			}
+			return directives, nil
+		}
+		if p.quoted || (p.tok != ";" && p.tok != "{" && p.tok != "}") {
+			if d == nil {
+				d = &nginxDirective{Name: p.tok, Line: p.line}
+			} else {
+				d.Args = append(d.Args, p.tok)
+			}
+			continue
+		}
+		switch {
+		case p.tok == "}" && nested && d == nil:
+			return directives, nil
+		case p.tok == "}" || d == nil:
+			return nil, fmt.Errorf("line %d: unexpected %q", p.line, p.tok)
+		case p.tok == "{":
+			block, err := p.parseBlock(true)
+			if err != nil {
+				return nil, err
+			}
+			d.Block = block
+		}
+		directives = append(directives, d)
+		d = nil
+	}
+}
+
+// next reads the next token into p.tok, skipping whitespace and comments.
+func (p *nginxParser) next() error {
+	p.quoted = false
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		switch {
+		case c == '\n':
+			p.line++
+			p.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == ';' || c == '{' || c == '}':
+			p.tok = string(c)
+			p.pos++
+			return nil
+		case c == '"' || c == '\'':
+			var buf bytes.Buffer
+			for p.pos++; ; p.pos++ {
+				if p.pos >= len(p.data) {
+					return fmt.Errorf("line %d: unterminated string", p.line)
+				}
+				ch := p.data[p.pos]
+				if ch == '\\' && p.pos+1 < len(p.data) {
+					p.pos++
+					ch = p.data[p.pos]
+				} else if ch == c {
+					p.pos++
+					break
+				}
+				if ch == '\n' {
+					p.line++
+				}
+				buf.WriteByte(ch)
+			}
+			p.tok = buf.String()
+			p.quoted = true
+			return nil
+		default:
+			start := p.pos
+			for p.pos < len(p.data) && !strings.ContainsRune(" \t\r\n;{}#\"'", rune(p.data[p.pos])) {
+				p.pos++
+			}
+			p.tok = string(p.data[start:p.pos])
+			return nil
+		}
+	}
+	p.tok = ""
+	p.eof = true
+	return nil
+}
+
+// nginxServerDirectives are the directives of server blocks which are
+// imported (or, like listen, don't need to be), other directives are
+// reported as warnings.
+var nginxServerDirectives = map[string]struct{}{
+	"listen":               {},
+	"server_name":          {},
+	"ssl_certificate":      {},
+	"ssl_certificate_key":  {},
+	"location":             {},
+	"proxy_set_header":     {},
+	"client_max_body_size": {},
+}
+
+// nginxLocationDirectives are the directives of location blocks which are
+// imported.
+var nginxLocationDirectives = map[string]struct{}{
+	"proxy_pass":           {},
+	"proxy_set_header":     {},
+	"client_max_body_size": {},
+}
+
+// nginxRoutes converts the server blocks of an nginx config into HTTP
+// routes, one for each location which proxies requests, returning warnings
+// for directives which can't be mapped to routes. Proxied upstreams become
+// the service of the route, apart from IP addresses which are replaced with
+// defaultService. Relative certificate paths are read from dir.
+func nginxRoutes(config []*nginxDirective, dir, defaultService string) ([]*router.Route, []string, error) {
+	var routes []*router.Route
+	var warnings []string
+	warn := func(line int, format string, v ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf("%d: %s", line, fmt.Sprintf(format, v...)))
+	}
+
+	var servers []*nginxDirective
+	for _, d := range config {
+		switch d.Name {
+		case "server":
+			servers = append(servers, d)
+		case "http":
+			for _, d := range d.Block {
+				if d.Name == "server" {
+					servers = append(servers, d)
+				}
+			}
+		}
+	}
+
+	for _, server := range servers {
+		var names []string
+		var certPath, keyPath string
+		var headers []*nginxDirective
+		var bodySize *nginxDirective
+		var locations []*nginxDirective
+		for _, d := range server.Block {
+			if _, ok := nginxServerDirectives[d.Name]; !ok {
+				warn(d.Line, "ignoring unsupported directive %q", d.Name)
+				continue
+			}
+			switch d.Name {
+			case "server_name":
+				for _, name := range d.Args {
+					switch {
+					case name == "" || name == "_":
+					case strings.HasPrefix(name, "~"):
+						warn(d.Line, "ignoring regular expression server name %q", name)
+					case strings.HasPrefix(name, "."):
+						warn(d.Line, "importing server name %q as %q, add a *%s route for its subdomains", name, name[1:], name)
+						names = append(names, name[1:])
+					default:
+						names = append(names, name)
+					}
+				}
+			case "ssl_certificate":
+				certPath = nginxArg(d)
+			case "ssl_certificate_key":
+				keyPath = nginxArg(d)
+			case "proxy_set_header":
+				headers = append(headers, d)
+			case "client_max_body_size":
+				bodySize = d
+			case "location":
+				locations = append(locations, d)
+			}
+		}
+		if len(names) == 0 {
+			warn(server.Line, "skipping server without a server_name")
+			continue
+		}
+
+		var cert, key string
+		if certPath != "" || keyPath != "" {
+			if certPath == "" || keyPath == "" {
+				warn(server.Line, "skipping TLS for %s which needs both ssl_certificate and ssl_certificate_key", names[0])
+			} else if strings.Contains(certPath+keyPath, "$") {
+				warn(server.Line, "skipping TLS for %s whose certificate path uses variables", names[0])
+			} else {
+				c, err := readNginxFile(dir, certPath)
+				if err != nil {
+					return nil, nil, err
+				}
+				k, err := readNginxFile(dir, keyPath)
+				if err != nil {
+					return nil, nil, err
+				}
+				cert, key = string(c), string(k)
+			}
+		}
+
+		var serverRoutes int
+		for _, location := range locations {
+			path, ok := nginxLocationPath(location, warn)
+			if !ok {
+				continue
+			}
+			var proxyPass *nginxDirective
+			locationHeaders, locationBodySize := headers, bodySize
+			var ownHeaders bool
+			for _, d := range location.Block {
+				if _, ok := nginxLocationDirectives[d.Name]; !ok {
+					warn(d.Line, "ignoring unsupported directive %q in location %s", d.Name, path)
+					continue
+				}
+				switch d.Name {
+				case "proxy_pass":
+					proxyPass = d
+				case "proxy_set_header":
+					// headers set in a location replace those of the
+					// server, as in nginx
+					if !ownHeaders {
+						locationHeaders, ownHeaders = nil, true
+					}
+					locationHeaders = append(locationHeaders, d)
+				case "client_max_body_size":
+					locationBodySize = d
+				}
+			}
+			if proxyPass == nil {
+				warn(location.Line, "skipping location %s which doesn't use proxy_pass", path)
+				continue
+			}
+
+			r := &router.HTTPRoute{
+				Domain:        names[0],
+				Path:          path,
+				LegacyTLSCert: cert,
+				LegacyTLSKey:  key,
+			}
+			r.Service = nginxService(proxyPass, defaultService, warn)
+			if path == "/" {
+				r.Path = ""
+				if len(names) > 1 {
+					r.Aliases = names[1:]
+				}
+			}
+			for _, h := range locationHeaders {
+				nginxHeader(r, h, warn)
+			}
+			if r.ForwardedHost && r.BackendHost == "" {
+				// the router only sets X-Forwarded-Host when it
+				// replaces the Host header
+				r.ForwardedHost = false
+			}
+			if locationBodySize != nil {
+				size, err := parseNginxSize(nginxArg(locationBodySize))
+				if err != nil {
+					warn(locationBodySize.Line, "ignoring invalid client_max_body_size %q", nginxArg(locationBodySize))
+				} else {
+					r.MaxRequestBodySize = size
+				}
+			}
+			route := r.ToRoute()
+			if err := route.Validate(); err != nil {
+				warn(location.Line, "skipping location %s: %s", path, err)
+				continue
+			}
+			routes = append(routes, route)
+			serverRoutes++
+		}
+		if serverRoutes == 0 {
+			warn(server.Line, "no routes imported for %s, which has no locations using proxy_pass", names[0])
+		}
+	}
+	return routes, warnings, nil
+}
+
+// nginxLocationPath returns the path prefix matched by a location, which
+// is only possible for prefix locations.
+func nginxLocationPath(d *nginxDirective, warn func(int, string, ...interface{})) (string, bool) {
+	args := d.Args
+	if len(args) == 2 && args[0] == "^~" {
+		args = args[1:]
+	}
+	if len(args) != 1 || !strings.HasPrefix(args[0], "/") {
+		warn(d.Line, "skipping location %s which isn't a path prefix", strings.Join(d.Args, " "))
+		return "", false
+	}
+	return args[0], true
+}
+
+// nginxService returns the service a proxy_pass directive proxies to, which
+// is the host of its URL (e.g. an upstream block's name).
+func nginxService(d *nginxDirective, defaultService string, warn func(int, string, ...interface{})) string {
+	u, err := url.Parse(nginxArg(d))
+	if err != nil || u.Host == "" {
+		warn(d.Line, "proxying to %q with service %s", nginxArg(d), defaultService)
+		return defaultService
+	}
+	if u.Path != "" && u.Path != "/" {
+		warn(d.Line, "ignoring the path of proxy_pass %s, requests are proxied with their original path", u)
+	}
+	host := u.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if net.ParseIP(host) != nil || strings.Contains(host, "$") {
+		warn(d.Line, "proxying to %s with service %s", u.Host, defaultService)
+		return defaultService
+	}
+	return host
+}
+
+// nginxHeader imports a proxy_set_header directive which sets the Host or
+// X-Forwarded-Host headers.
+func nginxHeader(r *router.HTTPRoute, d *nginxDirective, warn func(int, string, ...interface{})) {
+	if len(d.Args) != 2 {
+		warn(d.Line, "ignoring invalid proxy_set_header")
+		return
+	}
+	name, value := d.Args[0], d.Args[1]
+	switch {
+	case strings.EqualFold(name, "Host") && (value == "$host" || value == "$http_host"):
+		// the router sends the requested host by default
+	case strings.EqualFold(name, "Host") && !strings.Contains(value, "$"):
+		r.BackendHost = value
+	case strings.EqualFold(name, "X-Forwarded-Host") && (value == "$host" || value == "$http_host"):
+		r.ForwardedHost = true
+	case strings.EqualFold(name, "X-Forwarded-For"), strings.EqualFold(name, "X-Forwarded-Proto"):
+		// the router sets these itself
+	default:
+		warn(d.Line, "ignoring proxy_set_header %s %s", name, value)
+	}
+}
+
+// parseNginxSize parses an nginx size such as 10m.
+func parseNginxSize(s string) (int64, error) {
+	if s == "" {
+		return 0, errors.New("empty size")
+	}
+	multiplier := int64(1)
+	switch s[len(s)-1] {
+	case 'k', 'K':
+		multiplier = 1 << 10
+	case 'm', 'M':
+		multiplier = 1 << 20
+	case 'g', 'G':
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+func nginxArg(d *nginxDirective) string {
+	if len(d.Args) == 0 {
+		return ""
+	}
+	return d.Args[0]
+}
+
+func readNginxFile(dir, path string) ([]byte, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading TLS file: %s", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/flynn/flynn/pkg/tlscert"
+	"github.com/flynn/flynn/router/types"
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestFindHTTPRoute(c *C) {
+	root := &router.Route{Type: "http", ID: "root", Domain: "example.com", Aliases: []string{"www.example.com"}}
+	api := &router.Route{Type: "http", ID: "api", Domain: "example.com", Path: "/api/"}
+	apiV2 := &router.Route{Type: "http", ID: "api-v2", Domain: "example.com", Path: "/api/v2/"}
+	wildcard := &router.Route{Type: "http", ID: "wildcard", Domain: "*.example.com"}
+	deep := &router.Route{Type: "http", ID: "deep", Domain: "*.eu.example.com"}
+	tcp := &router.Route{Type: "tcp", ID: "tcp", Port: 4444}
+	routes := []*router.Route{tcp, root, api, apiV2, wildcard, deep}
+
+	for _, t := range []struct {
+		url      string
+		expected *router.Route
+	}{
+		{"example.com", root},
+		{"EXAMPLE.com:443", root},
+		{"https://example.com/", root},
+		{"example.com/apis", root},
+		{"example.com/api", api},
+		{"example.com/api/users", api},
+		{"example.com/api/v2/users", apiV2},
+		// path based routes apply to the aliases of their domain
+		{"www.example.com/api/users", api},
+		{"app.example.com/api/users", wildcard},
+		{"app.eu.example.com", deep},
+		{"example.org", nil},
+	} {
+		host, path := splitRouteURL(t.url)
+		route := findHTTPRoute(routes, host, path)
+		if t.expected == nil {
+			c.Assert(route, IsNil, Commentf("%s", t.url))
+			continue
+		}
+		c.Assert(route, NotNil, Commentf("%s", t.url))
+		c.Assert(route.ID, Equals, t.expected.ID, Commentf("%s", t.url))
+	}
+}
+
+func (S) TestCertFingerprint(c *C) {
+	cert, err := tlscert.Generate([]string{"example.com"})
+	c.Assert(err, IsNil)
+	block, _ := pem.Decode([]byte(cert.Cert))
+	c.Assert(block, NotNil)
+	parsed, err := x509.ParseCertificate(block.Bytes)
+	c.Assert(err, IsNil)
+	sum := sha256.Sum256(parsed.Raw)
+
+	fingerprint, expiry, err := certFingerprint(cert.Cert)
+	c.Assert(err, IsNil)
+	c.Assert(fingerprint, Equals, hex.EncodeToString(sum[:]))
+	c.Assert(expiry.Equal(parsed.NotAfter), Equals, true)
+
+	_, _, err = certFingerprint("not a certificate")
+	c.Assert(err, NotNil)
+}
+
+func (S) TestNginxRoutes(c *C) {
+	cert, err := tlscert.Generate([]string{"example.com"})
+	c.Assert(err, IsNil)
+	dir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "example.crt"), []byte(cert.Cert), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "example.key"), []byte(cert.PrivateKey), 0600), IsNil)
+
+	config, err := parseNginxConfig([]byte(`
+worker_processes 4;
+http {
+	upstream example-web {
+		server 10.0.0.1:8080;
+	}
+	server {
+		listen 443 ssl;
+		server_name example.com www.example.com;
+		ssl_certificate example.crt;
+		ssl_certificate_key example.key;
+		gzip on;
+
+		location / {
+			proxy_pass http://example-web;
+		}
+		location /api/ {
+			proxy_pass http://example-api:8080;
+			proxy_set_header Host "api.internal";
+			proxy_set_header X-Forwarded-Host $host;
+			client_max_body_size 10m;
+		}
+		location ~ \.php$ {
+			proxy_pass http://php;
+		}
+		location /static/ {
+			root /var/www; # served by nginx
+		}
+	}
+	server {
+		server_name legacy.example.com;
+		location / {
+			proxy_pass http://127.0.0.1:3000;
+		}
+	}
+	server {
+		listen 80 default_server;
+		return 444;
+	}
+}
+`))
+	c.Assert(err, IsNil)
+	routes, warnings, err := nginxRoutes(config, dir, "test-web")
+	c.Assert(err, IsNil)
+	c.Assert(warnings, DeepEquals, []string{
+		`12: ignoring unsupported directive "gzip"`,
+		`23: skipping location ~ \.php$ which isn't a path prefix`,
+		`27: ignoring unsupported directive "root" in location /static/`,
+		`26: skipping location /static/ which doesn't use proxy_pass`,
+		`33: proxying to 127.0.0.1:3000 with service test-web`,
+		`38: ignoring unsupported directive "return"`,
+		`36: skipping server without a server_name`,
+	})
+
+	c.Assert(routes, HasLen, 3)
+	root := routes[0].HTTPRoute()
+	c.Assert(root.Domain, Equals, "example.com")
+	c.Assert(root.Path, Equals, "")
+	c.Assert(root.Aliases, DeepEquals, []string{"www.example.com"})
+	c.Assert(root.Service, Equals, "example-web")
+	c.Assert(root.LegacyTLSCert, Equals, cert.Cert)
+	c.Assert(root.LegacyTLSKey, Equals, cert.PrivateKey)
+	c.Assert(root.BackendHost, Equals, "")
+
+	api := routes[1].HTTPRoute()
+	c.Assert(api.Domain, Equals, "example.com")
+	c.Assert(api.Path, Equals, "/api/")
+	c.Assert(api.Aliases, IsNil)
+	c.Assert(api.Service, Equals, "example-api")
+	c.Assert(api.LegacyTLSCert, Equals, cert.Cert)
+	c.Assert(api.BackendHost, Equals, "api.internal")
+	c.Assert(api.ForwardedHost, Equals, true)
+	c.Assert(api.MaxRequestBodySize, Equals, int64(10<<20))
+
+	legacy := routes[2].HTTPRoute()
+	c.Assert(legacy.Domain, Equals, "legacy.example.com")
+	c.Assert(legacy.Service, Equals, "test-web")
+	c.Assert(legacy.LegacyTLSCert, Equals, "")
+
+	for _, config := range []string{
+		"server {",
+		"server { listen 80; }}",
+		"listen 80",
+		`server_name "example.com;`,
+		"; server {}",
+	} {
+		_, err := parseNginxConfig([]byte(config))
+		c.Assert(err, NotNil, Commentf("%s", config))
+	}
+}
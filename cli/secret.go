@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/go-docopt"
+)
+
+func init() {
+	register("secret", runSecret, `
+usage: flynn secret [--time-format=<format>]
+       flynn secret set <name> [<value>]
+       flynn secret unset <name>...
+
+Manage app secrets.
+
+Releases refer to secrets in their env rather than containing their values,
+by setting env values in release configuration to {"secretRef": "<name>"}:
+
+	{"env": {"DATABASE_PASSWORD": {"secretRef": "db-password"}}}
+
+The controller adds the values of the secrets to the env of the release's
+jobs when they are started, so they aren't shown by "release show" or in the
+release JSON. Releases can only be deployed once the secrets they refer to
+exist, and changing a secret affects jobs started after the change.
+
+Secret values are never shown once set.
+
+Options:
+	--time-format=<format>  format times as relative, rfc3339 or local (default relative, or $FLYNN_TIME_FORMAT)
+
+Commands:
+	With no arguments, lists the names of the app's secrets.
+
+	set    set a secret, reading the value from stdin if it isn't given
+	       (which keeps it out of shell history)
+
+	unset  delete secrets
+
+Examples:
+
+	$ printf %s "p4ssw0rd" | flynn secret set db-password
+
+	$ flynn secret
+	NAME         UPDATED
+	db-password  2 minutes ago
+
+	$ flynn secret unset db-password
+`)
+}
+
+func runSecret(args *docopt.Args, client controller.Client) error {
+	if args.Bool["set"] {
+		return runSecretSet(args, client)
+	} else if args.Bool["unset"] {
+		return runSecretUnset(args, client)
+	}
+
+	format, err := parseTimeFormat(args.String["--time-format"])
+	if err != nil {
+		return err
+	}
+	secrets, err := client.AppSecretList(mustApp())
+	if err != nil {
+		return err
+	}
+	w := tabWriter()
+	defer w.Flush()
+	listRec(w, "NAME", "UPDATED")
+	for _, s := range secrets {
+		listRec(w, s.Name, format.Format(s.UpdatedAt))
+	}
+	return nil
+}
+
+func runSecretSet(args *docopt.Args, client controller.Client) error {
+	// <name> is a list as unset takes several
+	secret := &ct.Secret{
+		Name:  args.All["<name>"].([]string)[0],
+		Value: args.String["<value>"],
+	}
+	if secret.Value == "" {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("Failed to read from stdin: %s", err)
+		}
+		secret.Value = string(data)
+	}
+	if secret.Value == "" {
+		return fmt.Errorf("Secret %s has no value.", secret.Name)
+	}
+	if err := client.SetAppSecret(mustApp(), secret); err != nil {
+		return err
+	}
+	log.Printf("Set secret %s.", secret.Name)
+	return nil
+}
+
+func runSecretUnset(args *docopt.Args, client controller.Client) error {
+	for _, name := range args.All["<name>"].([]string) {
+		if err := client.DeleteAppSecret(mustApp(), name); err != nil {
+			return err
+		}
+		log.Printf("Unset secret %s.", name)
+	}
+	return nil
+}
+
+// checkReleaseSecrets returns an error listing the secrets which release
+// refers to in its env but which the app doesn't have, which the controller
+// would refuse to deploy it without.
+func checkReleaseSecrets(client controller.Client, release *ct.Release) error {
+	if len(release.SecretEnv) == 0 {
+		return nil
+	}
+	secrets, err := client.AppSecretList(mustApp())
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool, len(secrets))
+	for _, s := range secrets {
+		exists[s.Name] = true
+	}
+	var missing []string
+	for key, name := range release.SecretEnv {
+		if !exists[name] {
+			missing = append(missing, fmt.Sprintf("%s (for %s)", name, key))
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return fmt.Errorf("The release refers to secrets which don't exist, set them with \"flynn secret set\": %s", strings.Join(missing, ", "))
+}
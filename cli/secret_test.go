@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	. "github.com/flynn/go-check"
+	"github.com/flynn/go-docopt"
+)
+
+func runSecretCommand(c *C, client controller.Client, app string, argv ...string) error {
+	defer func(prev string) { flagApp = prev }(flagApp)
+	flagApp = app
+	cmd := commands["secret"]
+	args, err := docopt.Parse(cmd.usage, append([]string{"secret"}, argv...), false, "", cmd.optsFirst)
+	c.Assert(err, IsNil)
+	return runSecret(args, client)
+}
+
+func (S) TestSecret(c *C) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	client, app := newFakeApp(c, &ct.Release{})
+	c.Assert(runSecretCommand(c, client, app.Name, "set", "db-password", "s3cr3t"), IsNil)
+	c.Assert(runSecretCommand(c, client, app.Name, "set", "api-key", "k3y"), IsNil)
+	c.Assert(logs.String(), Matches, `(?s).*Set secret db-password\.\n.*Set secret api-key\.\n`)
+	value, ok := client.SecretValue(app.ID, "db-password")
+	c.Assert(ok, Equals, true)
+	c.Assert(value, Equals, "s3cr3t")
+
+	// the list shows names but not values
+	out := captureStdout(c, func() {
+		c.Assert(runSecretCommand(c, client, app.Name), IsNil)
+	})
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	c.Assert(lines, HasLen, 3)
+	c.Assert(strings.Fields(lines[0]), DeepEquals, []string{"NAME", "UPDATED"})
+	c.Assert(strings.HasPrefix(lines[1], "api-key "), Equals, true)
+	c.Assert(strings.HasPrefix(lines[2], "db-password "), Equals, true)
+	c.Assert(strings.Contains(out, "s3cr3t"), Equals, false)
+
+	c.Assert(runSecretCommand(c, client, app.Name, "unset", "db-password"), IsNil)
+	_, ok = client.SecretValue(app.ID, "db-password")
+	c.Assert(ok, Equals, false)
+	_, ok = client.SecretValue(app.ID, "api-key")
+	c.Assert(ok, Equals, true)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// traceSpan is printed for each request to the controller when the --trace
+// flag is set.
+type traceSpan struct {
+	Name       string    `json:"name"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	Status     int       `json:"status,omitempty"`
+	Start      time.Time `json:"start"`
+	DurationMs float64   `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// traceTransport writes a span to out for each request sent with next,
+// timing until the response headers are received (streamed response bodies
+// such as logs and events would otherwise never finish).
+type traceTransport struct {
+	next http.RoundTripper
+
+	mtx *sync.Mutex
+	out io.Writer
+}
+
+// newTraceTransport returns a function for wrapping the controller client's
+// transport which traces requests to out.
+func newTraceTransport(out io.Writer) func(http.RoundTripper) http.RoundTripper {
+	mtx := &sync.Mutex{}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &traceTransport{next: next, mtx: mtx, out: out}
+	}
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// don't print the cluster key if it is sent in the query
+	u := *req.URL
+	u.User = nil
+	if q := u.Query(); q.Get("key") != "" {
+		q.Del("key")
+		u.RawQuery = q.Encode()
+	}
+	span := &traceSpan{
+		Name:   req.Method + " " + u.Path,
+		Method: req.Method,
+		URL:    u.String(),
+		Start:  time.Now(),
+	}
+	res, err := t.next.RoundTrip(req)
+	span.DurationMs = float64(time.Since(span.Start)) / float64(time.Millisecond)
+	if err != nil {
+		span.Error = err.Error()
+	} else {
+		span.Status = res.StatusCode
+	}
+	t.mtx.Lock()
+	json.NewEncoder(t.out).Encode(span)
+	t.mtx.Unlock()
+	return res, err
+}
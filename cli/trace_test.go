@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	cfg "github.com/flynn/flynn/cli/config"
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestTrace(c *C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/apps/foo" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"00000000-0000-0000-0000-000000000001","name":"foo"}`))
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	cluster := &cfg.Cluster{ControllerURL: srv.URL, Key: "s3cr3t"}
+	client, err := cluster.ClientWithTransport(newTraceTransport(&out))
+	c.Assert(err, IsNil)
+	app, err := client.GetApp("foo")
+	c.Assert(err, IsNil)
+	c.Assert(app.Name, Equals, "foo")
+	_, err = client.GetApp("bar")
+	c.Assert(err, NotNil)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	c.Assert(lines, HasLen, 2)
+	var spans []traceSpan
+	for _, line := range lines {
+		var span traceSpan
+		c.Assert(json.Unmarshal([]byte(line), &span), IsNil)
+		spans = append(spans, span)
+	}
+	c.Assert(spans[0].Name, Equals, "GET /apps/foo")
+	c.Assert(spans[0].Method, Equals, "GET")
+	c.Assert(spans[0].URL, Equals, srv.URL+"/apps/foo")
+	c.Assert(spans[0].Status, Equals, 200)
+	c.Assert(spans[0].Start.IsZero(), Equals, false)
+	c.Assert(spans[1].Name, Equals, "GET /apps/bar")
+	c.Assert(spans[1].Status, Equals, 404)
+
+	// the key is never printed
+	c.Assert(strings.Contains(out.String(), "s3cr3t"), Equals, false)
+}
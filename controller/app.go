@@ -243,33 +243,37 @@ func (r *AppRepo) SetRelease(app *ct.App, releaseID string) error {
 	if err != nil {
 		return err
 	}
-	var release *ct.Release
+	if err := setAppRelease(tx, app.ID, app.ReleaseID, releaseID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	app.ReleaseID = releaseID
+	return tx.Commit()
+}
+
+// setAppRelease sets the release of the app with the given ID, which
+// currently has the release prevReleaseID, and creates an app release event.
+func setAppRelease(tx *postgres.DBTx, appID, prevReleaseID, releaseID string) error {
 	var prevRelease *ct.Release
-	if app.ReleaseID != "" {
-		row := tx.QueryRow("release_select", app.ReleaseID)
+	if prevReleaseID != "" {
+		row := tx.QueryRow("release_select", prevReleaseID)
 		prevRelease, _ = scanRelease(row)
 	}
-	row := tx.QueryRow("release_select", releaseID)
-	if release, err = scanRelease(row); err != nil {
+	release, err := scanRelease(tx.QueryRow("release_select", releaseID))
+	if err != nil {
 		return err
 	}
-	app.ReleaseID = releaseID
-	if err := tx.Exec("app_update_release", app.ID, app.ReleaseID); err != nil {
-		tx.Rollback()
+	if err := tx.Exec("app_update_release", appID, releaseID); err != nil {
 		return err
 	}
-	if err := createEvent(tx.Exec, &ct.Event{
-		AppID:      app.ID,
+	return createEvent(tx.Exec, &ct.Event{
+		AppID:      appID,
 		ObjectID:   release.ID,
 		ObjectType: ct.EventTypeAppRelease,
 	}, &ct.AppRelease{
 		PrevRelease: prevRelease,
 		Release:     release,
-	}); err != nil {
-		tx.Rollback()
-		return err
-	}
-	return tx.Commit()
+	})
 }
 
 func (r *AppRepo) GetRelease(id string) (*ct.Release, error) {
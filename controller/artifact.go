@@ -6,6 +6,7 @@ import (
 
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/host/types"
+	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/postgres"
 	"github.com/flynn/flynn/pkg/random"
 	"github.com/jackc/pgx"
@@ -37,6 +38,21 @@ func (r *ArtifactRepo) Add(data interface{}) error {
 	}
 
 	err = tx.QueryRow("artifact_insert", a.ID, string(a.Type), a.URI, a.Meta).Scan(&a.CreatedAt)
+	if postgres.IsUniquenessError(err, "artifacts_pkey") {
+		// the client supplied the ID of an existing artifact (e.g. when
+		// retrying a request which timed out), so return the original
+		// artifact rather than creating a new one
+		tx.Rollback()
+		existing, err := scanArtifact(r.db.QueryRow("artifact_select", a.ID))
+		if err != nil {
+			return err
+		}
+		if existing.Type != a.Type || existing.URI != a.URI {
+			return idempotencyConflictError("artifact", a.ID)
+		}
+		*a = *existing
+		return nil
+	}
 	if postgres.IsUniquenessError(err, "") {
 		tx.Rollback()
 		tx, err = r.db.Begin()
@@ -64,6 +80,32 @@ func (r *ArtifactRepo) Add(data interface{}) error {
 	return tx.Commit()
 }
 
+// Remove deletes an artifact which is not referenced by any release, for
+// example an artifact left behind after failing to create a release.
+func (r *ArtifactRepo) Remove(id string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	var count int64
+	if err := tx.QueryRow("artifact_release_count", id).Scan(&count); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if count > 0 {
+		tx.Rollback()
+		return httphelper.JSONError{
+			Code:    httphelper.ConflictErrorCode,
+			Message: "artifact is referenced by a release",
+		}
+	}
+	if err := tx.Exec("artifact_delete", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
 func scanArtifact(s postgres.Scanner) (*ct.Artifact, error) {
 	artifact := &ct.Artifact{}
 	var typ string
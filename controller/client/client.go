@@ -13,6 +13,7 @@ import (
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/pkg/dialer"
 	"github.com/flynn/flynn/pkg/httpclient"
+	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/pinned"
 	"github.com/flynn/flynn/pkg/stream"
 	"github.com/flynn/flynn/router/types"
@@ -23,6 +24,7 @@ type Client interface {
 	StreamFormations(since *time.Time, output chan<- *ct.ExpandedFormation) (stream.Stream, error)
 	PutDomain(dm *ct.DomainMigration) error
 	CreateArtifact(artifact *ct.Artifact) error
+	DeleteArtifact(artifactID string) error
 	CreateRelease(release *ct.Release) error
 	CreateApp(app *ct.App) error
 	UpdateApp(app *ct.App) error
@@ -37,6 +39,9 @@ type Client interface {
 	AddResourceApp(providerID, resourceID, appID string) (*ct.Resource, error)
 	DeleteResourceApp(providerID, resourceID, appID string) (*ct.Resource, error)
 	AppResourceList(appID string) ([]*ct.Resource, error)
+	AppSecretList(appID string) ([]*ct.Secret, error)
+	SetAppSecret(appID string, secret *ct.Secret) error
+	DeleteAppSecret(appID, name string) error
 	PutResource(resource *ct.Resource) error
 	DeleteResource(providerID, resourceID string) (*ct.Resource, error)
 	PutFormation(formation *ct.Formation) error
@@ -55,15 +60,19 @@ type Client interface {
 	FormationListActive() ([]*ct.ExpandedFormation, error)
 	DeleteFormation(appID, releaseID string) error
 	GetRelease(releaseID string) (*ct.Release, error)
+	UpdateReleaseMeta(release *ct.Release) error
 	GetArtifact(artifactID string) (*ct.Artifact, error)
 	GetApp(appID string) (*ct.App, error)
 	GetAppLog(appID string, options *ct.LogOpts) (io.ReadCloser, error)
 	StreamAppLog(appID string, options *ct.LogOpts, output chan<- *ct.SSELogChunk) (stream.Stream, error)
 	GetDeployment(deploymentID string) (*ct.Deployment, error)
 	CreateDeployment(appID, releaseID string) (*ct.Deployment, error)
+	PlanDeployment(appID string, release *ct.Release) (*ct.DeploymentPlan, error)
 	DeploymentList(appID string) ([]*ct.Deployment, error)
 	StreamDeployment(d *ct.Deployment, output chan *ct.DeploymentEvent) (stream.Stream, error)
-	DeployAppRelease(appID, releaseID string, stopWait <-chan struct{}) error
+	DeployAppRelease(appID, releaseID string, stopWait <-chan struct{}) (*ct.DeployResult, error)
+	CreateDeploymentIfCurrent(appID, releaseID, currentReleaseID string) (*ct.Deployment, error)
+	DeployAppReleaseIfCurrent(appID, releaseID, currentReleaseID string, stopWait <-chan struct{}) (*ct.DeployResult, error)
 	StreamJobEvents(appID string, output chan *ct.Job) (stream.Stream, error)
 	WatchJobEvents(appID, releaseID string) (ct.JobWatcher, error)
 	StreamEvents(opts ct.StreamEventsOptions, output chan *ct.Event) (stream.Stream, error)
@@ -80,6 +89,8 @@ type Client interface {
 	ArtifactList() ([]*ct.Artifact, error)
 	ReleaseList() ([]*ct.Release, error)
 	AppReleaseList(appID string) ([]*ct.Release, error)
+	AppReleaseListCount(appID string, count int) ([]*ct.Release, error)
+	AppReleaseSummary(appID string) (*ct.ReleaseSummary, error)
 	CreateKey(pubKey string) (*ct.Key, error)
 	GetKey(keyID string) (*ct.Key, error)
 	DeleteKey(id string) error
@@ -87,26 +98,94 @@ type Client interface {
 	Backup() (io.ReadCloser, error)
 	GetBackupMeta() (*ct.ClusterBackup, error)
 	DeleteRelease(appID, releaseID string) (*ct.ReleaseDeletion, error)
+	DeleteReleaseWithProgress(appID, releaseID string, progress func(*ct.ReleaseDeletionProgress)) (*ct.ReleaseDeletion, error)
 	ScheduleAppGarbageCollection(appID string) error
 }
 
 type Config struct {
 	Pin    []byte
 	Domain string
+
+	// TokenProvider, if set, is consulted for the key to authenticate
+	// each request with instead of using a static key.
+	TokenProvider httpclient.TokenProvider
+
+	// Cache, if set, caches release responses (see
+	// v1controller.ResponseCache).
+	Cache *v1controller.ResponseCache
+
+	// HTTPClient, if set, is used to send requests instead of a client
+	// built by NewClientWithConfig (e.g. to use a test transport). It can't
+	// be used with Pin.
+	HTTPClient *http.Client
+
+	// WrapTransport, if set, wraps the transport requests are sent with,
+	// whether that of HTTPClient or of the built client, so that requests
+	// can be instrumented (e.g. traced or logged) without changing how
+	// they are sent.
+	WrapTransport func(http.RoundTripper) http.RoundTripper
 }
 
-// ErrNotFound is returned when a resource is not found (HTTP status 404).
-var ErrNotFound = errors.New("controller: resource not found")
+// httpClient returns c with its transport wrapped by WrapTransport if it is
+// set, leaving c unchanged.
+func (config Config) httpClient(c *http.Client) *http.Client {
+	if config.WrapTransport == nil {
+		return c
+	}
+	wrapped := *c
+	transport := c.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	wrapped.Transport = config.WrapTransport(transport)
+	return &wrapped
+}
+
+var (
+	// ErrNotFound is returned when a resource is not found (HTTP status 404).
+	ErrNotFound = errors.New("controller: resource not found")
+
+	// ErrUnauthorized is returned when the controller rejects the client's
+	// key (HTTP status 401).
+	ErrUnauthorized = errors.New("controller: unauthorized")
+)
+
+// IsNotFound returns whether err indicates that a resource was not found.
+func IsNotFound(err error) bool {
+	if err == ErrNotFound {
+		return true
+	}
+	e, ok := err.(httphelper.JSONError)
+	return ok && (e.Code == httphelper.NotFoundErrorCode || e.Code == httphelper.ObjectNotFoundErrorCode)
+}
+
+// IsConflict returns whether err indicates that a request conflicted with
+// the current state of a resource (e.g. the resource already exists).
+func IsConflict(err error) bool {
+	e, ok := err.(httphelper.JSONError)
+	return ok && (e.Code == httphelper.ConflictErrorCode || e.Code == httphelper.ObjectExistsErrorCode)
+}
+
+// IsUnauthorized returns whether err indicates that the client is not
+// authorized to make a request.
+func IsUnauthorized(err error) bool {
+	if err == ErrUnauthorized {
+		return true
+	}
+	e, ok := err.(httphelper.JSONError)
+	return ok && e.Code == httphelper.UnauthorizedErrorCode
+}
 
 // newClient creates a generic Client object, additional attributes must
 // be set by the caller
 func newClient(key string, url string, http *http.Client) *v1controller.Client {
 	c := &v1controller.Client{
 		Client: &httpclient.Client{
-			ErrNotFound: ErrNotFound,
-			Key:         key,
-			URL:         url,
-			HTTP:        http,
+			ErrNotFound:     ErrNotFound,
+			ErrUnauthorized: ErrUnauthorized,
+			Key:             key,
+			URL:             url,
+			HTTP:            http,
 		},
 	}
 	return c
@@ -120,6 +199,10 @@ func NewClient(uri, key string) (Client, error) {
 }
 
 func NewClientWithHTTP(uri, key string, httpClient *http.Client) (Client, error) {
+	return newClientWithHTTP(uri, key, httpClient)
+}
+
+func newClientWithHTTP(uri, key string, httpClient *http.Client) (*v1controller.Client, error) {
 	if uri == "" {
 		uri = "http://controller.discoverd"
 	}
@@ -133,14 +216,29 @@ func NewClientWithHTTP(uri, key string, httpClient *http.Client) (Client, error)
 // NewClientWithConfig acts like NewClient, but supports custom configuration.
 func NewClientWithConfig(uri, key string, config Config) (Client, error) {
 	if config.Pin == nil {
-		return NewClient(uri, key)
+		httpClient := config.HTTPClient
+		if httpClient == nil {
+			httpClient = &http.Client{Transport: &http.Transport{Dial: dialer.Retry.Dial}}
+		}
+		c, err := newClientWithHTTP(uri, key, config.httpClient(httpClient))
+		if err != nil {
+			return nil, err
+		}
+		c.TokenProvider = config.TokenProvider
+		c.Cache = config.Cache
+		return c, nil
+	}
+	if config.HTTPClient != nil {
+		return nil, errors.New("controller: a custom HTTP client can't be used with a TLS pin")
 	}
 	d := &pinned.Config{Pin: config.Pin}
 	if config.Domain != "" {
 		d.Config = &tls.Config{ServerName: config.Domain}
 	}
 	httpClient := &http.Client{Transport: &http.Transport{DialTLS: d.Dial}}
-	c := newClient(key, uri, httpClient)
+	c := newClient(key, uri, config.httpClient(httpClient))
+	c.TokenProvider = config.TokenProvider
+	c.Cache = config.Cache
 	c.Host = config.Domain
 	c.HijackDial = d.Dial
 	return c, nil
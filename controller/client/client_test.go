@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestClientWithHTTPConfig(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"00000000-0000-0000-0000-000000000001","name":"foo"}`))
+	}))
+	defer srv.Close()
+
+	var viaClient, viaWrapper []string
+	httpClient := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		viaClient = append(viaClient, req.URL.Path)
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+	wrap := func(next http.RoundTripper) http.RoundTripper {
+		return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			viaWrapper = append(viaWrapper, req.URL.Path)
+			return next.RoundTrip(req)
+		})
+	}
+	client, err := NewClientWithConfig(srv.URL, "key", Config{HTTPClient: httpClient, WrapTransport: wrap})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := client.GetApp("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if app.Name != "foo" {
+		t.Fatalf("expected app foo, got %q", app.Name)
+	}
+	// requests go through the wrapper then the given client's transport
+	if len(viaWrapper) != 1 || viaWrapper[0] != "/apps/foo" {
+		t.Fatalf("unexpected requests via wrapper: %v", viaWrapper)
+	}
+	if len(viaClient) != 1 || viaClient[0] != "/apps/foo" {
+		t.Fatalf("unexpected requests via client: %v", viaClient)
+	}
+	// and the given client isn't changed
+	if _, ok := httpClient.Transport.(roundTripFunc); !ok {
+		t.Fatalf("expected transport to be unchanged, got %T", httpClient.Transport)
+	}
+
+	// the default client is also wrapped
+	viaWrapper = nil
+	client, err = NewClientWithConfig(srv.URL, "key", Config{WrapTransport: wrap})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetApp("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if len(viaWrapper) != 1 {
+		t.Fatalf("expected a request via the wrapper, got %v", viaWrapper)
+	}
+
+	if _, err := NewClientWithConfig(srv.URL, "key", Config{HTTPClient: httpClient, Pin: []byte("pin")}); err == nil {
+		t.Fatal("expected an error using a custom HTTP client with a TLS pin")
+	}
+}
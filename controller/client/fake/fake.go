@@ -0,0 +1,1008 @@
+// Package fake provides an in-memory implementation of the controller client
+// interface, for testing code which uses the controller without running one.
+//
+// Apps, artifacts, releases, formations, deployments and app secrets are
+// stored in memory
+// and deploys take effect immediately. Each formation is run by jobs which
+// are listed as up as soon as it is created. Methods which the fake doesn't
+// simulate return ErrNotImplemented.
+package fake
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	controller "github.com/flynn/flynn/controller/client"
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/host/resource"
+	"github.com/flynn/flynn/pkg/httpclient"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/random"
+	"github.com/flynn/flynn/pkg/stream"
+	"github.com/flynn/flynn/router/types"
+)
+
+// ErrNotImplemented is returned by methods which the fake client doesn't
+// simulate.
+var ErrNotImplemented = errors.New("fake: not implemented")
+
+var _ controller.Client = (*Client)(nil)
+
+// Client is an in-memory controller client. The zero value is not usable,
+// use NewClient.
+type Client struct {
+	// DeployErr, if set, is returned by deploys instead of deploying the
+	// release (the deployment is still recorded).
+	DeployErr error
+
+	// FileDeleteErrs, if set, maps the URIs of blobstore files to the
+	// error returned when deleting them, simulating storage failing
+	// partway through deleting a release. Deleting the release again
+	// resumes with the files which weren't deleted.
+	FileDeleteErrs map[string]error
+
+	// StartingJobs, if set, is the number of jobs of each process type
+	// which JobList lists as starting rather than up, simulating jobs
+	// which haven't finished starting.
+	StartingJobs map[string]int
+
+	mtx         sync.Mutex
+	apps        map[string]*ct.App
+	artifacts   map[string]*ct.Artifact
+	releases    map[string]*ct.Release
+	appReleases map[string][]string // release IDs of each app, oldest first
+	formations  map[string]*ct.Formation
+	deployments []*ct.Deployment
+	secrets     map[string]map[string]*ct.Secret // by app ID, then name
+
+	createdArtifacts []*ct.Artifact
+	createdReleases  []*ct.Release
+	deletedReleases  []string
+
+	// pendingFiles are the file deletions of deleted releases which failed
+	// partway, by release ID
+	pendingFiles map[string]*fileDeletion
+
+	// now is the time given to the last created object, which is increased
+	// so that objects created in quick succession are ordered correctly
+	now time.Time
+}
+
+// NewClient returns a fake client with no apps.
+func NewClient() *Client {
+	return &Client{
+		apps:         make(map[string]*ct.App),
+		artifacts:    make(map[string]*ct.Artifact),
+		releases:     make(map[string]*ct.Release),
+		appReleases:  make(map[string][]string),
+		formations:   make(map[string]*ct.Formation),
+		pendingFiles: make(map[string]*fileDeletion),
+		secrets:      make(map[string]map[string]*ct.Secret),
+	}
+}
+
+// SecretValue returns the value of the secret of the given app with the
+// given name, and whether it exists.
+func (c *Client) SecretValue(appID, name string) (string, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	secret, ok := c.secrets[appID][name]
+	if !ok {
+		return "", false
+	}
+	return secret.Value, true
+}
+
+// CreatedArtifacts returns the artifacts created with CreateArtifact, in the
+// order they were created.
+func (c *Client) CreatedArtifacts() []*ct.Artifact {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return append([]*ct.Artifact(nil), c.createdArtifacts...)
+}
+
+// CreatedReleases returns the releases created with CreateRelease, in the
+// order they were created.
+func (c *Client) CreatedReleases() []*ct.Release {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return append([]*ct.Release(nil), c.createdReleases...)
+}
+
+// DeletedReleases returns the IDs of the releases deleted with
+// DeleteRelease.
+func (c *Client) DeletedReleases() []string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return append([]string(nil), c.deletedReleases...)
+}
+
+// Deployments returns all deployments created, in the order they were
+// created.
+func (c *Client) Deployments() []*ct.Deployment {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return append([]*ct.Deployment(nil), c.deployments...)
+}
+
+func (c *Client) timestamp() *time.Time {
+	now := time.Now()
+	if !now.After(c.now) {
+		now = c.now.Add(time.Microsecond)
+	}
+	c.now = now
+	return &now
+}
+
+// app returns the app with the given ID or name.
+func (c *Client) app(idOrName string) (*ct.App, error) {
+	if app, ok := c.apps[idOrName]; ok {
+		return app, nil
+	}
+	for _, app := range c.apps {
+		if app.Name == idOrName {
+			return app, nil
+		}
+	}
+	return nil, controller.ErrNotFound
+}
+
+func (c *Client) addAppRelease(appID, releaseID string) {
+	for _, id := range c.appReleases[appID] {
+		if id == releaseID {
+			return
+		}
+	}
+	c.appReleases[appID] = append(c.appReleases[appID], releaseID)
+}
+
+func formationKey(appID, releaseID string) string {
+	return appID + "/" + releaseID
+}
+
+func (c *Client) CreateApp(app *ct.App) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if app.ID == "" {
+		app.ID = random.UUID()
+	}
+	if app.Name == "" {
+		app.Name = "app-" + app.ID
+	}
+	if _, err := c.app(app.Name); err == nil {
+		return httphelper.JSONError{Code: httphelper.ObjectExistsErrorCode, Message: "app already exists"}
+	}
+	if app.Strategy == "" {
+		app.Strategy = "all-at-once"
+	}
+	app.CreatedAt = c.timestamp()
+	app.UpdatedAt = app.CreatedAt
+	stored := *app
+	c.apps[app.ID] = &stored
+	return nil
+}
+
+func (c *Client) UpdateApp(app *ct.App) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	existing, err := c.app(app.ID)
+	if err != nil {
+		return err
+	}
+	if app.Meta != nil {
+		existing.Meta = app.Meta
+	}
+	if app.Strategy != "" {
+		existing.Strategy = app.Strategy
+	}
+	if app.DeployTimeout != 0 {
+		existing.DeployTimeout = app.DeployTimeout
+	}
+	existing.UpdatedAt = c.timestamp()
+	*app = *existing
+	return nil
+}
+
+func (c *Client) UpdateAppMeta(app *ct.App) error {
+	return c.UpdateApp(app)
+}
+
+func (c *Client) GetApp(appID string) (*ct.App, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	app, err := c.app(appID)
+	if err != nil {
+		return nil, err
+	}
+	res := *app
+	return &res, nil
+}
+
+func (c *Client) AppList() ([]*ct.App, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	apps := make([]*ct.App, 0, len(c.apps))
+	for _, app := range c.apps {
+		res := *app
+		apps = append(apps, &res)
+	}
+	return apps, nil
+}
+
+func (c *Client) DeleteApp(appID string) (*ct.AppDeletion, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	app, err := c.app(appID)
+	if err != nil {
+		return nil, err
+	}
+	delete(c.apps, app.ID)
+	delete(c.appReleases, app.ID)
+	return &ct.AppDeletion{AppID: app.ID}, nil
+}
+
+func (c *Client) CreateArtifact(artifact *ct.Artifact) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if artifact.ID == "" {
+		artifact.ID = random.UUID()
+	}
+	if artifact.CreatedAt == nil {
+		artifact.CreatedAt = c.timestamp()
+	}
+	stored := *artifact
+	c.artifacts[artifact.ID] = &stored
+	c.createdArtifacts = append(c.createdArtifacts, &stored)
+	return nil
+}
+
+func (c *Client) GetArtifact(artifactID string) (*ct.Artifact, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	artifact, ok := c.artifacts[artifactID]
+	if !ok {
+		return nil, controller.ErrNotFound
+	}
+	res := *artifact
+	return &res, nil
+}
+
+func (c *Client) ArtifactList() ([]*ct.Artifact, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	list := make([]*ct.Artifact, 0, len(c.artifacts))
+	for _, artifact := range c.artifacts {
+		res := *artifact
+		list = append(list, &res)
+	}
+	return list, nil
+}
+
+func (c *Client) DeleteArtifact(artifactID string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if _, ok := c.artifacts[artifactID]; !ok {
+		return controller.ErrNotFound
+	}
+	// as with the controller, artifacts used by a release can't be deleted
+	for _, release := range c.releases {
+		for _, id := range release.ArtifactIDs {
+			if id == artifactID {
+				return httphelper.JSONError{Code: httphelper.ConflictErrorCode, Message: "artifact is referenced by a release"}
+			}
+		}
+	}
+	delete(c.artifacts, artifactID)
+	return nil
+}
+
+// CreateRelease stores a copy of release, so later changes to release (or
+// to its maps) don't affect the stored release.
+func (c *Client) CreateRelease(release *ct.Release) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if release.ID == "" {
+		release.ID = random.UUID()
+	}
+	if _, ok := c.releases[release.ID]; ok {
+		return httphelper.JSONError{Code: httphelper.ObjectExistsErrorCode, Message: "release already exists"}
+	}
+	for _, id := range release.ArtifactIDs {
+		if _, ok := c.artifacts[id]; !ok {
+			return httphelper.JSONError{Code: httphelper.ValidationErrorCode, Message: "artifact " + id + " not found"}
+		}
+	}
+	release.CreatedAt = c.timestamp()
+	stored := copyRelease(release)
+	c.releases[release.ID] = stored
+	c.createdReleases = append(c.createdReleases, stored)
+	return nil
+}
+
+func (c *Client) GetRelease(releaseID string) (*ct.Release, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	release, ok := c.releases[releaseID]
+	if !ok {
+		return nil, controller.ErrNotFound
+	}
+	return copyRelease(release), nil
+}
+
+func (c *Client) UpdateReleaseMeta(release *ct.Release) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	stored, ok := c.releases[release.ID]
+	if !ok {
+		return controller.ErrNotFound
+	}
+	if stored.Meta == nil {
+		stored.Meta = make(map[string]string, len(release.Meta))
+	}
+	for k, v := range release.Meta {
+		stored.Meta[k] = v
+	}
+	*release = *copyRelease(stored)
+	return nil
+}
+
+func (c *Client) ReleaseList() ([]*ct.Release, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	list := make([]*ct.Release, 0, len(c.releases))
+	for _, release := range c.releases {
+		list = append(list, copyRelease(release))
+	}
+	return list, nil
+}
+
+// AppReleaseList returns the releases which have been deployed to the app
+// or have a formation for it, newest first.
+func (c *Client) AppReleaseList(appID string) ([]*ct.Release, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	app, err := c.app(appID)
+	if err != nil {
+		return nil, err
+	}
+	ids := c.appReleases[app.ID]
+	list := make([]*ct.Release, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		list = append(list, copyRelease(c.releases[ids[i]]))
+	}
+	return list, nil
+}
+
+// AppReleaseListCount returns the count most recent releases of the app.
+func (c *Client) AppReleaseListCount(appID string, count int) ([]*ct.Release, error) {
+	list, err := c.AppReleaseList(appID)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 && len(list) > count {
+		list = list[:count]
+	}
+	return list, nil
+}
+
+func (c *Client) AppReleaseSummary(appID string) (*ct.ReleaseSummary, error) {
+	list, err := c.AppReleaseList(appID)
+	if err != nil {
+		return nil, err
+	}
+	app, err := c.GetApp(appID)
+	if err != nil {
+		return nil, err
+	}
+	summary := &ct.ReleaseSummary{Count: len(list), CurrentReleaseID: app.ReleaseID}
+	if len(list) > 0 {
+		summary.NewestCreatedAt = list[0].CreatedAt
+		summary.OldestCreatedAt = list[len(list)-1].CreatedAt
+	}
+	return summary, nil
+}
+
+func (c *Client) SetAppRelease(appID, releaseID string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.setAppRelease(appID, releaseID)
+}
+
+func (c *Client) setAppRelease(appID, releaseID string) error {
+	app, err := c.app(appID)
+	if err != nil {
+		return err
+	}
+	if _, ok := c.releases[releaseID]; !ok {
+		return controller.ErrNotFound
+	}
+	app.ReleaseID = releaseID
+	c.addAppRelease(app.ID, releaseID)
+	return nil
+}
+
+func (c *Client) GetAppRelease(appID string) (*ct.Release, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	app, err := c.app(appID)
+	if err != nil {
+		return nil, err
+	}
+	release, ok := c.releases[app.ReleaseID]
+	if !ok {
+		return nil, controller.ErrNotFound
+	}
+	return copyRelease(release), nil
+}
+
+// DeleteRelease deletes the release from the app, and entirely if no other
+// app uses it.
+func (c *Client) DeleteRelease(appID, releaseID string) (*ct.ReleaseDeletion, error) {
+	return c.DeleteReleaseWithProgress(appID, releaseID, nil)
+}
+
+// DeleteReleaseWithProgress is like DeleteRelease, and reports the URIs of
+// the release's blobstore file artifacts as deleted if the release is
+// deleted entirely, calling progress for each one. If deleting a file fails
+// (see FileDeleteErrs), a *ct.ReleaseDeletionError is returned and deleting
+// the release again resumes with the remaining files.
+func (c *Client) DeleteReleaseWithProgress(appID, releaseID string, progress func(*ct.ReleaseDeletionProgress)) (*ct.ReleaseDeletion, error) {
+	deletion, events, err := c.deleteRelease(appID, releaseID)
+	if progress != nil {
+		for _, p := range events {
+			progress(p)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return deletion, nil
+}
+
+// fileDeletion is the deletion of the files of a release.
+type fileDeletion struct {
+	deletion *ct.ReleaseDeletion
+	files    []string // not yet deleted
+}
+
+func (c *Client) deleteRelease(appID, releaseID string) (*ct.ReleaseDeletion, []*ct.ReleaseDeletionProgress, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	app, err := c.app(appID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pending, ok := c.pendingFiles[releaseID]; ok && pending.deletion.AppID == app.ID {
+		return c.deleteFiles(pending)
+	}
+	if app.ReleaseID == releaseID {
+		return nil, nil, httphelper.JSONError{Code: httphelper.ValidationErrorCode, Message: "cannot delete current app release"}
+	}
+	ids := c.appReleases[app.ID]
+	found := false
+	for i, id := range ids {
+		if id == releaseID {
+			c.appReleases[app.ID] = append(ids[:i:i], ids[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, nil, controller.ErrNotFound
+	}
+	delete(c.formations, formationKey(app.ID, releaseID))
+	c.deletedReleases = append(c.deletedReleases, releaseID)
+
+	deletion := &ct.ReleaseDeletion{AppID: app.ID, ReleaseID: releaseID}
+	for otherID, otherIDs := range c.appReleases {
+		for _, id := range otherIDs {
+			if id == releaseID {
+				deletion.RemainingApps = append(deletion.RemainingApps, otherID)
+			}
+		}
+	}
+	if len(deletion.RemainingApps) == 0 {
+		var files []string
+		for _, id := range c.releases[releaseID].FileArtifactIDs() {
+			if artifact, ok := c.artifacts[id]; ok && artifact.Blobstore() {
+				files = append(files, artifact.URI)
+			}
+		}
+		delete(c.releases, releaseID)
+		deletion.TotalFiles = len(files)
+		return c.deleteFiles(&fileDeletion{deletion: deletion, files: files})
+	}
+	return deletion, nil, nil
+}
+
+// deleteFiles deletes the remaining files of d in order, stopping at the
+// first which fails to be deleted so that it can be resumed.
+func (c *Client) deleteFiles(d *fileDeletion) (*ct.ReleaseDeletion, []*ct.ReleaseDeletionProgress, error) {
+	var progress []*ct.ReleaseDeletionProgress
+	for len(d.files) > 0 {
+		uri := d.files[0]
+		if err := c.FileDeleteErrs[uri]; err != nil {
+			c.pendingFiles[d.deletion.ReleaseID] = d
+			partial := *d.deletion
+			partial.DeletedFiles = append([]string(nil), partial.DeletedFiles...)
+			return nil, progress, &ct.ReleaseDeletionError{Deletion: &partial, Err: err.Error()}
+		}
+		d.files = d.files[1:]
+		d.deletion.DeletedFiles = append(d.deletion.DeletedFiles, uri)
+		progress = append(progress, &ct.ReleaseDeletionProgress{
+			AppID:     d.deletion.AppID,
+			ReleaseID: d.deletion.ReleaseID,
+			File:      uri,
+			Deleted:   len(d.deletion.DeletedFiles),
+			Total:     d.deletion.TotalFiles,
+		})
+	}
+	delete(c.pendingFiles, d.deletion.ReleaseID)
+	return d.deletion, progress, nil
+}
+
+func (c *Client) PutFormation(formation *ct.Formation) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	app, err := c.app(formation.AppID)
+	if err != nil {
+		return err
+	}
+	if _, ok := c.releases[formation.ReleaseID]; !ok {
+		return controller.ErrNotFound
+	}
+	formation.AppID = app.ID
+	formation.UpdatedAt = c.timestamp()
+	if formation.CreatedAt == nil {
+		formation.CreatedAt = formation.UpdatedAt
+	}
+	stored := *formation
+	c.formations[formationKey(app.ID, formation.ReleaseID)] = &stored
+	c.addAppRelease(app.ID, formation.ReleaseID)
+	return nil
+}
+
+func (c *Client) GetFormation(appID, releaseID string) (*ct.Formation, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	app, err := c.app(appID)
+	if err != nil {
+		return nil, err
+	}
+	formation, ok := c.formations[formationKey(app.ID, releaseID)]
+	if !ok {
+		return nil, controller.ErrNotFound
+	}
+	res := *formation
+	return &res, nil
+}
+
+func (c *Client) FormationList(appID string) ([]*ct.Formation, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	app, err := c.app(appID)
+	if err != nil {
+		return nil, err
+	}
+	var list []*ct.Formation
+	for _, id := range c.appReleases[app.ID] {
+		if formation, ok := c.formations[formationKey(app.ID, id)]; ok {
+			res := *formation
+			list = append(list, &res)
+		}
+	}
+	return list, nil
+}
+
+func (c *Client) DeleteFormation(appID, releaseID string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	app, err := c.app(appID)
+	if err != nil {
+		return err
+	}
+	key := formationKey(app.ID, releaseID)
+	if _, ok := c.formations[key]; !ok {
+		return controller.ErrNotFound
+	}
+	delete(c.formations, key)
+	return nil
+}
+
+// CreateDeployment deploys the release immediately, moving the formation of
+// the app's current release to the new release.
+func (c *Client) CreateDeployment(appID, releaseID string) (*ct.Deployment, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.createDeployment(appID, releaseID, nil)
+}
+
+func (c *Client) CreateDeploymentIfCurrent(appID, releaseID, currentReleaseID string) (*ct.Deployment, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.createDeployment(appID, releaseID, &currentReleaseID)
+}
+
+func (c *Client) createDeployment(appID, releaseID string, currentReleaseID *string) (*ct.Deployment, error) {
+	app, err := c.app(appID)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := c.releases[releaseID]; !ok {
+		return nil, httphelper.JSONError{Code: httphelper.ValidationErrorCode, Message: "could not find release with ID " + releaseID}
+	}
+	if currentReleaseID != nil && *currentReleaseID != app.ReleaseID {
+		return nil, httphelper.JSONError{Code: httphelper.ConflictErrorCode, Message: "the app's current release has changed"}
+	}
+
+	d := &ct.Deployment{
+		ID:            random.UUID(),
+		AppID:         app.ID,
+		OldReleaseID:  app.ReleaseID,
+		NewReleaseID:  releaseID,
+		Strategy:      app.Strategy,
+		DeployTimeout: app.DeployTimeout,
+		CreatedAt:     c.timestamp(),
+	}
+	if old, ok := c.formations[formationKey(app.ID, app.ReleaseID)]; ok {
+		d.Processes = old.Processes
+	}
+	c.deployments = append(c.deployments, d)
+	if c.DeployErr != nil {
+		d.Status = "failed"
+		return nil, c.DeployErr
+	}
+
+	if d.OldReleaseID != "" && len(d.Processes) > 0 {
+		c.formations[formationKey(app.ID, releaseID)] = &ct.Formation{
+			AppID:     app.ID,
+			ReleaseID: releaseID,
+			Processes: d.Processes,
+			CreatedAt: d.CreatedAt,
+			UpdatedAt: d.CreatedAt,
+		}
+		delete(c.formations, formationKey(app.ID, d.OldReleaseID))
+	}
+	if err := c.setAppRelease(app.ID, releaseID); err != nil {
+		return nil, err
+	}
+	d.Status = "complete"
+	d.FinishedAt = d.CreatedAt
+	res := *d
+	return &res, nil
+}
+
+// DeployAppRelease deploys the release immediately (see CreateDeployment),
+// returning a deterministic result which takes no time and starts and stops
+// the jobs of the moved formation.
+func (c *Client) DeployAppRelease(appID, releaseID string, stopWait <-chan struct{}) (*ct.DeployResult, error) {
+	d, err := c.CreateDeployment(appID, releaseID)
+	if err != nil {
+		return nil, err
+	}
+	return deployResult(d), nil
+}
+
+func (c *Client) DeployAppReleaseIfCurrent(appID, releaseID, currentReleaseID string, stopWait <-chan struct{}) (*ct.DeployResult, error) {
+	d, err := c.CreateDeploymentIfCurrent(appID, releaseID, currentReleaseID)
+	if err != nil {
+		return nil, err
+	}
+	return deployResult(d), nil
+}
+
+func deployResult(d *ct.Deployment) *ct.DeployResult {
+	res := &ct.DeployResult{
+		DeploymentID: d.ID,
+		AppID:        d.AppID,
+		OldReleaseID: d.OldReleaseID,
+		ReleaseID:    d.NewReleaseID,
+		Status:       d.Status,
+		ScaleUp:      make(map[string]int),
+		ScaleDown:    make(map[string]int),
+	}
+	if d.OldReleaseID == "" {
+		return res
+	}
+	for typ, n := range d.Processes {
+		if n > 0 {
+			res.ScaleUp[typ] = n
+			res.ScaleDown[typ] = n
+		}
+	}
+	return res
+}
+
+func (c *Client) PlanDeployment(appID string, release *ct.Release) (*ct.DeploymentPlan, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	app, err := c.app(appID)
+	if err != nil {
+		return nil, err
+	}
+	if release.ID != "" {
+		var ok bool
+		if release, ok = c.releases[release.ID]; !ok {
+			return nil, controller.ErrNotFound
+		}
+	}
+	var processes map[string]int
+	if formation, ok := c.formations[formationKey(app.ID, app.ReleaseID)]; ok {
+		processes = formation.Processes
+	}
+	plan, err := ct.PlanDeployment(app.Strategy, c.releases[app.ReleaseID], release, processes)
+	if err != nil {
+		return nil, err
+	}
+	plan.AppID = app.ID
+	return plan, nil
+}
+
+func (c *Client) GetDeployment(deploymentID string) (*ct.Deployment, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, d := range c.deployments {
+		if d.ID == deploymentID {
+			res := *d
+			return &res, nil
+		}
+	}
+	return nil, controller.ErrNotFound
+}
+
+func (c *Client) DeploymentList(appID string) ([]*ct.Deployment, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	app, err := c.app(appID)
+	if err != nil {
+		return nil, err
+	}
+	var list []*ct.Deployment
+	for i := len(c.deployments) - 1; i >= 0; i-- {
+		if d := c.deployments[i]; d.AppID == app.ID {
+			res := *d
+			list = append(list, &res)
+		}
+	}
+	return list, nil
+}
+
+func copyRelease(r *ct.Release) *ct.Release {
+	res := *r
+	res.ArtifactIDs = append([]string(nil), r.ArtifactIDs...)
+	res.Env = copyMap(r.Env)
+	res.SecretEnv = copyMap(r.SecretEnv)
+	res.Meta = copyMap(r.Meta)
+	if r.Processes != nil {
+		res.Processes = make(map[string]ct.ProcessType, len(r.Processes))
+		for typ, proc := range r.Processes {
+			proc.Cmd = append([]string(nil), proc.Cmd...)
+			proc.Entrypoint = append([]string(nil), proc.Entrypoint...)
+			proc.Env = copyMap(proc.Env)
+			proc.Ports = append([]ct.Port(nil), proc.Ports...)
+			if proc.Resources != nil {
+				resources := make(resource.Resources, len(proc.Resources))
+				for typ, spec := range proc.Resources {
+					resources[typ] = spec
+				}
+				proc.Resources = resources
+			}
+			res.Processes[typ] = proc
+		}
+	}
+	return &res
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	res := make(map[string]string, len(m))
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
+}
+
+// JobList lists a job for each process of the app's formations, which are up
+// unless StartingJobs says otherwise.
+func (c *Client) JobList(appID string) ([]*ct.Job, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	app, err := c.app(appID)
+	if err != nil {
+		return nil, err
+	}
+	starting := make(map[string]int, len(c.StartingJobs))
+	for typ, n := range c.StartingJobs {
+		starting[typ] = n
+	}
+	var jobs []*ct.Job
+	for _, releaseID := range c.appReleases[app.ID] {
+		formation, ok := c.formations[formationKey(app.ID, releaseID)]
+		if !ok {
+			continue
+		}
+		types := make([]string, 0, len(formation.Processes))
+		for typ := range formation.Processes {
+			types = append(types, typ)
+		}
+		sort.Strings(types)
+		for _, typ := range types {
+			for i := 0; i < formation.Processes[typ]; i++ {
+				state := ct.JobStateUp
+				if starting[typ] > 0 {
+					state = ct.JobStateStarting
+					starting[typ]--
+				}
+				uuid := random.UUID()
+				jobs = append(jobs, &ct.Job{
+					ID:        "host-" + uuid,
+					UUID:      uuid,
+					HostID:    "host",
+					AppID:     app.ID,
+					ReleaseID: releaseID,
+					Type:      typ,
+					State:     state,
+					CreatedAt: formation.UpdatedAt,
+				})
+			}
+		}
+	}
+	return jobs, nil
+}
+
+// The remaining methods are not simulated.
+
+func (c *Client) GetCACert() ([]byte, error) { return nil, ErrNotImplemented }
+func (c *Client) StreamFormations(since *time.Time, output chan<- *ct.ExpandedFormation) (stream.Stream, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) PutDomain(dm *ct.DomainMigration) error     { return ErrNotImplemented }
+func (c *Client) CreateProvider(provider *ct.Provider) error { return ErrNotImplemented }
+func (c *Client) GetProvider(providerID string) (*ct.Provider, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) ProvisionResource(req *ct.ResourceReq) (*ct.Resource, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) GetResource(providerID, resourceID string) (*ct.Resource, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) ResourceListAll() ([]*ct.Resource, error) { return nil, ErrNotImplemented }
+func (c *Client) ResourceList(providerID string) ([]*ct.Resource, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) AddResourceApp(providerID, resourceID, appID string) (*ct.Resource, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) DeleteResourceApp(providerID, resourceID, appID string) (*ct.Resource, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) AppResourceList(appID string) ([]*ct.Resource, error) {
+	return nil, ErrNotImplemented
+}
+
+func (c *Client) AppSecretList(appID string) ([]*ct.Secret, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	app, err := c.app(appID)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(c.secrets[app.ID]))
+	for name := range c.secrets[app.ID] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]*ct.Secret, len(names))
+	for i, name := range names {
+		s := *c.secrets[app.ID][name]
+		s.Value = ""
+		list[i] = &s
+	}
+	return list, nil
+}
+
+func (c *Client) SetAppSecret(appID string, secret *ct.Secret) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	app, err := c.app(appID)
+	if err != nil {
+		return err
+	}
+	if secret.Name == "" {
+		return errors.New("controller: missing secret name")
+	}
+	if c.secrets[app.ID] == nil {
+		c.secrets[app.ID] = make(map[string]*ct.Secret)
+	}
+	stored, ok := c.secrets[app.ID][secret.Name]
+	if !ok {
+		stored = &ct.Secret{Name: secret.Name, CreatedAt: c.timestamp()}
+		c.secrets[app.ID][secret.Name] = stored
+	}
+	stored.Value = secret.Value
+	stored.UpdatedAt = c.timestamp()
+	*secret = *stored
+	secret.Value = ""
+	return nil
+}
+
+func (c *Client) DeleteAppSecret(appID, name string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	app, err := c.app(appID)
+	if err != nil {
+		return err
+	}
+	delete(c.secrets[app.ID], name)
+	return nil
+}
+func (c *Client) PutResource(resource *ct.Resource) error { return ErrNotImplemented }
+func (c *Client) DeleteResource(providerID, resourceID string) (*ct.Resource, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) PutJob(job *ct.Job) error            { return ErrNotImplemented }
+func (c *Client) DeleteJob(appID, jobID string) error { return ErrNotImplemented }
+func (c *Client) RouteList(appID string) ([]*router.Route, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) GetRoute(appID string, routeID string) (*router.Route, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) CreateRoute(appID string, route *router.Route) error { return ErrNotImplemented }
+func (c *Client) UpdateRoute(appID string, routeID string, route *router.Route) error {
+	return ErrNotImplemented
+}
+func (c *Client) DeleteRoute(appID string, routeID string) error { return ErrNotImplemented }
+func (c *Client) GetExpandedFormation(appID, releaseID string) (*ct.ExpandedFormation, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) FormationListActive() ([]*ct.ExpandedFormation, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) GetAppLog(appID string, options *ct.LogOpts) (io.ReadCloser, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) StreamAppLog(appID string, options *ct.LogOpts, output chan<- *ct.SSELogChunk) (stream.Stream, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) StreamDeployment(d *ct.Deployment, output chan *ct.DeploymentEvent) (stream.Stream, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) StreamJobEvents(appID string, output chan *ct.Job) (stream.Stream, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) WatchJobEvents(appID, releaseID string) (ct.JobWatcher, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) StreamEvents(opts ct.StreamEventsOptions, output chan *ct.Event) (stream.Stream, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) ListEvents(opts ct.ListEventsOptions) ([]*ct.Event, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) GetEvent(id int64) (*ct.Event, error) { return nil, ErrNotImplemented }
+func (c *Client) ExpectedScalingEvents(actual, expected map[string]int, releaseProcesses map[string]ct.ProcessType, clusterSize int) ct.JobEvents {
+	return nil
+}
+func (c *Client) RunJobAttached(appID string, job *ct.NewJob) (httpclient.ReadWriteCloser, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) RunJobDetached(appID string, req *ct.NewJob) (*ct.Job, error) {
+	return nil, ErrNotImplemented
+}
+func (c *Client) GetJob(appID, jobID string) (*ct.Job, error)     { return nil, ErrNotImplemented }
+func (c *Client) JobListActive() ([]*ct.Job, error)               { return nil, ErrNotImplemented }
+func (c *Client) KeyList() ([]*ct.Key, error)                     { return nil, ErrNotImplemented }
+func (c *Client) CreateKey(pubKey string) (*ct.Key, error)        { return nil, ErrNotImplemented }
+func (c *Client) GetKey(keyID string) (*ct.Key, error)            { return nil, ErrNotImplemented }
+func (c *Client) DeleteKey(id string) error                       { return ErrNotImplemented }
+func (c *Client) ProviderList() ([]*ct.Provider, error)           { return nil, ErrNotImplemented }
+func (c *Client) Backup() (io.ReadCloser, error)                  { return nil, ErrNotImplemented }
+func (c *Client) GetBackupMeta() (*ct.ClusterBackup, error)       { return nil, ErrNotImplemented }
+func (c *Client) ScheduleAppGarbageCollection(appID string) error { return ErrNotImplemented }
@@ -0,0 +1,108 @@
+package v1controller
+
+import (
+	"container/list"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// ResponseCache caches the responses to GET requests along with their
+// entity tags, so that repeating a request for a resource which hasn't
+// changed is answered by the controller with 304 Not Modified and decoded
+// from the cache rather than transferring it again. It holds a bounded
+// number of responses, evicting the least recently used.
+type ResponseCache struct {
+	mtx     sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type cacheEntry struct {
+	path string
+	etag string
+	body []byte
+}
+
+// DefaultResponseCacheSize is the number of responses held by a
+// ResponseCache created with a non-positive size.
+const DefaultResponseCacheSize = 1000
+
+// NewResponseCache returns an empty ResponseCache which holds up to size
+// responses.
+func NewResponseCache(size int) *ResponseCache {
+	if size <= 0 {
+		size = DefaultResponseCacheSize
+	}
+	return &ResponseCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *ResponseCache) get(path string) *cacheEntry {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	e, ok := c.entries[path]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*cacheEntry)
+}
+
+func (c *ResponseCache) set(entry *cacheEntry) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if e, ok := c.entries[entry.path]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[entry.path] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).path)
+	}
+}
+
+// Len returns the number of cached responses.
+func (c *ResponseCache) Len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.lru.Len()
+}
+
+// getCached is like Get but, if the client has a Cache, sends the entity
+// tag of any cached response to path in an If-None-Match header and decodes
+// the cached response if the controller reports it hasn't changed.
+func (c *Client) getCached(path string, out interface{}) error {
+	if c.Cache == nil {
+		return c.Get(path, out)
+	}
+	header := http.Header{"Accept": []string{"application/json"}}
+	cached := c.Cache.get(path)
+	if cached != nil {
+		header.Set("If-None-Match", cached.etag)
+	}
+	res, err := c.RawReq("GET", path, header, nil, nil)
+	if res != nil && res.StatusCode == http.StatusNotModified && cached != nil {
+		return json.Unmarshal(cached.body, out)
+	}
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if etag := res.Header.Get("ETag"); etag != "" {
+		c.Cache.set(&cacheEntry{path: path, etag: etag, body: body})
+	}
+	return json.Unmarshal(body, out)
+}
@@ -0,0 +1,35 @@
+package v1controller
+
+import "testing"
+
+func TestResponseCacheEviction(t *testing.T) {
+	cache := NewResponseCache(2)
+	cache.set(&cacheEntry{path: "/a", etag: `"a"`})
+	cache.set(&cacheEntry{path: "/b", etag: `"b"`})
+
+	// using /a makes /b the least recently used, so it is evicted
+	if cache.get("/a") == nil {
+		t.Fatal("expected /a to be cached")
+	}
+	cache.set(&cacheEntry{path: "/c", etag: `"c"`})
+	if n := cache.Len(); n != 2 {
+		t.Fatalf("expected 2 cached responses, got %d", n)
+	}
+	if cache.get("/b") != nil {
+		t.Fatal("expected /b to be evicted")
+	}
+	for _, path := range []string{"/a", "/c"} {
+		if cache.get(path) == nil {
+			t.Fatalf("expected %s to be cached", path)
+		}
+	}
+
+	// replacing an entry doesn't grow the cache
+	cache.set(&cacheEntry{path: "/a", etag: `"a2"`})
+	if n := cache.Len(); n != 2 {
+		t.Fatalf("expected 2 cached responses, got %d", n)
+	}
+	if e := cache.get("/a"); e == nil || e.etag != `"a2"` {
+		t.Fatalf("expected /a to be replaced, got %v", e)
+	}
+}
@@ -24,6 +24,11 @@ import (
 // Client is a client for the v1 of the controller API.
 type Client struct {
 	*httpclient.Client
+
+	// Cache, if set, caches the responses of GetRelease, GetAppRelease and
+	// AppReleaseList so that repeated requests for unchanged releases are
+	// answered with 304 Not Modified.
+	Cache *ResponseCache
 }
 
 type jobWatcher struct {
@@ -138,11 +143,26 @@ func (c *Client) PutDomain(dm *ct.DomainMigration) error {
 }
 
 // CreateArtifact creates a new artifact.
+//
+// If artifact.ID is set, it is used as an idempotency key: creating an
+// artifact with the ID of an existing artifact returns the existing artifact
+// rather than an error, so the request can be safely retried.
 func (c *Client) CreateArtifact(artifact *ct.Artifact) error {
 	return c.Post("/artifacts", artifact, artifact)
 }
 
-// CreateRelease creates a new release.
+// DeleteArtifact deletes an artifact which is not referenced by any release.
+func (c *Client) DeleteArtifact(artifactID string) error {
+	return c.Delete(fmt.Sprintf("/artifacts/%s", artifactID), nil)
+}
+
+// CreateRelease creates a new release, updating release with the fields
+// assigned by the controller (e.g. the ID if not set, CreatedAt and default
+// process resources).
+//
+// If release.ID is set, it is used as an idempotency key: creating a release
+// with the ID of an existing release returns the existing release rather than
+// an error, so the request can be safely retried.
 func (c *Client) CreateRelease(release *ct.Release) error {
 	return c.Post("/releases", release, release)
 }
@@ -261,6 +281,26 @@ func (c *Client) AppResourceList(appID string) ([]*ct.Resource, error) {
 	return resources, c.Get(fmt.Sprintf("/apps/%s/resources", appID), &resources)
 }
 
+// AppSecretList returns the secrets of appID, without their values.
+func (c *Client) AppSecretList(appID string) ([]*ct.Secret, error) {
+	var secrets []*ct.Secret
+	return secrets, c.Get(fmt.Sprintf("/apps/%s/secrets", appID), &secrets)
+}
+
+// SetAppSecret creates or updates the secret of appID named secret.Name with
+// secret.Value, which is cleared once the secret has been set.
+func (c *Client) SetAppSecret(appID string, secret *ct.Secret) error {
+	if secret.Name == "" {
+		return errors.New("controller: missing secret name")
+	}
+	return c.Put(fmt.Sprintf("/apps/%s/secrets/%s", appID, secret.Name), secret, secret)
+}
+
+// DeleteAppSecret deletes the secret of appID with the given name.
+func (c *Client) DeleteAppSecret(appID, name string) error {
+	return c.Delete(fmt.Sprintf("/apps/%s/secrets/%s", appID, name), nil)
+}
+
 // PutResource updates a resource.
 func (c *Client) PutResource(resource *ct.Resource) error {
 	if resource.ID == "" || resource.ProviderID == "" {
@@ -305,7 +345,7 @@ func (c *Client) SetAppRelease(appID, releaseID string) error {
 // GetAppRelease returns the current release of an app.
 func (c *Client) GetAppRelease(appID string) (*ct.Release, error) {
 	release := &ct.Release{}
-	return release, c.Get(fmt.Sprintf("/apps/%s/release", appID), release)
+	return release, c.getCached(fmt.Sprintf("/apps/%s/release", appID), release)
 }
 
 // RouteList returns all routes for an app.
@@ -370,7 +410,17 @@ func (c *Client) DeleteFormation(appID, releaseID string) error {
 // GetRelease returns details for the specified release.
 func (c *Client) GetRelease(releaseID string) (*ct.Release, error) {
 	release := &ct.Release{}
-	return release, c.Get(fmt.Sprintf("/releases/%s", releaseID), release)
+	return release, c.getCached(fmt.Sprintf("/releases/%s", releaseID), release)
+}
+
+// UpdateReleaseMeta merges release.Meta into the meta of the release with
+// release.ID, setting release to the updated release. Only the meta of a
+// release can be changed once it has been created.
+func (c *Client) UpdateReleaseMeta(release *ct.Release) error {
+	if release.ID == "" {
+		return errors.New("controller: missing id")
+	}
+	return c.Post(fmt.Sprintf("/releases/%s/meta", release.ID), release, release)
 }
 
 // GetArtifact returns details for the specified artifact.
@@ -454,6 +504,28 @@ func (c *Client) CreateDeployment(appID, releaseID string) (*ct.Deployment, erro
 	return deployment, c.Post(fmt.Sprintf("/apps/%s/deploy", appID), &ct.Release{ID: releaseID}, deployment)
 }
 
+// CreateDeploymentIfCurrent is like CreateDeployment, but fails with a
+// conflict error (see IsConflict) if the app's current release is no longer
+// currentReleaseID (which is empty if the app had no release).
+func (c *Client) CreateDeploymentIfCurrent(appID, releaseID, currentReleaseID string) (*ct.Deployment, error) {
+	deployment := &ct.Deployment{}
+	header := http.Header{"If-Match": {`"` + currentReleaseID + `"`}}
+	_, err := c.RawReq("POST", fmt.Sprintf("/apps/%s/deploy", appID), header, &ct.Release{ID: releaseID}, deployment)
+	return deployment, err
+}
+
+// PlanDeployment returns the actions deploying the given release to the app
+// would take, without deploying it. The release is either an existing
+// release (only its ID is used), or if it has no ID, a release which hasn't
+// been created.
+func (c *Client) PlanDeployment(appID string, release *ct.Release) (*ct.DeploymentPlan, error) {
+	if release.ID != "" {
+		release = &ct.Release{ID: release.ID}
+	}
+	plan := &ct.DeploymentPlan{}
+	return plan, c.Post(fmt.Sprintf("/apps/%s/deploy/plan", appID), release, plan)
+}
+
 // DeploymentList returns a list of all deployments.
 func (c *Client) DeploymentList(appID string) ([]*ct.Deployment, error) {
 	var deployments []*ct.Deployment
@@ -488,21 +560,56 @@ func (c *Client) StreamDeployment(d *ct.Deployment, output chan *ct.DeploymentEv
 	}, appEvents)
 }
 
-func (c *Client) DeployAppRelease(appID, releaseID string, stopWait <-chan struct{}) error {
+// DeployAppRelease deploys the release to the app and waits for the
+// deployment to finish, returning its result. The result is also returned
+// along with the error if the deployment fails.
+func (c *Client) DeployAppRelease(appID, releaseID string, stopWait <-chan struct{}) (*ct.DeployResult, error) {
+	start := time.Now()
 	d, err := c.CreateDeployment(appID, releaseID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return c.waitForDeployment(d, start, stopWait)
+}
+
+// DeployAppReleaseIfCurrent is like DeployAppRelease, but fails with a
+// conflict error if the app's current release is no longer currentReleaseID
+// (see CreateDeploymentIfCurrent).
+func (c *Client) DeployAppReleaseIfCurrent(appID, releaseID, currentReleaseID string, stopWait <-chan struct{}) (*ct.DeployResult, error) {
+	start := time.Now()
+	d, err := c.CreateDeploymentIfCurrent(appID, releaseID, currentReleaseID)
+	if err != nil {
+		return nil, err
+	}
+	return c.waitForDeployment(d, start, stopWait)
+}
+
+// waitForDeployment waits for d to finish, counting the jobs started and
+// stopped by the deployment for its result.
+func (c *Client) waitForDeployment(d *ct.Deployment, start time.Time, stopWait <-chan struct{}) (*ct.DeployResult, error) {
+	res := &ct.DeployResult{
+		DeploymentID: d.ID,
+		AppID:        d.AppID,
+		OldReleaseID: d.OldReleaseID,
+		ReleaseID:    d.NewReleaseID,
+		ScaleUp:      make(map[string]int),
+		ScaleDown:    make(map[string]int),
+	}
+	finish := func(status string) {
+		res.Status = status
+		res.Duration = time.Since(start)
 	}
 
 	// if initial deploy, just stop here
 	if d.FinishedAt != nil {
-		return nil
+		finish("complete")
+		return res, nil
 	}
 
 	events := make(chan *ct.DeploymentEvent)
 	stream, err := c.StreamDeployment(d, events)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer stream.Close()
 
@@ -511,20 +618,28 @@ outer:
 		select {
 		case e, ok := <-events:
 			if !ok {
-				return fmt.Errorf("unexpected close of deployment event stream: %s", stream.Err())
+				return nil, fmt.Errorf("unexpected close of deployment event stream: %s", stream.Err())
+			}
+			switch {
+			case e.ReleaseID == d.NewReleaseID && e.JobState == ct.JobStateUp:
+				res.ScaleUp[e.JobType]++
+			case e.ReleaseID == d.OldReleaseID && e.JobState == ct.JobStateDown:
+				res.ScaleDown[e.JobType]++
 			}
 			switch e.Status {
 			case "complete":
 				break outer
 			case "failed":
-				return e.Err()
+				finish("failed")
+				return res, e.Err()
 			}
 		case <-stopWait:
-			return errors.New("deploy wait cancelled")
+			return nil, errors.New("deploy wait cancelled")
 
 		}
 	}
-	return nil
+	finish("complete")
+	return res, nil
 }
 
 // StreamJobEvents streams job events to the output channel.
@@ -697,7 +812,23 @@ func (c *Client) ReleaseList() ([]*ct.Release, error) {
 // AppReleaseList returns a list of all releases under appID.
 func (c *Client) AppReleaseList(appID string) ([]*ct.Release, error) {
 	var releases []*ct.Release
-	return releases, c.Get(fmt.Sprintf("/apps/%s/releases", appID), &releases)
+	return releases, c.getCached(fmt.Sprintf("/apps/%s/releases", appID), &releases)
+}
+
+// AppReleaseListCount returns the count most recent releases under appID,
+// so that callers which only need recent releases don't have to fetch every
+// release of apps with a long history.
+func (c *Client) AppReleaseListCount(appID string, count int) ([]*ct.Release, error) {
+	var releases []*ct.Release
+	return releases, c.getCached(fmt.Sprintf("/apps/%s/releases?count=%d", appID, count), &releases)
+}
+
+// AppReleaseSummary returns the number of releases under appID along with
+// the current release ID and the creation time of the oldest and newest
+// releases, without listing every release.
+func (c *Client) AppReleaseSummary(appID string) (*ct.ReleaseSummary, error) {
+	summary := &ct.ReleaseSummary{}
+	return summary, c.Get(fmt.Sprintf("/apps/%s/release_summary", appID), summary)
 }
 
 // CreateKey uploads pubKey as the ssh public key.
@@ -735,13 +866,23 @@ func (c *Client) GetBackupMeta() (*ct.ClusterBackup, error) {
 	return b, c.Get("/backup", b)
 }
 
-// DeleteRelease deletes a release and any associated file artifacts.
+// DeleteRelease deletes a release and any associated file artifacts. If
+// deleting the files fails partway, a *ct.ReleaseDeletionError is returned,
+// and calling DeleteRelease again deletes only the remaining files.
 func (c *Client) DeleteRelease(appID, releaseID string) (*ct.ReleaseDeletion, error) {
+	return c.DeleteReleaseWithProgress(appID, releaseID, nil)
+}
+
+// DeleteReleaseWithProgress is like DeleteRelease but calls progress (if not
+// nil) as each of the release's files is deleted. The timeout waiting for
+// the deletion restarts after each file, so deleting many large files does
+// not time out.
+func (c *Client) DeleteReleaseWithProgress(appID, releaseID string, progress func(*ct.ReleaseDeletionProgress)) (*ct.ReleaseDeletion, error) {
 	events := make(chan *ct.Event)
 	stream, err := c.StreamEvents(ct.StreamEventsOptions{
 		AppID:       appID,
 		ObjectID:    releaseID,
-		ObjectTypes: []ct.EventType{ct.EventTypeReleaseDeletion},
+		ObjectTypes: []ct.EventType{ct.EventTypeReleaseDeletion, ct.EventTypeReleaseDeletionProgress},
 	}, events)
 	if err != nil {
 		return nil, err
@@ -752,21 +893,36 @@ func (c *Client) DeleteRelease(appID, releaseID string) (*ct.ReleaseDeletion, er
 		return nil, err
 	}
 
-	select {
-	case event, ok := <-events:
-		if !ok {
-			return nil, stream.Err()
-		}
-		var e ct.ReleaseDeletionEvent
-		if err := json.Unmarshal(event.Data, &e); err != nil {
-			return nil, err
-		}
-		if e.Error != "" {
-			return nil, errors.New(e.Error)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil, stream.Err()
+			}
+			if event.ObjectType == ct.EventTypeReleaseDeletionProgress {
+				if progress != nil {
+					var p ct.ReleaseDeletionProgress
+					if err := json.Unmarshal(event.Data, &p); err != nil {
+						return nil, err
+					}
+					progress(&p)
+				}
+				continue
+			}
+			var e ct.ReleaseDeletionEvent
+			if err := json.Unmarshal(event.Data, &e); err != nil {
+				return nil, err
+			}
+			if e.Error != "" {
+				if e.ReleaseDeletion != nil && e.ReleaseDeletion.TotalFiles > 0 {
+					return nil, &ct.ReleaseDeletionError{Deletion: e.ReleaseDeletion, Err: e.Error}
+				}
+				return nil, errors.New(e.Error)
+			}
+			return e.ReleaseDeletion, nil
+		case <-time.After(60 * time.Second):
+			return nil, errors.New("timed out waiting for release deletion")
 		}
-		return e.ReleaseDeletion, nil
-	case <-time.After(60 * time.Second):
-		return nil, errors.New("timed out waiting for release deletion")
 	}
 }
 
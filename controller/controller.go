@@ -58,7 +58,14 @@ func main() {
 		name.SetSeed(s)
 	}
 
-	db := postgres.Wait(nil, nil)
+	// the connection pool is configured with the FLYNN_POSTGRES_MAX_CONNS,
+	// FLYNN_POSTGRES_ACQUIRE_TIMEOUT and FLYNN_POSTGRES_MAX_CONN_LIFETIME
+	// env vars
+	dbConf, err := postgres.ConfFromEnv()
+	if err != nil {
+		shutdown.Fatal(err)
+	}
+	db := postgres.Wait(dbConf, nil)
 
 	if err := migrateDB(db); err != nil {
 		shutdown.Fatal(err)
@@ -66,7 +73,7 @@ func main() {
 
 	// Reconnect, preparing statements now that schema is migrated
 	db.Close()
-	db = postgres.Wait(nil, schema.PrepareStatements)
+	db = postgres.Wait(dbConf, schema.PrepareStatements)
 
 	shutdown.BeforeExit(func() { db.Close() })
 
@@ -217,6 +224,7 @@ func appHandler(c handlerConfig) http.Handler {
 	deploymentRepo := NewDeploymentRepo(c.db)
 	eventRepo := NewEventRepo(c.db)
 	backupRepo := NewBackupRepo(c.db)
+	secretRepo := NewSecretRepo(c.db)
 
 	api := controllerAPI{
 		domainMigrationRepo: domainMigrationRepo,
@@ -230,6 +238,7 @@ func appHandler(c handlerConfig) http.Handler {
 		deploymentRepo:      deploymentRepo,
 		eventRepo:           eventRepo,
 		backupRepo:          backupRepo,
+		secretRepo:          secretRepo,
 		clusterClient:       c.cc,
 		logaggc:             c.lc,
 		routerc:             c.rc,
@@ -280,12 +289,14 @@ func appHandler(c handlerConfig) http.Handler {
 	httpRouter.GET("/active-jobs", httphelper.WrapHandler(api.ListActiveJobs))
 
 	httpRouter.POST("/apps/:apps_id/deploy", httphelper.WrapHandler(api.appLookup(api.CreateDeployment)))
+	httpRouter.POST("/apps/:apps_id/deploy/plan", httphelper.WrapHandler(api.appLookup(api.PlanDeployment)))
 	httpRouter.GET("/apps/:apps_id/deployments", httphelper.WrapHandler(api.appLookup(api.ListDeployments)))
 	httpRouter.GET("/deployments/:deployment_id", httphelper.WrapHandler(api.GetDeployment))
 
 	httpRouter.PUT("/apps/:apps_id/release", httphelper.WrapHandler(api.appLookup(api.SetAppRelease)))
 	httpRouter.GET("/apps/:apps_id/release", httphelper.WrapHandler(api.appLookup(api.GetAppRelease)))
 	httpRouter.GET("/apps/:apps_id/releases", httphelper.WrapHandler(api.appLookup(api.GetAppReleases)))
+	httpRouter.GET("/apps/:apps_id/release_summary", httphelper.WrapHandler(api.appLookup(api.GetAppReleaseSummary)))
 
 	httpRouter.GET("/resources", httphelper.WrapHandler(api.GetResources))
 	httpRouter.POST("/providers/:providers_id/resources", httphelper.WrapHandler(api.ProvisionResource))
@@ -297,6 +308,10 @@ func appHandler(c handlerConfig) http.Handler {
 	httpRouter.DELETE("/providers/:providers_id/resources/:resources_id/apps/:app_id", httphelper.WrapHandler(api.DeleteResourceApp))
 	httpRouter.GET("/apps/:apps_id/resources", httphelper.WrapHandler(api.appLookup(api.GetAppResources)))
 
+	httpRouter.GET("/apps/:apps_id/secrets", httphelper.WrapHandler(api.appLookup(api.ListAppSecrets)))
+	httpRouter.PUT("/apps/:apps_id/secrets/:secrets_name", httphelper.WrapHandler(api.appLookup(api.PutAppSecret)))
+	httpRouter.DELETE("/apps/:apps_id/secrets/:secrets_name", httphelper.WrapHandler(api.appLookup(api.DeleteAppSecret)))
+
 	httpRouter.POST("/apps/:apps_id/routes", httphelper.WrapHandler(api.appLookup(api.CreateRoute)))
 	httpRouter.GET("/apps/:apps_id/routes", httphelper.WrapHandler(api.appLookup(api.GetRouteList)))
 	httpRouter.GET("/apps/:apps_id/routes/:routes_type/:routes_id", httphelper.WrapHandler(api.appLookup(api.GetRoute)))
@@ -304,6 +319,7 @@ func appHandler(c handlerConfig) http.Handler {
 	httpRouter.DELETE("/apps/:apps_id/routes/:routes_type/:routes_id", httphelper.WrapHandler(api.appLookup(api.DeleteRoute)))
 
 	httpRouter.POST("/apps/:apps_id/meta", httphelper.WrapHandler(api.appLookup(api.UpdateApp)))
+	httpRouter.POST("/releases/:releases_id/meta", httphelper.WrapHandler(api.UpdateReleaseMeta))
 
 	httpRouter.GET("/events", httphelper.WrapHandler(api.Events))
 	httpRouter.GET("/events/:id", httphelper.WrapHandler(api.GetEvent))
@@ -358,6 +374,7 @@ type controllerAPI struct {
 	deploymentRepo      *DeploymentRepo
 	eventRepo           *EventRepo
 	backupRepo          *BackupRepo
+	secretRepo          *SecretRepo
 	clusterClient       utils.ClusterClient
 	logaggc             logClient
 	routerc             routerc.Client
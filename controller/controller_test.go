@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/flynn/flynn/controller/client"
+	"github.com/flynn/flynn/controller/client/v1"
 	"github.com/flynn/flynn/controller/schema"
 	tu "github.com/flynn/flynn/controller/testutils"
 	ct "github.com/flynn/flynn/controller/types"
@@ -265,6 +266,65 @@ func (s *S) TestCreateArtifact(c *C) {
 	}
 }
 
+func (s *S) TestDeleteArtifact(c *C) {
+	artifact := s.createTestArtifact(c, &ct.Artifact{})
+	c.Assert(s.c.DeleteArtifact(artifact.ID), IsNil)
+	_, err := s.c.GetArtifact(artifact.ID)
+	c.Assert(err, Equals, controller.ErrNotFound)
+
+	// artifacts referenced by a release cannot be deleted
+	release := s.createTestRelease(c, &ct.Release{})
+	err = s.c.DeleteArtifact(release.ArtifactIDs[0])
+	c.Assert(controller.IsConflict(err), Equals, true)
+	_, err = s.c.GetArtifact(release.ArtifactIDs[0])
+	c.Assert(err, IsNil)
+}
+
+func (s *S) TestCreateIdempotent(c *C) {
+	artifact := s.createTestArtifact(c, &ct.Artifact{ID: random.UUID()})
+	retry := &ct.Artifact{ID: artifact.ID, Type: artifact.Type, URI: artifact.URI}
+	c.Assert(s.c.CreateArtifact(retry), IsNil)
+	c.Assert(retry.ID, Equals, artifact.ID)
+	c.Assert(retry.CreatedAt, DeepEquals, artifact.CreatedAt)
+
+	release := s.createTestRelease(c, &ct.Release{
+		ID:          random.UUID(),
+		ArtifactIDs: []string{artifact.ID},
+		Env:         map[string]string{"FOO": "bar"},
+	})
+	retryRelease := &ct.Release{
+		ID:          release.ID,
+		ArtifactIDs: []string{artifact.ID},
+		Env:         map[string]string{"FOO": "bar"},
+	}
+	c.Assert(s.c.CreateRelease(retryRelease), IsNil)
+	c.Assert(retryRelease.ID, Equals, release.ID)
+	c.Assert(retryRelease.CreatedAt, DeepEquals, release.CreatedAt)
+
+	// reusing an ID with a different payload is a conflict
+	err := s.c.CreateArtifact(&ct.Artifact{ID: artifact.ID, Type: artifact.Type, URI: artifact.URI + "/other"})
+	c.Assert(controller.IsConflict(err), Equals, true)
+	err = s.c.CreateRelease(&ct.Release{
+		ID:          release.ID,
+		ArtifactIDs: []string{artifact.ID},
+		Env:         map[string]string{"FOO": "baz"},
+	})
+	c.Assert(controller.IsConflict(err), Equals, true)
+	got, err := s.c.GetRelease(release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(got.Env, DeepEquals, map[string]string{"FOO": "bar"})
+
+	releases, err := s.c.ReleaseList()
+	c.Assert(err, IsNil)
+	count := 0
+	for _, r := range releases {
+		if r.ID == release.ID {
+			count++
+		}
+	}
+	c.Assert(count, Equals, 1)
+}
+
 func (s *S) createTestRelease(c *C, in *ct.Release) *ct.Release {
 	if len(in.ArtifactIDs) == 0 {
 		in.ArtifactIDs = []string{s.createTestArtifact(c, &ct.Artifact{Type: host.ArtifactTypeDocker}).ID}
@@ -383,6 +443,57 @@ func (s *S) TestReleaseList(c *C) {
 	c.Assert(list[0].ID, Not(Equals), "")
 }
 
+func (s *S) TestUpdateReleaseMeta(c *C) {
+	release := s.createTestRelease(c, &ct.Release{
+		Env:  map[string]string{"FOO": "bar"},
+		Meta: map[string]string{"created_by": "alice"},
+	})
+
+	// a caching client sees the change to the release
+	cache := v1controller.NewResponseCache(0)
+	cachingClient, err := controller.NewClientWithConfig(s.srv.URL, authKey, controller.Config{Cache: cache})
+	c.Assert(err, IsNil)
+	cached, err := cachingClient.GetRelease(release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(cached.Meta, DeepEquals, release.Meta)
+
+	// the given keys are merged into the meta, and nothing else changes
+	update := &ct.Release{
+		ID:   release.ID,
+		Env:  map[string]string{"FOO": "ignored"},
+		Meta: map[string]string{"commit": "3f2a1b9"},
+	}
+	c.Assert(s.c.UpdateReleaseMeta(update), IsNil)
+	c.Assert(update.Meta, DeepEquals, map[string]string{"created_by": "alice", "commit": "3f2a1b9"})
+	c.Assert(update.Env, DeepEquals, map[string]string{"FOO": "bar"})
+
+	got, err := s.c.GetRelease(release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, update)
+	cached, err = cachingClient.GetRelease(release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(cached, DeepEquals, update)
+
+	// concurrent updates of different keys don't lose either
+	errs := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func(i int) {
+			errs <- s.c.UpdateReleaseMeta(&ct.Release{
+				ID:   release.ID,
+				Meta: map[string]string{fmt.Sprintf("key%d", i): "true"},
+			})
+		}(i)
+	}
+	for i := 0; i < 10; i++ {
+		c.Assert(<-errs, IsNil)
+	}
+	got, err = s.c.GetRelease(release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(got.Meta, HasLen, 12)
+
+	c.Assert(s.c.UpdateReleaseMeta(&ct.Release{ID: random.UUID()}), Equals, controller.ErrNotFound)
+}
+
 func (s *S) TestReleaseArtifacts(c *C) {
 	// a release with no artifacts is ok
 	release := &ct.Release{}
@@ -477,6 +588,105 @@ func (s *S) TestAppReleaseList(c *C) {
 	c.Assert(list, HasLen, len(releases))
 	c.Assert(list[0], DeepEquals, releases[1])
 	c.Assert(list[1], DeepEquals, releases[0])
+
+	// check the list can be limited to the most recent releases
+	list, err = s.c.AppReleaseListCount(app.ID, 1)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 1)
+	c.Assert(list[0], DeepEquals, releases[1])
+	list, err = s.c.AppReleaseListCount(app.ID, 5)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, len(releases))
+}
+
+func (s *S) TestAppReleaseSummary(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "app-release-summary"})
+
+	summary, err := s.c.AppReleaseSummary(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(summary.Count, Equals, 0)
+	c.Assert(summary.CurrentReleaseID, Equals, "")
+	c.Assert(summary.OldestCreatedAt, IsNil)
+
+	releases := make([]*ct.Release, 3)
+	for i := range releases {
+		r := s.createTestRelease(c, &ct.Release{})
+		releases[i] = r
+		s.createTestFormation(c, &ct.Formation{ReleaseID: r.ID, AppID: app.ID})
+	}
+	c.Assert(s.c.SetAppRelease(app.ID, releases[1].ID), IsNil)
+
+	summary, err = s.c.AppReleaseSummary(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(summary.Count, Equals, len(releases))
+	c.Assert(summary.CurrentReleaseID, Equals, releases[1].ID)
+	c.Assert(summary.OldestCreatedAt.Equal(*releases[0].CreatedAt), Equals, true)
+	c.Assert(summary.NewestCreatedAt.Equal(*releases[2].CreatedAt), Equals, true)
+}
+
+func (s *S) TestReleaseETag(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "release-etag"})
+	release := s.createTestRelease(c, &ct.Release{})
+	c.Assert(s.c.SetAppRelease(app.ID, release.ID), IsNil)
+
+	get := func(path, etag string) *http.Response {
+		req, err := http.NewRequest("GET", s.srv.URL+path, nil)
+		c.Assert(err, IsNil)
+		req.SetBasicAuth("", authKey)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		res, err := http.DefaultClient.Do(req)
+		c.Assert(err, IsNil)
+		res.Body.Close()
+		return res
+	}
+
+	// repeating a request with the returned entity tag is answered with
+	// 304 Not Modified
+	for _, path := range []string{
+		"/releases/" + release.ID,
+		"/apps/" + app.ID + "/release",
+		"/apps/" + app.ID + "/releases",
+	} {
+		res := get(path, "")
+		c.Assert(res.StatusCode, Equals, 200)
+		etag := res.Header.Get("ETag")
+		c.Assert(etag, Not(Equals), "")
+		c.Assert(get(path, etag).StatusCode, Equals, 304, Commentf("path = %s", path))
+		c.Assert(get(path, `"other"`).StatusCode, Equals, 200, Commentf("path = %s", path))
+	}
+
+	// other resources aren't tagged
+	for _, path := range []string{"/apps/" + app.ID, "/apps"} {
+		res := get(path, "")
+		c.Assert(res.StatusCode, Equals, 200)
+		c.Assert(res.Header.Get("ETag"), Equals, "", Commentf("path = %s", path))
+	}
+
+	// a client with a cache decodes unchanged releases from it
+	cache := v1controller.NewResponseCache(0)
+	client, err := controller.NewClientWithConfig(s.srv.URL, authKey, controller.Config{Cache: cache})
+	c.Assert(err, IsNil)
+	for i := 0; i < 2; i++ {
+		current, err := client.GetAppRelease(app.ID)
+		c.Assert(err, IsNil)
+		c.Assert(current, DeepEquals, release)
+		list, err := client.AppReleaseList(app.ID)
+		c.Assert(err, IsNil)
+		c.Assert(list, DeepEquals, []*ct.Release{release})
+	}
+	c.Assert(cache.Len(), Equals, 2)
+
+	// and fetches them again once they change
+	next := s.createTestRelease(c, &ct.Release{})
+	c.Assert(s.c.SetAppRelease(app.ID, next.ID), IsNil)
+	current, err := client.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(current.ID, Equals, next.ID)
+	list, err := client.AppReleaseList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 2)
 }
 
 func (s *S) TestArtifactList(c *C) {
@@ -21,6 +21,12 @@ type Remover interface {
 	Remove(string) error
 }
 
+// Tagger is implemented by repositories whose resources are returned with
+// an entity tag so that clients can cache them (see respondWithETag).
+type Tagger interface {
+	ETag(thing interface{}) (string, error)
+}
+
 func crud(r *httprouter.Router, resource string, example interface{}, repo Repository) {
 	resourceType := reflect.TypeOf(example)
 	prefix := "/" + resource
@@ -50,12 +56,21 @@ func crud(r *httprouter.Router, resource string, example interface{}, repo Repos
 	}
 
 	singletonPath := prefix + "/:" + resource + "_id"
-	r.GET(singletonPath, httphelper.WrapHandler(func(ctx context.Context, rw http.ResponseWriter, _ *http.Request) {
+	r.GET(singletonPath, httphelper.WrapHandler(func(ctx context.Context, rw http.ResponseWriter, req *http.Request) {
 		thing, err := lookup(ctx)
 		if err != nil {
 			respondWithError(rw, err)
 			return
 		}
+		if tagger, ok := repo.(Tagger); ok {
+			etag, err := tagger.ETag(thing)
+			if err != nil {
+				respondWithError(rw, err)
+				return
+			}
+			respondWithETag(rw, req, etag, thing)
+			return
+		}
 		httphelper.JSON(rw, 200, thing)
 	}))
 
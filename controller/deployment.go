@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/flynn/flynn/controller/schema"
@@ -27,8 +28,11 @@ func NewDeploymentRepo(db *postgres.DB) *DeploymentRepo {
 	return &DeploymentRepo{db: db, q: q}
 }
 
-func (r *DeploymentRepo) Add(data interface{}) (*ct.Deployment, error) {
-	d := data.(*ct.Deployment)
+// Add creates a deployment, first locking the app so that the check of its
+// current release against expectedReleaseID (if not nil) and, for a
+// deployment which is already finished, the change of its release can't race
+// with concurrent deployments.
+func (r *DeploymentRepo) Add(d *ct.Deployment, expectedReleaseID *string) (*ct.Deployment, error) {
 	if d.ID == "" {
 		d.ID = random.UUID()
 	}
@@ -40,6 +44,22 @@ func (r *DeploymentRepo) Add(data interface{}) (*ct.Deployment, error) {
 	if err != nil {
 		return nil, err
 	}
+	var currentReleaseID *string
+	if err := tx.QueryRow("app_select_release_for_update", d.AppID).Scan(&currentReleaseID); err != nil {
+		tx.Rollback()
+		if err == pgx.ErrNoRows {
+			err = ErrNotFound
+		}
+		return nil, err
+	}
+	current := ""
+	if currentReleaseID != nil {
+		current = *currentReleaseID
+	}
+	if expectedReleaseID != nil && *expectedReleaseID != current {
+		tx.Rollback()
+		return nil, releaseChangedError(*expectedReleaseID, current)
+	}
 	if err := tx.QueryRow("deployment_insert", d.ID, d.AppID, oldReleaseID, d.NewReleaseID, d.Strategy, d.Processes, d.DeployTimeout).Scan(&d.CreatedAt); err != nil {
 		tx.Rollback()
 		return nil, err
@@ -47,6 +67,10 @@ func (r *DeploymentRepo) Add(data interface{}) (*ct.Deployment, error) {
 
 	// fake initial deployment
 	if d.FinishedAt != nil {
+		if err := setAppRelease(tx, d.AppID, current, d.NewReleaseID); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
 		if err := tx.Exec("deployment_update_finished_at", d.ID, d.FinishedAt); err != nil {
 			tx.Rollback()
 			return nil, err
@@ -137,11 +161,13 @@ func (c *controllerAPI) GetDeployment(ctx context.Context, w http.ResponseWriter
 	httphelper.JSON(w, 200, deployment)
 }
 
-func (c *controllerAPI) CreateDeployment(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+// deploymentReleases returns the release to deploy given in the request
+// body, along with the app's current release and formation (which are empty
+// if the app has no release).
+func (c *controllerAPI) deploymentReleases(ctx context.Context, req *http.Request) (release, oldRelease *ct.Release, oldFormation *ct.Formation, err error) {
 	var rid releaseID
 	if err := httphelper.DecodeJSON(req, &rid); err != nil {
-		respondWithError(w, err)
-		return
+		return nil, nil, nil, err
 	}
 
 	rel, err := c.releaseRepo.Get(rid.ID)
@@ -151,24 +177,44 @@ func (c *controllerAPI) CreateDeployment(ctx context.Context, w http.ResponseWri
 				Message: fmt.Sprintf("could not find release with ID %s", rid.ID),
 			}
 		}
-		respondWithError(w, err)
-		return
+		return nil, nil, nil, err
 	}
-	release := rel.(*ct.Release)
-	app := c.getApp(ctx)
+	release = rel.(*ct.Release)
+	oldRelease, oldFormation, err = c.currentReleaseFormation(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return release, oldRelease, oldFormation, nil
+}
 
-	// TODO: wrap all of this in a transaction
-	oldRelease, err := c.appRepo.GetRelease(app.ID)
+// currentReleaseFormation returns the app's current release and its
+// formation, which are empty if the app has no release.
+func (c *controllerAPI) currentReleaseFormation(ctx context.Context) (*ct.Release, *ct.Formation, error) {
+	app := c.getApp(ctx)
+	release, err := c.appRepo.GetRelease(app.ID)
 	if err == ErrNotFound {
-		oldRelease = &ct.Release{}
+		release = &ct.Release{}
 	} else if err != nil {
-		respondWithError(w, err)
-		return
+		return nil, nil, err
 	}
-	oldFormation, err := c.formationRepo.Get(app.ID, oldRelease.ID)
+	formation, err := c.formationRepo.Get(app.ID, release.ID)
 	if err == ErrNotFound {
-		oldFormation = &ct.Formation{}
+		formation = &ct.Formation{}
 	} else if err != nil {
+		return nil, nil, err
+	}
+	return release, formation, nil
+}
+
+func (c *controllerAPI) CreateDeployment(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	// TODO: wrap all of this in a transaction
+	release, oldRelease, oldFormation, err := c.deploymentReleases(ctx, req)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	app := c.getApp(ctx)
+	if err := c.secretRepo.CheckRefs(app.ID, release); err != nil {
 		respondWithError(w, err)
 		return
 	}
@@ -191,16 +237,13 @@ func (c *controllerAPI) CreateDeployment(ctx context.Context, w http.ResponseWri
 		return
 	}
 	if procCount == 0 {
-		// immediately set app release
-		if err := c.appRepo.SetRelease(app, release.ID); err != nil {
-			respondWithError(w, err)
-			return
-		}
+		// immediately set app release, which Add does along with
+		// creating the deployment
 		now := time.Now()
 		deployment.FinishedAt = &now
 	}
 
-	d, err := c.deploymentRepo.Add(deployment)
+	d, err := c.deploymentRepo.Add(deployment, ifMatchRelease(req))
 	if err != nil {
 		if postgres.IsUniquenessError(err, "isolate_deploys") {
 			httphelper.ValidationError(w, "", "Cannot create deploy, there is already one in progress for this app.")
@@ -213,6 +256,72 @@ func (c *controllerAPI) CreateDeployment(ctx context.Context, w http.ResponseWri
 	httphelper.JSON(w, 200, d)
 }
 
+// ifMatchRelease returns the ID of the release which the request's If-Match
+// header expects to be the app's current release, or nil if it doesn't
+// expect a particular release. The expectation fails if the release was
+// changed since the client fetched it.
+func ifMatchRelease(req *http.Request) *string {
+	ifMatch := req.Header.Get("If-Match")
+	if ifMatch == "" || ifMatch == "*" {
+		return nil
+	}
+	id := strings.Trim(ifMatch, `"`)
+	return &id
+}
+
+// releaseChangedError returns the conflict error for a request which
+// expected the app's current release to be expected rather than current.
+func releaseChangedError(expected, current string) error {
+	if current == "" {
+		current = "(none)"
+	}
+	if expected == "" {
+		expected = "(none)"
+	}
+	return httphelper.JSONError{
+		Code:    httphelper.ConflictErrorCode,
+		Message: fmt.Sprintf("the app's current release has changed (expected %s, current release is %s)", expected, current),
+	}
+}
+
+// PlanDeployment responds with the actions deploying the given release would
+// take, without creating a deployment. The release is either an existing
+// release given by ID, or a release which hasn't been created (one without
+// an ID) so that a deployment can be planned without creating anything.
+func (c *controllerAPI) PlanDeployment(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	release := &ct.Release{}
+	if err := httphelper.DecodeJSON(req, release); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	if release.ID != "" {
+		rel, err := c.releaseRepo.Get(release.ID)
+		if err != nil {
+			if err == ErrNotFound {
+				err = ct.ValidationError{
+					Message: fmt.Sprintf("could not find release with ID %s", release.ID),
+				}
+			}
+			respondWithError(w, err)
+			return
+		}
+		release = rel.(*ct.Release)
+	}
+	oldRelease, oldFormation, err := c.currentReleaseFormation(ctx)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	app := c.getApp(ctx)
+	plan, err := ct.PlanDeployment(app.Strategy, oldRelease, release, oldFormation.Processes)
+	if err != nil {
+		respondWithError(w, ct.ValidationError{Field: "strategy", Message: err.Error()})
+		return
+	}
+	plan.AppID = app.ID
+	httphelper.JSON(w, 200, plan)
+}
+
 func (c *controllerAPI) ListDeployments(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	app := c.getApp(ctx)
 	list, err := c.deploymentRepo.List(app.ID)
@@ -4,8 +4,10 @@ import (
 	"reflect"
 	"time"
 
+	"github.com/flynn/flynn/controller/client"
 	ct "github.com/flynn/flynn/controller/types"
 	hh "github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/random"
 	. "github.com/flynn/go-check"
 )
 
@@ -45,6 +47,109 @@ func (s *S) TestCreateDeployment(c *C) {
 	c.Assert(err.(hh.JSONError).Message, Equals, "Cannot create deploy, there is already one in progress for this app.")
 }
 
+func (s *S) TestPlanDeployment(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "plan-deployment", Strategy: "one-by-one"})
+	release := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {}},
+	})
+	c.Assert(s.c.PutFormation(&ct.Formation{
+		AppID:     app.ID,
+		ReleaseID: release.ID,
+		Processes: map[string]int{"web": 2},
+	}), IsNil)
+	defer s.c.DeleteFormation(app.ID, release.ID)
+	c.Assert(s.c.SetAppRelease(app.ID, release.ID), IsNil)
+
+	newRelease := s.createTestRelease(c, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {Service: "plan-deployment-web"}},
+	})
+	plan, err := s.c.PlanDeployment(app.ID, &ct.Release{ID: newRelease.ID})
+	c.Assert(err, IsNil)
+	c.Assert(plan.AppID, Equals, app.ID)
+	c.Assert(plan.OldReleaseID, Equals, release.ID)
+	c.Assert(plan.NewReleaseID, Equals, newRelease.ID)
+	c.Assert(plan.Strategy, Equals, "one-by-one")
+	c.Assert(plan.Steps, HasLen, 4)
+	c.Assert(plan.Services, DeepEquals, map[string]string{"web": "plan-deployment-web"})
+
+	// planning should not create a deployment or change the app release
+	list, err := s.c.DeploymentList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 0)
+	gotRelease, err := s.c.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRelease.ID, Equals, release.ID)
+
+	// a release which hasn't been created can be planned
+	plan, err = s.c.PlanDeployment(app.ID, &ct.Release{
+		Processes: map[string]ct.ProcessType{"web": {Service: "plan-deployment-web"}},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(plan.OldReleaseID, Equals, release.ID)
+	c.Assert(plan.NewReleaseID, Equals, "")
+	c.Assert(plan.Steps, HasLen, 4)
+
+	// an unknown release is a validation error
+	_, err = s.c.PlanDeployment(app.ID, &ct.Release{ID: random.UUID()})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+}
+
+func (s *S) TestCreateDeploymentIfCurrent(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "create-deployment-if-current"})
+	release := s.createTestRelease(c, &ct.Release{})
+	other := s.createTestRelease(c, &ct.Release{})
+
+	// the app has no release, so expecting one is a conflict
+	_, err := s.c.CreateDeploymentIfCurrent(app.ID, release.ID, other.ID)
+	c.Assert(controller.IsConflict(err), Equals, true)
+
+	d, err := s.c.CreateDeploymentIfCurrent(app.ID, release.ID, "")
+	c.Assert(err, IsNil)
+	c.Assert(d.FinishedAt, NotNil)
+
+	// deploying on top of a release other than the current one is a conflict
+	newRelease := s.createTestRelease(c, &ct.Release{})
+	_, err = s.c.CreateDeploymentIfCurrent(app.ID, newRelease.ID, other.ID)
+	c.Assert(controller.IsConflict(err), Equals, true)
+	gotRelease, err := s.c.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRelease.ID, Equals, release.ID)
+
+	_, err = s.c.CreateDeploymentIfCurrent(app.ID, newRelease.ID, release.ID)
+	c.Assert(err, IsNil)
+	gotRelease, err = s.c.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRelease.ID, Equals, newRelease.ID)
+
+	// of concurrent deployments expecting the same current release, only
+	// one succeeds
+	type result struct {
+		releaseID string
+		err       error
+	}
+	results := make(chan result)
+	for i := 0; i < 5; i++ {
+		r := s.createTestRelease(c, &ct.Release{})
+		go func() {
+			_, err := s.c.CreateDeploymentIfCurrent(app.ID, r.ID, newRelease.ID)
+			results <- result{r.ID, err}
+		}()
+	}
+	var deployed []string
+	for i := 0; i < 5; i++ {
+		res := <-results
+		if res.err == nil {
+			deployed = append(deployed, res.releaseID)
+			continue
+		}
+		c.Assert(controller.IsConflict(res.err), Equals, true, Commentf("err = %s", res.err))
+	}
+	c.Assert(deployed, HasLen, 1)
+	gotRelease, err = s.c.GetAppRelease(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(gotRelease.ID, Equals, deployed[0])
+}
+
 func (s *S) TestStreamDeployment(c *C) {
 	app := s.createTestApp(c, &ct.App{Name: "stream-deployment"})
 	release := s.createTestRelease(c, &ct.Release{
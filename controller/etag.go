@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/flynn/flynn/pkg/httphelper"
+)
+
+// contentETag returns an entity tag derived from the JSON encoding of v, for
+// resources which don't have a version of their own.
+func contentETag(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`"%x"`, sha256.Sum256(data)), nil
+}
+
+// etagMatches returns whether etag is one of the entity tags in the given
+// If-None-Match header value, which are compared weakly.
+func etagMatches(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// respondWithETag responds with v and its entity tag, or with 304 Not
+// Modified if the request has an If-None-Match header matching the tag so
+// that the client can use the copy it already has.
+func respondWithETag(w http.ResponseWriter, req *http.Request, etag string, v interface{}) {
+	w.Header().Set("ETag", etag)
+	if etagMatches(req.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	httphelper.JSON(w, 200, v)
+}
+
+// respondWithContentETag is like respondWithETag, using the contentETag of
+// v.
+func respondWithContentETag(w http.ResponseWriter, req *http.Request, v interface{}) {
+	etag, err := contentETag(v)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithETag(w, req, etag, v)
+}
@@ -257,7 +257,12 @@ func (c *controllerAPI) RunJob(ctx context.Context, w http.ResponseWriter, req *
 	env["FLYNN_PROCESS_TYPE"] = ""
 	env["FLYNN_JOB_ID"] = id
 	if newJob.ReleaseEnv {
-		for k, v := range release.Env {
+		releaseEnv, err := c.secretRepo.ResolveEnv(app.ID, release)
+		if err != nil {
+			respondWithError(w, err)
+			return
+		}
+		for k, v := range releaseEnv {
 			env[k] = v
 		}
 	}
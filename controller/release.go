@@ -1,13 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/flynn/flynn/controller/schema"
 	ct "github.com/flynn/flynn/controller/types"
 	"github.com/flynn/flynn/host/resource"
+	"github.com/flynn/flynn/pkg/ctxhelper"
 	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/pkg/postgres"
 	"github.com/flynn/flynn/pkg/random"
@@ -34,7 +37,7 @@ func NewReleaseRepo(db *postgres.DB, artifacts *ArtifactRepo, que *que.Client) *
 func scanRelease(s postgres.Scanner) (*ct.Release, error) {
 	var artifactIDs string
 	release := &ct.Release{}
-	err := s.Scan(&release.ID, &artifactIDs, &release.Env, &release.Processes, &release.Meta, &release.CreatedAt)
+	err := s.Scan(&release.ID, &artifactIDs, &release.Env, &release.Processes, &release.Meta, &release.CreatedAt, &release.SecretEnv)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			err = ErrNotFound
@@ -69,14 +72,42 @@ func (r *ReleaseRepo) Add(data interface{}) error {
 			Message: fmt.Sprintf("you can't create an env var with an empty key (tried to set \"\"=%q)", value),
 		}
 	}
+	for key, name := range release.SecretEnv {
+		if key == "" || name == "" {
+			return ct.ValidationError{
+				Field:   "secret_env",
+				Message: fmt.Sprintf("env vars and the secrets they refer to must be named (tried to set %q to secret %q)", key, name),
+			}
+		}
+		if _, ok := release.Env[key]; ok {
+			return ct.ValidationError{
+				Field:   "secret_env",
+				Message: fmt.Sprintf("env var %s can't be set in both env and secret_env", key),
+			}
+		}
+	}
 
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
 	}
 
-	err = tx.QueryRow("release_insert", release.ID, release.Env, release.Processes, release.Meta).Scan(&release.CreatedAt)
-	if err != nil {
+	err = tx.QueryRow("release_insert", release.ID, release.Env, release.Processes, release.Meta, release.SecretEnv).Scan(&release.CreatedAt)
+	if postgres.IsUniquenessError(err, "releases_pkey") {
+		// the client supplied the ID of an existing release (e.g. when
+		// retrying a request which timed out), so return the original
+		// release rather than creating a new one
+		tx.Rollback()
+		existing, err := r.Get(release.ID)
+		if err != nil {
+			return err
+		}
+		if !sameReleaseConfig(release, existing.(*ct.Release)) {
+			return idempotencyConflictError("release", release.ID)
+		}
+		*release = *existing.(*ct.Release)
+		return nil
+	} else if err != nil {
 		tx.Rollback()
 		return err
 	}
@@ -105,11 +136,59 @@ func (r *ReleaseRepo) Add(data interface{}) error {
 	return tx.Commit()
 }
 
+// sameReleaseConfig returns whether a and b have the same artifacts, env and
+// processes, ignoring their meta which can change once a release has been
+// created (see UpdateMeta).
+func sameReleaseConfig(a, b *ct.Release) bool {
+	config := func(r *ct.Release) []byte {
+		data, _ := json.Marshal(struct {
+			ArtifactIDs []string                  `json:"artifacts,omitempty"`
+			Env         map[string]string         `json:"env,omitempty"`
+			SecretEnv   map[string]string         `json:"secret_env,omitempty"`
+			Processes   map[string]ct.ProcessType `json:"processes,omitempty"`
+		}{r.ArtifactIDs, r.Env, r.SecretEnv, r.Processes})
+		return data
+	}
+	return bytes.Equal(config(a), config(b))
+}
+
+// idempotencyConflictError returns the error for a request to create a
+// resource with the ID of an existing one which differs from the request,
+// so the ID can't be a retry of the request which created it.
+func idempotencyConflictError(resource, id string) error {
+	return httphelper.JSONError{
+		Code:    httphelper.ConflictErrorCode,
+		Message: fmt.Sprintf("a different %s with ID %s already exists", resource, id),
+	}
+}
+
 func (r *ReleaseRepo) Get(id string) (interface{}, error) {
 	row := r.db.QueryRow("release_select", id)
 	return scanRelease(row)
 }
 
+// UpdateMeta merges meta into the meta of the release with the given ID,
+// which is the only part of a release which can change once it has been
+// created.
+func (r *ReleaseRepo) UpdateMeta(id string, meta map[string]string) (*ct.Release, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// merge the keys in the update so that concurrent updates of different
+	// keys don't overwrite each other
+	if err := tx.Exec("release_update_meta", id, meta); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	release, err := scanRelease(tx.QueryRow("release_select", id))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return release, tx.Commit()
+}
+
 func releaseList(rows *pgx.Rows) ([]*ct.Release, error) {
 	var releases []*ct.Release
 	for rows.Next() {
@@ -123,6 +202,12 @@ func releaseList(rows *pgx.Rows) ([]*ct.Release, error) {
 	return releases, rows.Err()
 }
 
+// ETag returns the entity tag of a release, which is derived from its
+// content as release meta can be changed.
+func (r *ReleaseRepo) ETag(release interface{}) (string, error) {
+	return contentETag(release)
+}
+
 func (r *ReleaseRepo) List() (interface{}, error) {
 	rows, err := r.db.Query("release_list")
 	if err != nil {
@@ -131,14 +216,39 @@ func (r *ReleaseRepo) List() (interface{}, error) {
 	return releaseList(rows)
 }
 
-func (r *ReleaseRepo) AppList(appID string) ([]*ct.Release, error) {
-	rows, err := r.db.Query(`release_app_list`, appID)
+// AppList returns the releases of the given app, newest first, limited to
+// the count most recent releases if count is positive.
+func (r *ReleaseRepo) AppList(appID string, count int) ([]*ct.Release, error) {
+	var rows *pgx.Rows
+	var err error
+	if count > 0 {
+		rows, err = r.db.Query("release_app_list_count", appID, count)
+	} else {
+		rows, err = r.db.Query("release_app_list", appID)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return releaseList(rows)
 }
 
+// AppSummary returns the number of releases for the given app, along with
+// its current release ID and the creation time of its oldest and newest
+// releases.
+func (r *ReleaseRepo) AppSummary(appID string) (*ct.ReleaseSummary, error) {
+	summary := &ct.ReleaseSummary{}
+	var currentID *string
+	if err := r.db.QueryRow("release_app_summary", appID).Scan(
+		&summary.Count, &summary.OldestCreatedAt, &summary.NewestCreatedAt, &currentID,
+	); err != nil {
+		return nil, err
+	}
+	if currentID != nil {
+		summary.CurrentReleaseID = *currentID
+	}
+	return summary, nil
+}
+
 // Delete deletes any formations for the given app and release, then deletes
 // the release and any associated file artifacts if there are no remaining
 // formations for the release, enqueueing a worker job to delete any files
@@ -269,17 +379,47 @@ func (r *ReleaseRepo) Delete(app *ct.App, release *ct.Release) error {
 	return tx.Commit()
 }
 
+// RetryCleanup schedules the pending job deleting the files of the given
+// deleted release of app to run immediately, returning whether there was
+// one (i.e. a previous attempt to delete the files failed).
+func (r *ReleaseRepo) RetryCleanup(app *ct.App, releaseID string) (bool, error) {
+	var jobID int64
+	err := r.db.QueryRow("release_cleanup_retry", app.ID, releaseID).Scan(&jobID)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 type releaseID struct {
 	ID string `json:"id"`
 }
 
 func (c *controllerAPI) GetAppReleases(ctx context.Context, w http.ResponseWriter, req *http.Request) {
-	list, err := c.releaseRepo.AppList(c.getApp(ctx).ID)
+	var count int
+	if s := req.FormValue("count"); s != "" {
+		var err error
+		count, err = strconv.Atoi(s)
+		if err != nil || count < 0 {
+			respondWithError(w, ct.ValidationError{Field: "count", Message: "is invalid"})
+			return
+		}
+	}
+	list, err := c.releaseRepo.AppList(c.getApp(ctx).ID, count)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	respondWithContentETag(w, req, list)
+}
+
+func (c *controllerAPI) GetAppReleaseSummary(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	summary, err := c.releaseRepo.AppSummary(c.getApp(ctx).ID)
 	if err != nil {
 		respondWithError(w, err)
 		return
 	}
-	httphelper.JSON(w, 200, list)
+	httphelper.JSON(w, 200, summary)
 }
 
 func (c *controllerAPI) SetAppRelease(ctx context.Context, w http.ResponseWriter, req *http.Request) {
@@ -317,12 +457,43 @@ func (c *controllerAPI) GetAppRelease(ctx context.Context, w http.ResponseWriter
 		respondWithError(w, err)
 		return
 	}
+	// the tag covers the whole release rather than just its ID as release
+	// meta can be changed (see UpdateReleaseMeta)
+	respondWithContentETag(w, req, release)
+}
+
+func (c *controllerAPI) UpdateReleaseMeta(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var data struct {
+		Meta map[string]string `json:"meta"`
+	}
+	if err := httphelper.DecodeJSON(req, &data); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	release, err := c.releaseRepo.UpdateMeta(params.ByName("releases_id"), data.Meta)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
 	httphelper.JSON(w, 200, release)
 }
 
 func (c *controllerAPI) DeleteRelease(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	app := c.getApp(ctx)
 	release, err := c.getRelease(ctx)
+	if err == ErrNotFound {
+		// the release may have been deleted with its files failing to be
+		// deleted, in which case retry deleting the remaining files now
+		params, _ := ctxhelper.ParamsFromContext(ctx)
+		retrying, retryErr := c.releaseRepo.RetryCleanup(app, params.ByName("releases_id"))
+		if retryErr != nil {
+			err = retryErr
+		} else if retrying {
+			w.WriteHeader(200)
+			return
+		}
+	}
 	if err != nil {
 		respondWithError(w, err)
 		return
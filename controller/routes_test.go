@@ -39,6 +39,15 @@ func (r *fakeRouter) CreateRoute(route *router.Route) error {
 	return nil
 }
 
+func (r *fakeRouter) ImportRoutes(routes []*router.Route) error {
+	for _, route := range routes {
+		if err := r.CreateRoute(route); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (r *fakeRouter) DeleteRoute(routeType, id string) error {
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
@@ -606,7 +606,7 @@ func (s *Scheduler) stopSurplusOmniJobs(formation *Formation) {
 
 		log.Info(fmt.Sprintf("detected %d surplus omni jobs", len(surplusJobs)), "type", typ)
 		for _, job := range surplusJobs {
-			s.stopJob(job)
+			s.stopJob(job, false)
 		}
 	}
 }
@@ -628,7 +628,7 @@ func (s *Scheduler) stopJobsWithMismatchedTags(formation *Formation) {
 			continue
 		}
 		log.Info("job has mismatched tags, stopping", "job.id", job.ID, "job.tags", job.Tags(), "host.id", host.ID, "host.tags", host.Tags)
-		s.stopJob(job)
+		s.stopJob(job, false)
 	}
 }
 
@@ -1382,11 +1382,27 @@ func (s *Scheduler) stopJobOfType(f *Formation, typ string) (err error) {
 	if err != nil {
 		return err
 	}
-	return s.stopJob(job)
+	return s.stopJob(job, s.isBeingReplaced(f, typ))
 }
 
-func (s *Scheduler) stopJob(job *Job) error {
-	log := s.logger.New("fn", "stopJob", "job.id", job.ID, "job.type", job.Type, "job.state", job.State)
+// isBeingReplaced returns whether jobs of the given type in the given
+// formation are being replaced by another release of the same app, which is
+// the case when a deployer scales the old release down whilst the new one is
+// scaled up. Such jobs are drained rather than stopped so that requests in
+// flight to them are not dropped.
+func (s *Scheduler) isBeingReplaced(f *Formation, typ string) bool {
+	for _, other := range s.formations {
+		if other.App.ID == f.App.ID && other.Release.ID != f.Release.ID && other.Processes[typ] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// stopJob stops the given job, draining its services first if drain is true
+// (see host.DrainJob)
+func (s *Scheduler) stopJob(job *Job, drain bool) error {
+	log := s.logger.New("fn", "stopJob", "job.id", job.ID, "job.type", job.Type, "job.state", job.State, "drain", drain)
 	log.Info("stopping job")
 
 	switch job.State {
@@ -1430,7 +1446,11 @@ func (s *Scheduler) stopJob(job *Job) error {
 	job.State = JobStateStopping
 	go func() {
 		// host.StopJob can block, so run it in a goroutine
-		if err := host.client.StopJob(job.JobID); err != nil {
+		stop := host.client.StopJob
+		if drain {
+			stop = host.client.DrainJob
+		}
+		if err := stop(job.JobID); err != nil {
 			log.Error("error requesting host to stop job", "err", err)
 		}
 	}()
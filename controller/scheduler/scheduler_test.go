@@ -254,6 +254,40 @@ func (TestSuite) TestFormationChange(c *C) {
 	c.Assert(job.ReleaseID, Equals, release.ID)
 }
 
+func (TestSuite) TestDeployDrain(c *C) {
+	hosts := newTestHosts()
+	s := runTestScheduler(c, newTestCluster(hosts), true)
+	defer s.Stop()
+	host := hosts[testHostID]
+
+	s.waitJobStart()
+
+	// scaling down a release which is not being replaced should stop
+	// the job without draining it
+	c.Log("Test scaling down a formation outside of a deploy")
+	s.PutFormation(&ct.Formation{AppID: testAppID, ReleaseID: testReleaseID, Processes: map[string]int{testJobType: 2}})
+	s.waitJobStart()
+	s.PutFormation(&ct.Formation{AppID: testAppID, ReleaseID: testReleaseID, Processes: map[string]int{testJobType: 1}})
+	job := s.waitJobStop()
+	c.Assert(host.IsStopped(job.JobID), Equals, true)
+	c.Assert(host.IsDrained(job.JobID), Equals, false)
+
+	// scaling down the old release whilst a new release is scaled up
+	// should drain the job
+	c.Log("Test scaling down a formation which is being replaced by a deploy")
+	artifact := &ct.Artifact{ID: random.UUID()}
+	processes := map[string]int{testJobType: testJobCount}
+	release := NewRelease(random.UUID(), artifact, processes)
+	s.CreateArtifact(artifact)
+	s.CreateRelease(release)
+	s.PutFormation(&ct.Formation{AppID: testAppID, ReleaseID: release.ID, Processes: processes})
+	s.waitJobStart()
+	s.PutFormation(&ct.Formation{AppID: testAppID, ReleaseID: testReleaseID, Processes: map[string]int{}})
+	job = s.waitJobStop()
+	c.Assert(job.ReleaseID, Equals, testReleaseID)
+	c.Assert(host.IsDrained(job.JobID), Equals, true)
+}
+
 func (TestSuite) TestRectify(c *C) {
 	s := runTestScheduler(c, nil, true)
 	defer s.Stop()
@@ -384,6 +384,31 @@ $$ LANGUAGE plpgsql`,
 	migrations.Add(18,
 		`INSERT INTO event_types (name) VALUES ('app_garbage_collection')`,
 	)
+	migrations.Add(19,
+		`INSERT INTO event_types (name) VALUES ('release_deletion_progress')`,
+	)
+	migrations.Add(20,
+		`ALTER TABLE releases ADD COLUMN secret_env jsonb`,
+		`CREATE TABLE app_secrets (
+    app_id uuid NOT NULL REFERENCES apps (app_id),
+    name text NOT NULL CHECK (name <> ''),
+    value text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (app_id, name)
+)`,
+		// resolve_release_env returns the env of a release run by the given
+		// app with the values of the app secrets referenced by secret_env
+		// added, omitting references to secrets which don't exist
+		`CREATE FUNCTION resolve_release_env(app uuid, env jsonb, secret_env jsonb) RETURNS jsonb AS $$
+			SELECT CASE WHEN secret_env IS NULL THEN env
+			ELSE COALESCE(env, '{}') || COALESCE((
+				SELECT jsonb_object_agg(e.key, s.value)
+				FROM jsonb_each_text(secret_env) e
+				JOIN app_secrets s ON s.app_id = app AND s.name = e.value
+			), '{}') END
+		$$ LANGUAGE SQL STABLE`,
+	)
 }
 
 func migrateDB(db *postgres.DB) error {
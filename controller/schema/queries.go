@@ -20,13 +20,23 @@ var preparedStatements = map[string]string{
 	"app_delete":                            appDeleteQuery,
 	"app_next_name_id":                      appNextNameIDQuery,
 	"app_get_release":                       appGetReleaseQuery,
+	"app_select_release_for_update":         appSelectReleaseForUpdateQuery,
+	"app_secret_list":                       appSecretListQuery,
+	"app_secret_upsert":                     appSecretUpsertQuery,
+	"app_secret_delete":                     appSecretDeleteQuery,
+	"app_secret_delete_by_app":              appSecretDeleteByAppQuery,
 	"release_list":                          releaseListQuery,
 	"release_select":                        releaseSelectQuery,
 	"release_insert":                        releaseInsertQuery,
+	"release_update_meta":                   releaseUpdateMetaQuery,
+	"release_resolve_env":                   releaseResolveEnvQuery,
 	"release_app_list":                      releaseAppListQuery,
+	"release_app_list_count":                releaseAppListCountQuery,
+	"release_app_summary":                   releaseAppSummaryQuery,
 	"release_artifacts_insert":              releaseArtifactsInsertQuery,
 	"release_artifacts_delete":              releaseArtifactsDeleteQuery,
 	"release_delete":                        releaseDeleteQuery,
+	"release_cleanup_retry":                 releaseCleanupRetryQuery,
 	"artifact_list":                         artifactListQuery,
 	"artifact_list_ids":                     artifactListIDsQuery,
 	"artifact_select":                       artifactSelectQuery,
@@ -43,6 +53,7 @@ var preparedStatements = map[string]string{
 	"event_select":                          eventSelectQuery,
 	"event_insert":                          eventInsertQuery,
 	"event_insert_unique":                   eventInsertUniqueQuery,
+	"event_release_deletion_files":          eventReleaseDeletionFilesQuery,
 	"formation_list_by_app":                 formationListByAppQuery,
 	"formation_list_by_release":             formationListByReleaseQuery,
 	"formation_list_active":                 formationListActiveQuery,
@@ -121,6 +132,8 @@ UPDATE apps SET deploy_timeout = $2, updated_at = now() WHERE app_id = $1`
 UPDATE apps SET deleted_at = now() WHERE app_id = $1 AND deleted_at IS NULL`
 	appNextNameIDQuery = `
 SELECT nextval('name_ids')`
+	appSelectReleaseForUpdateQuery = `
+SELECT release_id FROM apps WHERE app_id = $1 AND deleted_at IS NULL FOR UPDATE`
 	appGetReleaseQuery = `
 SELECT r.release_id,
   ARRAY(
@@ -128,8 +141,18 @@ SELECT r.release_id,
 	FROM release_artifacts a
 	WHERE a.release_id = r.release_id AND a.deleted_at IS NULL
 	ORDER BY a.index
-  ), r.env, r.processes, r.meta, r.created_at
+  ), r.env, r.processes, r.meta, r.created_at, r.secret_env
 FROM apps a JOIN releases r USING (release_id) WHERE a.app_id = $1 AND r.deleted_at IS NULL`
+	appSecretListQuery = `
+SELECT name, created_at, updated_at FROM app_secrets WHERE app_id = $1 ORDER BY name`
+	appSecretUpsertQuery = `
+INSERT INTO app_secrets (app_id, name, value) VALUES ($1, $2, $3)
+ON CONFLICT ON CONSTRAINT app_secrets_pkey DO UPDATE SET value = $3, updated_at = now()
+RETURNING created_at, updated_at`
+	appSecretDeleteQuery = `
+DELETE FROM app_secrets WHERE app_id = $1 AND name = $2`
+	appSecretDeleteByAppQuery = `
+DELETE FROM app_secrets WHERE app_id = $1`
 
 	releaseListQuery = `
 SELECT r.release_id,
@@ -138,7 +161,7 @@ SELECT r.release_id,
 	FROM release_artifacts a
 	WHERE a.release_id = r.release_id AND a.deleted_at IS NULL
 	ORDER BY a.index
-  ), r.env, r.processes, r.meta, r.created_at
+  ), r.env, r.processes, r.meta, r.created_at, r.secret_env
 FROM releases r WHERE r.deleted_at IS NULL ORDER BY r.created_at DESC`
 	releaseSelectQuery = `
 SELECT r.release_id,
@@ -147,11 +170,16 @@ SELECT r.release_id,
 	FROM release_artifacts a
 	WHERE a.release_id = r.release_id AND a.deleted_at IS NULL
 	ORDER BY a.index
-  ), r.env, r.processes, r.meta, r.created_at
+  ), r.env, r.processes, r.meta, r.created_at, r.secret_env
 FROM releases r WHERE r.release_id = $1 AND r.deleted_at IS NULL`
 	releaseInsertQuery = `
-INSERT INTO releases (release_id, env, processes, meta)
-VALUES ($1, $2, $3, $4) RETURNING created_at`
+INSERT INTO releases (release_id, env, processes, meta, secret_env)
+VALUES ($1, $2, $3, $4, $5) RETURNING created_at`
+	releaseUpdateMetaQuery = `
+UPDATE releases SET meta = COALESCE(jsonb_merge(CASE WHEN meta IS NULL OR meta = 'null' THEN '{}' ELSE meta END, $2), '{}')
+WHERE release_id = $1 AND deleted_at IS NULL`
+	releaseResolveEnvQuery = `
+SELECT resolve_release_env($1, env, secret_env) FROM releases WHERE release_id = $2`
 	releaseAppListQuery = `
 SELECT DISTINCT(r.release_id),
   ARRAY(
@@ -159,15 +187,34 @@ SELECT DISTINCT(r.release_id),
 	FROM release_artifacts a
 	WHERE a.release_id = r.release_id AND a.deleted_at IS NULL
 	ORDER BY a.index
-  ), r.env, r.processes, r.meta, r.created_at
+  ), r.env, r.processes, r.meta, r.created_at, r.secret_env
 FROM releases r JOIN formations f USING (release_id)
 WHERE f.app_id = $1 AND r.deleted_at IS NULL ORDER BY r.created_at DESC`
+	releaseAppListCountQuery = `
+SELECT DISTINCT(r.release_id),
+  ARRAY(
+	SELECT a.artifact_id
+	FROM release_artifacts a
+	WHERE a.release_id = r.release_id AND a.deleted_at IS NULL
+	ORDER BY a.index
+  ), r.env, r.processes, r.meta, r.created_at, r.secret_env
+FROM releases r JOIN formations f USING (release_id)
+WHERE f.app_id = $1 AND r.deleted_at IS NULL ORDER BY r.created_at DESC LIMIT $2`
+	releaseAppSummaryQuery = `
+SELECT COUNT(DISTINCT r.release_id), MIN(r.created_at), MAX(r.created_at),
+  (SELECT release_id FROM apps WHERE app_id = $1)
+FROM releases r JOIN formations f USING (release_id)
+WHERE f.app_id = $1 AND r.deleted_at IS NULL`
 	releaseArtifactsInsertQuery = `
 INSERT INTO release_artifacts (release_id, artifact_id, index) VALUES ($1, $2, $3)`
 	releaseArtifactsDeleteQuery = `
 UPDATE release_artifacts SET deleted_at = now() WHERE release_id = $1 AND artifact_id = $2 AND deleted_at IS NULL`
 	releaseDeleteQuery = `
 UPDATE releases SET deleted_at = now() WHERE release_id = $1 AND deleted_at IS NULL`
+	releaseCleanupRetryQuery = `
+UPDATE que_jobs SET run_at = now()
+WHERE job_class = 'release_cleanup' AND args->>'AppID' = $1 AND args->>'ReleaseID' = $2
+RETURNING job_id`
 	artifactListQuery = `
 SELECT artifact_id, type, uri, meta, created_at FROM artifacts
 WHERE deleted_at IS NULL ORDER BY created_at DESC`
@@ -225,6 +272,9 @@ VALUES ($1, $2, $3, $4)`
 	eventInsertUniqueQuery = `
 INSERT INTO events (app_id, object_id, unique_id, object_type, data)
 VALUES ($1, $2, $3, $4, $5) ON CONFLICT (unique_id) DO NOTHING`
+	eventReleaseDeletionFilesQuery = `
+SELECT DISTINCT data->>'file' FROM events
+WHERE object_type = 'release_deletion_progress' AND object_id = $1`
 	formationListByAppQuery = `
 SELECT app_id, release_id, processes, tags, created_at, updated_at
 FROM formations WHERE app_id = $1 AND deleted_at IS NULL ORDER BY created_at DESC`
@@ -241,7 +291,7 @@ SELECT
 	WHERE r.release_id = releases.release_id AND r.deleted_at IS NULL
 	ORDER BY r.index
   ),
-  releases.meta, releases.env, releases.processes,
+  releases.meta, resolve_release_env(formations.app_id, releases.env, releases.secret_env), releases.processes,
   formations.processes, formations.tags, formations.updated_at
 FROM formations
 JOIN apps USING (app_id)
@@ -271,7 +321,7 @@ SELECT
 	WHERE a.release_id = releases.release_id AND a.deleted_at IS NULL
 	ORDER BY a.index
   ),
-  releases.meta, releases.env, releases.processes,
+  releases.meta, resolve_release_env(formations.app_id, releases.env, releases.secret_env), releases.processes,
   formations.processes, formations.tags, formations.updated_at
 FROM formations
 JOIN apps USING (app_id)
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+
+	ct "github.com/flynn/flynn/controller/types"
+	"github.com/flynn/flynn/pkg/ctxhelper"
+	"github.com/flynn/flynn/pkg/httphelper"
+	"github.com/flynn/flynn/pkg/postgres"
+	"golang.org/x/net/context"
+)
+
+// secretNamePattern matches valid secret names, which are used in URL paths
+// so are restricted to characters which don't need escaping.
+var secretNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// SecretRepo stores the secrets of apps, which releases refer to by name in
+// their SecretEnv rather than containing the values in their env.
+type SecretRepo struct {
+	db *postgres.DB
+}
+
+func NewSecretRepo(db *postgres.DB) *SecretRepo {
+	return &SecretRepo{db: db}
+}
+
+// List returns the secrets of an app without their values.
+func (r *SecretRepo) List(appID string) ([]*ct.Secret, error) {
+	rows, err := r.db.Query("app_secret_list", appID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var secrets []*ct.Secret
+	for rows.Next() {
+		secret := &ct.Secret{}
+		if err := rows.Scan(&secret.Name, &secret.CreatedAt, &secret.UpdatedAt); err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, rows.Err()
+}
+
+// Set creates or updates an app secret.
+func (r *SecretRepo) Set(appID string, secret *ct.Secret) error {
+	if !secretNamePattern.MatchString(secret.Name) {
+		return ct.ValidationError{Field: "name", Message: "must only contain letters, digits, '.', '-' and '_'"}
+	}
+	return r.db.QueryRow("app_secret_upsert", appID, secret.Name, secret.Value).Scan(&secret.CreatedAt, &secret.UpdatedAt)
+}
+
+// Delete deletes an app secret, after which jobs of releases which refer to
+// it are started without the env var set.
+func (r *SecretRepo) Delete(appID, name string) error {
+	return r.db.Exec("app_secret_delete", appID, name)
+}
+
+// CheckRefs returns a validation error if the release refers to secrets
+// which the app doesn't have.
+func (r *SecretRepo) CheckRefs(appID string, release *ct.Release) error {
+	if len(release.SecretEnv) == 0 {
+		return nil
+	}
+	secrets, err := r.List(appID)
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool, len(secrets))
+	for _, secret := range secrets {
+		exists[secret.Name] = true
+	}
+	var missing []string
+	for key, name := range release.SecretEnv {
+		if !exists[name] {
+			missing = append(missing, fmt.Sprintf("%s (for %s)", name, key))
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return ct.ValidationError{
+		Field:   "secret_env",
+		Message: fmt.Sprintf("release %s refers to secrets which don't exist: %v", release.ID, missing),
+	}
+}
+
+// ResolveEnv returns the env of the release when run by the app, which
+// includes the values of the secrets the release refers to.
+func (r *SecretRepo) ResolveEnv(appID string, release *ct.Release) (map[string]string, error) {
+	if len(release.SecretEnv) == 0 {
+		return release.Env, nil
+	}
+	var env map[string]string
+	if err := r.db.QueryRow("release_resolve_env", appID, release.ID).Scan(&env); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func (c *controllerAPI) ListAppSecrets(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	secrets, err := c.secretRepo.List(c.getApp(ctx).ID)
+	if err != nil {
+		respondWithError(w, err)
+		return
+	}
+	httphelper.JSON(w, 200, secrets)
+}
+
+func (c *controllerAPI) PutAppSecret(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	var secret ct.Secret
+	if err := httphelper.DecodeJSON(req, &secret); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	secret.Name = params.ByName("secrets_name")
+	if err := c.secretRepo.Set(c.getApp(ctx).ID, &secret); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	// don't send the value back
+	secret.Value = ""
+	httphelper.JSON(w, 200, &secret)
+}
+
+func (c *controllerAPI) DeleteAppSecret(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	params, _ := ctxhelper.ParamsFromContext(ctx)
+	if err := c.secretRepo.Delete(c.getApp(ctx).ID, params.ByName("secrets_name")); err != nil {
+		respondWithError(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	ct "github.com/flynn/flynn/controller/types"
+	hh "github.com/flynn/flynn/pkg/httphelper"
+	. "github.com/flynn/go-check"
+)
+
+func (s *S) TestAppSecrets(c *C) {
+	app := s.createTestApp(c, &ct.App{Name: "app-secrets"})
+	release := s.createTestRelease(c, &ct.Release{
+		Env:       map[string]string{"FOO": "bar"},
+		SecretEnv: map[string]string{"DB_PASSWORD": "db-password"},
+		Processes: map[string]ct.ProcessType{"web": {}},
+	})
+
+	// the release refers to the secret by name
+	got, err := s.c.GetRelease(release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(got.Env, DeepEquals, map[string]string{"FOO": "bar"})
+	c.Assert(got.SecretEnv, DeepEquals, map[string]string{"DB_PASSWORD": "db-password"})
+
+	// the release can't be deployed until the secret exists
+	_, err = s.c.CreateDeployment(app.ID, release.ID)
+	c.Assert(hh.IsValidationError(err), Equals, true)
+
+	secret := &ct.Secret{Name: "db-password", Value: "s3cr3t"}
+	c.Assert(s.c.SetAppSecret(app.ID, secret), IsNil)
+	c.Assert(secret.Value, Equals, "")
+	c.Assert(secret.CreatedAt, NotNil)
+	secrets, err := s.c.AppSecretList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(secrets, HasLen, 1)
+	c.Assert(secrets[0].Name, Equals, "db-password")
+	c.Assert(secrets[0].Value, Equals, "")
+
+	_, err = s.c.CreateDeployment(app.ID, release.ID)
+	c.Assert(err, IsNil)
+
+	// the value is only added to the env of the expanded formation
+	c.Assert(s.c.PutFormation(&ct.Formation{
+		AppID:     app.ID,
+		ReleaseID: release.ID,
+		Processes: map[string]int{"web": 1},
+	}), IsNil)
+	defer s.c.DeleteFormation(app.ID, release.ID)
+	expanded, err := s.c.GetExpandedFormation(app.ID, release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(expanded.Release.Env, DeepEquals, map[string]string{"FOO": "bar", "DB_PASSWORD": "s3cr3t"})
+
+	// updating the secret changes the value
+	c.Assert(s.c.SetAppSecret(app.ID, &ct.Secret{Name: "db-password", Value: "n3w"}), IsNil)
+	expanded, err = s.c.GetExpandedFormation(app.ID, release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(expanded.Release.Env["DB_PASSWORD"], Equals, "n3w")
+
+	// once deleted, the env var is no longer set
+	c.Assert(s.c.DeleteAppSecret(app.ID, "db-password"), IsNil)
+	secrets, err = s.c.AppSecretList(app.ID)
+	c.Assert(err, IsNil)
+	c.Assert(secrets, HasLen, 0)
+	expanded, err = s.c.GetExpandedFormation(app.ID, release.ID)
+	c.Assert(err, IsNil)
+	c.Assert(expanded.Release.Env, DeepEquals, map[string]string{"FOO": "bar"})
+
+	// invalid names are rejected
+	err = s.c.SetAppSecret(app.ID, &ct.Secret{Name: "db:password", Value: "s3cr3t"})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+
+	// vars can't be set in both env and secret_env
+	err = s.c.CreateRelease(&ct.Release{
+		Env:       map[string]string{"DB_PASSWORD": "plain"},
+		SecretEnv: map[string]string{"DB_PASSWORD": "db-password"},
+	})
+	c.Assert(hh.IsValidationError(err), Equals, true)
+}
@@ -18,6 +18,7 @@ func NewFakeHostClient(hostID string, sync bool) *FakeHostClient {
 	h := &FakeHostClient{
 		hostID:        hostID,
 		stopped:       make(map[string]bool),
+		drained:       make(map[string]bool),
 		attach:        make(map[string]attachFunc),
 		volumes:       make(map[string]*volume.Info),
 		Jobs:          make(map[string]host.ActiveJob),
@@ -33,6 +34,7 @@ func NewFakeHostClient(hostID string, sync bool) *FakeHostClient {
 type FakeHostClient struct {
 	hostID           string
 	stopped          map[string]bool
+	drained          map[string]bool
 	attach           map[string]attachFunc
 	Jobs             map[string]host.ActiveJob
 	cluster          *FakeCluster
@@ -123,6 +125,19 @@ func (c *FakeHostClient) StopJob(id string) error {
 	}
 }
 
+func (c *FakeHostClient) DrainJob(id string) error {
+	c.jobsMtx.Lock()
+	c.drained[id] = true
+	c.jobsMtx.Unlock()
+	return c.StopJob(id)
+}
+
+func (c *FakeHostClient) IsDrained(id string) bool {
+	c.jobsMtx.RLock()
+	defer c.jobsMtx.RUnlock()
+	return c.drained[id]
+}
+
 func (c *FakeHostClient) stop(id string) error {
 	job := c.Jobs[id]
 	delete(c.Jobs, id)
@@ -0,0 +1,66 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DeployResult describes the outcome of deploying a release with
+// DeployAppRelease.
+type DeployResult struct {
+	DeploymentID string `json:"deployment,omitempty"`
+	AppID        string `json:"app,omitempty"`
+	OldReleaseID string `json:"old_release,omitempty"`
+	ReleaseID    string `json:"release,omitempty"`
+
+	// Status is the final status of the deployment, either "complete" or
+	// "failed".
+	Status string `json:"status,omitempty"`
+
+	// Duration is how long the deployment took, as seen by the client.
+	Duration time.Duration `json:"duration,omitempty"`
+
+	// ScaleUp and ScaleDown are the number of jobs of each process type of
+	// the new release which were started and of the old release which
+	// were stopped.
+	ScaleUp   map[string]int `json:"scale_up,omitempty"`
+	ScaleDown map[string]int `json:"scale_down,omitempty"`
+}
+
+// Summary returns a single line describing the deployment, for example
+// "Deployed release 1234 in 5.2s (web: 2 up, 2 down)".
+func (r *DeployResult) Summary() string {
+	verb := "Deployed"
+	if r.Status == "failed" {
+		verb = "Failed to deploy"
+	}
+	summary := fmt.Sprintf("%s release %s in %s", verb, r.ReleaseID, r.Duration.Round(100*time.Millisecond))
+
+	types := make([]string, 0, len(r.ScaleUp)+len(r.ScaleDown))
+	for typ := range r.ScaleUp {
+		types = append(types, typ)
+	}
+	for typ := range r.ScaleDown {
+		if _, ok := r.ScaleUp[typ]; !ok {
+			types = append(types, typ)
+		}
+	}
+	if len(types) == 0 {
+		return summary
+	}
+	sort.Strings(types)
+	changes := make([]string, len(types))
+	for i, typ := range types {
+		var counts []string
+		if n := r.ScaleUp[typ]; n > 0 {
+			counts = append(counts, fmt.Sprintf("%d up", n))
+		}
+		if n := r.ScaleDown[typ]; n > 0 {
+			counts = append(counts, fmt.Sprintf("%d down", n))
+		}
+		changes[i] = fmt.Sprintf("%s: %s", typ, strings.Join(counts, ", "))
+	}
+	return fmt.Sprintf("%s (%s)", summary, strings.Join(changes, "; "))
+}
@@ -0,0 +1,20 @@
+package types
+
+import (
+	"time"
+
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestDeployResultSummary(c *C) {
+	res := &DeployResult{ReleaseID: "new", Status: "complete", Duration: 5230 * time.Millisecond}
+	c.Assert(res.Summary(), Equals, "Deployed release new in 5.2s")
+
+	res.ScaleUp = map[string]int{"web": 2, "worker": 1}
+	res.ScaleDown = map[string]int{"web": 2, "clock": 1}
+	c.Assert(res.Summary(), Equals, "Deployed release new in 5.2s (clock: 1 down; web: 2 up, 2 down; worker: 1 up)")
+
+	res.Status = "failed"
+	res.ScaleUp, res.ScaleDown = nil, nil
+	c.Assert(res.Summary(), Equals, "Failed to deploy release new in 5.2s")
+}
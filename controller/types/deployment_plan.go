@@ -0,0 +1,130 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DeploymentPlan describes the actions a deployment would take, without
+// actually performing them.
+type DeploymentPlan struct {
+	AppID        string `json:"app,omitempty"`
+	OldReleaseID string `json:"old_release,omitempty"`
+	NewReleaseID string `json:"new_release,omitempty"`
+	Strategy     string `json:"strategy,omitempty"`
+
+	// ScaleUp and ScaleDown are the number of jobs of each process type of
+	// the new release which will be started and of the old release which
+	// will be stopped.
+	ScaleUp   map[string]int `json:"scale_up,omitempty"`
+	ScaleDown map[string]int `json:"scale_down,omitempty"`
+
+	// Services maps process types to the discoverd service whose backends
+	// are replaced by the deployment.
+	Services map[string]string `json:"services,omitempty"`
+
+	// Steps are the formation changes the deployer makes, in order.
+	Steps []DeploymentStep `json:"steps"`
+
+	// Notes contains caveats about the accuracy of the plan.
+	Notes []string `json:"notes,omitempty"`
+}
+
+// DeploymentStep is a single formation change made during a deployment.
+type DeploymentStep struct {
+	// Action is either "scale_up" (starting jobs of the new release) or
+	// "scale_down" (stopping jobs of the old release).
+	Action    string `json:"action"`
+	ReleaseID string `json:"release"`
+	JobType   string `json:"job_type"`
+	Count     int    `json:"count"`
+
+	// Omni is set if the count is per host rather than in total.
+	Omni bool `json:"omni,omitempty"`
+}
+
+const (
+	DeploymentStepScaleUp   = "scale_up"
+	DeploymentStepScaleDown = "scale_down"
+)
+
+// PlanDeployment returns the plan for deploying newRelease in place of
+// oldRelease with the given strategy, where processes is the app's current
+// formation (which the deployment also uses for the new release). It
+// assumes the current formation is fully running and no jobs of the new
+// release are, which is the case unless a previous deployment was
+// interrupted.
+func PlanDeployment(strategy string, oldRelease, newRelease *Release, processes map[string]int) (*DeploymentPlan, error) {
+	if oldRelease == nil {
+		oldRelease = &Release{}
+	}
+	plan := &DeploymentPlan{
+		OldReleaseID: oldRelease.ID,
+		NewReleaseID: newRelease.ID,
+		Strategy:     strategy,
+		ScaleUp:      make(map[string]int),
+		ScaleDown:    make(map[string]int),
+		Services:     make(map[string]string),
+		Steps:        []DeploymentStep{},
+	}
+
+	types := make([]string, 0, len(processes))
+	for typ, n := range processes {
+		if n > 0 {
+			types = append(types, typ)
+		}
+	}
+	sort.Strings(types)
+
+	omni := func(typ string) bool {
+		return newRelease.Processes[typ].Omni
+	}
+	for _, typ := range types {
+		n := processes[typ]
+		plan.ScaleUp[typ] = n
+		plan.ScaleDown[typ] = n
+		if service := newRelease.Processes[typ].Service; service != "" {
+			plan.Services[typ] = service
+		}
+		if omni(typ) {
+			plan.Notes = append(plan.Notes, fmt.Sprintf("%s is an omni process type, counts are per host", typ))
+		}
+	}
+	if oldRelease.ID == "" || len(types) == 0 {
+		// nothing is running, so the release is set immediately
+		plan.ScaleDown = nil
+		return plan, nil
+	}
+
+	step := func(action, releaseID, typ string, count int) DeploymentStep {
+		return DeploymentStep{Action: action, ReleaseID: releaseID, JobType: typ, Count: count, Omni: omni(typ)}
+	}
+
+	switch strategy {
+	case "one-by-one", "discoverd-meta", "sirenia":
+		for _, typ := range types {
+			for i := 0; i < processes[typ]; i++ {
+				plan.Steps = append(plan.Steps,
+					step(DeploymentStepScaleUp, newRelease.ID, typ, 1),
+					step(DeploymentStepScaleDown, oldRelease.ID, typ, 1),
+				)
+			}
+		}
+		switch strategy {
+		case "discoverd-meta":
+			plan.Notes = append(plan.Notes, "each new job must also be marked ready in its service metadata before an old job is stopped")
+		case "sirenia":
+			plan.Notes = append(plan.Notes, "the order of the sirenia process type's steps depends on the cluster state when deploying")
+		}
+	case "all-at-once":
+		for _, typ := range types {
+			plan.Steps = append(plan.Steps, step(DeploymentStepScaleUp, newRelease.ID, typ, processes[typ]))
+		}
+		for _, typ := range types {
+			plan.Steps = append(plan.Steps, step(DeploymentStepScaleDown, oldRelease.ID, typ, processes[typ]))
+		}
+	default:
+		return nil, fmt.Errorf("unknown deployment strategy %q", strategy)
+	}
+	return plan, nil
+}
@@ -0,0 +1,53 @@
+package types
+
+import (
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestPlanDeployment(c *C) {
+	oldRelease := &Release{ID: "old"}
+	newRelease := &Release{
+		ID: "new",
+		Processes: map[string]ProcessType{
+			"web":    {Service: "app-web"},
+			"worker": {},
+			"agent":  {Omni: true},
+		},
+	}
+	processes := map[string]int{"web": 2, "worker": 1, "agent": 1, "scheduler": 0}
+
+	up := func(typ string, n int) DeploymentStep {
+		return DeploymentStep{Action: DeploymentStepScaleUp, ReleaseID: "new", JobType: typ, Count: n, Omni: typ == "agent"}
+	}
+	down := func(typ string, n int) DeploymentStep {
+		return DeploymentStep{Action: DeploymentStepScaleDown, ReleaseID: "old", JobType: typ, Count: n, Omni: typ == "agent"}
+	}
+
+	plan, err := PlanDeployment("one-by-one", oldRelease, newRelease, processes)
+	c.Assert(err, IsNil)
+	c.Assert(plan.Steps, DeepEquals, []DeploymentStep{
+		up("agent", 1), down("agent", 1),
+		up("web", 1), down("web", 1),
+		up("web", 1), down("web", 1),
+		up("worker", 1), down("worker", 1),
+	})
+	c.Assert(plan.ScaleUp, DeepEquals, map[string]int{"web": 2, "worker": 1, "agent": 1})
+	c.Assert(plan.ScaleDown, DeepEquals, map[string]int{"web": 2, "worker": 1, "agent": 1})
+	c.Assert(plan.Services, DeepEquals, map[string]string{"web": "app-web"})
+	c.Assert(plan.Notes, HasLen, 1)
+
+	plan, err = PlanDeployment("all-at-once", oldRelease, newRelease, processes)
+	c.Assert(err, IsNil)
+	c.Assert(plan.Steps, DeepEquals, []DeploymentStep{
+		up("agent", 1), up("web", 2), up("worker", 1),
+		down("agent", 1), down("web", 2), down("worker", 1),
+	})
+
+	// nothing to do if the app has no running formation
+	plan, err = PlanDeployment("one-by-one", &Release{}, newRelease, nil)
+	c.Assert(err, IsNil)
+	c.Assert(plan.Steps, HasLen, 0)
+
+	_, err = PlanDeployment("unknown", oldRelease, newRelease, processes)
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,212 @@
+package types
+
+import (
+	"reflect"
+	"sort"
+)
+
+// ReleaseDiff describes the differences between two releases.
+type ReleaseDiff struct {
+	Env       MapDiff       `json:"env"`
+	Meta      MapDiff       `json:"meta"`
+	Processes ProcessesDiff `json:"processes"`
+	Artifacts ArtifactsDiff `json:"artifacts"`
+}
+
+// Empty returns whether there are no differences between the releases.
+func (d *ReleaseDiff) Empty() bool {
+	return d.Env.Empty() && d.Meta.Empty() && d.Processes.Empty() && d.Artifacts.Empty()
+}
+
+// MapDiff describes the differences between two string maps, for example
+// release environments.
+type MapDiff struct {
+	Added   map[string]string      `json:"added,omitempty"`
+	Removed map[string]string      `json:"removed,omitempty"`
+	Changed map[string]ValueChange `json:"changed,omitempty"`
+}
+
+// ValueChange is a value which differs between two maps.
+type ValueChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+func (d *MapDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// ProcessesDiff describes the differences between the process types of two
+// releases.
+type ProcessesDiff struct {
+	Added   []string                   `json:"added,omitempty"`
+	Removed []string                   `json:"removed,omitempty"`
+	Changed map[string]ProcessTypeDiff `json:"changed,omitempty"`
+}
+
+func (d *ProcessesDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// ProcessTypeDiff describes the differences between two versions of a
+// process type. Fields contains the JSON names of the fields which differ
+// (other than env, which is described by Env).
+type ProcessTypeDiff struct {
+	Fields []string `json:"fields,omitempty"`
+	Env    MapDiff  `json:"env"`
+}
+
+func (d *ProcessTypeDiff) Empty() bool {
+	return len(d.Fields) == 0 && d.Env.Empty()
+}
+
+// ArtifactsDiff describes the differences between the artifacts of two
+// releases. Reordered is set if both releases have the same artifacts in a
+// different order (which changes the image the release runs).
+type ArtifactsDiff struct {
+	Added     []string `json:"added,omitempty"`
+	Removed   []string `json:"removed,omitempty"`
+	Reordered bool     `json:"reordered,omitempty"`
+}
+
+func (d *ArtifactsDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && !d.Reordered
+}
+
+// DiffReleases returns the changes needed to get from release a to release b.
+// Either release may be nil, in which case it is treated as empty.
+func DiffReleases(a, b *Release) ReleaseDiff {
+	if a == nil {
+		a = &Release{}
+	}
+	if b == nil {
+		b = &Release{}
+	}
+	return ReleaseDiff{
+		Env:       DiffMaps(a.Env, b.Env),
+		Meta:      DiffMaps(a.Meta, b.Meta),
+		Processes: diffProcesses(a.Processes, b.Processes),
+		Artifacts: diffArtifacts(a.ArtifactIDs, b.ArtifactIDs),
+	}
+}
+
+// DiffMaps returns the changes needed to get from map a to map b.
+func DiffMaps(a, b map[string]string) MapDiff {
+	var diff MapDiff
+	for k, v := range a {
+		newV, ok := b[k]
+		if !ok {
+			if diff.Removed == nil {
+				diff.Removed = make(map[string]string)
+			}
+			diff.Removed[k] = v
+		} else if newV != v {
+			if diff.Changed == nil {
+				diff.Changed = make(map[string]ValueChange)
+			}
+			diff.Changed[k] = ValueChange{Old: v, New: newV}
+		}
+	}
+	for k, v := range b {
+		if _, ok := a[k]; !ok {
+			if diff.Added == nil {
+				diff.Added = make(map[string]string)
+			}
+			diff.Added[k] = v
+		}
+	}
+	return diff
+}
+
+func diffProcesses(a, b map[string]ProcessType) ProcessesDiff {
+	var diff ProcessesDiff
+	for typ, proc := range a {
+		newProc, ok := b[typ]
+		if !ok {
+			diff.Removed = append(diff.Removed, typ)
+			continue
+		}
+		if procDiff := DiffProcessTypes(proc, newProc); !procDiff.Empty() {
+			if diff.Changed == nil {
+				diff.Changed = make(map[string]ProcessTypeDiff)
+			}
+			diff.Changed[typ] = procDiff
+		}
+	}
+	for typ := range b {
+		if _, ok := a[typ]; !ok {
+			diff.Added = append(diff.Added, typ)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+// DiffProcessTypes returns the changes needed to get from process type a to
+// process type b.
+func DiffProcessTypes(a, b ProcessType) ProcessTypeDiff {
+	diff := ProcessTypeDiff{Env: DiffMaps(a.Env, b.Env)}
+	fields := []struct {
+		name string
+		a, b interface{}
+	}{
+		{"cmd", a.Cmd, b.Cmd},
+		{"entrypoint", a.Entrypoint, b.Entrypoint},
+		{"ports", a.Ports, b.Ports},
+		{"data", a.Data, b.Data},
+		{"omni", a.Omni, b.Omni},
+		{"host_network", a.HostNetwork, b.HostNetwork},
+		{"service", a.Service, b.Service},
+		{"resurrect", a.Resurrect, b.Resurrect},
+		{"resources", a.Resources, b.Resources},
+	}
+	for _, f := range fields {
+		if !equalValues(f.a, f.b) {
+			diff.Fields = append(diff.Fields, f.name)
+		}
+	}
+	return diff
+}
+
+// equalValues is like reflect.DeepEqual but treats nil and empty slices and
+// maps as equal, as they are equivalent once encoded as JSON.
+func equalValues(a, b interface{}) bool {
+	if isEmpty(a) && isEmpty(b) {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func isEmpty(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map:
+		return rv.Len() == 0
+	}
+	return false
+}
+
+func diffArtifacts(a, b []string) ArtifactsDiff {
+	var diff ArtifactsDiff
+	inA := make(map[string]bool, len(a))
+	for _, id := range a {
+		inA[id] = true
+	}
+	inB := make(map[string]bool, len(b))
+	for _, id := range b {
+		inB[id] = true
+		if !inA[id] {
+			diff.Added = append(diff.Added, id)
+		}
+	}
+	for _, id := range a {
+		if !inB[id] {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+	if len(diff.Added) == 0 && len(diff.Removed) == 0 && !reflect.DeepEqual(a, b) && len(a) > 0 {
+		diff.Reordered = true
+	}
+	return diff
+}
@@ -0,0 +1,95 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/flynn/flynn/host/resource"
+	. "github.com/flynn/go-check"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type S struct{}
+
+var _ = Suite(&S{})
+
+func (S) TestDiffReleasesEmpty(c *C) {
+	release := &Release{
+		ArtifactIDs: []string{"a1", "a2"},
+		Env:         map[string]string{"FOO": "bar"},
+		Processes: map[string]ProcessType{
+			"web": {Cmd: []string{"start", "web"}},
+		},
+	}
+	diff := DiffReleases(release, release)
+	c.Assert(diff.Empty(), Equals, true)
+
+	// nil and empty values are equivalent
+	diff = DiffReleases(&Release{Processes: map[string]ProcessType{"web": {}}}, &Release{
+		Env:       map[string]string{},
+		Processes: map[string]ProcessType{"web": {Cmd: []string{}, Resources: resource.Resources{}}},
+	})
+	c.Assert(diff.Empty(), Equals, true)
+
+	diff = DiffReleases(nil, nil)
+	c.Assert(diff.Empty(), Equals, true)
+}
+
+func (S) TestDiffReleasesEnv(c *C) {
+	a := &Release{Env: map[string]string{"KEEP": "1", "CHANGE": "old", "REMOVE": "x"}}
+	b := &Release{Env: map[string]string{"KEEP": "1", "CHANGE": "new", "ADD": "y"}}
+	diff := DiffReleases(a, b)
+	c.Assert(diff.Empty(), Equals, false)
+	c.Assert(diff.Env.Added, DeepEquals, map[string]string{"ADD": "y"})
+	c.Assert(diff.Env.Removed, DeepEquals, map[string]string{"REMOVE": "x"})
+	c.Assert(diff.Env.Changed, DeepEquals, map[string]ValueChange{"CHANGE": {Old: "old", New: "new"}})
+	c.Assert(diff.Meta.Empty(), Equals, true)
+	c.Assert(diff.Processes.Empty(), Equals, true)
+	c.Assert(diff.Artifacts.Empty(), Equals, true)
+
+	// diffing from nil treats everything as added
+	diff = DiffReleases(nil, b)
+	c.Assert(diff.Env.Added, DeepEquals, b.Env)
+	c.Assert(diff.Env.Removed, IsNil)
+	c.Assert(diff.Env.Changed, IsNil)
+}
+
+func (S) TestDiffReleasesProcesses(c *C) {
+	a := &Release{Processes: map[string]ProcessType{
+		"web":    {Cmd: []string{"web"}, Env: map[string]string{"PORT": "80"}},
+		"worker": {Cmd: []string{"worker"}},
+		"clock":  {Cmd: []string{"clock"}},
+	}}
+	b := &Release{Processes: map[string]ProcessType{
+		"web":    {Cmd: []string{"web", "--verbose"}, Env: map[string]string{"PORT": "8080"}, Omni: true},
+		"worker": {Cmd: []string{"worker"}},
+		"mailer": {Cmd: []string{"mailer"}},
+		"api":    {Cmd: []string{"api"}},
+	}}
+	diff := DiffReleases(a, b)
+	c.Assert(diff.Processes.Added, DeepEquals, []string{"api", "mailer"})
+	c.Assert(diff.Processes.Removed, DeepEquals, []string{"clock"})
+	c.Assert(diff.Processes.Changed, HasLen, 1)
+	web := diff.Processes.Changed["web"]
+	c.Assert(web.Fields, DeepEquals, []string{"cmd", "omni"})
+	c.Assert(web.Env.Changed, DeepEquals, map[string]ValueChange{"PORT": {Old: "80", New: "8080"}})
+}
+
+func (S) TestDiffReleasesArtifacts(c *C) {
+	diff := DiffReleases(
+		&Release{ArtifactIDs: []string{"image", "slug1"}},
+		&Release{ArtifactIDs: []string{"image", "slug2"}},
+	)
+	c.Assert(diff.Artifacts.Added, DeepEquals, []string{"slug2"})
+	c.Assert(diff.Artifacts.Removed, DeepEquals, []string{"slug1"})
+	c.Assert(diff.Artifacts.Reordered, Equals, false)
+
+	diff = DiffReleases(
+		&Release{ArtifactIDs: []string{"a", "b"}},
+		&Release{ArtifactIDs: []string{"b", "a"}},
+	)
+	c.Assert(diff.Artifacts.Added, IsNil)
+	c.Assert(diff.Artifacts.Removed, IsNil)
+	c.Assert(diff.Artifacts.Reordered, Equals, true)
+	c.Assert(diff.Empty(), Equals, false)
+}
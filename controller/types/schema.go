@@ -0,0 +1,123 @@
+package types
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// jsonSchemaer is implemented by types with custom JSON encodings which
+// provide their own JSON Schema.
+type jsonSchemaer interface {
+	JSONSchema() map[string]interface{}
+}
+
+var (
+	timeType         = reflect.TypeOf(time.Time{})
+	jsonSchemaerType = reflect.TypeOf((*jsonSchemaer)(nil)).Elem()
+)
+
+// ReleaseSchema returns a JSON Schema (draft 4) for releases, generated
+// from the JSON struct tags of Release and the types it contains so that
+// it stays in sync with them. String fields with an enum struct tag (e.g.
+// `enum:"tcp,udp"`) are restricted to the listed values.
+//
+// Env values can also be references to app secrets of the form
+// {"secretRef": "<name>"}, which are moved to secret_env when release
+// configuration is decoded.
+func ReleaseSchema() map[string]interface{} {
+	schema := jsonSchema(reflect.TypeOf(Release{}))
+	env := schema["properties"].(map[string]interface{})["env"].(map[string]interface{})
+	env["additionalProperties"] = map[string]interface{}{
+		"oneOf": []interface{}{
+			env["additionalProperties"],
+			map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"secretRef": map[string]interface{}{"type": "string", "minLength": 1},
+				},
+				"required":             []string{"secretRef"},
+				"additionalProperties": false,
+			},
+		},
+	}
+	schema["$schema"] = "http://json-schema.org/draft-04/schema#"
+	schema["title"] = "Release"
+	return schema
+}
+
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	if t.Implements(jsonSchemaerType) {
+		return reflect.Zero(t).Interface().(jsonSchemaer).JSONSchema()
+	}
+	if t.Kind() == reflect.Ptr {
+		return jsonSchema(t.Elem())
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return structSchema(t)
+	case reflect.Map:
+		// the empty properties don't change what is valid, but make
+		// validators report errors in values with the path of their key
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           map[string]interface{}{},
+			"additionalProperties": jsonSchema(t.Elem()),
+		}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": jsonSchema(t.Elem()),
+		}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			for k, v := range structSchema(embedded)["properties"].(map[string]interface{}) {
+				properties[k] = v
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema := jsonSchema(field.Type)
+		if enum := field.Tag.Get("enum"); enum != "" {
+			schema["enum"] = strings.Split(enum, ",")
+		}
+		properties[name] = schema
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
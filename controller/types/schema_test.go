@@ -0,0 +1,56 @@
+package types
+
+import (
+	"github.com/flynn/flynn/host/resource"
+	. "github.com/flynn/go-check"
+)
+
+func (S) TestReleaseSchema(c *C) {
+	schema := ReleaseSchema()
+	c.Assert(schema["$schema"], Equals, "http://json-schema.org/draft-04/schema#")
+	c.Assert(schema["type"], Equals, "object")
+	c.Assert(schema["additionalProperties"], Equals, false)
+
+	// properties are named after the JSON struct tags
+	properties := schema["properties"].(map[string]interface{})
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	c.Assert(names, HasLen, 8)
+	for _, name := range []string{"id", "artifacts", "env", "meta", "processes", "created_at", "secret_env", "artifact"} {
+		c.Assert(properties[name], NotNil, Commentf("missing property %s", name))
+	}
+	c.Assert(properties["created_at"], DeepEquals, map[string]interface{}{"type": "string", "format": "date-time"})
+	c.Assert(properties["secret_env"], DeepEquals, map[string]interface{}{
+		"type":                 "object",
+		"properties":           map[string]interface{}{},
+		"additionalProperties": map[string]interface{}{"type": "string"},
+	})
+
+	// env values are either strings or references to app secrets
+	env := properties["env"].(map[string]interface{})
+	c.Assert(env["type"], Equals, "object")
+	oneOf := env["additionalProperties"].(map[string]interface{})["oneOf"].([]interface{})
+	c.Assert(oneOf, HasLen, 2)
+	c.Assert(oneOf[0], DeepEquals, map[string]interface{}{"type": "string"})
+	c.Assert(oneOf[1].(map[string]interface{})["required"], DeepEquals, []string{"secretRef"})
+
+	proc := properties["processes"].(map[string]interface{})["additionalProperties"].(map[string]interface{})
+	procProperties := proc["properties"].(map[string]interface{})
+	c.Assert(procProperties["omni"], DeepEquals, map[string]interface{}{"type": "boolean"})
+
+	// port protocols are restricted by the enum struct tag
+	port := procProperties["ports"].(map[string]interface{})["items"].(map[string]interface{})
+	proto := port["properties"].(map[string]interface{})["proto"].(map[string]interface{})
+	c.Assert(proto["enum"], DeepEquals, []string{"tcp", "udp"})
+
+	// resources only allow the known resource types
+	resources := procProperties["resources"].(map[string]interface{})
+	c.Assert(resources["additionalProperties"], Equals, false)
+	resourceProperties := resources["properties"].(map[string]interface{})
+	c.Assert(resourceProperties, HasLen, len(resource.Types))
+	for _, typ := range resource.Types {
+		c.Assert(resourceProperties[string(typ)], NotNil)
+	}
+}
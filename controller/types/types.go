@@ -54,11 +54,34 @@ type Release struct {
 	Processes   map[string]ProcessType `json:"processes,omitempty"`
 	CreatedAt   *time.Time             `json:"created_at,omitempty"`
 
+	// SecretEnv maps env vars to the names of app secrets, whose values
+	// are added to the env of the release's jobs when they are started so
+	// that they aren't stored in the release itself
+	SecretEnv map[string]string `json:"secret_env,omitempty"`
+
 	// LegacyArtifactID is to support old clients which expect releases
 	// to have a single ArtifactID
 	LegacyArtifactID string `json:"artifact,omitempty"`
 }
 
+// Secret is a named value stored for an app which releases can refer to in
+// their SecretEnv. The value is only ever sent to the controller, it is not
+// included when listing secrets.
+type Secret struct {
+	Name      string     `json:"name,omitempty"`
+	Value     string     `json:"value,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// ReleaseSummary contains summary statistics of an app's releases.
+type ReleaseSummary struct {
+	Count            int        `json:"count"`
+	CurrentReleaseID string     `json:"current_release,omitempty"`
+	OldestCreatedAt  *time.Time `json:"oldest_created_at,omitempty"`
+	NewestCreatedAt  *time.Time `json:"newest_created_at,omitempty"`
+}
+
 func (r *Release) ImageArtifactID() string {
 	if len(r.ArtifactIDs) > 0 {
 		return r.ArtifactIDs[0]
@@ -103,7 +126,7 @@ type ProcessType struct {
 
 type Port struct {
 	Port    int           `json:"port"`
-	Proto   string        `json:"proto"`
+	Proto   string        `json:"proto" enum:"tcp,udp"`
 	Service *host.Service `json:"service,omitempty"`
 }
 
@@ -340,26 +363,27 @@ type LogOpts struct {
 type EventType string
 
 const (
-	EventTypeApp                  EventType = "app"
-	EventTypeAppDeletion          EventType = "app_deletion"
-	EventTypeAppRelease           EventType = "app_release"
-	EventTypeDeployment           EventType = "deployment"
-	EventTypeJob                  EventType = "job"
-	EventTypeScale                EventType = "scale"
-	EventTypeRelease              EventType = "release"
-	EventTypeReleaseDeletion      EventType = "release_deletion"
-	EventTypeArtifact             EventType = "artifact"
-	EventTypeProvider             EventType = "provider"
-	EventTypeResource             EventType = "resource"
-	EventTypeResourceDeletion     EventType = "resource_deletion"
-	EventTypeResourceAppDeletion  EventType = "resource_app_deletion"
-	EventTypeKey                  EventType = "key"
-	EventTypeKeyDeletion          EventType = "key_deletion"
-	EventTypeRoute                EventType = "route"
-	EventTypeRouteDeletion        EventType = "route_deletion"
-	EventTypeDomainMigration      EventType = "domain_migration"
-	EventTypeClusterBackup        EventType = "cluster_backup"
-	EventTypeAppGarbageCollection EventType = "app_garbage_collection"
+	EventTypeApp                     EventType = "app"
+	EventTypeAppDeletion             EventType = "app_deletion"
+	EventTypeAppRelease              EventType = "app_release"
+	EventTypeDeployment              EventType = "deployment"
+	EventTypeJob                     EventType = "job"
+	EventTypeScale                   EventType = "scale"
+	EventTypeRelease                 EventType = "release"
+	EventTypeReleaseDeletion         EventType = "release_deletion"
+	EventTypeReleaseDeletionProgress EventType = "release_deletion_progress"
+	EventTypeArtifact                EventType = "artifact"
+	EventTypeProvider                EventType = "provider"
+	EventTypeResource                EventType = "resource"
+	EventTypeResourceDeletion        EventType = "resource_deletion"
+	EventTypeResourceAppDeletion     EventType = "resource_app_deletion"
+	EventTypeKey                     EventType = "key"
+	EventTypeKeyDeletion             EventType = "key_deletion"
+	EventTypeRoute                   EventType = "route"
+	EventTypeRouteDeletion           EventType = "route_deletion"
+	EventTypeDomainMigration         EventType = "domain_migration"
+	EventTypeClusterBackup           EventType = "cluster_backup"
+	EventTypeAppGarbageCollection    EventType = "app_garbage_collection"
 )
 
 type Event struct {
@@ -422,6 +446,10 @@ type ReleaseDeletion struct {
 	ReleaseID     string   `json:"release"`
 	RemainingApps []string `json:"remaining_apps"`
 	DeletedFiles  []string `json:"deleted_files"`
+
+	// TotalFiles is the number of files of the release to delete, which
+	// is more than the number of DeletedFiles if deleting them failed.
+	TotalFiles int `json:"total_files,omitempty"`
 }
 
 type ReleaseDeletionEvent struct {
@@ -429,6 +457,29 @@ type ReleaseDeletionEvent struct {
 	Error           string           `json:"error"`
 }
 
+// ReleaseDeletionError is returned when deleting the files of a release
+// fails partway, with the files deleted so far in Deletion. The remaining
+// files are retried in the background, and deleting the release again
+// retries them immediately.
+type ReleaseDeletionError struct {
+	Deletion *ReleaseDeletion
+	Err      string
+}
+
+func (e *ReleaseDeletionError) Error() string {
+	return fmt.Sprintf("%s (deleted %d of %d files)", e.Err, len(e.Deletion.DeletedFiles), e.Deletion.TotalFiles)
+}
+
+// ReleaseDeletionProgress is emitted as each file of a deleted release is
+// deleted, before the final release deletion event.
+type ReleaseDeletionProgress struct {
+	AppID     string `json:"app"`
+	ReleaseID string `json:"release"`
+	File      string `json:"file"`
+	Deleted   int    `json:"deleted"`
+	Total     int    `json:"total"`
+}
+
 type JobWatcher interface {
 	WaitFor(expected JobEvents, timeout time.Duration, callback func(*Job) error) error
 	Close() error
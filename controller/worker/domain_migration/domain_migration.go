@@ -156,6 +156,7 @@ func dupRelease(release *ct.Release) *ct.Release {
 	return &ct.Release{
 		ArtifactIDs: release.ArtifactIDs,
 		Env:         release.Env,
+		SecretEnv:   release.SecretEnv,
 		Meta:        release.Meta,
 		Processes:   release.Processes,
 	}
@@ -233,7 +234,7 @@ func (m *migration) maybeDeployController() error {
 		log.Error("error creating release", "error", err)
 		return err
 	}
-	if err := m.client.DeployAppRelease(appName, release.ID, deployTimeout()); err != nil {
+	if _, err := m.client.DeployAppRelease(appName, release.ID, deployTimeout()); err != nil {
 		log.Error("error deploying release", "error", err)
 		return err
 	}
@@ -262,7 +263,7 @@ func (m *migration) maybeDeployRouter() error {
 		log.Error("error creating release", "error", err)
 		return err
 	}
-	if err := m.client.DeployAppRelease(appName, release.ID, deployTimeout()); err != nil {
+	if _, err := m.client.DeployAppRelease(appName, release.ID, deployTimeout()); err != nil {
 		log.Error("error deploying release", "error", err)
 		return err
 	}
@@ -293,7 +294,7 @@ func (m *migration) maybeDeployDashboard() error {
 		log.Error("error creating release", "error", err)
 		return err
 	}
-	if err := m.client.DeployAppRelease(appName, release.ID, deployTimeout()); err != nil {
+	if _, err := m.client.DeployAppRelease(appName, release.ID, deployTimeout()); err != nil {
 		log.Error("error deploying release", "error", err)
 		return err
 	}
@@ -37,16 +37,45 @@ func (c *context) HandleReleaseCleanup(job *que.Job) (err error) {
 	}
 	log = log.New("release_id", data.ReleaseID)
 
-	r := ct.ReleaseDeletion{AppID: data.AppID, ReleaseID: data.ReleaseID}
+	r := ct.ReleaseDeletion{AppID: data.AppID, ReleaseID: data.ReleaseID, TotalFiles: len(data.FileURIs)}
 	defer func() { c.createEvent(&r, err) }()
 
+	// skip files deleted by previous attempts, so that a retry after a
+	// partial failure only deletes the remainder
+	deleted, err := c.deletedFiles(data.ReleaseID)
+	if err != nil {
+		log.Error("error getting previously deleted files", "err", err)
+		return err
+	}
 	for _, uri := range data.FileURIs {
+		if _, ok := deleted[uri]; ok {
+			r.DeletedFiles = append(r.DeletedFiles, uri)
+		}
+	}
+	if len(r.DeletedFiles) > 0 {
+		log.Info("resuming release file deletion", "deleted", len(r.DeletedFiles), "total", len(data.FileURIs))
+	}
+
+	for _, uri := range data.FileURIs {
+		if _, ok := deleted[uri]; ok {
+			continue
+		}
 		log.Info("deleting file", "uri", uri)
 		if err := deleteFile(uri); err != nil {
 			log.Error("error deleting file", "err", err)
 			return err
 		}
 		r.DeletedFiles = append(r.DeletedFiles, uri)
+		if err := c.createProgressEvent(&ct.ReleaseDeletionProgress{
+			AppID:     data.AppID,
+			ReleaseID: data.ReleaseID,
+			File:      uri,
+			Deleted:   len(r.DeletedFiles),
+			Total:     len(data.FileURIs),
+		}); err != nil {
+			// progress is informational, so don't fail the cleanup
+			log.Error("error creating release deletion progress event", "err", err)
+		}
 	}
 	log.Info(fmt.Sprintf("deleted %d files", len(r.DeletedFiles)))
 
@@ -69,6 +98,29 @@ func deleteFile(uri string) error {
 	return nil
 }
 
+// deletedFiles returns the files of the release which progress events
+// record as deleted.
+func (c *context) deletedFiles(releaseID string) (map[string]struct{}, error) {
+	rows, err := c.db.Query("event_release_deletion_files", releaseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	files := make(map[string]struct{})
+	for rows.Next() {
+		var uri string
+		if err := rows.Scan(&uri); err != nil {
+			return nil, err
+		}
+		files[uri] = struct{}{}
+	}
+	return files, rows.Err()
+}
+
+func (c *context) createProgressEvent(p *ct.ReleaseDeletionProgress) error {
+	return c.db.Exec("event_insert", p.AppID, p.ReleaseID, string(ct.EventTypeReleaseDeletionProgress), p)
+}
+
 func (c *context) createEvent(r *ct.ReleaseDeletion, err error) error {
 	e := ct.ReleaseDeletionEvent{ReleaseDeletion: r}
 	if err != nil {
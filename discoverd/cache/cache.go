@@ -18,9 +18,19 @@ type ServiceCache interface {
 }
 
 func New(s discoverd.Service) (ServiceCache, error) {
+	return NewWithRemoveFunc(s, nil)
+}
+
+// NewWithRemoveFunc is like New but also calls onRemove in a new goroutine
+// with the address of each instance which is removed from Addrs, either
+// because it has started draining (see discoverd.DrainingMetaKey) or because
+// it has gone down without draining first.
+func NewWithRemoveFunc(s discoverd.Service, onRemove func(addr string)) (ServiceCache, error) {
 	d := &serviceCache{
-		addrs: make(map[string]struct{}),
-		stop:  make(chan struct{}),
+		addrs:    make(map[string]struct{}),
+		draining: make(map[string]struct{}),
+		stop:     make(chan struct{}),
+		onRemove: onRemove,
 	}
 	return d, d.start(s)
 }
@@ -32,6 +42,12 @@ type serviceCache struct {
 	leaderAddr string
 	addrs      map[string]struct{}
 
+	// draining are the addresses of instances which are still registered
+	// but shouldn't be sent new requests
+	draining map[string]struct{}
+
+	onRemove func(addr string)
+
 	// used by the test suite
 	watchers map[chan *discoverd.Event]struct{}
 
@@ -74,13 +90,31 @@ func (d *serviceCache) start(s discoverd.Service) (err error) {
 
 				switch event.Kind {
 				case discoverd.EventKindUp, discoverd.EventKindUpdate:
+					addr := event.Instance.Addr
 					d.Lock()
-					d.addrs[event.Instance.Addr] = struct{}{}
+					_, removed := d.addrs[addr]
+					if event.Instance.Meta[discoverd.DrainingMetaKey] == "true" {
+						delete(d.addrs, addr)
+						d.draining[addr] = struct{}{}
+					} else {
+						d.addrs[addr] = struct{}{}
+						delete(d.draining, addr)
+						removed = false
+					}
 					d.Unlock()
+					if removed {
+						d.removed(addr)
+					}
 				case discoverd.EventKindDown:
+					addr := event.Instance.Addr
 					d.Lock()
-					delete(d.addrs, event.Instance.Addr)
+					_, removed := d.addrs[addr]
+					delete(d.addrs, addr)
+					delete(d.draining, addr)
 					d.Unlock()
+					if removed {
+						d.removed(addr)
+					}
 				case discoverd.EventKindLeader:
 					d.Lock()
 					if event.Instance != nil {
@@ -99,6 +133,12 @@ func (d *serviceCache) start(s discoverd.Service) (err error) {
 	return <-current
 }
 
+func (d *serviceCache) removed(addr string) {
+	if d.onRemove != nil {
+		go d.onRemove(addr)
+	}
+}
+
 func (d *serviceCache) Close() error {
 	close(d.stop)
 	return d.stream.Close()
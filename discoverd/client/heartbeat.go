@@ -21,6 +21,11 @@ var EnvInstanceMeta = map[string]struct{}{
 	"FLYNN_JOB_ID":       {},
 }
 
+// DrainingMetaKey is set to "true" in the metadata of instances which are
+// about to be stopped, so that clients stop sending them new requests whilst
+// letting in-flight ones finish.
+const DrainingMetaKey = "FLYNN_DRAINING"
+
 type Heartbeater interface {
 	SetMeta(map[string]string) error
 	Close() error
@@ -175,6 +175,7 @@ Options:
 	release := &ct.Release{
 		ArtifactIDs: []string{artifact.ID, slugArtifact.ID},
 		Env:         prevRelease.Env,
+		SecretEnv:   prevRelease.SecretEnv,
 		Meta:        prevRelease.Meta,
 	}
 	if release.Meta == nil {
@@ -212,7 +213,7 @@ Options:
 	if err := client.CreateRelease(release); err != nil {
 		return fmt.Errorf("Error creating release: %s", err)
 	}
-	if err := client.DeployAppRelease(app.Name, release.ID, nil); err != nil {
+	if _, err := client.DeployAppRelease(app.Name, release.ID, nil); err != nil {
 		return fmt.Errorf("Error deploying app release: %s", err)
 	}
 
@@ -163,6 +163,18 @@ func (c *Client) Signal(signal int) error {
 	return err
 }
 
+// Drain marks the container's service instances as draining so that clients
+// stop sending them new requests whilst in-flight ones finish.
+func (c *Client) Drain() error {
+	return c.c.Call("ContainerInit.Drain", struct{}{}, &struct{}{})
+}
+
+// Deregister removes the container's services from discoverd so that it stops
+// receiving new requests before it is stopped.
+func (c *Client) Deregister() error {
+	return c.c.Call("ContainerInit.Deregister", struct{}{}, &struct{}{})
+}
+
 func newContainerInit(c *Config, logFile *os.File) *ContainerInit {
 	return &ContainerInit{
 		resume:    make(chan struct{}),
@@ -186,6 +198,9 @@ type ContainerInit struct {
 	ptyMaster  *os.File
 	openStdin  bool
 
+	heartbeaters []discoverd.Heartbeater
+	instanceMeta map[string]string
+
 	streams    map[chan StateChange]struct{}
 	streamsMtx sync.RWMutex
 }
@@ -211,6 +226,34 @@ func (c *ContainerInit) Signal(sig int, res *struct{}) error {
 	return nil
 }
 
+func (c *ContainerInit) Drain(arg, res *struct{}) error {
+	c.mtx.Lock()
+	hbs := c.heartbeaters
+	meta := make(map[string]string, len(c.instanceMeta)+1)
+	for k, v := range c.instanceMeta {
+		meta[k] = v
+	}
+	c.mtx.Unlock()
+	meta[discoverd.DrainingMetaKey] = "true"
+	for _, hb := range hbs {
+		if err := hb.SetMeta(meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ContainerInit) Deregister(arg, res *struct{}) error {
+	c.mtx.Lock()
+	hbs := c.heartbeaters
+	c.heartbeaters = nil
+	c.mtx.Unlock()
+	for _, hb := range hbs {
+		hb.Close()
+	}
+	return nil
+}
+
 func (c *ContainerInit) GetPtyMaster(arg struct{}, fd *fdrpc.FD) error {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
@@ -398,6 +441,22 @@ func getCmdPath(c *Config) (string, error) {
 	return cmdPath, nil
 }
 
+// instanceMeta returns the metadata of the container's service instances,
+// which is the discoverd.EnvInstanceMeta present in env.
+func instanceMeta(env map[string]string) map[string]string {
+	var meta map[string]string
+	for k, v := range env {
+		if _, ok := discoverd.EnvInstanceMeta[k]; !ok {
+			continue
+		}
+		if meta == nil {
+			meta = make(map[string]string)
+		}
+		meta[k] = v
+	}
+	return meta
+}
+
 func monitor(port host.Port, container *ContainerInit, env map[string]string, log log15.Logger) (discoverd.Heartbeater, error) {
 	config := port.Service
 	client := discoverd.NewClientWithURL(env["DISCOVERD"])
@@ -416,16 +475,7 @@ func monitor(port host.Port, container *ContainerInit, env map[string]string, lo
 	inst := &discoverd.Instance{
 		Addr:  fmt.Sprintf("%s:%v", env["EXTERNAL_IP"], port.Port),
 		Proto: port.Proto,
-	}
-	// add discoverd.EnvInstanceMeta if present
-	for k, v := range env {
-		if _, ok := discoverd.EnvInstanceMeta[k]; !ok {
-			continue
-		}
-		if inst.Meta == nil {
-			inst.Meta = make(map[string]string)
-		}
-		inst.Meta[k] = v
+		Meta:  instanceMeta(env),
 	}
 
 	// no checker, but we still want to register a service
@@ -691,10 +741,14 @@ func containerInitApp(c *Config, logFile *os.File) error {
 		}
 		hbs = append(hbs, hb)
 	}
+	init.mtx.Lock()
+	init.heartbeaters = hbs
+	init.instanceMeta = instanceMeta(c.Env)
+	init.mtx.Unlock()
 	exitCode := babySit(init.process)
 	log.Info("command exited", "status", exitCode)
 	init.mtx.Lock()
-	for _, hb := range hbs {
+	for _, hb := range init.heartbeaters {
 		hb.Close()
 	}
 	init.changeState(StateExited, "", exitCode)
@@ -13,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/flynn/flynn/bootstrap/discovery"
 	"github.com/flynn/flynn/host/cli"
@@ -55,6 +56,7 @@ options:
   --no-resurrect             disable cluster resurrection
   --max-job-concurrency=NUM  maximum number of jobs to start concurrently
   --partitions=PARTITIONS    specify resource partitions for host [default: system=cpu_shares:4096 background=cpu_shares:4096 user=cpu_shares:8192]
+  --drain-period=DURATION    how long to leave drained jobs running with their services marked as draining [default: 5s]
 	`)
 }
 
@@ -208,6 +210,11 @@ func runDaemon(args *docopt.Args) {
 		}
 	}
 
+	drainPeriod, err := time.ParseDuration(args.String["--drain-period"])
+	if err != nil || drainPeriod < 0 {
+		shutdown.Fatalf("invalid drain period: %q", args.String["--drain-period"])
+	}
+
 	log := logger.New("fn", "runDaemon", "host.id", hostID)
 	log.Info("starting daemon")
 
@@ -288,7 +295,7 @@ func runDaemon(args *docopt.Args) {
 	var backend Backend
 	switch backendName {
 	case "libcontainer":
-		backend, err = NewLibcontainerBackend(state, vman, bridgeName, flynnInit, mux, partitionCGroups, logger.New("host.id", hostID, "component", "backend", "backend", "libcontainer"))
+		backend, err = NewLibcontainerBackend(state, vman, bridgeName, flynnInit, mux, partitionCGroups, drainPeriod, logger.New("host.id", hostID, "component", "backend", "backend", "libcontainer"))
 	case "mock":
 		backend = MockBackend{}
 	default:
@@ -55,8 +55,20 @@ type Host struct {
 
 var ErrNotFound = errors.New("host: unknown job")
 
+// StopJob stops a job, waiting for it to exit.
 func (h *Host) StopJob(id string) error {
-	log := h.log.New("fn", "StopJob", "job.id", id)
+	return h.stopJob(id, false)
+}
+
+// DrainJob stops a job once its services have been drained (see
+// Container.Drain), returning without waiting for it to exit. It is used for
+// deploy-driven stops so that in-flight requests are not cut off.
+func (h *Host) DrainJob(id string) error {
+	return h.stopJob(id, true)
+}
+
+func (h *Host) stopJob(id string, drain bool) error {
+	log := h.log.New("fn", "stopJob", "job.id", id, "drain", drain)
 
 	log.Info("acquiring state database")
 	if err := h.state.Acquire(); err != nil {
@@ -84,6 +96,9 @@ func (h *Host) StopJob(id string) error {
 		return nil
 	case host.StatusRunning:
 		log.Info("stopping job")
+		if drain {
+			return h.backend.Drain(id)
+		}
 		return h.backend.Stop(id)
 	default:
 		log.Warn("job already stopped")
@@ -151,7 +166,11 @@ func (h *jobAPI) GetJob(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 
 func (h *jobAPI) StopJob(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	id := ps.ByName("id")
-	if err := h.host.StopJob(id); err != nil {
+	stop := h.host.StopJob
+	if r.URL.Query().Get("drain") == "true" {
+		stop = h.host.DrainJob
+	}
+	if err := stop(id); err != nil {
 		httphelper.Error(w, err)
 		return
 	}
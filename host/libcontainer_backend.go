@@ -71,7 +71,7 @@ var defaultCapabilities = []string{
 	"CAP_SYS_CHROOT",
 }
 
-func NewLibcontainerBackend(state *State, vman *volumemanager.Manager, bridgeName, initPath string, mux *logmux.Mux, partitionCGroups map[string]int64, logger log15.Logger) (Backend, error) {
+func NewLibcontainerBackend(state *State, vman *volumemanager.Manager, bridgeName, initPath string, mux *logmux.Mux, partitionCGroups map[string]int64, drainPeriod time.Duration, logger log15.Logger) (Backend, error) {
 	factory, err := libcontainer.New(
 		containerRoot,
 		libcontainer.Cgroupfs,
@@ -103,6 +103,7 @@ func NewLibcontainerBackend(state *State, vman *volumemanager.Manager, bridgeNam
 		discoverdConfigured: make(chan struct{}),
 		networkConfigured:   make(chan struct{}),
 		partitionCGroups:    partitionCGroups,
+		drainPeriod:         drainPeriod,
 		logger:              logger,
 	}, nil
 }
@@ -136,6 +137,10 @@ type LibcontainerBackend struct {
 
 	partitionCGroups map[string]int64 // name -> cpu shares
 
+	// drainPeriod is how long jobs with services are left running with
+	// their service instances marked as draining before being stopped
+	drainPeriod time.Duration
+
 	logger log15.Logger
 }
 
@@ -149,6 +154,9 @@ type Container struct {
 	l         *LibcontainerBackend
 	done      chan struct{}
 	*containerinit.Client
+
+	drainMtx sync.Mutex
+	draining bool
 }
 
 type dockerImageConfig struct {
@@ -918,6 +926,47 @@ func (c *Container) WaitStop(timeout time.Duration) error {
 	}
 }
 
+// Drain marks the job's service instances as draining so routers stop
+// sending them new requests, then stops the job in the background once the
+// backend's drain period has passed, giving in-flight requests time to
+// finish. Jobs without services, or with no drain period configured, are
+// stopped immediately, as are jobs which are already draining so that a
+// repeated stop request does not have to wait out the drain period.
+func (c *Container) Drain() error {
+	if c.l.drainPeriod == 0 || !c.hasServices() {
+		return c.Stop()
+	}
+	c.drainMtx.Lock()
+	draining := c.draining
+	c.draining = true
+	c.drainMtx.Unlock()
+	if draining {
+		return c.Stop()
+	}
+
+	log := c.l.logger.New("fn", "Drain", "job.id", c.job.ID)
+	if err := c.Client.Drain(); err != nil {
+		log.Error("error draining services", "err", err)
+		// stop the job straight away rather than leave it running
+		return c.Stop()
+	}
+	log.Info("draining services", "period", c.l.drainPeriod)
+	go func() {
+		select {
+		case <-time.After(c.l.drainPeriod):
+		case <-c.done:
+			return
+		}
+		if err := c.Deregister(); err != nil {
+			log.Error("error deregistering services", "err", err)
+		}
+		if err := c.Stop(); err != nil && err != rpcplus.ErrShutdown {
+			log.Error("error stopping job", "err", err)
+		}
+	}()
+	return nil
+}
+
 func (c *Container) Stop() error {
 	if err := c.Signal(int(syscall.SIGTERM)); err != nil {
 		return err
@@ -928,6 +977,15 @@ func (c *Container) Stop() error {
 	return nil
 }
 
+func (c *Container) hasServices() bool {
+	for _, port := range c.job.Config.Ports {
+		if port.Service != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (l *LibcontainerBackend) Stop(id string) error {
 	c, err := l.getContainer(id)
 	if err != nil {
@@ -941,6 +999,20 @@ func (l *LibcontainerBackend) Stop(id string) error {
 	return err
 }
 
+// Drain stops a job once its services have been drained (see
+// Container.Drain), returning without waiting for it to stop.
+func (l *LibcontainerBackend) Drain(id string) error {
+	c, err := l.getContainer(id)
+	if err != nil {
+		return err
+	}
+	err = c.Drain()
+	if err == rpcplus.ErrShutdown {
+		err = nil
+	}
+	return err
+}
+
 func (l *LibcontainerBackend) JobExists(id string) bool {
 	l.containersMtx.RLock()
 	defer l.containersMtx.RUnlock()
@@ -1,8 +1,10 @@
 package resource
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/docker/go-units"
 	"github.com/flynn/flynn/pkg/typeconv"
@@ -42,6 +44,9 @@ const (
 	TypeMaxProcs Type = "max_procs"
 )
 
+// Types are all the types of resource which can be set for a job.
+var Types = []Type{TypeMemory, TypeCPU, TypeMaxFD, TypeMaxProcs}
+
 var defaults = Resources{
 	TypeMemory: {Request: typeconv.Int64Ptr(1 * units.GiB), Limit: typeconv.Int64Ptr(1 * units.GiB)},
 	TypeCPU:    {Limit: typeconv.Int64Ptr(1000)}, // results in Linux default of 1024 shares
@@ -81,15 +86,101 @@ func ToType(s string) (Type, bool) {
 	return Type(""), false
 }
 
+// ParseLimit parses a human readable resource value. Memory sizes use binary
+// units with an optional "i" (e.g. "512MB" and "512MiB" are both 512 * 1024 *
+// 1024 bytes), CPU accepts an optional "m" milliCPU suffix (e.g. "250m") and
+// other types use decimal units (e.g. "10k" is 10000).
 func ParseLimit(typ Type, s string) (int64, error) {
 	switch typ {
 	case TypeMemory:
+		if n := len(s); n > 2 && (s[n-2:] == "iB" || s[n-2:] == "ib") {
+			s = s[:n-2] + "B"
+		}
 		return units.RAMInBytes(s)
+	case TypeCPU:
+		if strings.HasSuffix(s, "m") {
+			return strconv.ParseInt(strings.TrimSuffix(s, "m"), 10, 64)
+		}
+		return units.FromHumanSize(s)
 	default:
 		return units.FromHumanSize(s)
 	}
 }
 
+// UnmarshalJSON decodes resources, accepting human readable strings (see
+// ParseLimit) for requests and limits as well as numbers, so that for
+// example {"memory": {"limit": "512MB"}} can be used in release configs.
+func (r *Resources) UnmarshalJSON(data []byte) error {
+	var raw map[Type]struct {
+		Request json.RawMessage `json:"request"`
+		Limit   json.RawMessage `json:"limit"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		*r = nil
+		return nil
+	}
+	res := make(Resources, len(raw))
+	for typ, v := range raw {
+		var spec Spec
+		var err error
+		if spec.Request, err = parseJSONValue(typ, v.Request); err != nil {
+			return err
+		}
+		if spec.Limit, err = parseJSONValue(typ, v.Limit); err != nil {
+			return err
+		}
+		res[typ] = spec
+	}
+	*r = res
+	return nil
+}
+
+// JSONSchema returns a JSON Schema for resources, which only allows the
+// known resource types as keys, with requests and limits which are either
+// numbers or human readable strings.
+func (Resources) JSONSchema() map[string]interface{} {
+	value := map[string]interface{}{"type": []string{"integer", "string", "null"}}
+	spec := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"request": value,
+			"limit":   value,
+		},
+		"additionalProperties": false,
+	}
+	properties := make(map[string]interface{}, len(Types))
+	for _, typ := range Types {
+		properties[string(typ)] = spec
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+func parseJSONValue(typ Type, data json.RawMessage) (*int64, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	var n int64
+	if err := json.Unmarshal(data, &n); err == nil {
+		return &n, nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("resource: invalid %s value %s", typ, data)
+	}
+	n, err := ParseLimit(typ, s)
+	if err != nil {
+		return nil, fmt.Errorf("resource: invalid %s value %q: %s", typ, s, err)
+	}
+	return &n, nil
+}
+
 func FormatLimit(typ Type, limit int64) string {
 	switch typ {
 	case TypeMemory:
@@ -1,6 +1,7 @@
 package resource
 
 import (
+	"encoding/json"
 	"reflect"
 	"testing"
 
@@ -52,3 +53,56 @@ func (S) TestSetDefaultsRequest(c *C) {
 	}
 	c.Assert(*mem.Request, Equals, *mem.Limit)
 }
+
+func (S) TestParseLimit(c *C) {
+	for _, t := range []struct {
+		typ      Type
+		val      string
+		expected int64
+	}{
+		// memory uses binary units, with or without the "i"
+		{TypeMemory, "512MB", 512 * units.MiB},
+		{TypeMemory, "512MiB", 512 * units.MiB},
+		{TypeMemory, "1.5GB", 1536 * units.MiB},
+		{TypeMemory, "1.5GiB", 1536 * units.MiB},
+		{TypeMemory, "1024", 1024},
+
+		// other types use decimal units
+		{TypeMaxFD, "10k", 10000},
+		{TypeMaxFD, "1.5k", 1500},
+		{TypeMaxProcs, "100", 100},
+
+		// cpu is in milliCPU with an optional "m" suffix
+		{TypeCPU, "250m", 250},
+		{TypeCPU, "1000", 1000},
+	} {
+		actual, err := ParseLimit(t.typ, t.val)
+		c.Assert(err, IsNil, Commentf("%s=%s", t.typ, t.val))
+		c.Assert(actual, Equals, t.expected, Commentf("%s=%s", t.typ, t.val))
+	}
+
+	_, err := ParseLimit(TypeCPU, "1.5m")
+	c.Assert(err, NotNil)
+	_, err = ParseLimit(TypeMemory, "lots")
+	c.Assert(err, NotNil)
+}
+
+func (S) TestUnmarshalJSON(c *C) {
+	var r Resources
+	data := `{"memory":{"limit":"512MB","request":268435456},"cpu":{"limit":"250m"},"max_fd":{"limit":"10k"}}`
+	c.Assert(json.Unmarshal([]byte(data), &r), IsNil)
+	c.Assert(r, DeepEquals, Resources{
+		TypeMemory: {Request: typeconv.Int64Ptr(256 * units.MiB), Limit: typeconv.Int64Ptr(512 * units.MiB)},
+		TypeCPU:    {Limit: typeconv.Int64Ptr(250)},
+		TypeMaxFD:  {Limit: typeconv.Int64Ptr(10000)},
+	})
+
+	// values are normalized to numbers when encoded
+	out, err := json.Marshal(r[TypeMemory])
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, `{"request":268435456,"limit":536870912}`)
+	c.Assert(r[TypeMemory].Limit, NotNil)
+	c.Assert(FormatLimit(TypeMemory, *r[TypeMemory].Limit), Equals, "512MB")
+
+	c.Assert(json.Unmarshal([]byte(`{"memory":{"limit":"lots"}}`), &r), NotNil)
+}
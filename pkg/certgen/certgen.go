@@ -18,6 +18,11 @@ type Params struct {
 	Hosts []string
 	IsCA  bool
 	CA    *Certificate
+
+	// NotBefore and NotAfter, if set, are the validity period of the
+	// certificate, which otherwise is valid from now for five years.
+	NotBefore time.Time
+	NotAfter  time.Time
 }
 
 type Certificate struct {
@@ -43,8 +48,14 @@ func Generate(p Params) (*Certificate, error) {
 		return nil, err
 	}
 
-	notBefore := time.Now()
-	notAfter := notBefore.Add(5 * 365 * 24 * time.Hour)
+	notBefore := p.NotBefore
+	if notBefore.IsZero() {
+		notBefore = time.Now()
+	}
+	notAfter := p.NotAfter
+	if notAfter.IsZero() {
+		notAfter = notBefore.Add(5 * 365 * 24 * time.Hour)
+	}
 
 	template := &x509.Certificate{
 		SerialNumber: serialNumber,
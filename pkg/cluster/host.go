@@ -112,6 +112,13 @@ func (c *Host) StopJob(id string) error {
 	return c.c.Delete(fmt.Sprintf("/host/jobs/%s", id))
 }
 
+// DrainJob stops a running job once its services have been marked as
+// draining for the host's drain period, returning without waiting for the job
+// to stop.
+func (c *Host) DrainJob(id string) error {
+	return c.c.Delete(fmt.Sprintf("/host/jobs/%s?drain=true", id))
+}
+
 // SignalJob sends a signal to a running job.
 func (c *Host) SignalJob(id string, sig int) error {
 	return c.c.Put(fmt.Sprintf("/host/jobs/%s/signal/%d", id, sig), nil, nil)
@@ -29,13 +29,32 @@ type ReadWriteCloser interface {
 	CloseWrite() error
 }
 
+// TokenProvider supplies the key used to authenticate requests. It is
+// consulted for every request, so short-lived credentials can be rotated
+// without creating a new client.
+type TokenProvider interface {
+	Token() (string, error)
+}
+
+// StaticToken is a TokenProvider which always returns the same key.
+type StaticToken string
+
+func (t StaticToken) Token() (string, error) {
+	return string(t), nil
+}
+
 type Client struct {
-	ErrNotFound error
-	URL         string
-	Key         string
-	Host        string
-	HTTP        *http.Client
-	HijackDial  DialFunc
+	ErrNotFound     error
+	ErrUnauthorized error
+	URL             string
+	Key             string
+	Host            string
+	HTTP            *http.Client
+	HijackDial      DialFunc
+
+	// TokenProvider, if set, is used instead of Key to authenticate
+	// requests.
+	TokenProvider TokenProvider
 }
 
 func ToJSON(v interface{}) (io.Reader, error) {
@@ -68,8 +87,15 @@ func (c *Client) prepareReq(method, rawurl string, header http.Header, in interf
 		header.Set("Content-Type", "application/json")
 	}
 	req.Header = header
-	if c.Key != "" {
-		req.SetBasicAuth("", c.Key)
+	key := c.Key
+	if c.TokenProvider != nil {
+		key, err = c.TokenProvider.Token()
+		if err != nil {
+			return nil, fmt.Errorf("httpclient: error getting token: %s", err)
+		}
+	}
+	if key != "" {
+		req.SetBasicAuth("", key)
 	}
 	if c.Host != "" {
 		req.Host = c.Host
@@ -118,6 +144,9 @@ func (c *Client) rawReq(method, rawurl string, header http.Header, in, out inter
 		if res.StatusCode == 404 {
 			return res, c.ErrNotFound
 		}
+		if res.StatusCode == 401 && c.ErrUnauthorized != nil {
+			return res, c.ErrUnauthorized
+		}
 		return res, &url.Error{
 			Op:  req.Method,
 			URL: req.URL.String(),
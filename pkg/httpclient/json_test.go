@@ -0,0 +1,53 @@
+package httpclient
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+type countingTokens struct{ n int }
+
+func (t *countingTokens) Token() (string, error) {
+	t.n++
+	return "token" + strconv.Itoa(t.n), nil
+}
+
+type errorTokens struct{}
+
+func (errorTokens) Token() (string, error) {
+	return "", errors.New("broker unavailable")
+}
+
+func TestTokenProvider(t *testing.T) {
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, key, _ := req.BasicAuth()
+		keys = append(keys, key)
+	}))
+	defer srv.Close()
+
+	c := &Client{URL: srv.URL, Key: "static", HTTP: http.DefaultClient}
+	if err := c.Get("/", nil); err != nil {
+		t.Fatal(err)
+	}
+	c.TokenProvider = &countingTokens{}
+	for i := 0; i < 2; i++ {
+		if err := c.Get("/", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(keys) != 3 || keys[0] != "static" || keys[1] != "token1" || keys[2] != "token2" {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+
+	c.TokenProvider = errorTokens{}
+	if err := c.Get("/", nil); err == nil {
+		t.Fatal("expected error from token provider")
+	}
+	if len(keys) != 3 {
+		t.Fatalf("expected no request without a token, got %d", len(keys))
+	}
+}
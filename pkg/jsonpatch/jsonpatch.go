@@ -0,0 +1,287 @@
+// Package jsonpatch implements JSON Patch (RFC 6902) documents.
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Operation is a single JSON Patch operation.
+type Operation struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	From  string           `json:"from,omitempty"`
+	Value *json.RawMessage `json:"value,omitempty"`
+}
+
+// Patch is a JSON Patch document, a list of operations applied in order.
+type Patch []Operation
+
+// Decode parses a JSON Patch document.
+func Decode(data []byte) (Patch, error) {
+	var p Patch
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("jsonpatch: invalid patch document: %s", err)
+	}
+	return p, nil
+}
+
+// Apply applies the patch to the JSON document doc and returns the result.
+// If any operation fails (including a failed "test" operation) an error is
+// returned and doc is left unchanged.
+func (p Patch) Apply(doc []byte) ([]byte, error) {
+	var root interface{}
+	if err := json.Unmarshal(doc, &root); err != nil {
+		return nil, fmt.Errorf("jsonpatch: invalid document: %s", err)
+	}
+	for i, op := range p {
+		var err error
+		root, err = op.apply(root)
+		if err != nil {
+			return nil, fmt.Errorf("jsonpatch: operation %d (%s %s): %s", i, op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(root)
+}
+
+func (op *Operation) value() (interface{}, error) {
+	if op.Value == nil {
+		return nil, fmt.Errorf("missing value")
+	}
+	var v interface{}
+	if err := json.Unmarshal(*op.Value, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (op *Operation) apply(root interface{}) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add":
+		v, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		return add(root, path, v)
+	case "remove":
+		root, _, err := remove(root, path)
+		return root, err
+	case "replace":
+		v, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		if root, _, err = remove(root, path); err != nil {
+			return nil, err
+		}
+		return add(root, path, v)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if op.Op == "move" {
+			if hasPrefix(path, from) && len(path) > len(from) {
+				return nil, fmt.Errorf("cannot move %q into one of its children", op.From)
+			}
+			root, v, err = remove(root, from)
+		} else {
+			v, err = get(root, from)
+			v = deepCopy(v)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return add(root, path, v)
+	case "test":
+		v, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+		actual, err := get(root, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(actual, v) {
+			return nil, fmt.Errorf("test failed")
+		}
+		return root, nil
+	default:
+		return nil, fmt.Errorf("unknown operation %q", op.Op)
+	}
+}
+
+// parsePointer parses a JSON Pointer (RFC 6901) into its reference tokens.
+func parsePointer(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("invalid path %q", s)
+	}
+	tokens := strings.Split(s[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func hasPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func get(root interface{}, path []string) (interface{}, error) {
+	v := root
+	for _, t := range path {
+		switch c := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = c[t]; !ok {
+				return nil, fmt.Errorf("%q does not exist", t)
+			}
+		case []interface{}:
+			i, err := arrayIndex(t, len(c)-1)
+			if err != nil {
+				return nil, err
+			}
+			v = c[i]
+		default:
+			return nil, fmt.Errorf("%q does not exist", t)
+		}
+	}
+	return v, nil
+}
+
+// add sets the value at path, inserting into arrays rather than replacing
+// elements, and returns the new root.
+func add(root interface{}, path []string, v interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+	parent, err := get(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	key := path[len(path)-1]
+	switch c := parent.(type) {
+	case map[string]interface{}:
+		c[key] = v
+	case []interface{}:
+		var i int
+		if key == "-" {
+			i = len(c)
+		} else if i, err = arrayIndex(key, len(c)); err != nil {
+			return nil, err
+		}
+		c = append(c, nil)
+		copy(c[i+1:], c[i:])
+		c[i] = v
+		return setParent(root, path[:len(path)-1], c)
+	default:
+		return nil, fmt.Errorf("parent of %q is not an object or array", key)
+	}
+	return root, nil
+}
+
+// remove deletes the value at path, returning the new root and the removed
+// value.
+func remove(root interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, root, nil
+	}
+	parent, err := get(root, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	key := path[len(path)-1]
+	switch c := parent.(type) {
+	case map[string]interface{}:
+		v, ok := c[key]
+		if !ok {
+			return nil, nil, fmt.Errorf("%q does not exist", key)
+		}
+		delete(c, key)
+		return root, v, nil
+	case []interface{}:
+		i, err := arrayIndex(key, len(c)-1)
+		if err != nil {
+			return nil, nil, err
+		}
+		v := c[i]
+		c = append(c[:i:i], c[i+1:]...)
+		root, err = setParent(root, path[:len(path)-1], c)
+		return root, v, err
+	default:
+		return nil, nil, fmt.Errorf("parent of %q is not an object or array", key)
+	}
+}
+
+// setParent replaces the array at path with arr (which is needed as
+// inserting into or removing from a slice may reallocate it).
+func setParent(root interface{}, path []string, arr []interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return arr, nil
+	}
+	grandparent, err := get(root, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	key := path[len(path)-1]
+	switch c := grandparent.(type) {
+	case map[string]interface{}:
+		c[key] = arr
+	case []interface{}:
+		i, err := arrayIndex(key, len(c)-1)
+		if err != nil {
+			return nil, err
+		}
+		c[i] = arr
+	}
+	return root, nil
+}
+
+func arrayIndex(s string, max int) (int, error) {
+	i, err := strconv.Atoi(s)
+	if err != nil || (len(s) > 1 && s[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", s)
+	}
+	if i < 0 || i > max {
+		return 0, fmt.Errorf("array index %d out of bounds", i)
+	}
+	return i, nil
+}
+
+func deepCopy(v interface{}) interface{} {
+	switch c := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(c))
+		for k, v := range c {
+			m[k] = deepCopy(v)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(c))
+		for i, v := range c {
+			a[i] = deepCopy(v)
+		}
+		return a
+	default:
+		return v
+	}
+}
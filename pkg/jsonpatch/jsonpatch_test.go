@@ -0,0 +1,106 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+		err   bool
+	}{
+		{
+			name:  "add object member",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"add","path":"/b","value":2}]`,
+			want:  `{"a":1,"b":2}`,
+		},
+		{
+			name:  "add array element",
+			doc:   `{"a":[1,3]}`,
+			patch: `[{"op":"add","path":"/a/1","value":2},{"op":"add","path":"/a/-","value":4}]`,
+			want:  `{"a":[1,2,3,4]}`,
+		},
+		{
+			name:  "remove array element",
+			doc:   `{"p":{"web":{"ports":[{"port":80},{"port":443}]}}}`,
+			patch: `[{"op":"remove","path":"/p/web/ports/0"}]`,
+			want:  `{"p":{"web":{"ports":[{"port":443}]}}}`,
+		},
+		{
+			name:  "replace",
+			doc:   `{"a":[1,2]}`,
+			patch: `[{"op":"replace","path":"/a/1","value":"x"}]`,
+			want:  `{"a":[1,"x"]}`,
+		},
+		{
+			name:  "move and copy",
+			doc:   `{"a":{"x":1},"b":{}}`,
+			patch: `[{"op":"copy","from":"/a/x","path":"/b/y"},{"op":"move","from":"/a","path":"/c"}]`,
+			want:  `{"b":{"y":1},"c":{"x":1}}`,
+		},
+		{
+			name:  "escaped path",
+			doc:   `{"a/b":1,"c~d":2}`,
+			patch: `[{"op":"remove","path":"/a~1b"},{"op":"remove","path":"/c~0d"}]`,
+			want:  `{}`,
+		},
+		{
+			name:  "test passes",
+			doc:   `{"a":{"b":[1]}}`,
+			patch: `[{"op":"test","path":"/a","value":{"b":[1]}}]`,
+			want:  `{"a":{"b":[1]}}`,
+		},
+		{
+			name:  "test fails",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"test","path":"/a","value":2}]`,
+			err:   true,
+		},
+		{
+			name:  "remove missing",
+			doc:   `{"a":1}`,
+			patch: `[{"op":"remove","path":"/b"}]`,
+			err:   true,
+		},
+		{
+			name:  "index out of bounds",
+			doc:   `{"a":[1]}`,
+			patch: `[{"op":"replace","path":"/a/1","value":2}]`,
+			err:   true,
+		},
+		{
+			name:  "unknown op",
+			doc:   `{}`,
+			patch: `[{"op":"merge","path":"/a","value":2}]`,
+			err:   true,
+		},
+	} {
+		p, err := Decode([]byte(test.patch))
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		res, err := p.Apply([]byte(test.doc))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected error, got %s", test.name, res)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		var got, want interface{}
+		json.Unmarshal(res, &got)
+		json.Unmarshal([]byte(test.want), &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %s, got %s", test.name, test.want, res)
+		}
+	}
+}
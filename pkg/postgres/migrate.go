@@ -1,7 +1,11 @@
 package postgres
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx"
@@ -18,17 +22,49 @@ func NewMigrations() *Migrations {
 	return &l
 }
 
+// Checksum returns a hex encoded SHA256 digest of the migration's statements,
+// which is recorded in schema_migrations when the migration is applied.
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(strings.Join(m.Stmts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// ChecksumMismatchError is returned by Migrate when the checksum recorded for
+// a previously applied migration does not match the migration's statements.
+type ChecksumMismatchError struct {
+	ID       int
+	Expected string
+	Actual   string
+}
+
+func (e ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("postgres: checksum mismatch for migration %d (expected %s, recorded %s)", e.ID, e.Expected, e.Actual)
+}
+
 type Migrations []Migration
 
 func (m *Migrations) Add(id int, stmts ...string) {
 	*m = append(*m, Migration{ID: id, Stmts: stmts})
 }
 
+// migrateLog logs the start and end of each migration which is applied, so
+// that the duration of upgrades can be predicted.
+var migrateLog = log15.New("component", "postgres", "fn", "migrate")
+
+// Migrate applies the migrations which haven't already been applied, each in
+// its own transaction, recording their checksums and how long their
+// statements took to run in schema_migrations.
 func (m Migrations) Migrate(db *DB) error {
 	var initialized bool
 	for _, migration := range m {
 		if !initialized {
 			db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (id bigint PRIMARY KEY)")
+			if err := db.Exec(sqlAddChecksumColumn); err != nil {
+				return err
+			}
+			if err := db.Exec(sqlAddDurationColumn); err != nil {
+				return err
+			}
 			initialized = true
 		}
 
@@ -41,24 +77,46 @@ func (m Migrations) Migrate(db *DB) error {
 			tx.Rollback()
 			return err
 		}
-		var tmp bool
-		if err := tx.QueryRow("SELECT true FROM schema_migrations WHERE id = $1", migration.ID).Scan(&tmp); err != pgx.ErrNoRows {
-			tx.Rollback()
-			if err == nil {
+		checksum := migration.Checksum()
+		var recorded *string
+		if err := tx.QueryRow("SELECT checksum FROM schema_migrations WHERE id = $1", migration.ID).Scan(&recorded); err != pgx.ErrNoRows {
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			if recorded == nil {
+				// the migration was applied before checksums were
+				// recorded, so record it now
+				if err := tx.Exec("UPDATE schema_migrations SET checksum = $1 WHERE id = $2", checksum, migration.ID); err != nil {
+					tx.Rollback()
+					return err
+				}
+				if err := tx.Commit(); err != nil {
+					return err
+				}
 				continue
 			}
-			return err
+			tx.Rollback()
+			if *recorded != checksum {
+				return ChecksumMismatchError{ID: migration.ID, Expected: checksum, Actual: *recorded}
+			}
+			continue
 		}
 
+		log := migrateLog.New("id", migration.ID)
+		log.Info("applying migration")
+		start := time.Now()
 		for _, s := range migration.Stmts {
 			err = tx.Exec(s)
 			if err != nil {
 				tx.Rollback()
+				log.Error("migration failed", "duration", time.Since(start), "err", err)
 				return err
 			}
 		}
+		duration := time.Since(start)
 
-		if err := tx.Exec("INSERT INTO schema_migrations (id) VALUES ($1)", migration.ID); err != nil {
+		if err := tx.Exec("INSERT INTO schema_migrations (id, checksum, duration_ms) VALUES ($1, $2, $3)", migration.ID, checksum, int64(duration/time.Millisecond)); err != nil {
 			tx.Rollback()
 			return err
 		}
@@ -70,10 +128,77 @@ func (m Migrations) Migrate(db *DB) error {
 		if err := tx.Commit(); err != nil {
 			return err
 		}
+		log.Info("applied migration", "duration", duration)
 	}
 	return nil
 }
 
+// MigrateTo applies any outstanding migrations up to and including the
+// migration with the given ID. It returns an error if a later migration has
+// already been applied, as rolling back migrations is not supported.
+func (m Migrations) MigrateTo(db *DB, id int) error {
+	version, err := m.Version(db)
+	if err != nil {
+		return err
+	}
+	if id < version {
+		return fmt.Errorf("postgres: cannot migrate to %d, migration %d has already been applied", id, version)
+	}
+	for i, migration := range m {
+		if migration.ID == id {
+			return m[:i+1].Migrate(db)
+		}
+	}
+	return fmt.Errorf("postgres: unknown migration %d", id)
+}
+
+// Version returns the ID of the most recently applied migration recorded in
+// schema_migrations, or zero if no migrations have been applied.
+func (m Migrations) Version(db *DB) (int, error) {
+	var version int64
+	err := db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM schema_migrations").Scan(&version)
+	if IsPostgresCode(err, UndefinedTable) {
+		return 0, nil
+	}
+	return int(version), err
+}
+
+// Durations returns how long the statements of each applied migration took
+// to run, keyed by migration ID. Migrations applied before durations were
+// recorded are omitted.
+func (m Migrations) Durations(db *DB) (map[int]time.Duration, error) {
+	rows, err := db.Query("SELECT id, duration_ms FROM schema_migrations WHERE duration_ms IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	durations := make(map[int]time.Duration)
+	for rows.Next() {
+		var id, ms int64
+		if err := rows.Scan(&id, &ms); err != nil {
+			return nil, err
+		}
+		durations[int(id)] = time.Duration(ms) * time.Millisecond
+	}
+	return durations, rows.Err()
+}
+
+const sqlAddChecksumColumn = `
+DO $$
+BEGIN
+	ALTER TABLE schema_migrations ADD COLUMN checksum text;
+EXCEPTION
+	WHEN duplicate_column THEN NULL;
+END $$`
+
+const sqlAddDurationColumn = `
+DO $$
+BEGIN
+	ALTER TABLE schema_migrations ADD COLUMN duration_ms bigint;
+EXCEPTION
+	WHEN duplicate_column THEN NULL;
+END $$`
+
 func ResetOnMigration(db *DB, log log15.Logger, doneCh chan struct{}) {
 	for {
 		listener, err := db.Listen("schema_migrations", log)
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/flynn/flynn/discoverd/client"
@@ -15,10 +17,12 @@ import (
 
 const (
 	InvalidTextRepresentation = "22P02"
+	NotNullViolation          = "23502"
 	CheckViolation            = "23514"
 	UniqueViolation           = "23505"
 	RaiseException            = "P0001"
 	ForeignKeyViolation       = "23503"
+	UndefinedTable            = "42P01"
 )
 
 type Conf struct {
@@ -26,8 +30,66 @@ type Conf struct {
 	User     string
 	Password string
 	Database string
+
+	// MaxConnections is the maximum number of connections in the pool,
+	// defaulting to DefaultMaxConnections.
+	MaxConnections int
+
+	// AcquireTimeout is the maximum time to wait for a connection when all
+	// connections are busy, defaulting to DefaultAcquireTimeout.
+	AcquireTimeout time.Duration
+
+	// MaxConnLifetime is the maximum amount of time a connection is used
+	// for, zero meaning no limit. Connections which are older are closed
+	// when they are released after being used through DB's methods, and
+	// the pool opens a new connection when one is next needed. Connections
+	// used directly through the embedded ConnPool are not checked.
+	MaxConnLifetime time.Duration
 }
 
+// ConfFromEnv returns the configuration of the database given by
+// FLYNN_POSTGRES, PGUSER, PGPASSWORD and PGDATABASE, with the pool options
+// set from FLYNN_POSTGRES_MAX_CONNS, FLYNN_POSTGRES_ACQUIRE_TIMEOUT and
+// FLYNN_POSTGRES_MAX_CONN_LIFETIME (durations such as "30s") if they are set.
+func ConfFromEnv() (*Conf, error) {
+	conf := &Conf{
+		Service:  os.Getenv("FLYNN_POSTGRES"),
+		User:     os.Getenv("PGUSER"),
+		Password: os.Getenv("PGPASSWORD"),
+		Database: os.Getenv("PGDATABASE"),
+	}
+	if s := os.Getenv("FLYNN_POSTGRES_MAX_CONNS"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("postgres: invalid FLYNN_POSTGRES_MAX_CONNS %q", s)
+		}
+		conf.MaxConnections = n
+	}
+	for _, d := range []struct {
+		env string
+		val *time.Duration
+	}{
+		{"FLYNN_POSTGRES_ACQUIRE_TIMEOUT", &conf.AcquireTimeout},
+		{"FLYNN_POSTGRES_MAX_CONN_LIFETIME", &conf.MaxConnLifetime},
+	} {
+		s := os.Getenv(d.env)
+		if s == "" {
+			continue
+		}
+		val, err := time.ParseDuration(s)
+		if err != nil || val < 0 {
+			return nil, fmt.Errorf("postgres: invalid %s %q", d.env, s)
+		}
+		*d.val = val
+	}
+	return conf, nil
+}
+
+const (
+	DefaultMaxConnections = 20
+	DefaultAcquireTimeout = 30 * time.Second
+)
+
 var connectAttempts = attempt.Strategy{
 	Min:   5,
 	Total: 5 * time.Minute,
@@ -35,16 +97,14 @@ var connectAttempts = attempt.Strategy{
 }
 
 func New(connPool *pgx.ConnPool, conf *Conf) *DB {
-	return &DB{connPool, conf}
+	return &DB{ConnPool: connPool, conf: conf}
 }
 
 func Wait(conf *Conf, afterConn func(*pgx.Conn) error) *DB {
 	if conf == nil {
-		conf = &Conf{
-			Service:  os.Getenv("FLYNN_POSTGRES"),
-			User:     os.Getenv("PGUSER"),
-			Password: os.Getenv("PGPASSWORD"),
-			Database: os.Getenv("PGDATABASE"),
+		var err error
+		if conf, err = ConfFromEnv(); err != nil {
+			shutdown.Fatal(err)
 		}
 	}
 	events := make(chan *discoverd.Event)
@@ -91,29 +151,122 @@ func Wait(conf *Conf, afterConn func(*pgx.Conn) error) *DB {
 }
 
 func Open(conf *Conf, afterConn func(*pgx.Conn) error) (*DB, error) {
-	connConfig := pgx.ConnConfig{
-		Host:     fmt.Sprintf("leader.%s.discoverd", conf.Service),
-		User:     conf.User,
-		Database: conf.Database,
-		Password: conf.Password,
-	}
-	connPool, err := pgx.NewConnPool(pgx.ConnPoolConfig{
-		ConnConfig:     connConfig,
+	db := &DB{conf: conf}
+	connPool, err := pgx.NewConnPool(poolConfig(conf, db.trackConn(afterConn)))
+	if err != nil {
+		return nil, err
+	}
+	db.ConnPool = connPool
+	return db, nil
+}
+
+// poolConfig returns the connection pool configuration for conf, using the
+// defaults for pool options which aren't set.
+func poolConfig(conf *Conf, afterConn func(*pgx.Conn) error) pgx.ConnPoolConfig {
+	maxConns := conf.MaxConnections
+	if maxConns == 0 {
+		maxConns = DefaultMaxConnections
+	}
+	acquireTimeout := conf.AcquireTimeout
+	if acquireTimeout == 0 {
+		acquireTimeout = DefaultAcquireTimeout
+	}
+	return pgx.ConnPoolConfig{
+		ConnConfig: pgx.ConnConfig{
+			Host:     fmt.Sprintf("leader.%s.discoverd", conf.Service),
+			User:     conf.User,
+			Database: conf.Database,
+			Password: conf.Password,
+		},
 		AfterConnect:   afterConn,
-		MaxConnections: 20,
-		AcquireTimeout: 30 * time.Second,
-	})
-	db := &DB{connPool, conf}
-	return db, err
+		MaxConnections: maxConns,
+		AcquireTimeout: acquireTimeout,
+	}
 }
 
 type DB struct {
 	*pgx.ConnPool
 	conf *Conf
+
+	// connCreated records when each connection was opened if a maximum
+	// connection lifetime is configured.
+	connMtx     sync.Mutex
+	connCreated map[*pgx.Conn]time.Time
+}
+
+func (db *DB) maxConnLifetime() time.Duration {
+	if db.conf == nil {
+		return 0
+	}
+	return db.conf.MaxConnLifetime
+}
+
+// trackConn returns an AfterConnect function which calls afterConn and then
+// records when the connection was opened.
+func (db *DB) trackConn(afterConn func(*pgx.Conn) error) func(*pgx.Conn) error {
+	return func(conn *pgx.Conn) error {
+		if afterConn != nil {
+			if err := afterConn(conn); err != nil {
+				return err
+			}
+		}
+		lifetime := db.maxConnLifetime()
+		if lifetime <= 0 {
+			return nil
+		}
+		now := time.Now()
+		db.connMtx.Lock()
+		defer db.connMtx.Unlock()
+		if db.connCreated == nil {
+			db.connCreated = make(map[*pgx.Conn]time.Time)
+		}
+		// forget connections which were dropped by the pool without
+		// being released through DB (e.g. because they died)
+		for c, created := range db.connCreated {
+			if now.Sub(created) > 2*lifetime {
+				delete(db.connCreated, c)
+			}
+		}
+		db.connCreated[conn] = now
+		return nil
+	}
+}
+
+// closeIfExpired closes conn if it was opened longer than MaxConnLifetime
+// ago, so that the pool drops it rather than reusing it when it is released.
+func (db *DB) closeIfExpired(conn *pgx.Conn) {
+	if conn == nil {
+		return
+	}
+	db.connMtx.Lock()
+	created, ok := db.connCreated[conn]
+	expired := ok && time.Since(created) >= db.maxConnLifetime()
+	if expired {
+		delete(db.connCreated, conn)
+	}
+	db.connMtx.Unlock()
+	if expired {
+		conn.Close()
+	}
+}
+
+func (db *DB) rowsAfterClose(rows *pgx.Rows) { db.closeIfExpired(rows.Conn()) }
+func (db *DB) txAfterClose(tx *pgx.Tx)       { db.closeIfExpired(tx.Conn()) }
+
+// Release returns conn to the pool, first closing it if it has exceeded the
+// maximum connection lifetime.
+func (db *DB) Release(conn *pgx.Conn) {
+	db.closeIfExpired(conn)
+	db.ConnPool.Release(conn)
 }
 
 func (db *DB) Exec(query string, args ...interface{}) error {
-	_, err := db.ConnPool.Exec(query, args...)
+	conn, err := db.Acquire()
+	if err != nil {
+		return err
+	}
+	defer db.Release(conn)
+	_, err = conn.Exec(query, args...)
 	return err
 }
 
@@ -121,7 +274,7 @@ func (db *DB) ExecRetry(query string, args ...interface{}) error {
 	retries := 0
 	max := 30
 	for {
-		_, err := db.ConnPool.Exec(query, args...)
+		err := db.Exec(query, args...)
 		if err == pgx.ErrDeadConn && retries < max {
 			retries++
 			time.Sleep(1 * time.Second)
@@ -135,12 +288,25 @@ type Scanner interface {
 	Scan(...interface{}) error
 }
 
+func (db *DB) Query(query string, args ...interface{}) (*pgx.Rows, error) {
+	rows, err := db.ConnPool.Query(query, args...)
+	if err == nil {
+		// runs before the pool releases the connection
+		rows.AfterClose(db.rowsAfterClose)
+	}
+	return rows, err
+}
+
 func (db *DB) QueryRow(query string, args ...interface{}) Scanner {
-	return rowErrFixer{db.ConnPool.QueryRow(query, args...)}
+	rows, _ := db.Query(query, args...)
+	return rowErrFixer{(*pgx.Row)(rows)}
 }
 
 func (db *DB) Begin() (*DBTx, error) {
 	tx, err := db.ConnPool.Begin()
+	if err == nil {
+		tx.AfterClose(db.txAfterClose)
+	}
 	return &DBTx{tx}, err
 }
 
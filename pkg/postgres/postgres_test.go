@@ -0,0 +1,103 @@
+package postgres
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+func TestPoolConfig(t *testing.T) {
+	config := poolConfig(&Conf{Service: "pg", User: "flynn", Password: "s3cret", Database: "router"}, nil)
+	if config.Host != "leader.pg.discoverd" || config.User != "flynn" || config.Password != "s3cret" || config.Database != "router" {
+		t.Fatalf("unexpected connection config: %+v", config.ConnConfig)
+	}
+	if config.MaxConnections != DefaultMaxConnections {
+		t.Fatalf("expected %d max connections by default, got %d", DefaultMaxConnections, config.MaxConnections)
+	}
+	if config.AcquireTimeout != DefaultAcquireTimeout {
+		t.Fatalf("expected an acquire timeout of %s by default, got %s", DefaultAcquireTimeout, config.AcquireTimeout)
+	}
+
+	config = poolConfig(&Conf{Service: "pg", MaxConnections: 50, AcquireTimeout: 5 * time.Second}, nil)
+	if config.MaxConnections != 50 {
+		t.Fatalf("expected 50 max connections, got %d", config.MaxConnections)
+	}
+	if config.AcquireTimeout != 5*time.Second {
+		t.Fatalf("expected an acquire timeout of 5s, got %s", config.AcquireTimeout)
+	}
+}
+
+func TestConfFromEnv(t *testing.T) {
+	vars := []string{"FLYNN_POSTGRES", "FLYNN_POSTGRES_MAX_CONNS", "FLYNN_POSTGRES_ACQUIRE_TIMEOUT", "FLYNN_POSTGRES_MAX_CONN_LIFETIME"}
+	for _, v := range vars {
+		defer os.Setenv(v, os.Getenv(v))
+		os.Setenv(v, "")
+	}
+
+	os.Setenv("FLYNN_POSTGRES", "pg")
+	conf, err := ConfFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Service != "pg" || conf.MaxConnections != 0 || conf.AcquireTimeout != 0 || conf.MaxConnLifetime != 0 {
+		t.Fatalf("unexpected conf: %+v", conf)
+	}
+
+	os.Setenv("FLYNN_POSTGRES_MAX_CONNS", "50")
+	os.Setenv("FLYNN_POSTGRES_ACQUIRE_TIMEOUT", "5s")
+	os.Setenv("FLYNN_POSTGRES_MAX_CONN_LIFETIME", "1h")
+	conf, err = ConfFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.MaxConnections != 50 || conf.AcquireTimeout != 5*time.Second || conf.MaxConnLifetime != time.Hour {
+		t.Fatalf("unexpected conf: %+v", conf)
+	}
+
+	for env, val := range map[string]string{
+		"FLYNN_POSTGRES_MAX_CONNS":         "lots",
+		"FLYNN_POSTGRES_ACQUIRE_TIMEOUT":   "-1s",
+		"FLYNN_POSTGRES_MAX_CONN_LIFETIME": "1 hour",
+	} {
+		prev := os.Getenv(env)
+		os.Setenv(env, val)
+		if _, err := ConfFromEnv(); err == nil {
+			t.Fatalf("expected an error with %s=%q", env, val)
+		}
+		os.Setenv(env, prev)
+	}
+}
+
+func TestConnLifetime(t *testing.T) {
+	db := &DB{conf: &Conf{MaxConnLifetime: time.Hour}}
+	track := db.trackConn(nil)
+	old, recent := &pgx.Conn{}, &pgx.Conn{}
+	if err := track(old); err != nil {
+		t.Fatal(err)
+	}
+	if err := track(recent); err != nil {
+		t.Fatal(err)
+	}
+	db.connCreated[old] = time.Now().Add(-2 * time.Hour)
+
+	// only connections older than the lifetime are closed and forgotten
+	db.closeIfExpired(old)
+	db.closeIfExpired(recent)
+	if _, ok := db.connCreated[old]; ok {
+		t.Fatal("expected the expired connection to be forgotten")
+	}
+	if _, ok := db.connCreated[recent]; !ok {
+		t.Fatal("expected the recent connection to still be tracked")
+	}
+
+	// connections aren't tracked without a lifetime
+	db = &DB{conf: &Conf{}}
+	if err := db.trackConn(nil)(&pgx.Conn{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.connCreated) != 0 {
+		t.Fatalf("expected no tracked connections, got %d", len(db.connCreated))
+	}
+}
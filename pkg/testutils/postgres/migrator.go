@@ -0,0 +1,111 @@
+package pgtestutils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flynn/flynn/pkg/postgres"
+)
+
+// TestingT is the subset of *testing.T and go-check's *C used by Migrator.
+type TestingT interface {
+	Fatal(args ...interface{})
+}
+
+// Migrator applies a set of migrations to a test database, fast-forwarding
+// to arbitrary versions and seeding the fixture rows that a migration test
+// needs at that version.
+type Migrator struct {
+	t          TestingT
+	db         *postgres.DB
+	migrations postgres.Migrations
+}
+
+// NewMigrator returns a Migrator which applies migrations to db, failing t
+// if any step fails.
+func NewMigrator(t TestingT, db *postgres.DB, migrations *postgres.Migrations) *Migrator {
+	return &Migrator{t: t, db: db, migrations: *migrations}
+}
+
+// Row maps column names to the values to insert into them.
+type Row map[string]interface{}
+
+// Fixture is a row to insert into Table.
+type Fixture struct {
+	Table string
+	Row   Row
+
+	// Returning, if set, is a column of the inserted row to scan into
+	// Dest, typically to capture a generated ID.
+	Returning string
+	Dest      interface{}
+}
+
+// MigrateTo applies the migrations up to and including id, then checks the
+// recorded schema version is id.
+func (m *Migrator) MigrateTo(id int) {
+	if err := m.migrations.MigrateTo(m.db, id); err != nil {
+		m.t.Fatal(err)
+	}
+	version, err := m.migrations.Version(m.db)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	if version != id {
+		m.t.Fatal(fmt.Sprintf("expected schema version %d, got %d", id, version))
+	}
+}
+
+// Durations returns how long each applied migration took, keyed by
+// migration ID.
+func (m *Migrator) Durations() map[int]time.Duration {
+	durations, err := m.migrations.Durations(m.db)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return durations
+}
+
+// Seed fast-forwards the schema to version and inserts fixtures, so that
+// they have the shape of rows written by code running at that version.
+func (m *Migrator) Seed(version int, fixtures ...*Fixture) {
+	m.MigrateTo(version)
+	for _, f := range fixtures {
+		m.Insert(f)
+	}
+}
+
+// Insert inserts a fixture row into the current schema.
+func (m *Migrator) Insert(f *Fixture) {
+	columns := make([]string, 0, len(f.Row))
+	for column := range f.Row {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	names := make([]string, len(columns))
+	params := make([]string, len(columns))
+	args := make([]interface{}, len(columns))
+	for i, column := range columns {
+		names[i] = quoteIdent(column)
+		params[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = f.Row[column]
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quoteIdent(f.Table), strings.Join(names, ", "), strings.Join(params, ", "))
+
+	var err error
+	if f.Returning != "" {
+		err = m.db.QueryRow(query+" RETURNING "+quoteIdent(f.Returning), args...).Scan(f.Dest)
+	} else {
+		err = m.db.Exec(query, args...)
+	}
+	if err != nil {
+		m.t.Fatal(fmt.Sprintf("error inserting fixture into %s: %s", f.Table, err))
+	}
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
@@ -2,6 +2,7 @@ package tlscert
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/flynn/flynn/pkg/certgen"
 )
@@ -19,19 +20,63 @@ func (c *Cert) String() string {
 }
 
 func Generate(hosts []string) (*Cert, error) {
-	data := &Cert{}
-	ca, err := certgen.Generate(certgen.Params{IsCA: true})
+	return generate(certgen.Params{Hosts: hosts})
+}
+
+// Options are the optional settings of a certificate generated by
+// GenerateForDomain.
+type Options struct {
+	// SANs are other hostnames or IP addresses the certificate is also
+	// valid for.
+	SANs []string
+
+	// Wildcard is whether the certificate is also valid for the
+	// subdomains of the domain (i.e. *.domain).
+	Wildcard bool
+
+	// NotBefore and NotAfter, if set, are the validity period of the
+	// certificate, which otherwise is valid from now for five years. They
+	// can be in the past to generate an expired certificate.
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// GenerateForDomain generates a certificate and private key for domain,
+// signed by a new CA whose certificate is returned as the CACert of the
+// result so that clients can be configured to trust it. It is intended for
+// tests and local development rather than for serving real traffic.
+func GenerateForDomain(domain string, opts *Options) (*Cert, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	hosts := []string{domain}
+	if opts.Wildcard {
+		hosts = append(hosts, "*."+domain)
+	}
+	hosts = append(hosts, opts.SANs...)
+	return generate(certgen.Params{
+		Hosts:     hosts,
+		NotBefore: opts.NotBefore,
+		NotAfter:  opts.NotAfter,
+	})
+}
+
+// generate generates a certificate with params signed by a new CA with the
+// same validity period.
+func generate(params certgen.Params) (*Cert, error) {
+	ca, err := certgen.Generate(certgen.Params{IsCA: true, NotBefore: params.NotBefore, NotAfter: params.NotAfter})
 	if err != nil {
 		return nil, err
 	}
-	cert, err := certgen.Generate(certgen.Params{Hosts: hosts, CA: ca})
+	params.CA = ca
+	cert, err := certgen.Generate(params)
 	if err != nil {
 		return nil, err
 	}
-	data.CACert = ca.PEM
-	data.Cert = cert.PEM
-	data.Pin = cert.Pin
-	data.PrivateKey = cert.KeyPEM
-
-	return data, err
+	return &Cert{
+		CACert:     ca.PEM,
+		Cert:       cert.PEM,
+		Pin:        cert.Pin,
+		PrivateKey: cert.KeyPEM,
+	}, nil
 }
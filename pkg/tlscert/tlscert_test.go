@@ -0,0 +1,64 @@
+package tlscert_test
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/flynn/flynn/pkg/tlscert"
+	. "github.com/flynn/go-check"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&S{})
+
+type S struct{}
+
+func parseCert(c *C, data string) *x509.Certificate {
+	block, _ := pem.Decode([]byte(data))
+	c.Assert(block, NotNil)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	c.Assert(err, IsNil)
+	return cert
+}
+
+func (S) TestGenerateForDomain(c *C) {
+	cert, err := tlscert.GenerateForDomain("example.com", &tlscert.Options{
+		SANs:     []string{"example.org", "127.0.0.1"},
+		Wildcard: true,
+	})
+	c.Assert(err, IsNil)
+	pool := x509.NewCertPool()
+	c.Assert(pool.AppendCertsFromPEM([]byte(cert.CACert)), Equals, true)
+	parsed := parseCert(c, cert.Cert)
+	for _, name := range []string{"example.com", "foo.example.com", "example.org", "127.0.0.1"} {
+		_, err := parsed.Verify(x509.VerifyOptions{DNSName: name, Roots: pool})
+		c.Assert(err, IsNil, Commentf("%s", name))
+	}
+	_, err = parsed.Verify(x509.VerifyOptions{DNSName: "foo.example.org", Roots: pool})
+	c.Assert(err, NotNil)
+	block, _ := pem.Decode([]byte(cert.PrivateKey))
+	c.Assert(block, NotNil)
+	_, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	c.Assert(err, IsNil)
+}
+
+func (S) TestGenerateForDomainExpired(c *C) {
+	notAfter := time.Now().Add(-time.Hour).Truncate(time.Second)
+	cert, err := tlscert.GenerateForDomain("example.com", &tlscert.Options{
+		NotBefore: notAfter.Add(-24 * time.Hour),
+		NotAfter:  notAfter,
+	})
+	c.Assert(err, IsNil)
+	pool := x509.NewCertPool()
+	c.Assert(pool.AppendCertsFromPEM([]byte(cert.CACert)), Equals, true)
+	parsed := parseCert(c, cert.Cert)
+	c.Assert(parsed.NotAfter.Equal(notAfter), Equals, true)
+	_, err = parsed.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: pool})
+	c.Assert(err, FitsTypeOf, x509.CertificateInvalidError{})
+	c.Assert(err.(x509.CertificateInvalidError).Reason, Equals, x509.Expired)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"sort"
 
@@ -26,6 +27,7 @@ func apiHandler(rtr *Router) http.Handler {
 	r.HandlerFunc("GET", status.Path, status.HealthyHandler.ServeHTTP)
 
 	r.POST("/routes", httphelper.WrapHandler(api.CreateRoute))
+	r.POST("/routes/import", httphelper.WrapHandler(api.ImportRoutes))
 	r.PUT("/routes/:route_type/:id", httphelper.WrapHandler(api.UpdateRoute))
 	r.GET("/routes", httphelper.WrapHandler(api.GetRoutes))
 	r.GET("/routes/:route_type/:id", httphelper.WrapHandler(api.GetRoute))
@@ -36,6 +38,7 @@ func apiHandler(rtr *Router) http.Handler {
 	r.DELETE("/certificates/:id", httphelper.WrapHandler(api.DeleteCert))
 	r.GET("/certificates", httphelper.WrapHandler(api.GetCerts))
 	r.GET("/events", httphelper.WrapHandler(api.StreamEvents))
+	r.GET("/metrics", httphelper.WrapHandler(api.GetMetrics))
 
 	r.HandlerFunc("GET", "/debug/*path", pprof.Handler.ServeHTTP)
 
@@ -58,6 +61,11 @@ func (api *API) CreateRoute(ctx context.Context, w http.ResponseWriter, req *htt
 		return
 	}
 
+	if err := route.Validate(); err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
+
 	err := l.AddRoute(route)
 	if err != nil {
 		rjson, jerr := json.Marshal(&route)
@@ -85,6 +93,65 @@ func (api *API) CreateRoute(ctx context.Context, w http.ResponseWriter, req *htt
 	httphelper.JSON(w, 200, route)
 }
 
+// respondWithValidationError responds with a validation error for the field
+// of a router.ValidationError returned by Route.Validate.
+func respondWithValidationError(w http.ResponseWriter, err error) {
+	if e, ok := err.(router.ValidationError); ok {
+		httphelper.ValidationError(w, e.Field, e.Message)
+		return
+	}
+	httphelper.ValidationError(w, "", err.Error())
+}
+
+type routeImporter interface {
+	ImportRoutes([]*router.Route) error
+}
+
+func (api *API) ImportRoutes(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	log, _ := ctxhelper.LoggerFromContext(ctx)
+
+	var routes []*router.Route
+	if err := json.NewDecoder(req.Body).Decode(&routes); err != nil {
+		log.Error(err.Error())
+		httphelper.Error(w, err)
+		return
+	}
+
+	for _, route := range routes {
+		if route.Type != "http" {
+			httphelper.ValidationError(w, "type", "Only http routes can be imported")
+			return
+		}
+		if err := route.Validate(); err != nil {
+			respondWithValidationError(w, err)
+			return
+		}
+	}
+
+	l, ok := api.router.HTTP.(routeImporter)
+	if !ok {
+		httphelper.Error(w, errors.New("router: http listener does not support importing routes"))
+		return
+	}
+
+	if err := l.ImportRoutes(routes); err != nil {
+		switch err {
+		case ErrConflict:
+			httphelper.Error(w, httphelper.JSONError{
+				Code:    httphelper.ConflictErrorCode,
+				Message: "Duplicate route",
+			})
+		case ErrInvalid:
+			httphelper.ValidationError(w, "", "Invalid route")
+		default:
+			log.Error(err.Error())
+			httphelper.Error(w, err)
+		}
+		return
+	}
+	httphelper.JSON(w, 200, routes)
+}
+
 func (api *API) UpdateRoute(ctx context.Context, w http.ResponseWriter, req *http.Request) {
 	log, _ := ctxhelper.LoggerFromContext(ctx)
 	params, _ := ctxhelper.ParamsFromContext(ctx)
@@ -105,6 +172,11 @@ func (api *API) UpdateRoute(ctx context.Context, w http.ResponseWriter, req *htt
 		return
 	}
 
+	if err := route.Validate(); err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
+
 	if err := l.UpdateRoute(route); err != nil {
 		if err == ErrNotFound {
 			w.WriteHeader(404)
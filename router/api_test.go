@@ -1,11 +1,14 @@
 package main
 
 import (
+	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
 	"strings"
 	"time"
 
 	"github.com/flynn/flynn/discoverd/testutil"
+	"github.com/flynn/flynn/pkg/httphelper"
 	"github.com/flynn/flynn/router/client"
 	"github.com/flynn/flynn/router/types"
 	. "github.com/flynn/go-check"
@@ -158,6 +161,68 @@ func (s *S) TestAPISetHTTPRoute(c *C) {
 	c.Assert(r.Service, Equals, "bar")
 }
 
+func (s *S) TestAPIUpdateRouteTimestamps(c *C) {
+	srv := s.newTestAPIServer(c)
+	defer srv.Close()
+
+	r := router.HTTPRoute{Domain: "example.com", Service: "foo"}.ToRoute()
+	c.Assert(srv.CreateRoute(r), IsNil)
+	c.Assert(r.CreatedAt.IsZero(), Equals, false)
+	c.Assert(r.UpdatedAt.Equal(r.CreatedAt), Equals, true)
+	created := r.CreatedAt
+
+	time.Sleep(10 * time.Millisecond)
+	r.Service = "bar"
+	c.Assert(srv.UpdateRoute(r), IsNil)
+	r, err := srv.GetRoute("http", r.ID)
+	c.Assert(err, IsNil)
+	c.Assert(r.CreatedAt.Equal(created), Equals, true)
+	c.Assert(r.UpdatedAt.After(created), Equals, true)
+}
+
+func (s *S) TestAPICreateInvalidRoute(c *C) {
+	srv := s.newTestAPIServer(c)
+	defer srv.Close()
+
+	for _, r := range []*router.Route{
+		router.HTTPRoute{Domain: "example..com", Service: "test"}.ToRoute(),
+		router.HTTPRoute{Domain: "foo.*.example.com", Service: "test"}.ToRoute(),
+		router.HTTPRoute{Domain: "example.com", Service: "test_service"}.ToRoute(),
+		router.HTTPRoute{Domain: "example.com", Service: "test", Certificate: &router.Certificate{Cert: "cert"}}.ToRoute(),
+		router.TCPRoute{}.ToRoute(),
+	} {
+		err := srv.CreateRoute(r)
+		c.Assert(httphelper.IsValidationError(err), Equals, true, Commentf("route: %+v, err: %v", r, err))
+	}
+	routes, err := srv.ListRoutes("")
+	c.Assert(err, IsNil)
+	c.Assert(routes, HasLen, 0)
+}
+
+func (s *S) TestAPIUpdateInvalidRoute(c *C) {
+	srv := s.newTestAPIServer(c)
+	defer srv.Close()
+
+	r := router.HTTPRoute{Domain: "update-invalid.example.com", Service: "test"}.ToRoute()
+	c.Assert(srv.CreateRoute(r), IsNil)
+
+	for _, update := range []func(*router.Route){
+		func(r *router.Route) { r.Service = "test_service" },
+		func(r *router.Route) { r.Retries = -1 },
+		func(r *router.Route) { r.LoadBalancing = "random" },
+		func(r *router.Route) { r.MaxRequestBodySize = -1 },
+		func(r *router.Route) { r.CORS = &router.CORS{} },
+	} {
+		invalid := *r
+		update(&invalid)
+		err := srv.UpdateRoute(&invalid)
+		c.Assert(httphelper.IsValidationError(err), Equals, true, Commentf("route: %+v, err: %v", invalid, err))
+	}
+	got, err := srv.GetRoute("http", r.ID)
+	c.Assert(err, IsNil)
+	c.Assert(got.Service, Equals, "test")
+}
+
 func (s *S) TestAPISetTCPRoute(c *C) {
 	srv := s.newTestAPIServer(c)
 	defer srv.Close()
@@ -295,3 +360,55 @@ func (s *S) TestStreamEvents(c *C) {
 		c.Fatal("Timed out waiting for remove event")
 	}
 }
+
+func (s *S) TestAPIMetrics(c *C) {
+	srv := s.newTestAPIServer(c)
+	defer srv.Close()
+	l := srv.listeners[0].(*HTTPListener)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/missing" {
+			w.WriteHeader(404)
+		}
+	}))
+	defer backend.Close()
+
+	addRoute(c, l, router.HTTPRoute{Domain: "metrics.example.com", Service: "metrics-test"}.ToRoute())
+	unregister := discoverdRegisterHTTPService(c, l, "metrics-test", backend.Listener.Addr().String())
+	defer unregister()
+
+	get := func(path string) {
+		req, err := http.NewRequest("GET", "http://"+l.Addr+path, nil)
+		c.Assert(err, IsNil)
+		req.Host = "metrics.example.com"
+		res, err := httpClient.Do(req)
+		c.Assert(err, IsNil)
+		res.Body.Close()
+	}
+	getMetrics := func() string {
+		res, err := http.Get(srv.URL + "/metrics")
+		c.Assert(err, IsNil)
+		defer res.Body.Close()
+		c.Assert(res.StatusCode, Equals, 200)
+		data, err := ioutil.ReadAll(res.Body)
+		c.Assert(err, IsNil)
+		return string(data)
+	}
+
+	labels := `domain="metrics.example.com",path="/",service="metrics-test"`
+	for i := 0; i < 3; i++ {
+		get("/")
+	}
+	get("/missing")
+	metrics := getMetrics()
+	c.Assert(strings.Contains(metrics, `router_http_requests_total{`+labels+`,code="200"} 3`), Equals, true, Commentf("metrics:\n%s", metrics))
+	c.Assert(strings.Contains(metrics, `router_http_requests_total{`+labels+`,code="404"} 1`), Equals, true, Commentf("metrics:\n%s", metrics))
+	c.Assert(strings.Contains(metrics, `router_http_request_duration_seconds_bucket{`+labels+`,le="+Inf"} 4`), Equals, true, Commentf("metrics:\n%s", metrics))
+	c.Assert(strings.Contains(metrics, `router_http_request_duration_seconds_count{`+labels+`} 4`), Equals, true, Commentf("metrics:\n%s", metrics))
+
+	// the counters increment with further requests
+	get("/")
+	metrics = getMetrics()
+	c.Assert(strings.Contains(metrics, `router_http_requests_total{`+labels+`,code="200"} 4`), Equals, true, Commentf("metrics:\n%s", metrics))
+	c.Assert(strings.Contains(metrics, `router_http_request_duration_seconds_count{`+labels+`} 5`), Equals, true, Commentf("metrics:\n%s", metrics))
+}
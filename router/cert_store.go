@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/tls"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// certStore maps the domains of HTTP routes to their TLS keypairs for
+// looking up certificates by SNI server name during TLS handshakes.
+//
+// The map is copy-on-write: lookups load an immutable snapshot without
+// locking, and updates copy the current map, modify the copy and then swap
+// it in atomically, so a handshake always sees a consistent set of
+// certificates even whilst certificates are being rotated.
+type certStore struct {
+	// mtx serializes updates, lookups don't acquire it
+	mtx   sync.Mutex
+	certs atomic.Value // map[string]*tls.Certificate
+}
+
+func newCertStore() *certStore {
+	s := &certStore{}
+	s.certs.Store(make(map[string]*tls.Certificate))
+	return s
+}
+
+func (s *certStore) load() map[string]*tls.Certificate {
+	return s.certs.Load().(map[string]*tls.Certificate)
+}
+
+// Get returns the keypair for serverName from the domains returned by
+// certDomains, so an exact domain takes precedence over wildcard domains.
+// Domains whose routes have no certificate are skipped in favour of a less
+// specific domain with one (e.g. an exact route without a certificate uses
+// the certificate of a matching wildcard route), as it is more likely to
+// be valid for serverName than the listener's default keypair. The keypair
+// is nil if none of the matching routes have a certificate (so the default
+// keypair is used), and ok is false if there is no route for serverName.
+func (s *certStore) Get(serverName string) (keypair *tls.Certificate, ok bool) {
+	certs := s.load()
+	for _, domain := range certDomains(strings.ToLower(serverName)) {
+		kp, exists := certs[domain]
+		if !exists {
+			continue
+		}
+		if kp != nil {
+			return kp, true
+		}
+		ok = true
+	}
+	return nil, ok
+}
+
+// certDomains returns the domains which may match serverName in order of
+// precedence, which is serverName itself followed by wildcard domains up to
+// 5 subdomains deep from most to least specific, in the same way as routing
+// requests.
+func certDomains(serverName string) []string {
+	d := strings.SplitN(serverName, ".", 5)
+	domains := make([]string, 0, len(d)+1)
+	domains = append(domains, serverName)
+	for i := len(d); i > 0; i-- {
+		domains = append(domains, "*."+strings.Join(d[len(d)-i:], "."))
+	}
+	return domains
+}
+
+// Set sets the keypair for domain, which may be nil if the domain's route
+// has no certificate.
+func (s *certStore) Set(domain string, keypair *tls.Certificate) {
+	s.update(func(certs map[string]*tls.Certificate) {
+		certs[strings.ToLower(domain)] = keypair
+	})
+}
+
+// Remove removes the keypair for domain.
+func (s *certStore) Remove(domain string) {
+	s.update(func(certs map[string]*tls.Certificate) {
+		delete(certs, strings.ToLower(domain))
+	})
+}
+
+func (s *certStore) update(f func(map[string]*tls.Certificate)) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	prev := s.load()
+	next := make(map[string]*tls.Certificate, len(prev)+1)
+	for domain, keypair := range prev {
+		next[domain] = keypair
+	}
+	f(next)
+	s.certs.Store(next)
+}
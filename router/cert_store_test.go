@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/tls"
+	"sync"
+
+	. "github.com/flynn/go-check"
+)
+
+func (s *S) TestCertStoreGet(c *C) {
+	store := newCertStore()
+	exact, wildcard := &tls.Certificate{}, &tls.Certificate{}
+	store.Set("Example.com", exact)
+	store.Set("*.example.com", wildcard)
+	store.Set("nocert.example.org", nil)
+
+	for _, t := range []struct {
+		name    string
+		keypair *tls.Certificate
+		ok      bool
+	}{
+		{"example.com", exact, true},
+		{"EXAMPLE.COM", exact, true},
+		{"foo.example.com", wildcard, true},
+		{"a.b.c.example.com", wildcard, true},
+		{"nocert.example.org", nil, true},
+		{"example.org", nil, false},
+	} {
+		keypair, ok := store.Get(t.name)
+		c.Assert(ok, Equals, t.ok, Commentf("%s", t.name))
+		c.Assert(keypair == t.keypair, Equals, true, Commentf("%s", t.name))
+	}
+
+	store.Remove("*.example.com")
+	_, ok := store.Get("foo.example.com")
+	c.Assert(ok, Equals, false)
+}
+
+// TestCertStorePrecedence checks that an exact domain's certificate takes
+// precedence over a wildcard certificate, as when migrating a domain from a
+// wildcard certificate to its own.
+func (s *S) TestCertStorePrecedence(c *C) {
+	store := newCertStore()
+	exact, wildcard, nested := &tls.Certificate{}, &tls.Certificate{}, &tls.Certificate{}
+	store.Set("*.example.org", wildcard)
+	store.Set("a.example.org", exact)
+	store.Set("*.b.example.org", nested)
+	store.Set("nocert.example.org", nil)
+	store.Set("*.nocert.example.net", nil)
+
+	for _, t := range []struct {
+		name    string
+		keypair *tls.Certificate
+		ok      bool
+	}{
+		// the exact certificate wins over the wildcard
+		{"a.example.org", exact, true},
+		// only the wildcard matches
+		{"c.example.org", wildcard, true},
+		// the most specific wildcard wins
+		{"c.b.example.org", nested, true},
+		{"c.d.example.org", wildcard, true},
+		// an exact route without a certificate uses the wildcard one
+		{"nocert.example.org", wildcard, true},
+		// a matching route without any certificate uses the default
+		{"a.nocert.example.net", nil, true},
+		// nothing matches, so the handshake either uses the default
+		// certificate or fails
+		{"example.net", nil, false},
+	} {
+		keypair, ok := store.Get(t.name)
+		c.Assert(ok, Equals, t.ok, Commentf("%s", t.name))
+		c.Assert(keypair == t.keypair, Equals, true, Commentf("%s", t.name))
+	}
+
+	// removing the exact certificate falls back to the wildcard
+	store.Remove("a.example.org")
+	keypair, ok := store.Get("a.example.org")
+	c.Assert(ok, Equals, true)
+	c.Assert(keypair == wildcard, Equals, true)
+}
+
+// TestCertStoreConcurrentRotation rotates certificates whilst concurrently
+// looking them up, and should be run with the race detector.
+func (s *S) TestCertStoreConcurrentRotation(c *C) {
+	store := newCertStore()
+	keypairs := []*tls.Certificate{{}, {}, {}}
+	store.Set("example.com", keypairs[0])
+	store.Set("*.example.com", keypairs[0])
+	valid := func(kp *tls.Certificate) bool {
+		for _, k := range keypairs {
+			if kp == k {
+				return true
+			}
+		}
+		return false
+	}
+
+	stop := make(chan struct{})
+	errs := make(chan string, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				for _, name := range []string{"example.com", "foo.example.com"} {
+					if kp, ok := store.Get(name); !ok || !valid(kp) {
+						errs <- name
+						return
+					}
+				}
+			}
+		}()
+	}
+	for i := 0; i < 1000; i++ {
+		kp := keypairs[i%len(keypairs)]
+		store.Set("example.com", kp)
+		store.Set("*.example.com", kp)
+		// domains being added and removed must not affect lookups of
+		// other domains
+		store.Set("other.example.org", kp)
+		store.Remove("other.example.org")
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for name := range errs {
+		c.Errorf("lookup of %s returned an inconsistent keypair", name)
+	}
+}
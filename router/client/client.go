@@ -54,6 +54,10 @@ func NewWithAddr(addr string) Client {
 type Client interface {
 	// CreateRoute creates a new route.
 	CreateRoute(*router.Route) error
+	// ImportRoutes creates the given HTTP routes and their certificates in
+	// bulk, deduplicating certificates. It is intended for restoring a large
+	// number of routes from a dump.
+	ImportRoutes([]*router.Route) error
 	// UpdateRoute updates an existing route by overwriting all fields on the route
 	// except ID and Domain.
 	UpdateRoute(*router.Route) error
@@ -82,6 +86,10 @@ func (c *client) CreateRoute(r *router.Route) error {
 	return c.Post("/routes", r, r)
 }
 
+func (c *client) ImportRoutes(routes []*router.Route) error {
+	return c.Post("/routes/import", routes, &routes)
+}
+
 func (c *client) UpdateRoute(r *router.Route) error {
 	return c.Put("/routes/"+r.Type+"/"+r.ID, r, r)
 }
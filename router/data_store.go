@@ -2,12 +2,16 @@ package main
 
 import (
 	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/flynn/flynn/pkg/random"
 	"github.com/flynn/flynn/router/types"
 	"github.com/jackc/pgx"
 	"golang.org/x/net/context"
@@ -20,6 +24,7 @@ var ErrInvalid = errors.New("router: invalid route")
 type DataStore interface {
 	Add(route *router.Route) error
 	AddCert(cert *router.Certificate) error
+	Import(routes []*router.Route) error
 	Update(route *router.Route) error
 	Get(id string) (*router.Route, error)
 	GetCert(id string) (*router.Certificate, error)
@@ -86,8 +91,8 @@ func (d *pgDataStore) Ping() error {
 }
 
 const sqlAddRouteHTTP = `
-INSERT INTO ` + tableNameHTTP + ` (parent_ref, service, leader, domain, sticky, path)
-	VALUES ($1, $2, $3, $4, $5, $6)
+INSERT INTO ` + tableNameHTTP + ` (parent_ref, service, leader, domain, sticky, path, access_log, idle_timeout_ms, max_request_body_size, max_response_body_size, health_check_path, health_check_interval_ms, health_check_unhealthy_threshold, compress, external_key, aliases, retries, retry_non_idempotent, cors, backend_max_idle_conns, backend_idle_timeout_ms, request_timeout_ms, backend_tls, backend_host, forwarded_host, load_balancing)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26)
 	RETURNING id, created_at, updated_at`
 
 const sqlAddRouteTCP = `
@@ -127,6 +132,26 @@ func (d *pgDataStore) addHTTP(r *router.Route) error {
 		r.Domain,
 		r.Sticky,
 		r.Path,
+		r.AccessLog,
+		durationToMillis(r.IdleTimeout),
+		r.MaxRequestBodySize,
+		r.MaxResponseBodySize,
+		healthCheckPath(r.HealthCheck),
+		healthCheckIntervalMillis(r.HealthCheck),
+		healthCheckUnhealthyThreshold(r.HealthCheck),
+		r.Compress,
+		r.ExternalKey,
+		aliases(r),
+		r.Retries,
+		r.RetryNonIdempotent,
+		r.CORS,
+		r.BackendMaxIdleConns,
+		durationToMillis(r.BackendIdleTimeout),
+		durationToMillis(r.RequestTimeout),
+		r.BackendTLS,
+		r.BackendHost,
+		r.ForwardedHost,
+		r.LoadBalancing,
 	).Scan(&r.ID, &r.CreatedAt, &r.UpdatedAt); err != nil {
 		tx.Rollback()
 		return err
@@ -154,7 +179,7 @@ SELECT id, created_at, updated_at FROM ` + tableNameCertificates + `
 
 const sqlAddCert = `
 INSERT INTO ` + tableNameCertificates + ` (cert, key, cert_sha256)
-	VALUES ($1, $2, $3)
+	VALUES ($1, NULLIF($2, ''), $3)
 	RETURNING id, created_at, updated_at
 `
 
@@ -183,7 +208,7 @@ func (d *pgDataStore) addCertWithTx(tx *pgx.Tx, c *router.Certificate) error {
 	c.Cert = strings.Trim(c.Cert, " \n")
 	c.Key = strings.Trim(c.Key, " \n")
 	tlsCertSHA256 := sha256.Sum256([]byte(c.Cert))
-	if err := tx.QueryRow(sqlSelectCert, tlsCertSHA256[:]).Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt); err != nil {
+	if err := tx.QueryRow("certificate_select_by_sha256", tlsCertSHA256[:]).Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt); err != nil {
 		if err := tx.QueryRow(sqlAddCert, c.Cert, c.Key, tlsCertSHA256[:]).Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt); err != nil {
 			return err
 		}
@@ -223,9 +248,276 @@ func (d *pgDataStore) addRouteCertWithTx(tx *pgx.Tx, r *router.Route) error {
 		CreatedAt: cert.CreatedAt,
 		UpdatedAt: cert.UpdatedAt,
 	}
+	r.CertSHA256 = certSHA256(cert.Cert)
 	return nil
 }
 
+// certSHA256 returns the hex encoded SHA256 digest of the given PEM encoded
+// certificate, ignoring leading and trailing whitespace.
+func certSHA256(cert string) string {
+	digest := sha256.Sum256([]byte(strings.Trim(cert, " \n")))
+	return hex.EncodeToString(digest[:])
+}
+
+const sqlImportSelectCerts = `
+SELECT id, encode(cert_sha256, 'hex'), created_at, updated_at FROM ` + tableNameCertificates + `
+	WHERE cert_sha256 = ANY($1::bytea[]) AND deleted_at IS NULL`
+
+const sqlImportCerts = `
+INSERT INTO ` + tableNameCertificates + ` (cert, key, cert_sha256)
+	SELECT cert, NULLIF(key, ''), cert_sha256 FROM unnest($1::text[], $2::text[], $3::bytea[]) AS c (cert, key, cert_sha256)
+	RETURNING id, encode(cert_sha256, 'hex'), created_at, updated_at`
+
+const sqlImportRoutesHTTP = `
+INSERT INTO ` + tableNameHTTP + ` (id, parent_ref, service, leader, domain, sticky, path, access_log, idle_timeout_ms, max_request_body_size, max_response_body_size, health_check_path, health_check_interval_ms, health_check_unhealthy_threshold, compress, external_key, aliases, retries, retry_non_idempotent, cors, backend_max_idle_conns, backend_idle_timeout_ms, request_timeout_ms, backend_tls, backend_host, forwarded_host, load_balancing)
+	SELECT id::uuid, parent_ref, service, leader, domain, sticky, path, access_log, idle_timeout_ms, max_request_body_size, max_response_body_size, health_check_path, health_check_interval_ms, health_check_unhealthy_threshold, compress, external_key, COALESCE(string_to_array(NULLIF(aliases, ''), ','), '{}'), retries, retry_non_idempotent, NULLIF(cors, '')::jsonb, backend_max_idle_conns, backend_idle_timeout_ms, request_timeout_ms, NULLIF(backend_tls, '')::jsonb, backend_host, forwarded_host, load_balancing FROM unnest(
+		$1::text[], $2::text[], $3::text[], $4::bool[], $5::text[], $6::bool[], $7::text[], $8::bool[], $9::bigint[], $10::bigint[], $11::bigint[], $12::text[], $13::bigint[], $14::integer[], $15::bool[], $16::bool[], $17::text[], $18::integer[], $19::bool[], $20::text[], $21::integer[], $22::bigint[], $23::bigint[], $24::text[], $25::text[], $26::bool[], $27::text[]
+	) WITH ORDINALITY AS r (id, parent_ref, service, leader, domain, sticky, path, access_log, idle_timeout_ms, max_request_body_size, max_response_body_size, health_check_path, health_check_interval_ms, health_check_unhealthy_threshold, compress, external_key, aliases, retries, retry_non_idempotent, cors, backend_max_idle_conns, backend_idle_timeout_ms, request_timeout_ms, backend_tls, backend_host, forwarded_host, load_balancing, n)
+	ORDER BY n
+	RETURNING id, path, created_at, updated_at`
+
+const sqlImportRouteCertificates = `
+INSERT INTO ` + tableNameRoutesCertificate + ` (http_route_id, certificate_id)
+	SELECT http_route_id::uuid, certificate_id::uuid FROM unnest($1::text[], $2::text[])
+	AS rc (http_route_id, certificate_id)`
+
+// Import inserts the given HTTP routes and their certificates in a single
+// transaction using a fixed number of statements, which is much faster than
+// calling Add for each route when restoring a large number of routes.
+// Certificates are deduplicated by their SHA256 digest, both within routes
+// and against certificates which already exist.
+func (d *pgDataStore) Import(routes []*router.Route) error {
+	if d.routeType != routeTypeHTTP {
+		return fmt.Errorf("router: importing %s routes is not supported", d.routeType)
+	}
+	if len(routes) == 0 {
+		return nil
+	}
+
+	// default routes must be inserted before any path based routes on the
+	// same domain so that the check_http_route_update trigger can see them
+	sorted := make(defaultRoutesFirst, len(routes))
+	copy(sorted, routes)
+	sort.Stable(sorted)
+
+	certs := make(map[string]*router.Certificate)
+	routeCerts := make(map[string]*router.Certificate, len(sorted))
+	var (
+		ids, parentRefs, services, domains, paths       []string
+		routeAliases, corses, backendTLSes              []string
+		backendHosts, loadBalancings                    []string
+		leaders, stickies, accessLogs, compresses       []bool
+		externalKeys, retryNonIdempotents               []bool
+		forwardedHosts                                  []bool
+		retries, backendMaxIdleConns                    []int32
+		backendIdleTimeouts, requestTimeouts            []int64
+		idleTimeouts, maxRequestSizes, maxResponseSizes []int64
+		healthCheckPaths                                []string
+		healthCheckIntervals                            []int64
+		healthCheckThresholds                           []int32
+	)
+	for _, r := range sorted {
+		r.ID = random.UUID()
+		r.Type = d.routeType
+		ids = append(ids, r.ID)
+		parentRefs = append(parentRefs, r.ParentRef)
+		services = append(services, r.Service)
+		leaders = append(leaders, r.Leader)
+		domains = append(domains, r.Domain)
+		stickies = append(stickies, r.Sticky)
+		paths = append(paths, r.Path)
+		accessLogs = append(accessLogs, r.AccessLog)
+		idleTimeouts = append(idleTimeouts, durationToMillis(r.IdleTimeout))
+		maxRequestSizes = append(maxRequestSizes, r.MaxRequestBodySize)
+		maxResponseSizes = append(maxResponseSizes, r.MaxResponseBodySize)
+		healthCheckPaths = append(healthCheckPaths, healthCheckPath(r.HealthCheck))
+		healthCheckIntervals = append(healthCheckIntervals, healthCheckIntervalMillis(r.HealthCheck))
+		healthCheckThresholds = append(healthCheckThresholds, healthCheckUnhealthyThreshold(r.HealthCheck))
+		compresses = append(compresses, r.Compress)
+		externalKeys = append(externalKeys, r.ExternalKey)
+		// multidimensional arrays can't be unnested into rows of arrays,
+		// so aliases are joined and split again in the query
+		routeAliases = append(routeAliases, strings.Join(r.Aliases, ","))
+		retries = append(retries, int32(r.Retries))
+		retryNonIdempotents = append(retryNonIdempotents, r.RetryNonIdempotent)
+		// jsonb arrays can't be encoded, so CORS and backend TLS
+		// settings are passed as JSON text (empty for none) and cast in the
+		// query
+		cors, err := corsJSON(r.CORS)
+		if err != nil {
+			return err
+		}
+		corses = append(corses, cors)
+		backendMaxIdleConns = append(backendMaxIdleConns, int32(r.BackendMaxIdleConns))
+		backendIdleTimeouts = append(backendIdleTimeouts, durationToMillis(r.BackendIdleTimeout))
+		requestTimeouts = append(requestTimeouts, durationToMillis(r.RequestTimeout))
+		backendTLS, err := backendTLSJSON(r.BackendTLS)
+		if err != nil {
+			return err
+		}
+		backendTLSes = append(backendTLSes, backendTLS)
+		backendHosts = append(backendHosts, r.BackendHost)
+		forwardedHosts = append(forwardedHosts, r.ForwardedHost)
+		loadBalancings = append(loadBalancings, r.LoadBalancing)
+
+		cert := r.Certificate
+		if r.LegacyTLSCert != "" || r.LegacyTLSKey != "" {
+			cert = &router.Certificate{Cert: r.LegacyTLSCert, Key: r.LegacyTLSKey}
+		}
+		if cert == nil {
+			continue
+		}
+		certPEM := strings.Trim(cert.Cert, " \n")
+		digest := sha256.Sum256([]byte(certPEM))
+		sha := hex.EncodeToString(digest[:])
+		if _, ok := certs[sha]; !ok {
+			certs[sha] = &router.Certificate{
+				Cert: certPEM,
+				Key:  strings.Trim(cert.Key, " \n"),
+			}
+		}
+		routeCerts[r.ID] = certs[sha]
+	}
+
+	tx, err := d.pgx.Begin()
+	if err != nil {
+		return err
+	}
+	if err := d.importCertsWithTx(tx, certs); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	rows, err := tx.Query(sqlImportRoutesHTTP, ids, parentRefs, services, leaders, domains, stickies, paths, accessLogs, idleTimeouts, maxRequestSizes, maxResponseSizes, healthCheckPaths, healthCheckIntervals, healthCheckThresholds, compresses, externalKeys, routeAliases, retries, retryNonIdempotents, corses, backendMaxIdleConns, backendIdleTimeouts, requestTimeouts, backendTLSes, backendHosts, forwardedHosts, loadBalancings)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	byID := make(map[string]*router.Route, len(sorted))
+	for _, r := range sorted {
+		byID[r.ID] = r
+	}
+	for rows.Next() {
+		var id, path string
+		var createdAt, updatedAt time.Time
+		if err := rows.Scan(&id, &path, &createdAt, &updatedAt); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return err
+		}
+		r := byID[id]
+		r.Path = path
+		r.CreatedAt = createdAt
+		r.UpdatedAt = updatedAt
+	}
+	if err := rows.Err(); err != nil {
+		tx.Rollback()
+		if postgres.IsUniquenessError(err, "") {
+			err = ErrConflict
+		} else if postgres.IsPostgresCode(err, postgres.RaiseException) {
+			err = ErrInvalid
+		}
+		return err
+	}
+
+	if len(routeCerts) > 0 {
+		routeIDs := make([]string, 0, len(routeCerts))
+		certIDs := make([]string, 0, len(routeCerts))
+		for _, r := range sorted {
+			cert, ok := routeCerts[r.ID]
+			if !ok {
+				continue
+			}
+			routeIDs = append(routeIDs, r.ID)
+			certIDs = append(certIDs, cert.ID)
+			r.LegacyTLSCert = ""
+			r.LegacyTLSKey = ""
+			r.Certificate = &router.Certificate{
+				ID:        cert.ID,
+				Cert:      cert.Cert,
+				Key:       cert.Key,
+				CreatedAt: cert.CreatedAt,
+				UpdatedAt: cert.UpdatedAt,
+			}
+			r.CertSHA256 = certSHA256(cert.Cert)
+		}
+		if _, err := tx.Exec(sqlImportRouteCertificates, routeIDs, certIDs); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// importCertsWithTx sets the ID and timestamps of the given certificates
+// (keyed by hex encoded SHA256 digest), inserting any which don't exist.
+func (d *pgDataStore) importCertsWithTx(tx *pgx.Tx, certs map[string]*router.Certificate) error {
+	if len(certs) == 0 {
+		return nil
+	}
+	digests := make([][]byte, 0, len(certs))
+	for sha := range certs {
+		digest, _ := hex.DecodeString(sha)
+		digests = append(digests, digest)
+	}
+	scanCerts := func(rows *pgx.Rows) error {
+		defer rows.Close()
+		for rows.Next() {
+			var id, sha string
+			var createdAt, updatedAt time.Time
+			if err := rows.Scan(&id, &sha, &createdAt, &updatedAt); err != nil {
+				return err
+			}
+			if cert, ok := certs[sha]; ok {
+				cert.ID = id
+				cert.CreatedAt = createdAt
+				cert.UpdatedAt = updatedAt
+			}
+		}
+		return rows.Err()
+	}
+
+	rows, err := tx.Query(sqlImportSelectCerts, digests)
+	if err != nil {
+		return err
+	}
+	if err := scanCerts(rows); err != nil {
+		return err
+	}
+
+	var certPEMs, keys []string
+	var missing [][]byte
+	for sha, cert := range certs {
+		if cert.ID != "" {
+			continue
+		}
+		digest, _ := hex.DecodeString(sha)
+		certPEMs = append(certPEMs, cert.Cert)
+		keys = append(keys, cert.Key)
+		missing = append(missing, digest)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	rows, err = tx.Query(sqlImportCerts, certPEMs, keys, missing)
+	if err != nil {
+		return err
+	}
+	return scanCerts(rows)
+}
+
+type defaultRoutesFirst []*router.Route
+
+func (p defaultRoutesFirst) Len() int      { return len(p) }
+func (p defaultRoutesFirst) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p defaultRoutesFirst) Less(i, j int) bool {
+	return isDefaultPath(p[i].Path) && !isDefaultPath(p[j].Path)
+}
+
+func isDefaultPath(path string) bool {
+	return path == "" || path == "/"
+}
+
 const sqlGetCert = `
 SELECT ` + selectColumnsHTTPCert + `, ARRAY(
 	SELECT http_route_id::varchar FROM ` + tableNameRoutesCertificate + ` WHERE certificate_id = $1
@@ -234,7 +526,7 @@ SELECT ` + selectColumnsHTTPCert + `, ARRAY(
 
 func (d *pgDataStore) GetCert(id string) (*router.Certificate, error) {
 	cert := &router.Certificate{Routes: []string{}}
-	if err := d.pgx.QueryRow(sqlGetCert, id).Scan(&cert.ID, &cert.Cert, &cert.Key, &cert.CreatedAt, &cert.UpdatedAt, &cert.Routes); err != nil {
+	if err := d.pgx.QueryRow("certificate_select", id).Scan(&cert.ID, &cert.Cert, &cert.Key, &cert.CreatedAt, &cert.UpdatedAt, &cert.Routes); err != nil {
 		return nil, err
 	}
 	return cert, nil
@@ -247,7 +539,7 @@ SELECT ` + selectColumnsHTTPCert + `, ARRAY(
 `
 
 func (d *pgDataStore) ListCerts() ([]*router.Certificate, error) {
-	rows, err := d.pgx.Query(sqlListCerts)
+	rows, err := d.pgx.Query("certificate_list")
 	if err != nil {
 		return nil, err
 	}
@@ -271,7 +563,7 @@ INNER JOIN ` + tableNameRoutesCertificate + ` AS rc
 `
 
 func (d *pgDataStore) ListCertRoutes(id string) ([]*router.Route, error) {
-	rows, err := d.pgx.Query(sqlListCertRoutes, id)
+	rows, err := d.pgx.Query("certificate_route_list", id)
 	if err != nil {
 		return nil, err
 	}
@@ -316,7 +608,12 @@ func (d *pgDataStore) RemoveCert(id string) error {
 
 const sqlUpdateRouteHTTP = `
 UPDATE ` + tableNameHTTP + ` AS r
-	SET parent_ref = $1, service = $2, leader = $3, sticky = $4, path = $5
+	SET parent_ref = $1, service = $2, leader = $3, sticky = $4, path = $5, access_log = $8, idle_timeout_ms = $9,
+		max_request_body_size = $10, max_response_body_size = $11, health_check_path = $12,
+		health_check_interval_ms = $13, health_check_unhealthy_threshold = $14, compress = $15, external_key = $16,
+		aliases = $17, retries = $18, retry_non_idempotent = $19, cors = $20,
+		backend_max_idle_conns = $21, backend_idle_timeout_ms = $22, request_timeout_ms = $23, backend_tls = $24,
+		backend_host = $25, forwarded_host = $26, load_balancing = $27
 	WHERE id = $6 AND domain = $7 AND deleted_at IS NULL
 	RETURNING %s`
 
@@ -354,6 +651,26 @@ func (d *pgDataStore) updateHTTP(r *router.Route) error {
 		r.Path,
 		r.ID,
 		r.Domain,
+		r.AccessLog,
+		durationToMillis(r.IdleTimeout),
+		r.MaxRequestBodySize,
+		r.MaxResponseBodySize,
+		healthCheckPath(r.HealthCheck),
+		healthCheckIntervalMillis(r.HealthCheck),
+		healthCheckUnhealthyThreshold(r.HealthCheck),
+		r.Compress,
+		r.ExternalKey,
+		aliases(r),
+		r.Retries,
+		r.RetryNonIdempotent,
+		r.CORS,
+		r.BackendMaxIdleConns,
+		durationToMillis(r.BackendIdleTimeout),
+		durationToMillis(r.RequestTimeout),
+		r.BackendTLS,
+		r.BackendHost,
+		r.ForwardedHost,
+		r.LoadBalancing,
 	)); err != nil {
 		tx.Rollback()
 		return err
@@ -378,35 +695,56 @@ func (d *pgDataStore) updateTCP(r *router.Route) error {
 
 const sqlRemoveRoute = `UPDATE %s SET deleted_at = now() WHERE id = $1`
 
+// sqlRemoveRouteCerts removes the certificate associations of a deleted
+// route and deletes any of its certificates which are no longer used by
+// another route (the outer UPDATE doesn't see the rows deleted by the CTE,
+// hence the explicit route check).
+const sqlRemoveRouteCerts = `
+WITH deleted AS (
+	DELETE FROM ` + tableNameRoutesCertificate + ` WHERE http_route_id = $1 RETURNING certificate_id
+)
+UPDATE ` + tableNameCertificates + ` AS c SET deleted_at = now()
+	WHERE c.id IN (SELECT certificate_id FROM deleted) AND c.deleted_at IS NULL
+	AND NOT EXISTS (
+		SELECT 1 FROM ` + tableNameRoutesCertificate + ` AS rc
+		WHERE rc.certificate_id = c.id AND rc.http_route_id <> $1
+	)`
+
 func (d *pgDataStore) Remove(id string) error {
-	_, err := d.pgx.Exec(fmt.Sprintf(sqlRemoveRoute, d.tableName), id)
-	if postgres.IsPostgresCode(err, postgres.RaiseException) {
-		err = ErrInvalid
+	tx, err := d.pgx.Begin()
+	if err != nil {
+		return err
 	}
-	return err
+	if _, err := tx.Exec(fmt.Sprintf(sqlRemoveRoute, d.tableName), id); err != nil {
+		tx.Rollback()
+		if postgres.IsPostgresCode(err, postgres.RaiseException) {
+			err = ErrInvalid
+		}
+		return err
+	}
+	if d.tableName == tableNameHTTP {
+		if _, err := tx.Exec(sqlRemoveRouteCerts, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
 const sqlGetHTTPRoute = `
-SELECT %s FROM %s AS r
-	LEFT OUTER JOIN %s AS rc ON r.id = rc.http_route_id
-	LEFT OUTER JOIN %s AS c ON c.id = rc.certificate_id
+SELECT ` + selectColumnsHTTP + `, ` + selectColumnsHTTPCert + `, ` + selectColumnsHTTPCertSHA256 + ` FROM ` + tableNameHTTP + ` AS r
+	LEFT OUTER JOIN ` + tableNameRoutesCertificate + ` AS rc ON r.id = rc.http_route_id
+	LEFT OUTER JOIN ` + tableNameCertificates + ` AS c ON c.id = rc.certificate_id
 	WHERE r.id = $1 AND r.deleted_at IS NULL`
 
-const sqlGetTCPRoute = `SELECT %s FROM %s WHERE id = $1 AND deleted_at IS NULL`
+const sqlGetTCPRoute = `SELECT ` + selectColumnsTCP + ` FROM ` + tableNameTCP + ` WHERE id = $1 AND deleted_at IS NULL`
 
 func (d *pgDataStore) Get(id string) (*router.Route, error) {
 	if id == "" {
 		return nil, ErrNotFound
 	}
 
-	var query string
-	switch d.tableName {
-	case tableNameHTTP:
-		query = fmt.Sprintf(sqlGetHTTPRoute, d.columnNames(), d.tableName, tableNameRoutesCertificate, tableNameCertificates)
-	case tableNameTCP:
-		query = fmt.Sprintf(sqlGetTCPRoute, d.columnNames(), d.tableName)
-	}
-	row := d.pgx.QueryRow(query, id)
+	row := d.pgx.QueryRow(d.routeType+"_route_select", id)
 
 	r := &router.Route{}
 	err := d.scanRoute(r, row)
@@ -421,22 +759,15 @@ func (d *pgDataStore) Get(id string) (*router.Route, error) {
 }
 
 const sqlListHTTPRoutes = `
-SELECT %s FROM %s AS r
-	LEFT OUTER JOIN %s AS rc ON r.id = rc.http_route_id
-	LEFT OUTER JOIN %s AS c ON c.id = rc.certificate_id
+SELECT ` + selectColumnsHTTP + `, ` + selectColumnsHTTPCert + `, ` + selectColumnsHTTPCertSHA256 + ` FROM ` + tableNameHTTP + ` AS r
+	LEFT OUTER JOIN ` + tableNameRoutesCertificate + ` AS rc ON r.id = rc.http_route_id
+	LEFT OUTER JOIN ` + tableNameCertificates + ` AS c ON c.id = rc.certificate_id
 	WHERE r.deleted_at IS NULL`
 
-const sqlListTCPRoutes = `SELECT %s FROM %s WHERE deleted_at IS NULL`
+const sqlListTCPRoutes = `SELECT ` + selectColumnsTCP + ` FROM ` + tableNameTCP + ` WHERE deleted_at IS NULL`
 
 func (d *pgDataStore) List() ([]*router.Route, error) {
-	var query string
-	switch d.tableName {
-	case tableNameHTTP:
-		query = fmt.Sprintf(sqlListHTTPRoutes, d.columnNames(), d.tableName, tableNameRoutesCertificate, tableNameCertificates)
-	case tableNameTCP:
-		query = fmt.Sprintf(sqlListTCPRoutes, d.columnNames(), d.tableName)
-	}
-	rows, err := d.pgx.Query(query)
+	rows, err := d.pgx.Query(d.routeType + "_route_list")
 	if err != nil {
 		return nil, err
 	}
@@ -563,15 +894,16 @@ func (d *pgDataStore) startListener(ctx context.Context) (<-chan string, <-chan
 }
 
 const (
-	selectColumnsHTTP     = "r.id, r.parent_ref, r.service, r.leader, r.domain, r.sticky, r.path, r.created_at, r.updated_at"
-	selectColumnsHTTPCert = "c.id, c.cert, c.key, c.created_at, c.updated_at"
-	selectColumnsTCP      = "id, parent_ref, service, leader, port, created_at, updated_at"
+	selectColumnsHTTP           = "r.id, r.parent_ref, r.service, r.leader, r.domain, r.sticky, r.path, r.access_log, r.idle_timeout_ms, r.max_request_body_size, r.max_response_body_size, r.health_check_path, r.health_check_interval_ms, r.health_check_unhealthy_threshold, r.compress, r.external_key, r.aliases, r.retries, r.retry_non_idempotent, r.cors, r.backend_max_idle_conns, r.backend_idle_timeout_ms, r.request_timeout_ms, r.backend_tls, r.backend_host, r.forwarded_host, r.load_balancing, r.created_at, r.updated_at"
+	selectColumnsHTTPCert       = "c.id, c.cert, COALESCE(c.key, ''), c.created_at, c.updated_at"
+	selectColumnsHTTPCertSHA256 = "encode(c.cert_sha256, 'hex')"
+	selectColumnsTCP            = "id, parent_ref, service, leader, port, created_at, updated_at"
 )
 
 func (d *pgDataStore) columnNames() string {
 	switch d.routeType {
 	case routeTypeHTTP:
-		return selectColumnsHTTP + ", " + selectColumnsHTTPCert
+		return selectColumnsHTTP + ", " + selectColumnsHTTPCert + ", " + selectColumnsHTTPCertSHA256
 	case routeTypeTCP:
 		return selectColumnsTCP
 	default:
@@ -587,7 +919,13 @@ func (d *pgDataStore) scanRouteWithoutCert(route *router.Route, s scannable) err
 	route.Type = d.routeType
 	switch d.tableName {
 	case tableNameHTTP:
-		return s.Scan(
+		var idleTimeout, hcInterval int64
+		var hcPath string
+		var hcThreshold, retries, backendMaxIdleConns int32
+		var backendIdleTimeout, requestTimeout int64
+		var cors *router.CORS
+		var backendTLS *router.BackendTLS
+		if err := s.Scan(
 			&route.ID,
 			&route.ParentRef,
 			&route.Service,
@@ -595,9 +933,43 @@ func (d *pgDataStore) scanRouteWithoutCert(route *router.Route, s scannable) err
 			&route.Domain,
 			&route.Sticky,
 			&route.Path,
+			&route.AccessLog,
+			&idleTimeout,
+			&route.MaxRequestBodySize,
+			&route.MaxResponseBodySize,
+			&hcPath,
+			&hcInterval,
+			&hcThreshold,
+			&route.Compress,
+			&route.ExternalKey,
+			&route.Aliases,
+			&retries,
+			&route.RetryNonIdempotent,
+			&cors,
+			&backendMaxIdleConns,
+			&backendIdleTimeout,
+			&requestTimeout,
+			&backendTLS,
+			&route.BackendHost,
+			&route.ForwardedHost,
+			&route.LoadBalancing,
 			&route.CreatedAt,
 			&route.UpdatedAt,
-		)
+		); err != nil {
+			return err
+		}
+		route.IdleTimeout = millisToDuration(idleTimeout)
+		route.Retries = int(retries)
+		route.CORS = cors
+		route.BackendMaxIdleConns = int(backendMaxIdleConns)
+		route.BackendIdleTimeout = millisToDuration(backendIdleTimeout)
+		route.RequestTimeout = millisToDuration(requestTimeout)
+		route.BackendTLS = backendTLS
+		if len(route.Aliases) == 0 {
+			route.Aliases = nil
+		}
+		route.HealthCheck = scanHealthCheck(hcPath, hcInterval, hcThreshold)
+		return nil
 	case tableNameTCP:
 		return s.Scan(
 			&route.ID,
@@ -616,8 +988,14 @@ func (d *pgDataStore) scanRoute(route *router.Route, s scannable) error {
 	route.Type = d.routeType
 	switch d.tableName {
 	case tableNameHTTP:
-		var certID, certCert, certKey *string
+		var certID, certCert, certKey, certSHA256 *string
 		var certCreatedAt, certUpdatedAt *time.Time
+		var idleTimeout, hcInterval int64
+		var hcPath string
+		var hcThreshold, retries, backendMaxIdleConns int32
+		var backendIdleTimeout, requestTimeout int64
+		var cors *router.CORS
+		var backendTLS *router.BackendTLS
 		if err := s.Scan(
 			&route.ID,
 			&route.ParentRef,
@@ -626,6 +1004,26 @@ func (d *pgDataStore) scanRoute(route *router.Route, s scannable) error {
 			&route.Domain,
 			&route.Sticky,
 			&route.Path,
+			&route.AccessLog,
+			&idleTimeout,
+			&route.MaxRequestBodySize,
+			&route.MaxResponseBodySize,
+			&hcPath,
+			&hcInterval,
+			&hcThreshold,
+			&route.Compress,
+			&route.ExternalKey,
+			&route.Aliases,
+			&retries,
+			&route.RetryNonIdempotent,
+			&cors,
+			&backendMaxIdleConns,
+			&backendIdleTimeout,
+			&requestTimeout,
+			&backendTLS,
+			&route.BackendHost,
+			&route.ForwardedHost,
+			&route.LoadBalancing,
 			&route.CreatedAt,
 			&route.UpdatedAt,
 			&certID,
@@ -633,9 +1031,24 @@ func (d *pgDataStore) scanRoute(route *router.Route, s scannable) error {
 			&certKey,
 			&certCreatedAt,
 			&certUpdatedAt,
+			&certSHA256,
 		); err != nil {
 			return err
 		}
+		route.IdleTimeout = millisToDuration(idleTimeout)
+		route.Retries = int(retries)
+		route.CORS = cors
+		route.BackendMaxIdleConns = int(backendMaxIdleConns)
+		route.BackendIdleTimeout = millisToDuration(backendIdleTimeout)
+		route.RequestTimeout = millisToDuration(requestTimeout)
+		route.BackendTLS = backendTLS
+		if len(route.Aliases) == 0 {
+			route.Aliases = nil
+		}
+		route.HealthCheck = scanHealthCheck(hcPath, hcInterval, hcThreshold)
+		if certSHA256 != nil {
+			route.CertSHA256 = *certSHA256
+		}
 		if certID != nil {
 			route.Certificate = &router.Certificate{
 				ID:        *certID,
@@ -668,3 +1081,77 @@ func unlistenAndRelease(pool *pgx.ConnPool, conn *pgx.Conn, channel string) {
 	}
 	pool.Release(conn)
 }
+
+// durationToMillis converts d to the number of milliseconds stored in
+// duration columns such as http_routes.idle_timeout_ms.
+func durationToMillis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+func millisToDuration(ms int64) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
+// aliases returns the column value of a route's aliases, which is an empty
+// array rather than NULL if it has none.
+func aliases(r *router.Route) []string {
+	if r.Aliases == nil {
+		return []string{}
+	}
+	return r.Aliases
+}
+
+// corsJSON returns the JSON encoding of cors, or an empty string if it is
+// nil.
+func corsJSON(cors *router.CORS) (string, error) {
+	if cors == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(cors)
+	return string(data), err
+}
+
+// backendTLSJSON returns the JSON encoding of b, or an empty string if it
+// is nil.
+func backendTLSJSON(b *router.BackendTLS) (string, error) {
+	if b == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(b)
+	return string(data), err
+}
+
+// healthCheckPath, healthCheckIntervalMillis and
+// healthCheckUnhealthyThreshold return the column values of a route's health
+// check, which is stored with an empty path if the route has none.
+func healthCheckPath(hc *router.HealthCheck) string {
+	if hc == nil {
+		return ""
+	}
+	return hc.Path
+}
+
+func healthCheckIntervalMillis(hc *router.HealthCheck) int64 {
+	if hc == nil {
+		return 0
+	}
+	return durationToMillis(hc.Interval)
+}
+
+func healthCheckUnhealthyThreshold(hc *router.HealthCheck) int32 {
+	if hc == nil {
+		return 0
+	}
+	return int32(hc.UnhealthyThreshold)
+}
+
+func scanHealthCheck(path string, intervalMillis int64, threshold int32) *router.HealthCheck {
+	if path == "" {
+		return nil
+	}
+	return &router.HealthCheck{
+		Path:               path,
+		Interval:           millisToDuration(intervalMillis),
+		UnhealthyThreshold: int(threshold),
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/flynn/flynn/pkg/tlscert"
+	"github.com/flynn/flynn/router/types"
+	. "github.com/flynn/go-check"
+)
+
+func (s *S) TestImportHTTPRoutes(c *C) {
+	ds := NewPostgresDataStore(routeTypeHTTP, s.pgx)
+
+	const domainCount = 150
+	const certCount = 10
+	certs := make([]*router.Certificate, certCount)
+	for i := range certs {
+		cert := tlsConfigForDomain(fmt.Sprintf("%d.example.com", i))
+		certs[i] = &router.Certificate{Cert: cert.Cert, Key: cert.PrivateKey}
+	}
+
+	// one of the certificates already exists and should be reused
+	existing := &router.Certificate{Cert: certs[0].Cert, Key: certs[0].Key}
+	c.Assert(ds.AddCert(existing), IsNil)
+
+	routes := make([]*router.Route, 0, domainCount*2)
+	for i := 0; i < domainCount; i++ {
+		domain := fmt.Sprintf("%d.example.com", i)
+		cert := certs[i%certCount]
+		// add the path based route first to check default routes are
+		// inserted before the routes which depend on them
+		routes = append(routes, router.HTTPRoute{
+			Domain:  domain,
+			Path:    "/path",
+			Service: "test",
+		}.ToRoute(), router.HTTPRoute{
+			Domain:  domain,
+			Service: "test",
+			Certificate: &router.Certificate{
+				// whitespace should be ignored when deduplicating
+				Cert: "\n" + cert.Cert + "\n",
+				Key:  cert.Key,
+			},
+		}.ToRoute())
+	}
+	c.Assert(ds.Import(routes), IsNil)
+
+	for _, r := range routes {
+		c.Assert(r.ID, Not(Equals), "")
+		c.Assert(r.CreatedAt.IsZero(), Equals, false)
+		if r.Path == "/" {
+			c.Assert(r.Certificate, NotNil)
+		} else {
+			c.Assert(r.Path, Equals, "/path/")
+			c.Assert(r.Certificate, IsNil)
+		}
+	}
+
+	list, err := ds.List()
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, domainCount*2)
+
+	certList, err := ds.ListCerts()
+	c.Assert(err, IsNil)
+	c.Assert(certList, HasLen, certCount)
+	for _, cert := range certList {
+		c.Assert(cert.Routes, HasLen, domainCount/certCount)
+		if cert.Cert == existing.Cert {
+			c.Assert(cert.ID, Equals, existing.ID)
+		}
+	}
+
+	var routeCerts int
+	c.Assert(s.pgx.QueryRow("SELECT count(*) FROM route_certificates").Scan(&routeCerts), IsNil)
+	c.Assert(routeCerts, Equals, domainCount)
+
+	// importing the same routes again should conflict and insert nothing
+	c.Assert(ds.Import(routes), Equals, ErrConflict)
+	list, err = ds.List()
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, domainCount*2)
+}
+
+func (s *S) TestHTTPRouteCertSHA256(c *C) {
+	ds := NewPostgresDataStore(routeTypeHTTP, s.pgx)
+
+	cert := tlsConfigForDomain("sha256.example.com")
+	digest := sha256.Sum256([]byte(strings.Trim(cert.Cert, " \n")))
+	expected := hex.EncodeToString(digest[:])
+
+	r := router.HTTPRoute{
+		Domain:  "sha256.example.com",
+		Service: "test",
+		Certificate: &router.Certificate{
+			Cert: "\n  " + cert.Cert + "\n",
+			Key:  cert.PrivateKey,
+		},
+	}.ToRoute()
+	c.Assert(ds.Add(r), IsNil)
+	c.Assert(r.CertSHA256, Equals, expected)
+
+	got, err := ds.Get(r.ID)
+	c.Assert(err, IsNil)
+	c.Assert(got.CertSHA256, Equals, expected)
+
+	noCert := router.HTTPRoute{Domain: "nocert.example.com", Service: "test"}.ToRoute()
+	c.Assert(ds.Add(noCert), IsNil)
+
+	list, err := ds.List()
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 2)
+	for _, route := range list {
+		if route.ID == r.ID {
+			c.Assert(route.CertSHA256, Equals, expected)
+		} else {
+			c.Assert(route.CertSHA256, Equals, "")
+		}
+	}
+}
+
+func (s *S) TestRemoveHTTPRouteCerts(c *C) {
+	ds := NewPostgresDataStore(routeTypeHTTP, s.pgx)
+
+	shared := tlsConfigForDomain("*.shared.example.com")
+	addRoute := func(domain string, cert *tlscert.Cert) *router.Route {
+		r := router.HTTPRoute{
+			Domain:  domain,
+			Service: "test",
+			Certificate: &router.Certificate{
+				Cert: cert.Cert,
+				Key:  cert.PrivateKey,
+			},
+		}.ToRoute()
+		c.Assert(ds.Add(r), IsNil)
+		return r
+	}
+	r1 := addRoute("1.shared.example.com", shared)
+	r2 := addRoute("2.shared.example.com", shared)
+	r3 := addRoute("unshared.example.com", tlsConfigForDomain("unshared.example.com"))
+	c.Assert(r1.Certificate.ID, Equals, r2.Certificate.ID)
+
+	routeCertCount := func(routeID string) (n int) {
+		c.Assert(s.pgx.QueryRow("SELECT count(*) FROM route_certificates WHERE http_route_id = $1", routeID).Scan(&n), IsNil)
+		return
+	}
+	certDeleted := func(certID string) (deleted bool) {
+		c.Assert(s.pgx.QueryRow("SELECT deleted_at IS NOT NULL FROM certificates WHERE id = $1", certID).Scan(&deleted), IsNil)
+		return
+	}
+
+	// removing a route removes its association but not a cert which is
+	// still used by another route
+	c.Assert(ds.Remove(r1.ID), IsNil)
+	c.Assert(routeCertCount(r1.ID), Equals, 0)
+	c.Assert(routeCertCount(r2.ID), Equals, 1)
+	c.Assert(certDeleted(r1.Certificate.ID), Equals, false)
+	cert, err := ds.GetCert(r1.Certificate.ID)
+	c.Assert(err, IsNil)
+	c.Assert(cert.Routes, DeepEquals, []string{r2.ID})
+
+	// removing the last route using a cert deletes the cert
+	c.Assert(ds.Remove(r3.ID), IsNil)
+	c.Assert(routeCertCount(r3.ID), Equals, 0)
+	c.Assert(certDeleted(r3.Certificate.ID), Equals, true)
+
+	// associations are also removed if a route row is deleted
+	var cascade bool
+	c.Assert(s.pgx.QueryRow("SELECT confdeltype = 'c' FROM pg_constraint WHERE conname = 'route_certificates_http_route_id_fkey'").Scan(&cascade), IsNil)
+	c.Assert(cascade, Equals, true)
+}
+
+const benchmarkRouteCount = 500
+
+// addBenchmarkRoutes adds benchmarkRouteCount HTTP routes which all share the
+// same certificate.
+func (s *S) addBenchmarkRoutes(c *C) *pgDataStore {
+	ds := NewPostgresDataStore(routeTypeHTTP, s.pgx)
+	cert := tlsConfigForDomain("example.com")
+	for i := 0; i < benchmarkRouteCount; i++ {
+		err := ds.Add(router.HTTPRoute{
+			Domain:  fmt.Sprintf("%d.example.com", i),
+			Service: "test",
+			Certificate: &router.Certificate{
+				Cert: cert.Cert,
+				Key:  cert.PrivateKey,
+			},
+		}.ToRoute())
+		c.Assert(err, IsNil)
+	}
+	return ds
+}
+
+func (s *S) BenchmarkListHTTPRoutesPrepared(c *C) {
+	ds := s.addBenchmarkRoutes(c)
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		routes, err := ds.List()
+		if err != nil {
+			c.Fatal(err)
+		}
+		if len(routes) != benchmarkRouteCount {
+			c.Fatalf("expected %d routes, got %d", benchmarkRouteCount, len(routes))
+		}
+	}
+}
+
+func (s *S) BenchmarkListHTTPRoutesUnprepared(c *C) {
+	ds := s.addBenchmarkRoutes(c)
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		rows, err := s.pgx.Query(sqlListHTTPRoutes)
+		if err != nil {
+			c.Fatal(err)
+		}
+		var n int
+		for rows.Next() {
+			if err := ds.scanRoute(&router.Route{}, rows); err != nil {
+				c.Fatal(err)
+			}
+			n++
+		}
+		if err := rows.Err(); err != nil {
+			c.Fatal(err)
+		}
+		if n != benchmarkRouteCount {
+			c.Fatalf("expected %d routes, got %d", benchmarkRouteCount, n)
+		}
+	}
+}
+
+func (s *S) BenchmarkGetHTTPRoutePrepared(c *C) {
+	ds := s.addBenchmarkRoutes(c)
+	routes, err := ds.List()
+	c.Assert(err, IsNil)
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		if _, err := ds.Get(routes[i%len(routes)].ID); err != nil {
+			c.Fatal(err)
+		}
+	}
+}
+
+func (s *S) BenchmarkGetHTTPRouteUnprepared(c *C) {
+	ds := s.addBenchmarkRoutes(c)
+	routes, err := ds.List()
+	c.Assert(err, IsNil)
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		if err := ds.scanRoute(&router.Route{}, s.pgx.QueryRow(sqlGetHTTPRoute, routes[i%len(routes)].ID)); err != nil {
+			c.Fatal(err)
+		}
+	}
+}
+
+func (s *S) TestExternalKeyCert(c *C) {
+	ds := NewPostgresDataStore(routeTypeHTTP, s.pgx)
+
+	cert := tlsConfigForDomain("external.example.com")
+	r := router.HTTPRoute{
+		Domain:      "external.example.com",
+		Service:     "test",
+		ExternalKey: true,
+		Certificate: &router.Certificate{Cert: cert.Cert},
+	}.ToRoute()
+	c.Assert(ds.Add(r), IsNil)
+
+	var keyIsNull bool
+	c.Assert(s.pgx.QueryRow("SELECT key IS NULL FROM certificates WHERE id = $1", r.Certificate.ID).Scan(&keyIsNull), IsNil)
+	c.Assert(keyIsNull, Equals, true)
+
+	got, err := ds.Get(r.ID)
+	c.Assert(err, IsNil)
+	c.Assert(got.ExternalKey, Equals, true)
+	c.Assert(got.Certificate, NotNil)
+	c.Assert(got.Certificate.Cert, Equals, strings.Trim(cert.Cert, " \n"))
+	c.Assert(got.Certificate.Key, Equals, "")
+
+	storedCert, err := ds.GetCert(r.Certificate.ID)
+	c.Assert(err, IsNil)
+	c.Assert(storedCert.Key, Equals, "")
+}
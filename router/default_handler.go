@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"strconv"
+)
+
+// notFoundPage serves a static page with a 404 status, typically a branded
+// error page for requests to domains which have no route.
+type notFoundPage struct {
+	body        []byte
+	contentType string
+}
+
+func newNotFoundPage(path string) (*notFoundPage, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	return &notFoundPage{body: body, contentType: contentType}, nil
+}
+
+func (p *notFoundPage) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", p.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(p.body)))
+	w.WriteHeader(404)
+	if req.Method != "HEAD" {
+		w.Write(p.body)
+	}
+}
+
+// newDefaultHandler returns the handler for requests which don't match any
+// route, either proxying them to the backend at backendURL or serving the
+// page at pagePath. It returns nil if neither is set, in which case a plain
+// 404 response is sent.
+func newDefaultHandler(backendURL, pagePath string) (http.Handler, error) {
+	switch {
+	case backendURL != "" && pagePath != "":
+		return nil, errors.New("only one of a default backend and a not found page can be set")
+	case backendURL != "":
+		u, err := url.Parse(backendURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default backend URL: %s", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("invalid default backend URL %q, expected http(s)://host[:port]", backendURL)
+		}
+		// the Host header is passed through so the backend can show
+		// which domain was requested
+		return httputil.NewSingleHostReverseProxy(u), nil
+	case pagePath != "":
+		page, err := newNotFoundPage(pagePath)
+		if err != nil {
+			return nil, err
+		}
+		return page, nil
+	default:
+		return nil, nil
+	}
+}
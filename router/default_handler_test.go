@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+
+	. "github.com/flynn/go-check"
+)
+
+func (s *S) newHTTPListenerWithDefault(c *C, handler http.Handler) *HTTPListener {
+	cert := tlsConfigForDomain("example.com")
+	pair, err := tls.X509KeyPair([]byte(cert.CACert), []byte(cert.PrivateKey))
+	c.Assert(err, IsNil)
+	l := &HTTPListener{
+		Addr:           "127.0.0.1:0",
+		TLSAddr:        "127.0.0.1:0",
+		DefaultHandler: handler,
+		keypair:        pair,
+		ds:             NewPostgresDataStore("http", s.pgx),
+		discoverd:      s.discoverd,
+	}
+	c.Assert(l.Start(), IsNil)
+	return l
+}
+
+func (s *S) TestHTTPDefaultBackend(c *C) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(404)
+		w.Write([]byte("no app at " + req.Host))
+	}))
+	defer backend.Close()
+	srv := httptest.NewServer(httpTestHandler("app"))
+	defer srv.Close()
+
+	handler, err := newDefaultHandler(backend.URL, "")
+	c.Assert(err, IsNil)
+	l := s.newHTTPListenerWithDefault(c, handler)
+	defer l.Close()
+	addHTTPRoute(c, l)
+	discoverdRegisterHTTP(c, l, srv.Listener.Addr().String())
+
+	// matched hosts are routed as usual
+	assertGet(c, "http://"+l.Addr, "example.com", "app")
+
+	// unmatched hosts are proxied to the default backend, over HTTP and
+	// HTTPS
+	for _, url := range []string{"http://" + l.Addr, "https://" + l.TLSAddr} {
+		req := newReq(url, "unknown.example.org")
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{ServerName: "unknown.example.org", InsecureSkipVerify: true},
+		}}
+		res, err := client.Do(req)
+		c.Assert(err, IsNil)
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(res.StatusCode, Equals, 404)
+		c.Assert(string(data), Equals, "no app at unknown.example.org")
+	}
+}
+
+func (s *S) TestHTTPNotFoundPage(c *C) {
+	page := filepath.Join(c.MkDir(), "404.html")
+	c.Assert(ioutil.WriteFile(page, []byte("<h1>No such app</h1>"), 0644), IsNil)
+	handler, err := newDefaultHandler("", page)
+	c.Assert(err, IsNil)
+	l := s.newHTTPListenerWithDefault(c, handler)
+	defer l.Close()
+
+	res, err := httpClient.Do(newReq("http://"+l.Addr, "unknown.example.org"))
+	c.Assert(err, IsNil)
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, 404)
+	c.Assert(res.Header.Get("Content-Type"), Equals, "text/html; charset=utf-8")
+	c.Assert(string(data), Equals, "<h1>No such app</h1>")
+
+	_, err = newDefaultHandler("http://example.com", page)
+	c.Assert(err, NotNil)
+	_, err = newDefaultHandler("example.com:80", "")
+	c.Assert(err, NotNil)
+}
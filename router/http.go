@@ -3,6 +3,7 @@ package main
 import (
 	"crypto/md5"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
 	"errors"
 	"log"
@@ -22,6 +23,7 @@ import (
 	"github.com/flynn/flynn/router/types"
 	"golang.org/x/net/context"
 	"golang.org/x/net/http2"
+	"gopkg.in/inconshreveable/log15.v2"
 )
 
 type HTTPListener struct {
@@ -31,11 +33,28 @@ type HTTPListener struct {
 	Addr    string
 	TLSAddr string
 
+	// DrainTimeout is how long to wait for in-flight requests to a backend
+	// which is draining or has gone down to finish, defaults to
+	// defaultDrainTimeout
+	DrainTimeout time.Duration
+
+	// TLSPolicy restricts the TLS versions and cipher suites negotiated
+	// by the HTTPS listener, defaults to defaultTLSPolicy
+	TLSPolicy *TLSPolicy
+
+	// DefaultHandler, if set, handles requests which don't match any
+	// route instead of responding with a plain 404
+	DefaultHandler http.Handler
+
 	mtx      sync.RWMutex
 	domains  map[string]*node
 	routes   map[string]*httpRoute
 	services map[string]*httpService
 
+	// certs holds the keypairs of routes for TLS handshakes, so they don't
+	// contend with route updates for mtx
+	certs *certStore
+
 	discoverd DiscoverdClient
 	ds        DataStore
 	wm        *WatchManager
@@ -47,10 +66,16 @@ type HTTPListener struct {
 	cookieKey   *[32]byte
 	keypair     tls.Certificate
 
+	// accessLogger is used to log requests to routes which have access
+	// logging enabled
+	accessLogger log15.Logger
+
 	preSync  func()
 	postSync func(<-chan struct{})
 }
 
+const defaultDrainTimeout = 30 * time.Second
+
 type DiscoverdClient interface {
 	Service(string) discoverd.Service
 	AddService(string, *discoverd.ServiceConfig) error
@@ -63,6 +88,9 @@ func (s *HTTPListener) Close() error {
 		return nil
 	}
 	s.stopSync()
+	for _, route := range s.routes {
+		route.stopHealthCheck()
+	}
 	for _, service := range s.services {
 		service.sc.Close()
 	}
@@ -96,10 +124,17 @@ func (s *HTTPListener) Start() error {
 	s.routes = make(map[string]*httpRoute)
 	s.domains = make(map[string]*node)
 	s.services = make(map[string]*httpService)
+	s.certs = newCertStore()
 
 	if s.cookieKey == nil {
 		s.cookieKey = &[32]byte{}
 	}
+	if s.accessLogger == nil {
+		s.accessLogger = logger.New("log", "access")
+	}
+	if s.DrainTimeout == 0 {
+		s.DrainTimeout = defaultDrainTimeout
+	}
 
 	if err := s.startSync(ctx); err != nil {
 		s.Close()
@@ -195,6 +230,16 @@ func (s *HTTPListener) UpdateRoute(r *router.Route) error {
 	return s.ds.Update(r)
 }
 
+// ImportRoutes adds the given routes in bulk, see pgDataStore.Import.
+func (s *HTTPListener) ImportRoutes(routes []*router.Route) error {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	if s.closed {
+		return ErrClosed
+	}
+	return s.ds.Import(routes)
+}
+
 func md5sum(data string) string {
 	digest := md5.Sum([]byte(data))
 	return hex.EncodeToString(digest[:])
@@ -254,6 +299,21 @@ func (s *HTTPListener) RemoveCert(id string) error {
 	return s.ds.RemoveCert(id)
 }
 
+// backendTLSConfig returns the TLS configuration used to connect to the
+// backends of a route with backend TLS, which only verifies the backends'
+// certificates if the route requires it.
+func backendTLSConfig(b *router.BackendTLS) *tls.Config {
+	c := &tls.Config{
+		ServerName:         b.ServerName,
+		InsecureSkipVerify: !b.Verify,
+	}
+	if b.CACert != "" {
+		c.RootCAs = x509.NewCertPool()
+		c.RootCAs.AppendCertsFromPEM([]byte(b.CACert))
+	}
+	return c
+}
+
 type httpSyncHandler struct {
 	l *HTTPListener
 }
@@ -298,15 +358,17 @@ func (h *httpSyncHandler) Set(data *router.Route) error {
 		service = nil
 	}
 	if service == nil {
-		sc, err := cache.New(h.l.discoverd.Service(r.Service))
+		service = &httpService{
+			name:    r.Service,
+			tracker: proxy.NewBackendTracker(),
+		}
+		sc, err := cache.NewWithRemoveFunc(h.l.discoverd.Service(r.Service), func(addr string) {
+			service.drain(addr, h.l.DrainTimeout)
+		})
 		if err != nil {
 			return err
 		}
-
-		service = &httpService{
-			name: r.Service,
-			sc:   sc,
-		}
+		service.sc = sc
 		h.l.services[r.Service] = service
 	}
 	service.refs++
@@ -317,13 +379,76 @@ func (h *httpSyncHandler) Set(data *router.Route) error {
 		bf = service.sc.Addrs
 	}
 	r.rp = proxy.NewReverseProxy(bf, h.l.cookieKey, r.Sticky, logger)
+	r.rp.TrackBackends(service.tracker)
+	r.rp.IdleTimeout = r.IdleTimeout
+	r.rp.RequestTimeout = r.RequestTimeout
+	r.rp.BackendHost = r.BackendHost
+	r.rp.ForwardedHost = r.ForwardedHost
+	r.rp.SetLoadBalancing(proxy.LoadBalancing(r.LoadBalancing))
+	r.rp.MaxRequestBodySize = r.MaxRequestBodySize
+	r.rp.MaxResponseBodySize = r.MaxResponseBodySize
+	r.rp.Compress = r.Compress
+	r.rp.Retries = r.Retries
+	r.rp.RetryNonIdempotent = r.RetryNonIdempotent
+	if c := r.CORS; c != nil {
+		r.rp.CORS = &proxy.CORS{
+			AllowedOrigins:   c.AllowedOrigins,
+			AllowedMethods:   c.AllowedMethods,
+			AllowedHeaders:   c.AllowedHeaders,
+			AllowCredentials: c.AllowCredentials,
+			MaxAge:           c.MaxAge,
+		}
+	}
+	if r.BackendMaxIdleConns != 0 || r.BackendIdleTimeout != 0 {
+		r.rp.SetKeepAlive(proxy.KeepAlive{
+			MaxIdleConnsPerBackend: r.BackendMaxIdleConns,
+			IdleConnTimeout:        r.BackendIdleTimeout,
+		})
+	}
+	var backendTLS *tls.Config
+	if b := r.BackendTLS; b != nil {
+		backendTLS = backendTLSConfig(b)
+		r.rp.SetBackendTLS(backendTLS)
+	}
+	if hc := r.HealthCheck; hc != nil {
+		r.health = proxy.NewHealthChecker(hc.Path, hc.Interval, hc.UnhealthyThreshold, bf, logger.New("route", data.ID, "service", r.Service))
+		if backendTLS != nil {
+			r.health.SetTLS(backendTLS)
+		}
+		r.rp.CheckHealth(r.health)
+	}
+	if r.AccessLog {
+		r.rp.AccessLogger = h.l.accessLogger.New("route", data.ID, "service", r.Service)
+	}
 	r.service = service
+	old, ok := h.l.routes[data.ID]
+	if ok {
+		old.stopHealthCheck()
+		old.rp.CloseIdleConnections()
+		// keep counting requests to the route across updates
+		r.rp.Metrics = old.rp.Metrics
+	} else {
+		r.rp.Metrics = proxy.NewRouteMetrics()
+	}
+	r.startHealthCheck()
 	h.l.routes[data.ID] = r
 	if data.Path == "/" {
-		if tree, ok := h.l.domains[strings.ToLower(r.Domain)]; ok {
+		h.l.certs.Set(r.Domain, r.keypair)
+		tree, ok := h.l.domains[strings.ToLower(r.Domain)]
+		if ok {
 			tree.backend = r
 		} else {
-			h.l.domains[strings.ToLower(r.Domain)] = NewTree(r)
+			tree = NewTree(r)
+			h.l.domains[strings.ToLower(r.Domain)] = tree
+		}
+		// aliases share the domain's tree, so path based routes on the
+		// domain are also routed for them
+		for _, alias := range r.Aliases {
+			h.l.domains[strings.ToLower(alias)] = tree
+			h.l.certs.Set(alias, r.keypair)
+		}
+		if old != nil {
+			h.l.removeAliases(old, tree, r.Aliases)
 		}
 	} else {
 		if tree, ok := h.l.domains[strings.ToLower(r.Domain)]; ok {
@@ -353,11 +478,15 @@ func (h *httpSyncHandler) Remove(id string) error {
 		r.service.sc.Close()
 		delete(h.l.services, r.service.name)
 	}
+	r.stopHealthCheck()
+	r.rp.CloseIdleConnections()
 
 	delete(h.l.routes, id)
 	if tree, ok := h.l.domains[r.Domain]; ok {
 		if r.Path == "/" && tree.backend == r {
 			delete(h.l.domains, r.Domain)
+			h.l.certs.Remove(r.Domain)
+			h.l.removeAliases(r, tree, nil)
 		} else if tree.Lookup(r.Path) == r {
 			tree.Remove(r.Path)
 		}
@@ -366,6 +495,25 @@ func (h *httpSyncHandler) Remove(id string) error {
 	return nil
 }
 
+// removeAliases removes the aliases of route r which are routed using tree,
+// other than those in keep.
+func (s *HTTPListener) removeAliases(r *httpRoute, tree *node, keep []string) {
+	kept := make(map[string]struct{}, len(keep))
+	for _, alias := range keep {
+		kept[strings.ToLower(alias)] = struct{}{}
+	}
+	for _, alias := range r.Aliases {
+		alias = strings.ToLower(alias)
+		if _, ok := kept[alias]; ok {
+			continue
+		}
+		if s.domains[alias] == tree {
+			delete(s.domains, alias)
+			s.certs.Remove(alias)
+		}
+	}
+}
+
 func (s *HTTPListener) listenAndServe() error {
 	var err error
 	s.listener, err = listenFunc("tcp4", s.Addr)
@@ -391,17 +539,26 @@ var errMissingTLS = errors.New("router: route not found or TLS not configured")
 
 func (s *HTTPListener) listenAndServeTLS() error {
 	certForHandshake := func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-		r := s.findRoute(hello.ServerName, "/")
-		if r == nil {
+		keypair, ok := s.certs.Get(hello.ServerName)
+		if !ok {
+			if s.DefaultHandler != nil {
+				// complete the handshake with the default keypair so
+				// the request reaches the default handler
+				return nil, nil
+			}
 			return nil, errMissingTLS
 		}
-		return r.keypair, nil
+		return keypair, nil
+	}
+	policy := s.TLSPolicy
+	if policy == nil {
+		policy = defaultTLSPolicy
 	}
-	tlsConfig := tlsconfig.SecureCiphers(&tls.Config{
+	tlsConfig := policy.apply(tlsconfig.SecureCiphers(&tls.Config{
 		GetCertificate: certForHandshake,
 		Certificates:   []tls.Certificate{s.keypair},
 		NextProtos:     []string{http2.NextProtoTLS, "h2-14"},
-	})
+	}))
 
 	l, err := listenFunc("tcp4", s.TLSAddr)
 	if err != nil {
@@ -474,6 +631,10 @@ func (s *HTTPListener) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	ctx = ctxhelper.NewContextStartTime(ctx, time.Now())
 	r := s.findRoute(req.Host, req.URL.Path)
 	if r == nil {
+		if s.DefaultHandler != nil {
+			s.DefaultHandler.ServeHTTP(w, req)
+			return
+		}
 		fail(w, 404)
 		return
 	}
@@ -489,6 +650,21 @@ type httpRoute struct {
 	keypair *tls.Certificate
 	service *httpService
 	rp      *proxy.ReverseProxy
+
+	// health checks the route's backends if it has a health check
+	health *proxy.HealthChecker
+}
+
+func (r *httpRoute) startHealthCheck() {
+	if r.health != nil {
+		r.health.Start()
+	}
+}
+
+func (r *httpRoute) stopHealthCheck() {
+	if r.health != nil {
+		r.health.Stop()
+	}
 }
 
 // A service definition: name, and set of backends.
@@ -496,6 +672,27 @@ type httpService struct {
 	name string
 	sc   cache.ServiceCache
 	refs int
+
+	// tracker tracks in-flight requests to the service's backends across
+	// all of its routes
+	tracker *proxy.BackendTracker
+}
+
+// drain waits for in-flight requests to a backend which is draining or has
+// gone down to finish, logging whether they did so within timeout. The
+// service cache has already stopped new requests being sent to it.
+func (s *httpService) drain(addr string, timeout time.Duration) {
+	n := s.tracker.InFlight(addr)
+	if n == 0 {
+		return
+	}
+	log := logger.New("fn", "drain", "service", s.name, "backend", addr)
+	log.Info("draining backend", "in_flight", n)
+	if s.tracker.Drain(addr, timeout) {
+		log.Info("backend drained")
+	} else {
+		log.Warn("timed out draining backend", "in_flight", s.tracker.InFlight(addr), "timeout", timeout)
+	}
 }
 
 func (r *httpRoute) ServeHTTP(ctx context.Context, w http.ResponseWriter, req *http.Request) {
@@ -2,8 +2,11 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -12,8 +15,10 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -21,11 +26,13 @@ import (
 	"github.com/flynn/flynn/discoverd/testutil"
 	"github.com/flynn/flynn/pkg/httpclient"
 	"github.com/flynn/flynn/pkg/tlscert"
+	"github.com/flynn/flynn/router/proxy"
 	"github.com/flynn/flynn/router/types"
 	. "github.com/flynn/go-check"
 	"github.com/jackc/pgx"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/websocket"
+	"gopkg.in/inconshreveable/log15.v2"
 )
 
 const UUIDRegex = "[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}"
@@ -96,7 +103,7 @@ func tlsConfigForDomain(domain string) *tlscert.Cert {
 	if c, ok := tlsCerts[wildcard]; ok {
 		return c
 	}
-	c, err := tlscert.Generate([]string{domain})
+	c, err := tlscert.GenerateForDomain(domain, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -108,7 +115,7 @@ func refreshTLSConfigForDomain(domain string) *tlscert.Cert {
 	tlsCertsMux.Lock()
 	defer tlsCertsMux.Unlock()
 	domain = normalizeDomain(domain)
-	c, err := tlscert.Generate([]string{domain})
+	c, err := tlscert.GenerateForDomain(domain, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -513,6 +520,94 @@ func (s *S) TestWildcardRouting(c *C) {
 	assertGet(c, "http://"+l.Addr, "dev.foo.bar", "3")
 }
 
+func (s *S) TestHTTPSCertPrecedence(c *C) {
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	addCertRoute := func(domain string) {
+		cert, err := tlscert.Generate([]string{domain})
+		c.Assert(err, IsNil)
+		addRoute(c, l, router.HTTPRoute{
+			Domain:      domain,
+			Service:     "test",
+			Certificate: &router.Certificate{Cert: cert.Cert, Key: cert.PrivateKey},
+		}.ToRoute())
+	}
+	addCertRoute("*.precedence.org")
+	addCertRoute("a.precedence.org")
+
+	// handshake returns the names of the certificate presented for
+	// serverName
+	handshake := func(serverName string) ([]string, error) {
+		conn, err := tls.Dial("tcp", l.TLSAddr, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].DNSNames, nil
+	}
+
+	// the exact certificate takes precedence over the wildcard
+	names, err := handshake("a.precedence.org")
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"a.precedence.org"})
+
+	// other subdomains use the wildcard
+	names, err = handshake("b.precedence.org")
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"*.precedence.org"})
+
+	// without a default handler, the handshake fails if nothing matches
+	_, err = handshake("precedence.net")
+	c.Assert(err, NotNil)
+}
+
+func (s *S) TestAliasRouting(c *C) {
+	srv1 := httptest.NewServer(httpTestHandler("1"))
+	srv2 := httptest.NewServer(httpTestHandler("2"))
+	defer srv1.Close()
+	defer srv2.Close()
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	r := addRoute(c, l, router.HTTPRoute{
+		Domain:  "foo.bar",
+		Aliases: []string{"www.foo.bar", "*.foo.example.com"},
+		Service: "1",
+	}.ToRoute())
+	addRoute(c, l, router.HTTPRoute{
+		Domain:  "foo.bar",
+		Path:    "/2/",
+		Service: "2",
+	}.ToRoute())
+
+	discoverdRegisterHTTPService(c, l, "1", srv1.Listener.Addr().String())
+	discoverdRegisterHTTPService(c, l, "2", srv2.Listener.Addr().String())
+
+	for _, host := range []string{"foo.bar", "www.foo.bar", "dev.foo.example.com"} {
+		assertGet(c, "http://"+l.Addr, host, "1")
+		assertGet(c, "http://"+l.Addr+"/2/", host, "2")
+	}
+
+	// aliases can't be used by other routes
+	err := addRouteAssertErr(c, l, router.HTTPRoute{Domain: "www.foo.bar", Service: "3"}.ToRoute())
+	c.Assert(err, Equals, ErrConflict)
+	err = addRouteAssertErr(c, l, router.HTTPRoute{Domain: "baz.bar", Aliases: []string{"foo.bar"}, Service: "3"}.ToRoute())
+	c.Assert(err, Equals, ErrConflict)
+
+	// removing an alias stops routing it
+	wait := waitForEvent(c, l, "set", "")
+	r.Aliases = []string{"www.foo.bar"}
+	c.Assert(l.UpdateRoute(r), IsNil)
+	wait()
+	assertGet(c, "http://"+l.Addr, "www.foo.bar", "1")
+	res, err := httpClient.Do(newReq("http://"+l.Addr, "dev.foo.example.com"))
+	c.Assert(err, IsNil)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, 404)
+}
+
 func (s *S) TestLeaderRouting(c *C) {
 	srv1 := httptest.NewServer(httpTestHandler("1"))
 	srv2 := httptest.NewServer(httpTestHandler("2"))
@@ -635,7 +730,7 @@ func (s *S) TestHTTPResync(c *C) {
 		cmu.Lock()
 		defer cmu.Unlock()
 		connPids = append(connPids, conn.Pid)
-		return nil
+		return prepareStatements(conn)
 	}
 	pgxpool, err := pgx.NewConnPool(poolConfig)
 	if err != nil {
@@ -984,6 +1079,83 @@ func (s *S) TestConnectionHeaders(c *C) {
 	}
 }
 
+func (s *S) TestHTTPAccessLog(c *C) {
+	srv := httptest.NewServer(httpTestHandler("1"))
+	defer srv.Close()
+
+	records := make(chan *log15.Record, 10)
+	accessLogger := log15.New()
+	accessLogger.SetHandler(log15.FuncHandler(func(r *log15.Record) error {
+		records <- r
+		return nil
+	}))
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+	l.mtx.Lock()
+	l.accessLogger = accessLogger
+	l.mtx.Unlock()
+
+	logged := addRoute(c, l, router.HTTPRoute{
+		Domain:    "logged.example.com",
+		Service:   "test",
+		AccessLog: true,
+	}.ToRoute())
+	quiet := addRoute(c, l, router.HTTPRoute{
+		Domain:  "quiet.example.com",
+		Service: "test",
+	}.ToRoute())
+	unregister := discoverdRegisterHTTP(c, l, srv.Listener.Addr().String())
+	defer unregister()
+
+	assertLogged := func(route *router.Route) {
+		select {
+		case r := <-records:
+			fields := make(map[string]interface{}, len(r.Ctx)/2)
+			for i := 0; i+1 < len(r.Ctx); i += 2 {
+				fields[r.Ctx[i].(string)] = r.Ctx[i+1]
+			}
+			c.Assert(fields["route"], Equals, route.ID)
+			c.Assert(fields["host"], Equals, route.Domain)
+			c.Assert(fields["method"], Equals, "GET")
+			c.Assert(fields["path"], Equals, "/")
+			c.Assert(fields["status"], Equals, 200)
+			c.Assert(fields["backend"], Equals, srv.Listener.Addr().String())
+			_, ok := fields["latency"].(time.Duration)
+			c.Assert(ok, Equals, true)
+		case <-time.After(5 * time.Second):
+			c.Fatalf("timed out waiting for access log entry for %s", route.Domain)
+		}
+	}
+	assertNotLogged := func() {
+		select {
+		case r := <-records:
+			c.Fatalf("unexpected access log entry: %s %v", r.Msg, r.Ctx)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	assertGet(c, "http://"+l.Addr, logged.Domain, "1")
+	assertLogged(logged)
+	assertGet(c, "http://"+l.Addr, quiet.Domain, "1")
+	assertNotLogged()
+
+	// toggling access logging on a route takes effect immediately
+	quiet.AccessLog = true
+	wait := waitForEvent(c, l, "set", "")
+	c.Assert(l.UpdateRoute(quiet), IsNil)
+	wait()
+	assertGet(c, "http://"+l.Addr, quiet.Domain, "1")
+	assertLogged(quiet)
+
+	logged.AccessLog = false
+	wait = waitForEvent(c, l, "set", "")
+	c.Assert(l.UpdateRoute(logged), IsNil)
+	wait()
+	assertGet(c, "http://"+l.Addr, logged.Domain, "1")
+	assertNotLogged()
+}
+
 func (s *S) TestHTTPWebsocket(c *C) {
 	done := make(chan struct{})
 	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
@@ -1051,6 +1223,70 @@ func (s *S) TestHTTPWebsocket(c *C) {
 	}
 }
 
+func (s *S) TestHTTPWebsocketIdleTimeout(c *C) {
+	closed := make(chan error, 1)
+	srv := httptest.NewServer(websocket.Handler(func(conn *websocket.Conn) {
+		// echo messages until the connection is closed
+		buf := make([]byte, 64)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				closed <- err
+				return
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	idleTimeout := 500 * time.Millisecond
+	addRoute(c, l, router.HTTPRoute{
+		Domain:      "example.com",
+		Service:     "test",
+		IdleTimeout: idleTimeout,
+	}.ToRoute())
+	discoverdRegisterHTTP(c, l, srv.Listener.Addr().String())
+
+	conn, err := net.Dial("tcp", l.Addr)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	conf, err := websocket.NewConfig("ws://example.com/websocket", "http://example.net")
+	c.Assert(err, IsNil)
+	wc, err := websocket.NewClient(conf, conn)
+	c.Assert(err, IsNil)
+
+	// messages sent more often than the idle timeout keep the connection
+	// open and are proxied in both directions
+	res := make([]byte, 64)
+	for i := 0; i < 3; i++ {
+		msg := fmt.Sprintf("message %d", i)
+		_, err = wc.Write([]byte(msg))
+		c.Assert(err, IsNil)
+		n, err := wc.Read(res)
+		c.Assert(err, IsNil)
+		c.Assert(string(res[:n]), Equals, msg)
+		time.Sleep(idleTimeout / 2)
+	}
+
+	// the router should close both sides once the connection is idle
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	_, err = wc.Read(res)
+	c.Assert(err, NotNil)
+	c.Assert(time.Since(start) < 5*time.Second, Equals, true)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for backend connection to be closed")
+	}
+}
+
 func (s *S) TestUpgradeHeaderIsCaseInsensitive(c *C) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		c.Assert(strings.ToLower(req.Header.Get("Connection")), Equals, "upgrade")
@@ -1662,3 +1898,820 @@ func (s *S) TestHTTPCloseNotify(c *C) {
 		c.Fatal("CloseNotify not called")
 	}
 }
+
+func (s *S) TestHTTPDrainBackend(c *C) {
+	// the draining backend blocks requests until finish is closed, and
+	// counts them so the test can check it isn't sent new ones
+	var draining int32
+	started := make(chan struct{}, 10)
+	finish := make(chan struct{})
+	srv1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&draining, 1)
+		started <- struct{}{}
+		<-finish
+		w.Write([]byte("draining"))
+	}))
+	defer srv1.Close()
+	srv2 := httptest.NewServer(httpTestHandler("other"))
+	defer srv2.Close()
+	addr1 := srv1.Listener.Addr().String()
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	addRoute(c, l, router.HTTPRoute{
+		Domain:  "drain.example.com",
+		Service: "test",
+	}.ToRoute())
+	l.mtx.RLock()
+	service := l.services["test"]
+	l.mtx.RUnlock()
+	dc := l.discoverd.(discoverdClient)
+	sc := service.sc.(serviceCache)
+	hb := discoverdRegisterInstance(c, dc, sc, "test", addr1)
+
+	// start a request which blocks in the backend
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	done := make(chan result)
+	go func() {
+		res, err := httpClient.Do(newReq("http://"+l.Addr, "drain.example.com"))
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		done <- result{status: res.StatusCode, body: string(data), err: err}
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for request to reach the backend")
+	}
+	c.Assert(service.tracker.InFlight(addr1), Equals, 1)
+
+	// mark the backend as draining whilst the request is in flight and add
+	// another, new requests should only be sent to the new one
+	discoverdDrain(c, hb, sc)
+	unregister2 := discoverdRegisterHTTP(c, l, srv2.Listener.Addr().String())
+	defer unregister2()
+	for i := 0; i < 5; i++ {
+		assertGet(c, "http://"+l.Addr, "drain.example.com", "other")
+	}
+	c.Assert(atomic.LoadInt32(&draining), Equals, int32(1))
+
+	// the in-flight request should complete successfully
+	close(finish)
+	select {
+	case r := <-done:
+		c.Assert(r.err, IsNil)
+		c.Assert(r.status, Equals, 200)
+		c.Assert(r.body, Equals, "draining")
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for in-flight request")
+	}
+	c.Assert(service.tracker.InFlight(addr1), Equals, 0)
+
+	// the backend can then be removed
+	discoverdUnregisterFunc(c, hb, sc)()
+}
+
+func (s *S) TestHTTPMaxBodySize(c *C) {
+	var reqs int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&reqs, 1)
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if size := req.URL.Query().Get("size"); size != "" {
+			n, _ := strconv.Atoi(size)
+			w.Write(bytes.Repeat([]byte("a"), n))
+			return
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	addRoute(c, l, router.HTTPRoute{
+		Domain:              "example.com",
+		Service:             "test",
+		MaxRequestBodySize:  10,
+		MaxResponseBodySize: 20,
+	}.ToRoute())
+	discoverdRegisterHTTP(c, l, srv.Listener.Addr().String())
+
+	post := func(body string, chunked bool) (int, string) {
+		var r io.Reader = strings.NewReader(body)
+		if chunked {
+			// hide the length so the body is sent chunked
+			r = ioutil.NopCloser(r)
+		}
+		req, err := http.NewRequest("POST", "http://"+l.Addr, r)
+		c.Assert(err, IsNil)
+		req.Host = "example.com"
+		res, err := httpClient.Do(req)
+		c.Assert(err, IsNil)
+		defer res.Body.Close()
+		data, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(data)
+	}
+
+	// a body under the limit is passed through
+	status, body := post("0123456789", false)
+	c.Assert(status, Equals, 200)
+	c.Assert(body, Equals, "0123456789")
+	c.Assert(atomic.LoadInt32(&reqs), Equals, int32(1))
+
+	// a body over the limit is rejected before reaching the backend
+	status, _ = post("0123456789a", false)
+	c.Assert(status, Equals, http.StatusRequestEntityTooLarge)
+	c.Assert(atomic.LoadInt32(&reqs), Equals, int32(1))
+
+	// a chunked body over the limit is rejected
+	status, _ = post("0123456789a", true)
+	c.Assert(status, Equals, http.StatusRequestEntityTooLarge)
+
+	// a response over the limit is rejected
+	res, err := httpClient.Do(newReq("http://"+l.Addr+"/?size=21", "example.com"))
+	c.Assert(err, IsNil)
+	res.Body.Close()
+	c.Assert(res.StatusCode, Equals, http.StatusBadGateway)
+
+	res, err = httpClient.Do(newReq("http://"+l.Addr+"/?size=20", "example.com"))
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(data, HasLen, 20)
+}
+
+func (s *S) TestHTTPHealthCheck(c *C) {
+	var failing int32
+	newBackend := func(name string, checkFails func() bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/health" {
+				if checkFails() {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+				return
+			}
+			w.Write([]byte(name))
+		}))
+	}
+	healthy := newBackend("healthy", func() bool { return false })
+	defer healthy.Close()
+	flaky := newBackend("flaky", func() bool { return atomic.LoadInt32(&failing) == 1 })
+	defer flaky.Close()
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	addRoute(c, l, router.HTTPRoute{
+		Domain:  "health.example.com",
+		Service: "test",
+		HealthCheck: &router.HealthCheck{
+			Path:               "/health",
+			Interval:           20 * time.Millisecond,
+			UnhealthyThreshold: 2,
+		},
+	}.ToRoute())
+	unregister := discoverdRegisterHTTP(c, l, healthy.Listener.Addr().String())
+	defer unregister()
+	unregister = discoverdRegisterHTTP(c, l, flaky.Listener.Addr().String())
+	defer unregister()
+
+	// servedByFlaky makes requests until one is served by the flaky backend,
+	// returning whether that happened within n requests
+	servedByFlaky := func(n int) bool {
+		for i := 0; i < n; i++ {
+			res, err := httpClient.Do(newReq("http://"+l.Addr, "health.example.com"))
+			c.Assert(err, IsNil)
+			data, err := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			c.Assert(err, IsNil)
+			c.Assert(res.StatusCode, Equals, 200)
+			if string(data) == "flaky" {
+				return true
+			}
+		}
+		return false
+	}
+	waitFor := func(desc string, f func() bool) {
+		timeout := time.After(5 * time.Second)
+		for !f() {
+			select {
+			case <-timeout:
+				c.Fatalf("timed out waiting for %s", desc)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	c.Assert(servedByFlaky(100), Equals, true)
+
+	l.mtx.RLock()
+	var health *proxy.HealthChecker
+	for _, r := range l.routes {
+		health = r.health
+	}
+	l.mtx.RUnlock()
+	c.Assert(health, NotNil)
+	flakyAddr := flaky.Listener.Addr().String()
+
+	// the failing backend should be removed from rotation
+	atomic.StoreInt32(&failing, 1)
+	waitFor("backend to become unhealthy", func() bool { return !health.Healthy(flakyAddr) })
+	c.Assert(servedByFlaky(50), Equals, false)
+
+	// and added back once it recovers
+	atomic.StoreInt32(&failing, 0)
+	waitFor("backend to become healthy", func() bool { return health.Healthy(flakyAddr) })
+	c.Assert(servedByFlaky(100), Equals, true)
+}
+
+func (s *S) TestHTTPCompression(c *C) {
+	text := strings.Repeat("compress me ", 200)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/small":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("small"))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(text))
+		case "/encoded":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "br")
+			w.Write([]byte(text))
+		default:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("Content-Length", strconv.Itoa(len(text)))
+			w.Write([]byte(text))
+		}
+	}))
+	defer srv.Close()
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	addRoute(c, l, router.HTTPRoute{
+		Domain:   "compress.example.com",
+		Service:  "test",
+		Compress: true,
+	}.ToRoute())
+	unregister := discoverdRegisterHTTP(c, l, srv.Listener.Addr().String())
+	defer unregister()
+
+	// use a client which doesn't add or decode Accept-Encoding itself
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	get := func(path, acceptEncoding string) (*http.Response, []byte) {
+		req := newReq("http://"+l.Addr+path, "compress.example.com")
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		res, err := client.Do(req)
+		c.Assert(err, IsNil)
+		defer res.Body.Close()
+		c.Assert(res.StatusCode, Equals, 200)
+		data, err := ioutil.ReadAll(res.Body)
+		c.Assert(err, IsNil)
+		return res, data
+	}
+
+	// compressible responses are encoded for clients which accept gzip
+	for _, enc := range []string{"gzip", "deflate, gzip;q=0.5", "*"} {
+		res, data := get("/", enc)
+		c.Assert(res.Header.Get("Content-Encoding"), Equals, "gzip", Commentf("Accept-Encoding: %s", enc))
+		c.Assert(res.Header.Get("Vary"), Equals, "Accept-Encoding")
+		c.Assert(len(data) < len(text), Equals, true)
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		c.Assert(err, IsNil)
+		decoded, err := ioutil.ReadAll(gz)
+		c.Assert(err, IsNil)
+		c.Assert(string(decoded), Equals, text)
+	}
+
+	// but not for clients which don't
+	for _, enc := range []string{"", "identity", "deflate", "gzip;q=0"} {
+		res, data := get("/", enc)
+		c.Assert(res.Header.Get("Content-Encoding"), Equals, "", Commentf("Accept-Encoding: %s", enc))
+		c.Assert(string(data), Equals, text)
+	}
+
+	// small, already compressed and already encoded responses are passed
+	// through unchanged
+	res, data := get("/small", "gzip")
+	c.Assert(res.Header.Get("Content-Encoding"), Equals, "")
+	c.Assert(string(data), Equals, "small")
+	res, data = get("/image", "gzip")
+	c.Assert(res.Header.Get("Content-Encoding"), Equals, "")
+	c.Assert(string(data), Equals, text)
+	res, data = get("/encoded", "gzip")
+	c.Assert(res.Header.Get("Content-Encoding"), Equals, "br")
+	c.Assert(string(data), Equals, text)
+}
+
+func (s *S) TestHTTPRetries(c *C) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		w.Write(append([]byte("ok:"), body...))
+	}))
+	defer good.Close()
+
+	// refused is the address of a closed listener, so connections to it
+	// are refused
+	refused, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	refusedAddr := refused.Addr().String()
+	refused.Close()
+
+	// reset reads each request and closes the connection without
+	// responding, so requests fail after being sent
+	reset, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer reset.Close()
+	var resetRequests int64
+	go func() {
+		for {
+			conn, err := reset.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				if _, err := http.ReadRequest(bufio.NewReader(conn)); err == nil {
+					atomic.AddInt64(&resetRequests, 1)
+				}
+				conn.Close()
+			}()
+		}
+	}()
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	do := func(method, host string) (int, string) {
+		req, err := http.NewRequest(method, "http://"+l.Addr, strings.NewReader("body"))
+		c.Assert(err, IsNil)
+		req.Host = host
+		res, err := httpClient.Do(req)
+		c.Assert(err, IsNil)
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		c.Assert(err, IsNil)
+		return res.StatusCode, string(data)
+	}
+
+	// requests to backends refusing connections are always tried on
+	// another backend
+	addRoute(c, l, router.HTTPRoute{Domain: "refused.example.com", Service: "refused-test"}.ToRoute())
+	unregister := discoverdRegisterHTTPService(c, l, "refused-test", refusedAddr)
+	defer unregister()
+	unregister = discoverdRegisterHTTPService(c, l, "refused-test", good.Listener.Addr().String())
+	defer unregister()
+	for i := 0; i < 10; i++ {
+		status, body := do("POST", "refused.example.com")
+		c.Assert(status, Equals, 200)
+		c.Assert(body, Equals, "ok:body")
+	}
+
+	// requests failing after being sent are retried if the route has
+	// retries and the method is idempotent
+	r := addRoute(c, l, router.HTTPRoute{Domain: "retry.example.com", Service: "retry-test", Retries: 1}.ToRoute())
+	unregister = discoverdRegisterHTTPService(c, l, "retry-test", reset.Addr().String())
+	defer unregister()
+	unregister = discoverdRegisterHTTPService(c, l, "retry-test", good.Listener.Addr().String())
+	defer unregister()
+	for i := 0; i < 10; i++ {
+		status, body := do("PUT", "retry.example.com")
+		c.Assert(status, Equals, 200)
+		c.Assert(body, Equals, "ok:body")
+	}
+
+	// but POST requests aren't, so every one sent to the resetting
+	// backend fails
+	atomic.StoreInt64(&resetRequests, 0)
+	var failed int64
+	for i := 0; i < 20; i++ {
+		if status, _ := do("POST", "retry.example.com"); status == 503 {
+			failed++
+		}
+	}
+	c.Assert(failed, Equals, atomic.LoadInt64(&resetRequests))
+
+	// unless the route allows it
+	wait := waitForEvent(c, l, "set", "")
+	r.RetryNonIdempotent = true
+	c.Assert(l.UpdateRoute(r), IsNil)
+	wait()
+	for i := 0; i < 10; i++ {
+		status, body := do("POST", "retry.example.com")
+		c.Assert(status, Equals, 200)
+		c.Assert(body, Equals, "ok:body")
+	}
+}
+
+func (s *S) TestHTTPCORS(c *C) {
+	var backendRequests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&backendRequests, 1)
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	addRoute(c, l, router.HTTPRoute{
+		Domain:  "cors.example.com",
+		Service: "cors-test",
+		CORS: &router.CORS{
+			AllowedOrigins:   []string{"https://allowed.example.com"},
+			AllowedMethods:   []string{"GET", "PUT"},
+			AllowedHeaders:   []string{"X-Custom"},
+			AllowCredentials: true,
+			MaxAge:           time.Minute,
+		},
+	}.ToRoute())
+	unregister := discoverdRegisterHTTPService(c, l, "cors-test", srv.Listener.Addr().String())
+	defer unregister()
+
+	do := func(method, origin string, header http.Header) *http.Response {
+		req := newReq("http://"+l.Addr, "cors.example.com")
+		req.Method = method
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		res, err := httpClient.Do(req)
+		c.Assert(err, IsNil)
+		res.Body.Close()
+		return res
+	}
+	preflight := func(origin, method, headers string) *http.Response {
+		return do("OPTIONS", origin, http.Header{
+			"Access-Control-Request-Method":  {method},
+			"Access-Control-Request-Headers": {headers},
+		})
+	}
+
+	// an allowed preflight request is answered by the router
+	res := preflight("https://allowed.example.com", "PUT", "X-Custom")
+	c.Assert(res.StatusCode, Equals, 204)
+	c.Assert(res.Header.Get("Access-Control-Allow-Origin"), Equals, "https://allowed.example.com")
+	c.Assert(res.Header.Get("Access-Control-Allow-Methods"), Equals, "GET, PUT")
+	c.Assert(res.Header.Get("Access-Control-Allow-Headers"), Equals, "X-Custom")
+	c.Assert(res.Header.Get("Access-Control-Allow-Credentials"), Equals, "true")
+	c.Assert(res.Header.Get("Access-Control-Max-Age"), Equals, "60")
+	c.Assert(atomic.LoadInt64(&backendRequests), Equals, int64(0))
+
+	// preflight requests with a disallowed origin, method or header are
+	// forbidden
+	for _, args := range [][]string{
+		{"https://denied.example.com", "PUT", "X-Custom"},
+		{"https://allowed.example.com", "DELETE", ""},
+		{"https://allowed.example.com", "PUT", "X-Other"},
+	} {
+		res := preflight(args[0], args[1], args[2])
+		c.Assert(res.StatusCode, Equals, 403)
+		c.Assert(res.Header.Get("Access-Control-Allow-Origin"), Equals, "")
+	}
+	c.Assert(atomic.LoadInt64(&backendRequests), Equals, int64(0))
+
+	// responses to allowed origins get CORS headers, replacing those set
+	// by the backend
+	res = do("GET", "https://allowed.example.com", nil)
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(res.Header.Get("Access-Control-Allow-Origin"), Equals, "https://allowed.example.com")
+	c.Assert(res.Header.Get("Access-Control-Allow-Credentials"), Equals, "true")
+
+	// responses to denied origins get none
+	res = do("GET", "https://denied.example.com", nil)
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(res.Header.Get("Access-Control-Allow-Origin"), Equals, "")
+	c.Assert(res.Header.Get("Access-Control-Allow-Credentials"), Equals, "")
+	c.Assert(atomic.LoadInt64(&backendRequests), Equals, int64(2))
+}
+
+func (s *S) TestHTTPBackendKeepAlive(c *C) {
+	// count the connections the router opens to the backend
+	var conns int64
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	addRoute(c, l, router.HTTPRoute{Domain: "pooled.example.com", Service: "keepalive-test"}.ToRoute())
+	addRoute(c, l, router.HTTPRoute{Domain: "unpooled.example.com", Service: "keepalive-test", BackendMaxIdleConns: -1}.ToRoute())
+	unregister := discoverdRegisterHTTPService(c, l, "keepalive-test", srv.Listener.Addr().String())
+	defer unregister()
+
+	get := func(host string) {
+		res, err := httpClient.Do(newReq("http://"+l.Addr, host))
+		c.Assert(err, IsNil)
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		c.Assert(err, IsNil)
+		c.Assert(res.StatusCode, Equals, 200)
+		c.Assert(string(data), Equals, "ok")
+	}
+
+	// sequential requests reuse a single connection
+	for i := 0; i < 10; i++ {
+		get("pooled.example.com")
+	}
+	c.Assert(atomic.LoadInt64(&conns), Equals, int64(1))
+
+	// unless keep-alive is disabled for the route
+	atomic.StoreInt64(&conns, 0)
+	for i := 0; i < 10; i++ {
+		get("unpooled.example.com")
+	}
+	c.Assert(atomic.LoadInt64(&conns), Equals, int64(10))
+}
+
+func (s *S) BenchmarkHTTPBackendKeepAlive(c *C) {
+	srv := httptest.NewServer(httpTestHandler("ok"))
+	defer srv.Close()
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	addRoute(c, l, router.HTTPRoute{Domain: "example.com", Service: "keepalive-bench"}.ToRoute())
+	unregister := discoverdRegisterHTTPService(c, l, "keepalive-bench", srv.Listener.Addr().String())
+	defer unregister()
+
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		res, err := httpClient.Do(newReq("http://"+l.Addr, "example.com"))
+		c.Assert(err, IsNil)
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+}
+
+func (s *S) TestHTTPRequestTimeout(c *C) {
+	done := make(chan struct{})
+	defer close(done)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow-body" {
+			// respond immediately but take a while to send the body
+			w.WriteHeader(200)
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+			w.Write([]byte("ok"))
+			return
+		}
+		select {
+		case <-time.After(5 * time.Second):
+		case <-done:
+		}
+		w.Write([]byte("too late"))
+	}))
+	defer srv.Close()
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	addRoute(c, l, router.HTTPRoute{Domain: "timeout.example.com", Service: "timeout-test", RequestTimeout: 100 * time.Millisecond}.ToRoute())
+	unregister := discoverdRegisterHTTPService(c, l, "timeout-test", srv.Listener.Addr().String())
+	defer unregister()
+
+	// a backend which doesn't respond within the timeout results in a 504
+	start := time.Now()
+	res, err := httpClient.Do(newReq("http://"+l.Addr, "timeout.example.com"))
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, 504)
+	c.Assert(string(data), Equals, "Gateway Timeout\n")
+	elapsed := time.Since(start)
+	c.Assert(elapsed >= 100*time.Millisecond, Equals, true, Commentf("elapsed = %s", elapsed))
+	c.Assert(elapsed < time.Second, Equals, true, Commentf("elapsed = %s", elapsed))
+
+	// the timeout doesn't limit how long the body takes once the backend
+	// has started responding
+	res, err = httpClient.Do(newReq("http://"+l.Addr+"/slow-body", "timeout.example.com"))
+	c.Assert(err, IsNil)
+	data, err = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(res.StatusCode, Equals, 200)
+	c.Assert(string(data), Equals, "ok")
+}
+
+func (s *S) TestHTTPBackendTLS(c *C) {
+	var requests int32
+	var serverName atomic.Value
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		serverName.Store(req.TLS.ServerName)
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	caCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+	unregister := discoverdRegisterHTTPService(c, l, "backend-tls-test", srv.Listener.Addr().String())
+	defer unregister()
+
+	get := func(domain string) (int, string) {
+		res, err := httpClient.Do(newReq("http://"+l.Addr, domain))
+		c.Assert(err, IsNil)
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		c.Assert(err, IsNil)
+		return res.StatusCode, string(data)
+	}
+
+	// without verification the router connects to the TLS-only backend
+	// despite its certificate being untrusted
+	addRoute(c, l, router.HTTPRoute{Domain: "unverified.example.com", Service: "backend-tls-test", BackendTLS: &router.BackendTLS{}}.ToRoute())
+	status, body := get("unverified.example.com")
+	c.Assert(status, Equals, 200)
+	c.Assert(body, Equals, "ok")
+	c.Assert(atomic.LoadInt32(&requests), Equals, int32(1))
+
+	// requiring verification fails closed when the certificate is untrusted,
+	// without sending the request to the backend
+	addRoute(c, l, router.HTTPRoute{Domain: "untrusted.example.com", Service: "backend-tls-test", BackendTLS: &router.BackendTLS{Verify: true}}.ToRoute())
+	status, _ = get("untrusted.example.com")
+	c.Assert(status, Equals, 503)
+	c.Assert(atomic.LoadInt32(&requests), Equals, int32(1))
+
+	// the certificate is trusted when verified with its CA, and the
+	// server name is sent with SNI
+	addRoute(c, l, router.HTTPRoute{Domain: "trusted.example.com", Service: "backend-tls-test", BackendTLS: &router.BackendTLS{
+		Verify:     true,
+		CACert:     caCert,
+		ServerName: "example.com",
+	}}.ToRoute())
+	status, body = get("trusted.example.com")
+	c.Assert(status, Equals, 200)
+	c.Assert(body, Equals, "ok")
+	c.Assert(atomic.LoadInt32(&requests), Equals, int32(2))
+	c.Assert(serverName.Load(), Equals, "example.com")
+}
+
+func (s *S) TestHTTPBackendHost(c *C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Backend-Host", req.Host)
+		w.Header().Set("Backend-Forwarded-Host", req.Header.Get("X-Forwarded-Host"))
+	}))
+	defer srv.Close()
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	addRoute(c, l, router.HTTPRoute{Domain: "backend-host.example.com", Service: "backend-host-test", BackendHost: "app.internal:8080"}.ToRoute())
+	addRoute(c, l, router.HTTPRoute{Domain: "forwarded-host.example.com", Service: "backend-host-test", BackendHost: "app.internal", ForwardedHost: true}.ToRoute())
+	addRoute(c, l, router.HTTPRoute{Domain: "original-host.example.com", Service: "backend-host-test"}.ToRoute())
+	unregister := discoverdRegisterHTTPService(c, l, "backend-host-test", srv.Listener.Addr().String())
+	defer unregister()
+
+	for _, t := range []struct {
+		host          string
+		forwardedHost string
+		backendHost   string
+		backendFwd    string
+	}{
+		{host: "backend-host.example.com", backendHost: "app.internal:8080"},
+		{host: "forwarded-host.example.com", backendHost: "app.internal", backendFwd: "forwarded-host.example.com"},
+		// the host set by a previous proxy is kept
+		{host: "forwarded-host.example.com", forwardedHost: "example.org", backendHost: "app.internal", backendFwd: "example.org"},
+		{host: "original-host.example.com", backendHost: "original-host.example.com"},
+	} {
+		req := newReq("http://"+l.Addr, t.host)
+		if t.forwardedHost != "" {
+			req.Header.Set("X-Forwarded-Host", t.forwardedHost)
+		}
+		res, err := httpClient.Do(req)
+		c.Assert(err, IsNil)
+		res.Body.Close()
+		c.Assert(res.StatusCode, Equals, 200)
+		c.Assert(res.Request.Host, Equals, t.host)
+		c.Assert(res.Header.Get("Backend-Host"), Equals, t.backendHost, Commentf("%s", t.host))
+		c.Assert(res.Header.Get("Backend-Forwarded-Host"), Equals, t.backendFwd, Commentf("%s", t.host))
+	}
+}
+
+func (s *S) TestHTTPLoadBalancingIPHash(c *C) {
+	srv1 := httptest.NewServer(httpTestHandler("1"))
+	srv2 := httptest.NewServer(httpTestHandler("2"))
+	defer srv1.Close()
+	defer srv2.Close()
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	addRoute(c, l, router.HTTPRoute{
+		Domain:        "ip-hash.example.com",
+		Service:       "ip-hash-test",
+		LoadBalancing: router.LoadBalancingIPHash,
+	}.ToRoute())
+	defer discoverdRegisterHTTPService(c, l, "ip-hash-test", srv1.Listener.Addr().String())()
+	defer discoverdRegisterHTTPService(c, l, "ip-hash-test", srv2.Listener.Addr().String())()
+
+	// every request is from 127.0.0.1, so should be sent to the same
+	// backend, even from new connections
+	get := func() string {
+		res, err := httpClient.Do(newReq("http://"+l.Addr, "ip-hash.example.com"))
+		c.Assert(err, IsNil)
+		defer res.Body.Close()
+		c.Assert(res.StatusCode, Equals, 200)
+		data, err := ioutil.ReadAll(res.Body)
+		c.Assert(err, IsNil)
+		return string(data)
+	}
+	backend := get()
+	for i := 0; i < 10; i++ {
+		httpClient.Transport.(*http.Transport).CloseIdleConnections()
+		c.Assert(get(), Equals, backend)
+	}
+}
+
+func (s *S) TestHTTPLoadBalancingLeastConn(c *C) {
+	started := make(chan string)
+	finish := make(chan struct{})
+	handler := func(id string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/slow" {
+				started <- id
+				<-finish
+			}
+			w.Write([]byte(id))
+		})
+	}
+	srv1 := httptest.NewServer(handler("1"))
+	srv2 := httptest.NewServer(handler("2"))
+	defer srv1.Close()
+	defer srv2.Close()
+
+	l := s.newHTTPListener(c)
+	defer l.Close()
+
+	addRoute(c, l, router.HTTPRoute{
+		Domain:        "least-conn.example.com",
+		Service:       "least-conn-test",
+		LoadBalancing: router.LoadBalancingLeastConn,
+	}.ToRoute())
+	defer discoverdRegisterHTTPService(c, l, "least-conn-test", srv1.Listener.Addr().String())()
+	defer discoverdRegisterHTTPService(c, l, "least-conn-test", srv2.Listener.Addr().String())()
+
+	// start a request which blocks in one of the backends
+	done := make(chan error)
+	go func() {
+		res, err := httpClient.Do(newReq("http://"+l.Addr+"/slow", "least-conn.example.com"))
+		if err == nil {
+			res.Body.Close()
+		}
+		done <- err
+	}()
+	var busy string
+	select {
+	case busy = <-started:
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for request to reach the backend")
+	}
+
+	// requests should be sent to the idle backend whilst it is in flight
+	for i := 0; i < 10; i++ {
+		res, err := httpClient.Do(newReq("http://"+l.Addr, "least-conn.example.com"))
+		c.Assert(err, IsNil)
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(res.StatusCode, Equals, 200)
+		c.Assert(string(data), Not(Equals), busy)
+	}
+
+	close(finish)
+	select {
+	case err := <-done:
+		c.Assert(err, IsNil)
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for in-flight request")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/flynn/flynn/router/proxy"
+	"golang.org/x/net/context"
+)
+
+// routeMetrics is a snapshot of the metrics of an HTTP route along with the
+// route fields used to label them.
+type routeMetrics struct {
+	domain  string
+	path    string
+	service string
+	proxy.MetricsSnapshot
+}
+
+// routeMetrics returns a snapshot of the metrics of each HTTP route, sorted
+// by domain and path.
+func (s *HTTPListener) routeMetrics() []*routeMetrics {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	res := make([]*routeMetrics, 0, len(s.routes))
+	for _, r := range s.routes {
+		if r.rp.Metrics == nil {
+			continue
+		}
+		res = append(res, &routeMetrics{
+			domain:          r.Domain,
+			path:            r.Path,
+			service:         r.Service,
+			MetricsSnapshot: r.rp.Metrics.Snapshot(),
+		})
+	}
+	sort.Sort(sortRouteMetrics(res))
+	return res
+}
+
+type sortRouteMetrics []*routeMetrics
+
+func (s sortRouteMetrics) Len() int      { return len(s) }
+func (s sortRouteMetrics) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s sortRouteMetrics) Less(i, j int) bool {
+	if s[i].domain != s[j].domain {
+		return s[i].domain < s[j].domain
+	}
+	return s[i].path < s[j].path
+}
+
+// GetMetrics serves the request counts and latency histograms of HTTP
+// routes in the Prometheus text exposition format.
+func (api *API) GetMetrics(ctx context.Context, w http.ResponseWriter, req *http.Request) {
+	l := api.router.HTTP.(*HTTPListener)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w, l.routeMetrics())
+}
+
+func writeMetrics(w io.Writer, metrics []*routeMetrics) error {
+	b := bufio.NewWriter(w)
+
+	fmt.Fprintln(b, "# HELP router_http_requests_total Number of HTTP requests proxied for each route by status code.")
+	fmt.Fprintln(b, "# TYPE router_http_requests_total counter")
+	for _, m := range metrics {
+		statuses := make([]int, 0, len(m.Statuses))
+		for status := range m.Statuses {
+			statuses = append(statuses, status)
+		}
+		sort.Ints(statuses)
+		for _, status := range statuses {
+			fmt.Fprintf(b, "router_http_requests_total{%s,code=%q} %d\n", m.labels(), strconv.Itoa(status), m.Statuses[status])
+		}
+	}
+
+	fmt.Fprintln(b, "# HELP router_http_request_duration_seconds Latency of HTTP requests proxied for each route.")
+	fmt.Fprintln(b, "# TYPE router_http_request_duration_seconds histogram")
+	for _, m := range metrics {
+		labels := m.labels()
+		for i, le := range proxy.LatencyBuckets {
+			fmt.Fprintf(b, "router_http_request_duration_seconds_bucket{%s,le=%q} %d\n", labels, strconv.FormatFloat(le, 'g', -1, 64), m.Buckets[i])
+		}
+		fmt.Fprintf(b, "router_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, m.Count)
+		fmt.Fprintf(b, "router_http_request_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(m.Sum, 'g', -1, 64))
+		fmt.Fprintf(b, "router_http_request_duration_seconds_count{%s} %d\n", labels, m.Count)
+	}
+	return b.Flush()
+}
+
+func (m *routeMetrics) labels() string {
+	path := m.path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf(`domain="%s",path="%s",service="%s"`, escapeLabel(m.domain), escapeLabel(path), escapeLabel(m.service))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a Prometheus label value.
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
@@ -5,7 +5,9 @@ import (
 	"encoding/hex"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/flynn/flynn/pkg/postgres"
 	"github.com/flynn/flynn/pkg/testutils/postgres"
@@ -36,91 +38,50 @@ type MigrateSuite struct{}
 
 var _ = Suite(&MigrateSuite{})
 
-type testMigrator struct {
-	c  *C
-	db *postgres.DB
-	id int
-}
-
-func (t *testMigrator) migrateTo(id int) {
-	t.c.Assert((*migrations)[t.id:id].Migrate(t.db), IsNil)
-	t.id = id
-}
-
 func (MigrateSuite) TestMigrateTLSObject(c *C) {
 	db := setupTestDB(c, "routertest_tls_object_migration")
-	m := &testMigrator{c: c, db: db}
-
-	// start from ID 4
-	m.migrateTo(4)
+	m := pgtestutils.NewMigrator(c, db, migrations)
 
 	nRoutes := 5
 	routes := make([]*router.Route, nRoutes)
 	certs := make([]*tlscert.Cert, nRoutes)
-	for i := 0; i < len(routes)-2; i++ {
-		cert := tlsConfigForDomain(fmt.Sprintf("migrationtest%d.example.org", i))
-		r := &router.Route{
-			ParentRef:     fmt.Sprintf("some/parent/ref/%d", i),
-			Service:       fmt.Sprintf("migrationtest%d.example.org", i),
-			Domain:        fmt.Sprintf("migrationtest%d.example.org", i),
-			LegacyTLSCert: cert.CACert,
-			LegacyTLSKey:  cert.PrivateKey,
-		}
-		err := db.QueryRow(`
-			INSERT INTO http_routes (parent_ref, service, domain, tls_cert, tls_key)
-			VALUES ($1, $2, $3, $4, $5) RETURNING id`,
-			r.ParentRef,
-			r.Service,
-			r.Domain,
-			r.LegacyTLSCert,
-			r.LegacyTLSKey).Scan(&r.ID)
-		c.Assert(err, IsNil)
-		routes[i] = r
-		certs[i] = cert
-	}
-
-	{
-		// Add route with leading and trailing whitespace on cert and key
-		i := len(routes) - 2
-		cert := certs[i-1] // use the same cert as the previous route
-		r := &router.Route{
-			ParentRef:     fmt.Sprintf("some/parent/ref/%d", i),
-			Service:       fmt.Sprintf("migrationtest%d.example.org", i),
-			Domain:        fmt.Sprintf("migrationtest%d.example.org", i),
-			LegacyTLSCert: "  \n\n  \n " + cert.CACert + "   \n   \n   ",
-			LegacyTLSKey:  "    \n   " + cert.PrivateKey + "   \n   \n  ",
-		}
-		err := db.QueryRow(`
-			INSERT INTO http_routes (parent_ref, service, domain, tls_cert, tls_key)
-			VALUES ($1, $2, $3, $4, $5) RETURNING id`,
-			r.ParentRef,
-			r.Service,
-			r.Domain,
-			r.LegacyTLSCert,
-			r.LegacyTLSKey).Scan(&r.ID)
-		c.Assert(err, IsNil)
-		routes[i] = r
-		certs[i] = cert
-	}
-
-	{
-		// Add route without cert
-		i := len(routes) - 1
+	fixtures := make([]*pgtestutils.Fixture, nRoutes)
+	for i := range routes {
 		r := &router.Route{
 			ParentRef: fmt.Sprintf("some/parent/ref/%d", i),
 			Service:   fmt.Sprintf("migrationtest%d.example.org", i),
 			Domain:    fmt.Sprintf("migrationtest%d.example.org", i),
 		}
-		err := db.QueryRow(`
-			INSERT INTO http_routes (parent_ref, service, domain)
-			VALUES ($1, $2, $3) RETURNING id`,
-			r.ParentRef,
-			r.Service,
-			r.Domain).Scan(&r.ID)
-		c.Assert(err, IsNil)
+		switch {
+		case i < nRoutes-2:
+			certs[i] = tlsConfigForDomain(r.Domain)
+			r.LegacyTLSCert = certs[i].CACert
+			r.LegacyTLSKey = certs[i].PrivateKey
+		case i == nRoutes-2:
+			// Add route with leading and trailing whitespace on cert
+			// and key, using the same cert as the previous route
+			certs[i] = certs[i-1]
+			r.LegacyTLSCert = "  \n\n  \n " + certs[i].CACert + "   \n   \n   "
+			r.LegacyTLSKey = "    \n   " + certs[i].PrivateKey + "   \n   \n  "
+		default:
+			// the last route doesn't have a cert
+		}
+		row := pgtestutils.Row{
+			"parent_ref": r.ParentRef,
+			"service":    r.Service,
+			"domain":     r.Domain,
+		}
+		if r.LegacyTLSCert != "" {
+			row["tls_cert"] = r.LegacyTLSCert
+			row["tls_key"] = r.LegacyTLSKey
+		}
 		routes[i] = r
+		fixtures[i] = &pgtestutils.Fixture{Table: "http_routes", Row: row, Returning: "id", Dest: &r.ID}
 	}
 
+	// start from ID 4
+	m.Seed(4, fixtures...)
+
 	for i, cert := range certs {
 		if i == 0 || i >= len(certs)-2 {
 			continue
@@ -129,7 +90,7 @@ func (MigrateSuite) TestMigrateTLSObject(c *C) {
 	}
 
 	// run TLS object migration
-	m.migrateTo(5)
+	m.MigrateTo(5)
 
 	for i, r := range routes {
 		cert := certs[i]
@@ -180,3 +141,324 @@ func (MigrateSuite) TestMigrateTLSObject(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(count, Equals, int64(nRoutes-1)) // the last route doesn't have a cert
 }
+
+func (MigrateSuite) TestAnalyzeTLSObjectMigration(c *C) {
+	db := setupTestDB(c, "routertest_tls_object_migration_analysis")
+	m := pgtestutils.NewMigrator(c, db, migrations)
+
+	var fixtures []*pgtestutils.Fixture
+	addRoute := func(i int, cert, key string) {
+		row := pgtestutils.Row{
+			"parent_ref": fmt.Sprintf("some/parent/ref/%d", i),
+			"service":    fmt.Sprintf("analysistest%d.example.org", i),
+			"domain":     fmt.Sprintf("analysistest%d.example.org", i),
+		}
+		if cert != "" {
+			row["tls_cert"] = cert
+			row["tls_key"] = key
+		}
+		fixtures = append(fixtures, &pgtestutils.Fixture{Table: "http_routes", Row: row})
+	}
+	var cert *tlscert.Cert
+	for i := 0; i < 3; i++ {
+		cert = tlsConfigForDomain(fmt.Sprintf("analysistest%d.example.org", i))
+		addRoute(i, cert.CACert, cert.PrivateKey)
+	}
+	// the same cert as the previous route with surrounding whitespace
+	addRoute(3, "  \n "+cert.CACert+" \n  ", cert.PrivateKey)
+	addRoute(4, "not a certificate", cert.PrivateKey)
+	addRoute(5, "", "")
+	m.Seed(4, fixtures...)
+
+	analysis, err := analyzeTLSObjectMigration(db)
+	c.Assert(err, IsNil)
+	c.Assert(analysis.Certificates, Equals, 4)
+	c.Assert(analysis.Routes, Equals, 5)
+	c.Assert(analysis.InvalidCerts, HasLen, 1)
+	c.Assert(analysis.InvalidCerts[0].Domain, Equals, "analysistest4.example.org")
+
+	// the analysis doesn't migrate anything
+	version, err := migrations.Version(db)
+	c.Assert(err, IsNil)
+	c.Assert(version, Equals, 4)
+
+	// the analysis matches what the migration does
+	m.MigrateTo(5)
+	var certificates, routeCertificates int
+	c.Assert(db.QueryRow("SELECT COUNT(*) FROM certificates").Scan(&certificates), IsNil)
+	c.Assert(db.QueryRow("SELECT COUNT(*) FROM route_certificates").Scan(&routeCertificates), IsNil)
+	c.Assert(certificates, Equals, analysis.Certificates)
+	c.Assert(routeCertificates, Equals, analysis.Routes)
+
+	_, err = analyzeTLSObjectMigration(db)
+	c.Assert(err, NotNil)
+}
+
+func (MigrateSuite) TestMigrateCertSHA256Backfill(c *C) {
+	db := setupTestDB(c, "routertest_cert_sha256_migration")
+	m := pgtestutils.NewMigrator(c, db, migrations)
+
+	m.MigrateTo(5)
+
+	addRoute := func(domain string) string {
+		var id string
+		err := db.QueryRow(`
+			INSERT INTO http_routes (parent_ref, service, domain)
+			VALUES ('some/parent/ref', $1, $1) RETURNING id`, domain).Scan(&id)
+		c.Assert(err, IsNil)
+		return id
+	}
+	// addCert inserts a cert with the given (possibly wrong) digest
+	addCert := func(cert, key string, digest []byte, age time.Duration) string {
+		var id string
+		err := db.QueryRow(`
+			INSERT INTO certificates (cert, key, cert_sha256, created_at)
+			VALUES ($1, $2, $3, now() - $4::interval) RETURNING id`,
+			cert, key, digest, fmt.Sprintf("%d seconds", int(age.Seconds()))).Scan(&id)
+		c.Assert(err, IsNil)
+		return id
+	}
+	addRouteCert := func(routeID, certID string) {
+		c.Assert(db.Exec(`INSERT INTO route_certificates (http_route_id, certificate_id) VALUES ($1, $2)`, routeID, certID), IsNil)
+	}
+	digest := func(s string) []byte {
+		sum := sha256.Sum256([]byte(s))
+		return sum[:]
+	}
+
+	certA := tlsConfigForDomain("migrationtest-a.example.org")
+	certB := tlsConfigForDomain("migrationtest-b.example.org")
+
+	// the oldest copy of cert A has surrounding whitespace and was hashed
+	// without trimming it
+	paddedA := "  \n\n " + certA.CACert + "  \n  "
+	oldA := addCert(paddedA, " \n"+certA.PrivateKey, digest(paddedA), time.Hour)
+	newA := addCert(certA.CACert, certA.PrivateKey, digest(certA.CACert), time.Minute)
+	// cert B has a digest which doesn't match its contents at all
+	idB := addCert(certB.CACert, certB.PrivateKey, digest("wrong"), time.Minute)
+
+	route1 := addRoute("migrationtest1.example.org")
+	route2 := addRoute("migrationtest2.example.org")
+	route3 := addRoute("migrationtest3.example.org")
+	addRouteCert(route1, oldA)
+	addRouteCert(route2, newA)
+	addRouteCert(route3, oldA)
+	addRouteCert(route3, newA)
+	addRouteCert(route3, idB)
+
+	m.MigrateTo(6)
+
+	type certRow struct {
+		ID     string
+		Cert   string
+		Key    string
+		SHA256 []byte
+	}
+	rows, err := db.Query(`SELECT id, cert, key, cert_sha256 FROM certificates WHERE deleted_at IS NULL ORDER BY created_at`)
+	c.Assert(err, IsNil)
+	var certs []certRow
+	for rows.Next() {
+		var r certRow
+		c.Assert(rows.Scan(&r.ID, &r.Cert, &r.Key, &r.SHA256), IsNil)
+		certs = append(certs, r)
+	}
+	c.Assert(rows.Err(), IsNil)
+
+	// the two copies of cert A are merged into the oldest one
+	c.Assert(certs, HasLen, 2)
+	c.Assert(certs[0].ID, Equals, oldA)
+	c.Assert(certs[0].Cert, Equals, strings.Trim(certA.CACert, " \n"))
+	c.Assert(certs[0].Key, Equals, strings.Trim(certA.PrivateKey, " \n"))
+	c.Assert(certs[0].SHA256, DeepEquals, digest(strings.Trim(certA.CACert, " \n")))
+	c.Assert(certs[1].ID, Equals, idB)
+	c.Assert(certs[1].SHA256, DeepEquals, digest(strings.Trim(certB.CACert, " \n")))
+
+	routeCerts := func(routeID string) []string {
+		rows, err := db.Query(`SELECT certificate_id FROM route_certificates WHERE http_route_id = $1 ORDER BY certificate_id`, routeID)
+		c.Assert(err, IsNil)
+		var ids []string
+		for rows.Next() {
+			var id string
+			c.Assert(rows.Scan(&id), IsNil)
+			ids = append(ids, id)
+		}
+		c.Assert(rows.Err(), IsNil)
+		return ids
+	}
+	c.Assert(routeCerts(route1), DeepEquals, []string{oldA})
+	c.Assert(routeCerts(route2), DeepEquals, []string{oldA})
+	route3Certs := []string{oldA, idB}
+	sort.Strings(route3Certs)
+	c.Assert(routeCerts(route3), DeepEquals, route3Certs)
+}
+
+func (MigrateSuite) TestMigrateChecksumMismatch(c *C) {
+	db := setupTestDB(c, "routertest_migrate_checksum_mismatch")
+	m := pgtestutils.NewMigrator(c, db, migrations)
+
+	m.MigrateTo(4)
+
+	// re-running the applied migrations should succeed
+	c.Assert((*migrations)[:4].Migrate(db), IsNil)
+
+	// tamper with a recorded checksum
+	c.Assert(db.Exec(`UPDATE schema_migrations SET checksum = 'tampered' WHERE id = 3`), IsNil)
+
+	err := migrations.Migrate(db)
+	c.Assert(err, NotNil)
+	mismatch, ok := err.(postgres.ChecksumMismatchError)
+	if !ok {
+		c.Fatalf("expected checksum mismatch error, got %T: %s", err, err)
+	}
+	c.Assert(mismatch.ID, Equals, 3)
+	c.Assert(mismatch.Actual, Equals, "tampered")
+	c.Assert(mismatch.Expected, Equals, (*migrations)[2].Checksum())
+
+	// check the guard stopped later migrations from being applied
+	var count int64
+	c.Assert(db.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE id = 5`).Scan(&count), IsNil)
+	c.Assert(count, Equals, int64(0))
+}
+
+func (MigrateSuite) TestMigrateTo(c *C) {
+	db := setupTestDB(c, "routertest_migrate_to")
+
+	c.Assert(migrations.MigrateTo(db, 3), IsNil)
+	version, err := migrations.Version(db)
+	c.Assert(err, IsNil)
+	c.Assert(version, Equals, 3)
+
+	// migrating to the current version is a no-op
+	c.Assert(migrations.MigrateTo(db, 3), IsNil)
+
+	// migrating to an earlier version is an error
+	c.Assert(migrations.MigrateTo(db, 2), NotNil)
+
+	// migrating to an unknown version is an error
+	c.Assert(migrations.MigrateTo(db, 1000), NotNil)
+
+	c.Assert(migrations.MigrateTo(db, 5), IsNil)
+	version, err = migrations.Version(db)
+	c.Assert(err, IsNil)
+	c.Assert(version, Equals, 5)
+}
+
+func (MigrateSuite) TestMigrationDurations(c *C) {
+	db := setupTestDB(c, "routertest_migration_durations")
+	m := pgtestutils.NewMigrator(c, db, migrations)
+
+	m.MigrateTo(5)
+	durations := m.Durations()
+	c.Assert(durations, HasLen, 5)
+	for id := 1; id <= 5; id++ {
+		d, ok := durations[id]
+		c.Assert(ok, Equals, true, Commentf("no duration recorded for migration %d", id))
+		c.Assert(d >= 0, Equals, true, Commentf("negative duration %s for migration %d", d, id))
+	}
+}
+
+func (MigrateSuite) TestMigrateRouteTypes(c *C) {
+	db := setupTestDB(c, "routertest_route_types_migration")
+	m := pgtestutils.NewMigrator(c, db, migrations)
+
+	m.Seed(16,
+		&pgtestutils.Fixture{Table: "http_routes", Row: pgtestutils.Row{
+			"parent_ref": "some/parent/ref",
+			"service":    "migrationtest",
+			"domain":     "migrationtest.example.org",
+			"sticky":     true,
+			"aliases":    []string{"www.migrationtest.example.org"},
+		}},
+		&pgtestutils.Fixture{Table: "http_routes", Row: pgtestutils.Row{
+			"parent_ref": "some/parent/ref",
+			"service":    "migrationtest-api",
+			"domain":     "migrationtest.example.org",
+			"path":       "/api/",
+			"retries":    2,
+			"cors":       `{"allowed_origins":["https://example.org"]}`,
+		}},
+		&pgtestutils.Fixture{Table: "tcp_routes", Row: pgtestutils.Row{
+			"parent_ref": "some/parent/ref",
+			"service":    "migrationtest-tcp",
+			"port":       4444,
+		}},
+	)
+
+	// rows returns each row of table as JSON keyed by ID
+	rows := func(table, except string) map[string]string {
+		res, err := db.Query(fmt.Sprintf(`SELECT id, (row_to_json(r)::jsonb - $1)::text FROM %s AS r`, table), except)
+		c.Assert(err, IsNil)
+		defer res.Close()
+		rows := make(map[string]string)
+		for res.Next() {
+			var id, row string
+			c.Assert(res.Scan(&id, &row), IsNil)
+			rows[id] = row
+		}
+		c.Assert(res.Err(), IsNil)
+		return rows
+	}
+	httpRoutes := rows("http_routes", "")
+	tcpRoutes := rows("tcp_routes", "")
+	c.Assert(httpRoutes, HasLen, 2)
+	c.Assert(tcpRoutes, HasLen, 1)
+
+	m.MigrateTo(17)
+
+	// existing routes are unchanged apart from the added type
+	c.Assert(rows("http_routes", "type"), DeepEquals, httpRoutes)
+	c.Assert(rows("tcp_routes", "type"), DeepEquals, tcpRoutes)
+
+	// all routes can be listed together along with their type
+	res, err := db.Query(`SELECT id, type FROM routes`)
+	c.Assert(err, IsNil)
+	types := make(map[string]string)
+	for res.Next() {
+		var id, typ string
+		c.Assert(res.Scan(&id, &typ), IsNil)
+		types[id] = typ
+	}
+	c.Assert(res.Err(), IsNil)
+	c.Assert(types, HasLen, 3)
+	for id := range httpRoutes {
+		c.Assert(types[id], Equals, "http")
+	}
+	for id := range tcpRoutes {
+		c.Assert(types[id], Equals, "tcp")
+	}
+
+	// the shared constraints apply to both kinds of route
+	err = db.Exec(`INSERT INTO tcp_routes (parent_ref, service, port) VALUES ('some/parent/ref', '', 4445)`)
+	c.Assert(err, NotNil)
+	err = db.Exec(`INSERT INTO http_routes (parent_ref, service, domain, type) VALUES ('some/parent/ref', 'migrationtest', 'other.example.org', 'tcp')`)
+	c.Assert(err, NotNil)
+}
+
+// TestRouteServiceConstraint checks that routes can't be created without a
+// service, which has been enforced since the first migration so needs no
+// backfill.
+func (MigrateSuite) TestRouteServiceConstraint(c *C) {
+	db := setupTestDB(c, "routertest_route_service_constraint")
+	m := pgtestutils.NewMigrator(c, db, migrations)
+
+	assertRejected := func() {
+		for _, t := range []struct {
+			sql  string
+			code string
+		}{
+			{`INSERT INTO http_routes (parent_ref, service, domain) VALUES ('some/parent/ref', NULL, 'null.example.org')`, postgres.NotNullViolation},
+			{`INSERT INTO http_routes (parent_ref, service, domain) VALUES ('some/parent/ref', '', 'empty.example.org')`, postgres.CheckViolation},
+			{`INSERT INTO tcp_routes (parent_ref, service, port) VALUES ('some/parent/ref', NULL, 4444)`, postgres.NotNullViolation},
+			{`INSERT INTO tcp_routes (parent_ref, service, port) VALUES ('some/parent/ref', '', 4445)`, postgres.CheckViolation},
+		} {
+			err := db.Exec(t.sql)
+			c.Assert(postgres.IsPostgresCode(err, t.code), Equals, true, Commentf("%s: %v", t.sql, err))
+		}
+	}
+
+	m.MigrateTo(1)
+	assertRejected()
+
+	c.Assert(migrateDB(db), IsNil)
+	assertRejected()
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// backendTLSHandshakeTimeout limits the TLS handshakes of upgraded
+// connections to backends, matching the TLSHandshakeTimeout of the HTTP
+// transports
+const backendTLSHandshakeTimeout = 10 * time.Second
+
+// SetBackendTLS configures the proxy to connect to its backends over TLS
+// using c rather than plaintext, including for upgraded connections. If c
+// doesn't set a ServerName, the backend's host is used.
+func (p *ReverseProxy) SetBackendTLS(c *tls.Config) {
+	t := p.transport.http
+	if t == nil {
+		t = newHTTPTransport(DefaultKeepAlive)
+	}
+	t.TLSClientConfig = c
+	p.transport.http = t
+	p.transport.tls = c
+}
+
+// backendTLSConn performs a TLS handshake with the backend at addr over
+// conn, closing conn if it fails.
+func backendTLSConn(conn net.Conn, addr string, c *tls.Config) (net.Conn, error) {
+	if c.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		c = c.Clone()
+		c.ServerName = host
+	}
+	tlsConn := tls.Client(conn, c)
+	conn.SetDeadline(time.Now().Add(backendTLSHandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// BackendTracker tracks the number of in-flight requests to each backend so
+// that the removal of a backend can wait for its requests to finish rather
+// than cutting them off. It can be shared between proxies which use the same
+// set of backends.
+type BackendTracker struct {
+	mtx      sync.Mutex
+	inFlight map[string]int
+	idle     map[string]chan struct{}
+}
+
+// NewBackendTracker returns a BackendTracker with no in-flight requests.
+func NewBackendTracker() *BackendTracker {
+	return &BackendTracker{
+		inFlight: make(map[string]int),
+		idle:     make(map[string]chan struct{}),
+	}
+}
+
+// InFlight returns the number of in-flight requests to backend.
+func (t *BackendTracker) InFlight(backend string) int {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.inFlight[backend]
+}
+
+// acquire records a request to backend and returns a function which must be
+// called exactly once when the request has finished.
+func (t *BackendTracker) acquire(backend string) func() {
+	if t == nil {
+		return func() {}
+	}
+	t.mtx.Lock()
+	t.inFlight[backend]++
+	t.mtx.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { t.release(backend) })
+	}
+}
+
+func (t *BackendTracker) release(backend string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.inFlight[backend]--
+	if t.inFlight[backend] > 0 {
+		return
+	}
+	delete(t.inFlight, backend)
+	if ch, ok := t.idle[backend]; ok {
+		close(ch)
+		delete(t.idle, backend)
+	}
+}
+
+// Drain waits up to timeout for the in-flight requests to backend to finish,
+// returning whether they did. It doesn't stop new requests being sent to
+// backend, which is the job of the BackendListFunc of the proxies using it.
+func (t *BackendTracker) Drain(backend string, timeout time.Duration) bool {
+	t.mtx.Lock()
+	if t.inFlight[backend] == 0 {
+		t.mtx.Unlock()
+		return true
+	}
+	ch, ok := t.idle[backend]
+	if !ok {
+		ch = make(chan struct{})
+		t.idle[backend] = ch
+	}
+	t.mtx.Unlock()
+
+	select {
+	case <-ch:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// trackedBody calls done when the response body is closed.
+type trackedBody struct {
+	io.ReadCloser
+	done func()
+}
+
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done()
+	return err
+}
+
+// trackedConn calls done when the connection is closed.
+type trackedConn struct {
+	net.Conn
+	done func()
+}
+
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.done()
+	return err
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// minCompressSize is the smallest response body which is compressed, as the
+// gzip overhead outweighs the saving for very small bodies. Responses of
+// unknown length are always compressed.
+const minCompressSize = 1024
+
+// incompressibleTypes are content types which are already compressed, in
+// addition to all image, audio and video types other than SVG.
+var incompressibleTypes = map[string]struct{}{
+	"application/gzip":             {},
+	"application/x-gzip":           {},
+	"application/zip":              {},
+	"application/x-bzip2":          {},
+	"application/x-xz":             {},
+	"application/x-7z-compressed":  {},
+	"application/x-rar-compressed": {},
+	"application/octet-stream":     {},
+	"application/pdf":              {},
+	"application/font-woff":        {},
+	"font/woff":                    {},
+	"font/woff2":                   {},
+}
+
+// shouldCompress returns whether the response to req should be gzip encoded
+// by the proxy.
+func shouldCompress(req *http.Request, res *http.Response) bool {
+	if req.Method == "HEAD" || !acceptsGzip(req.Header) {
+		return false
+	}
+	switch res.StatusCode {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+	if enc := res.Header.Get("Content-Encoding"); enc != "" && enc != "identity" {
+		return false
+	}
+	if res.ContentLength >= 0 && res.ContentLength < minCompressSize {
+		return false
+	}
+	if res.Header.Get("Content-Range") != "" {
+		return false
+	}
+	return compressibleType(res.Header.Get("Content-Type"))
+}
+
+func compressibleType(contentType string) bool {
+	if contentType == "" {
+		// the client will sniff the type, which may be already compressed
+		return false
+	}
+	typ, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if _, ok := incompressibleTypes[typ]; ok {
+		return false
+	}
+	switch {
+	case typ == "image/svg+xml":
+		return true
+	case strings.HasPrefix(typ, "image/"), strings.HasPrefix(typ, "audio/"), strings.HasPrefix(typ, "video/"):
+		return false
+	}
+	return true
+}
+
+// acceptsGzip returns whether the Accept-Encoding request header allows a
+// gzip encoded response.
+func acceptsGzip(h http.Header) bool {
+	for _, v := range h["Accept-Encoding"] {
+		for _, token := range strings.Split(v, ",") {
+			parts := strings.Split(token, ";")
+			coding := strings.ToLower(strings.TrimSpace(parts[0]))
+			if coding != "gzip" && coding != "*" {
+				continue
+			}
+			accepted := true
+			for _, param := range parts[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+						accepted = false
+					}
+				}
+			}
+			return accepted
+		}
+	}
+	return false
+}
+
+// prepareCompressedHeaders updates response headers for a body which the
+// proxy gzip encodes.
+func prepareCompressedHeaders(h http.Header) {
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	h.Add("Vary", "Accept-Encoding")
+	// the encoded body is not byte for byte identical to the original, so
+	// a strong validator no longer applies
+	if etag := h.Get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("Etag", "W/"+etag)
+	}
+}
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(nil) },
+}
+
+// gzipResponseWriter gzip encodes data written to an http.ResponseWriter.
+// Flushing it flushes the encoder before flushing the response so that
+// streamed responses are not held up in the encoder's buffer.
+type gzipResponseWriter struct {
+	rw http.ResponseWriter
+	gz *gzip.Writer
+}
+
+func newGzipResponseWriter(rw http.ResponseWriter) *gzipResponseWriter {
+	gz := gzipWriterPool.Get().(*gzip.Writer)
+	gz.Reset(rw)
+	return &gzipResponseWriter{rw: rw, gz: gz}
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	return w.gz.Write(p)
+}
+
+func (w *gzipResponseWriter) Flush() {
+	w.gz.Flush()
+	if f, ok := w.rw.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Abandon discards any buffered data rather than writing it to the response
+// when the writer is closed, for use when the response is being aborted.
+func (w *gzipResponseWriter) Abandon() {
+	w.gz.Reset(ioutil.Discard)
+}
+
+// Close writes the gzip footer and returns the encoder to the pool.
+func (w *gzipResponseWriter) Close() error {
+	err := w.gz.Close()
+	gzipWriterPool.Put(w.gz)
+	return err
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS configures the Cross-Origin Resource Sharing headers which a
+// ReverseProxy adds to responses, and its handling of preflight requests.
+type CORS struct {
+	// AllowedOrigins are the origins allowed to make cross-origin
+	// requests, "*" allows any origin.
+	AllowedOrigins []string
+	// AllowedMethods are the methods allowed in cross-origin requests,
+	// defaulting to GET, HEAD and POST.
+	AllowedMethods []string
+	// AllowedHeaders are the request headers allowed in cross-origin
+	// requests in addition to the CORS safelisted headers.
+	AllowedHeaders []string
+	// AllowCredentials is whether cross-origin requests may include
+	// credentials.
+	AllowCredentials bool
+	// MaxAge, if non-zero, is how long the result of a preflight request
+	// may be cached.
+	MaxAge time.Duration
+}
+
+var defaultCORSMethods = []string{"GET", "HEAD", "POST"}
+
+// safelistedHeaders are the request headers which are always allowed in
+// cross-origin requests.
+var safelistedHeaders = []string{"Accept", "Accept-Language", "Content-Language", "Content-Type"}
+
+var preflightForbidden = []byte("Forbidden: cross-origin request not allowed\n")
+
+// isPreflight returns whether req is a CORS preflight request.
+func isPreflight(req *http.Request) bool {
+	return req.Method == "OPTIONS" && req.Header.Get("Origin") != "" && req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+func (c *CORS) allowsOrigin(origin string) bool {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORS) methods() []string {
+	if len(c.AllowedMethods) == 0 {
+		return defaultCORSMethods
+	}
+	return c.AllowedMethods
+}
+
+func (c *CORS) allowsMethod(method string) bool {
+	for _, m := range c.methods() {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORS) allowsHeader(header string) bool {
+	for _, list := range [][]string{safelistedHeaders, c.AllowedHeaders} {
+		for _, h := range list {
+			if strings.EqualFold(h, header) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// servePreflight responds to a preflight request, with a 204 status if the
+// origin, method and headers of the actual request are allowed and a 403
+// otherwise. It returns the status.
+func (c *CORS) servePreflight(rw http.ResponseWriter, req *http.Request) int {
+	h := rw.Header()
+	h.Add("Vary", "Origin")
+	origin := req.Header.Get("Origin")
+	allowed := c.allowsOrigin(origin) && c.allowsMethod(req.Header.Get("Access-Control-Request-Method"))
+	var headers []string
+	for _, header := range strings.Split(req.Header.Get("Access-Control-Request-Headers"), ",") {
+		if header = strings.TrimSpace(header); header == "" {
+			continue
+		}
+		if !c.allowsHeader(header) {
+			allowed = false
+		}
+		headers = append(headers, header)
+	}
+	if !allowed {
+		rw.WriteHeader(http.StatusForbidden)
+		rw.Write(preflightForbidden)
+		return http.StatusForbidden
+	}
+
+	c.setOriginHeaders(h, origin)
+	h.Set("Access-Control-Allow-Methods", strings.Join(c.methods(), ", "))
+	if len(headers) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if c.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+	}
+	rw.WriteHeader(http.StatusNoContent)
+	return http.StatusNoContent
+}
+
+// setResponseHeaders sets the CORS headers of a response to a request from
+// origin, replacing any set by the backend.
+func (c *CORS) setResponseHeaders(h http.Header, origin string) {
+	h.Del("Access-Control-Allow-Origin")
+	h.Del("Access-Control-Allow-Credentials")
+	h.Add("Vary", "Origin")
+	if origin != "" && c.allowsOrigin(origin) {
+		c.setOriginHeaders(h, origin)
+	}
+}
+
+func (c *CORS) setOriginHeaders(h http.Header, origin string) {
+	if c.AllowCredentials {
+		// the wildcard origin can't be used with credentials
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Credentials", "true")
+	} else if c.allowsAnyOrigin() {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+}
+
+func (c *CORS) allowsAnyOrigin() bool {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+const (
+	defaultHealthCheckInterval  = 10 * time.Second
+	defaultHealthCheckThreshold = 3
+
+	// maxHealthCheckTimeout is the longest a single check may take, checks
+	// with a shorter interval time out after the interval.
+	maxHealthCheckTimeout = 5 * time.Second
+)
+
+// HealthChecker periodically requests a path from each backend returned by a
+// BackendListFunc and marks backends which consecutively fail a number of
+// checks as unhealthy, so proxies using it stop sending them requests until
+// they pass a check again.
+type HealthChecker struct {
+	path        string
+	interval    time.Duration
+	threshold   int
+	getBackends BackendListFunc
+	client      *http.Client
+	scheme      string
+	l           log15.Logger
+
+	mtx       sync.Mutex
+	failures  map[string]int
+	unhealthy map[string]struct{}
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewHealthChecker returns a HealthChecker which requests path from the
+// backends returned by bf every interval, and considers a backend unhealthy
+// after threshold consecutive failures. Zero values of interval and threshold
+// use the defaults of 10s and 3. Checks do not start until Start is called.
+func NewHealthChecker(path string, interval time.Duration, threshold int, bf BackendListFunc, l log15.Logger) *HealthChecker {
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	if threshold <= 0 {
+		threshold = defaultHealthCheckThreshold
+	}
+	timeout := interval
+	if timeout > maxHealthCheckTimeout {
+		timeout = maxHealthCheckTimeout
+	}
+	return &HealthChecker{
+		path:        path,
+		interval:    interval,
+		threshold:   threshold,
+		getBackends: bf,
+		client: &http.Client{
+			Transport: &http.Transport{Dial: dialer.Dial, DisableKeepAlives: true},
+			Timeout:   timeout,
+		},
+		scheme:    "http",
+		l:         l,
+		failures:  make(map[string]int),
+		unhealthy: make(map[string]struct{}),
+		stop:      make(chan struct{}),
+	}
+}
+
+// SetTLS makes the checker connect to backends over TLS using c, for routes
+// with backend TLS. It must be called before Start.
+func (h *HealthChecker) SetTLS(c *tls.Config) {
+	h.client.Transport.(*http.Transport).TLSClientConfig = c
+	h.scheme = "https"
+}
+
+// Start starts checking backends in a goroutine, with the first check
+// happening immediately.
+func (h *HealthChecker) Start() {
+	go h.run()
+}
+
+// Stop stops checking backends.
+func (h *HealthChecker) Stop() {
+	h.stopOnce.Do(func() { close(h.stop) })
+}
+
+// Healthy returns whether backend has not failed enough consecutive checks to
+// be considered unhealthy.
+func (h *HealthChecker) Healthy(backend string) bool {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	_, unhealthy := h.unhealthy[backend]
+	return !unhealthy
+}
+
+// filter removes unhealthy backends from backends in place. If every backend
+// is unhealthy they are all returned, as a failing health check is more
+// likely to be a problem with the check than with every backend.
+func (h *HealthChecker) filter(backends []string) []string {
+	if h == nil {
+		return backends
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if len(h.unhealthy) == 0 {
+		return backends
+	}
+	res := backends[:0]
+	var removed []string
+	for _, b := range backends {
+		if _, ok := h.unhealthy[b]; ok {
+			removed = append(removed, b)
+		} else {
+			res = append(res, b)
+		}
+	}
+	if len(res) == 0 {
+		return append(res, removed...)
+	}
+	return res
+}
+
+func (h *HealthChecker) run() {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		h.checkAll()
+		select {
+		case <-ticker.C:
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+// checkAll checks every current backend concurrently, and forgets the state
+// of backends which have gone away.
+func (h *HealthChecker) checkAll() {
+	backends := h.getBackends()
+	current := make(map[string]struct{}, len(backends))
+	var wg sync.WaitGroup
+	for _, b := range backends {
+		current[b] = struct{}{}
+		wg.Add(1)
+		go func(backend string) {
+			defer wg.Done()
+			h.record(backend, h.check(backend))
+		}(b)
+	}
+	wg.Wait()
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	for b := range h.failures {
+		if _, ok := current[b]; !ok {
+			delete(h.failures, b)
+		}
+	}
+	for b := range h.unhealthy {
+		if _, ok := current[b]; !ok {
+			delete(h.unhealthy, b)
+		}
+	}
+}
+
+func (h *HealthChecker) check(backend string) error {
+	res, err := h.client.Get(h.scheme + "://" + backend + h.path)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode >= 400 {
+		return healthCheckStatusError(res.StatusCode)
+	}
+	return nil
+}
+
+func (h *HealthChecker) record(backend string, err error) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	_, wasUnhealthy := h.unhealthy[backend]
+	if err == nil {
+		delete(h.failures, backend)
+		if wasUnhealthy {
+			delete(h.unhealthy, backend)
+			h.l.Info("backend passed health check, marking healthy", "backend", backend)
+		}
+		return
+	}
+	h.failures[backend]++
+	if !wasUnhealthy && h.failures[backend] >= h.threshold {
+		h.unhealthy[backend] = struct{}{}
+		h.l.Warn("backend failed health check, marking unhealthy", "backend", backend, "failures", h.failures[backend], "err", err)
+	}
+}
+
+type healthCheckStatusError int
+
+func (e healthCheckStatusError) Error() string {
+	return "unhealthy response status: " + http.StatusText(int(e))
+}
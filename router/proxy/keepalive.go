@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+)
+
+// KeepAlive configures the pool of idle connections which the proxy keeps
+// open to backends so that they can be reused by subsequent requests.
+type KeepAlive struct {
+	// MaxIdleConnsPerBackend is the maximum number of idle connections
+	// kept open to each backend, a negative value disables keep-alive.
+	MaxIdleConnsPerBackend int
+	// IdleConnTimeout is how long an idle connection is kept open before
+	// being closed, zero meaning no limit.
+	IdleConnTimeout time.Duration
+}
+
+// DefaultKeepAlive is the keep-alive configuration used for routes which
+// don't set their own.
+var DefaultKeepAlive = KeepAlive{
+	MaxIdleConnsPerBackend: 32,
+	IdleConnTimeout:        90 * time.Second,
+}
+
+// SetDefaultKeepAlive replaces DefaultKeepAlive and the transport shared by
+// routes without their own keep-alive configuration. It should be called
+// before any requests are proxied.
+func SetDefaultKeepAlive(k KeepAlive) {
+	DefaultKeepAlive = k
+	httpTransport = newHTTPTransport(k)
+}
+
+// withDefaults returns k with unset fields taken from DefaultKeepAlive.
+func (k KeepAlive) withDefaults() KeepAlive {
+	if k.MaxIdleConnsPerBackend == 0 {
+		k.MaxIdleConnsPerBackend = DefaultKeepAlive.MaxIdleConnsPerBackend
+	}
+	if k.IdleConnTimeout == 0 {
+		k.IdleConnTimeout = DefaultKeepAlive.IdleConnTimeout
+	}
+	return k
+}
+
+func newHTTPTransport(k KeepAlive) *http.Transport {
+	t := &http.Transport{
+		Dial:                  customDial,
+		ResponseHeaderTimeout: 120 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second, // only used with backend TLS
+		IdleConnTimeout:       k.IdleConnTimeout,
+	}
+	if k.MaxIdleConnsPerBackend < 0 {
+		t.DisableKeepAlives = true
+	} else {
+		t.MaxIdleConnsPerHost = k.MaxIdleConnsPerBackend
+	}
+	return t
+}
+
+// SetKeepAlive configures the proxy to pool connections to its backends
+// separately from other routes using k, with unset fields taken from
+// DefaultKeepAlive.
+func (p *ReverseProxy) SetKeepAlive(k KeepAlive) {
+	t := newHTTPTransport(k.withDefaults())
+	t.TLSClientConfig = p.transport.tls
+	p.transport.http = t
+}
+
+// CloseIdleConnections closes the idle connections of the proxy's own
+// connection pool, if it has one. It should be called once the proxy is
+// no longer used.
+func (p *ReverseProxy) CloseIdleConnections() {
+	if t := p.transport.http; t != nil {
+		t.CloseIdleConnections()
+	}
+}
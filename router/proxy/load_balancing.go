@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"net"
+	"sort"
+	"sync/atomic"
+)
+
+// LoadBalancing is an algorithm for choosing the backend a request is sent
+// to. If the chosen backend fails, the others are tried in the order the
+// algorithm prefers them.
+type LoadBalancing string
+
+const (
+	// RoundRobin sends requests to each backend in turn.
+	RoundRobin LoadBalancing = "round-robin"
+
+	// LeastConn sends requests to the backend with the fewest in-flight
+	// requests, counting those from all routes to the same service if the
+	// proxy tracks its backends, in turn between backends with the same
+	// number.
+	LeastConn LoadBalancing = "least-conn"
+
+	// IPHash sends requests from the same client IP address to the same
+	// backend for as long as it is available, giving clients affinity to
+	// a backend without sticky session cookies. Backends being added or
+	// removed only moves the clients of those backends.
+	IPHash LoadBalancing = "ip-hash"
+)
+
+// SetLoadBalancing sets the algorithm used to choose the backend of each
+// request, which defaults to RoundRobin.
+func (p *ReverseProxy) SetLoadBalancing(lb LoadBalancing) {
+	p.transport.balancing = lb
+}
+
+// orderBackends sorts backends in place into the order requests from
+// clientAddr should try them in.
+func (t *transport) orderBackends(backends []string, clientAddr string) {
+	if len(backends) < 2 {
+		return
+	}
+	// backends are listed in no particular order, so sort them so that
+	// rotating through them is consistent
+	sort.Strings(backends)
+
+	switch t.balancing {
+	case IPHash:
+		if ip := clientIP(clientAddr); ip != "" {
+			sortByHash(backends, ip)
+			return
+		}
+	case LeastConn:
+		if t.tracker != nil {
+			t.rotate(backends)
+			inFlight := make([]int, len(backends))
+			for i, b := range backends {
+				inFlight[i] = t.tracker.InFlight(b)
+			}
+			sort.Stable(byInFlight{backends, inFlight})
+			return
+		}
+	}
+	t.rotate(backends)
+}
+
+// rotate rotates backends by one more place than for the previous request.
+func (t *transport) rotate(backends []string) {
+	n := int(atomic.AddUint64(&t.next, 1) % uint64(len(backends)))
+	rotated := make([]string, 0, len(backends))
+	rotated = append(rotated, backends[n:]...)
+	rotated = append(rotated, backends[:n]...)
+	copy(backends, rotated)
+}
+
+// sortByHash sorts backends by the hash of each backend with key, which
+// orders them consistently for each key (i.e. rendezvous hashing).
+func sortByHash(backends []string, key string) {
+	hashes := make([]uint64, len(backends))
+	for i, b := range backends {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(b))
+		hashes[i] = h.Sum64()
+	}
+	sort.Sort(byHash{backends, hashes})
+}
+
+func clientIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+type byInFlight struct {
+	backends []string
+	inFlight []int
+}
+
+func (b byInFlight) Len() int           { return len(b.backends) }
+func (b byInFlight) Less(i, j int) bool { return b.inFlight[i] < b.inFlight[j] }
+func (b byInFlight) Swap(i, j int) {
+	b.backends[i], b.backends[j] = b.backends[j], b.backends[i]
+	b.inFlight[i], b.inFlight[j] = b.inFlight[j], b.inFlight[i]
+}
+
+type byHash struct {
+	backends []string
+	hashes   []uint64
+}
+
+func (b byHash) Len() int           { return len(b.backends) }
+func (b byHash) Less(i, j int) bool { return b.hashes[i] > b.hashes[j] }
+func (b byHash) Swap(i, j int) {
+	b.backends[i], b.backends[j] = b.backends[j], b.backends[i]
+	b.hashes[i], b.hashes[j] = b.hashes[j], b.hashes[i]
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds in seconds of the buckets of request
+// latency histograms, which are the Prometheus client defaults.
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// RouteMetrics counts the requests proxied for a route by response status
+// code, and records a histogram of their latencies.
+type RouteMetrics struct {
+	mtx      sync.Mutex
+	statuses map[int]uint64
+	buckets  []uint64
+	count    uint64
+	sum      float64
+}
+
+// NewRouteMetrics returns an empty RouteMetrics.
+func NewRouteMetrics() *RouteMetrics {
+	return &RouteMetrics{
+		statuses: make(map[int]uint64),
+		buckets:  make([]uint64, len(LatencyBuckets)),
+	}
+}
+
+// Observe records a request which completed with the given status after
+// latency. It does nothing if m is nil.
+func (m *RouteMetrics) Observe(status int, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	seconds := latency.Seconds()
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.statuses[status]++
+	for i, le := range LatencyBuckets {
+		if seconds <= le {
+			m.buckets[i]++
+		}
+	}
+	m.count++
+	m.sum += seconds
+}
+
+// MetricsSnapshot is a copy of the metrics of a route at a point in time.
+type MetricsSnapshot struct {
+	// Statuses is the number of requests completed with each status code.
+	Statuses map[int]uint64
+	// Buckets is the cumulative number of requests which completed within
+	// each of LatencyBuckets.
+	Buckets []uint64
+	// Count is the total number of requests and Sum their total latency in
+	// seconds.
+	Count uint64
+	Sum   float64
+}
+
+// Snapshot returns a copy of the current metrics.
+func (m *RouteMetrics) Snapshot() MetricsSnapshot {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	s := MetricsSnapshot{
+		Statuses: make(map[int]uint64, len(m.statuses)),
+		Buckets:  append([]uint64(nil), m.buckets...),
+		Count:    m.count,
+		Sum:      m.sum,
+	}
+	for status, n := range m.statuses {
+		s.Statuses[status] = n
+	}
+	return s
+}
@@ -7,13 +7,16 @@
 package proxy
 
 import (
+	"errors"
 	"io"
 	"net"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/flynn/flynn/pkg/ctxhelper"
 	"golang.org/x/net/context"
 	"gopkg.in/inconshreveable/log15.v2"
 )
@@ -36,6 +39,9 @@ var (
 	}
 
 	serviceUnavailable = []byte("Service Unavailable\n")
+	requestTooLarge    = []byte("Request Entity Too Large\n")
+	responseTooLarge   = []byte("Bad Gateway: response too large\n")
+	gatewayTimeout     = []byte("Gateway Timeout\n")
 )
 
 // ReverseProxy is an HTTP Handler that takes an incoming request and
@@ -53,6 +59,63 @@ type ReverseProxy struct {
 
 	// Logger is the logger for the proxy.
 	Logger log15.Logger
+
+	// AccessLogger, if set, logs the method, path, status, latency and
+	// backend of each proxied request.
+	AccessLogger log15.Logger
+
+	// IdleTimeout, if non-zero, is how long an upgraded connection may go
+	// without data being sent in either direction before it is closed.
+	IdleTimeout time.Duration
+
+	// RequestTimeout, if non-zero, is how long to wait for a backend to
+	// start responding to a request (including any retries) before giving
+	// up with a 504 status. It doesn't limit how long the response body
+	// takes to be sent.
+	RequestTimeout time.Duration
+
+	// MaxRequestBodySize, if non-zero, is the maximum size of request
+	// bodies, larger requests are rejected with a 413 status.
+	MaxRequestBodySize int64
+
+	// MaxResponseBodySize, if non-zero, is the maximum size of response
+	// bodies. Responses which are known to be larger are replaced with a 502
+	// status, and streamed responses are cut off at the limit.
+	MaxResponseBodySize int64
+
+	// Compress is whether to gzip encode responses to clients which accept
+	// it, unless the backend already encoded them, they are small or their
+	// content type is already compressed.
+	Compress bool
+
+	// Retries is the number of other backends to retry a request on if it
+	// fails with a connection error after being sent to a backend. Only
+	// requests with idempotent methods are retried unless
+	// RetryNonIdempotent is set. Requests which fail to connect to a
+	// backend are always tried on the other backends.
+	Retries int
+
+	// RetryNonIdempotent is whether requests with non-idempotent methods
+	// (e.g. POST) are retried.
+	RetryNonIdempotent bool
+
+	// Metrics, if set, records the status and latency of each proxied
+	// request.
+	Metrics *RouteMetrics
+
+	// CORS, if set, configures the CORS headers added to responses, and
+	// preflight requests are responded to directly rather than being
+	// proxied.
+	CORS *CORS
+
+	// BackendHost, if set, replaces the Host header of requests sent to
+	// backends.
+	BackendHost string
+
+	// ForwardedHost is whether requests which have their Host header
+	// replaced by BackendHost have the original host set in the
+	// X-Forwarded-Host header, unless a previous proxy has set it.
+	ForwardedHost bool
 }
 
 // NewReverseProxy initializes a new ReverseProxy with a callback to get
@@ -70,6 +133,17 @@ func NewReverseProxy(bf BackendListFunc, stickyKey *[32]byte, sticky bool, l log
 	}
 }
 
+// TrackBackends configures the proxy to record in-flight requests in t.
+func (p *ReverseProxy) TrackBackends(t *BackendTracker) {
+	p.transport.tracker = t
+}
+
+// CheckHealth configures the proxy to not send requests to backends which h
+// considers unhealthy.
+func (p *ReverseProxy) CheckHealth(h *HealthChecker) {
+	p.transport.health = h
+}
+
 // ServeHTTP implements http.Handler.
 func (p *ReverseProxy) ServeHTTP(ctx context.Context, rw http.ResponseWriter, req *http.Request) {
 	transport := p.transport
@@ -78,11 +152,24 @@ func (p *ReverseProxy) ServeHTTP(ctx context.Context, rw http.ResponseWriter, re
 	}
 
 	outreq := prepareRequest(req)
+	if p.BackendHost != "" {
+		if p.ForwardedHost && outreq.Header.Get("X-Forwarded-Host") == "" {
+			outreq.Header.Set("X-Forwarded-Host", req.Host)
+		}
+		outreq.Host = p.BackendHost
+	}
 
 	l := p.Logger.New("request_id", req.Header.Get("X-Request-Id"), "client_addr", req.RemoteAddr, "host", req.Host, "path", req.URL.Path, "method", req.Method)
 
+	if p.CORS != nil && isPreflight(req) {
+		status := p.CORS.servePreflight(rw, req)
+		p.logAccess(ctx, req, status, "")
+		return
+	}
+
 	if isConnectionUpgrade(req.Header) {
-		p.serveUpgrade(rw, l, outreq)
+		status, backend := p.serveUpgrade(rw, l, outreq)
+		p.logAccess(ctx, req, status, backend)
 		return
 	}
 
@@ -98,16 +185,119 @@ func (p *ReverseProxy) ServeHTTP(ctx context.Context, rw http.ResponseWriter, re
 		}
 	}()
 
-	res, err := transport.RoundTrip(ctx, outreq, l)
+	var body *maxBytesReader
+	if p.MaxRequestBodySize > 0 && outreq.Body != nil {
+		if outreq.ContentLength > p.MaxRequestBodySize {
+			l.Info("request body too large", "status", "413", "content_length", outreq.ContentLength, "max", p.MaxRequestBodySize)
+			p.writeRequestTooLarge(ctx, rw, req)
+			return
+		}
+		body = &maxBytesReader{ReadCloser: outreq.Body, n: p.MaxRequestBodySize}
+		outreq.Body = body
+	}
+
+	var timer *time.Timer
+	if p.RequestTimeout > 0 {
+		timer = time.AfterFunc(p.RequestTimeout, cancel)
+	}
+	res, err := transport.RoundTrip(ctx, outreq, l, p.retries(req))
+	if timer != nil && !timer.Stop() {
+		// the timeout fired, so the request was canceled (or will be
+		// if the response arrived just in time)
+		if err == nil {
+			res.Body.Close()
+		}
+		l.Error("request timed out", "status", "504", "timeout", p.RequestTimeout)
+		rw.WriteHeader(http.StatusGatewayTimeout)
+		rw.Write(gatewayTimeout)
+		p.logAccess(ctx, req, http.StatusGatewayTimeout, "")
+		return
+	}
+	if err != nil && body != nil && body.Exceeded() {
+		l.Info("request body too large", "status", "413", "max", p.MaxRequestBodySize)
+		p.writeRequestTooLarge(ctx, rw, req)
+		return
+	}
 	if err != nil {
 		rw.WriteHeader(http.StatusServiceUnavailable)
 		rw.Write(serviceUnavailable)
+		p.logAccess(ctx, req, http.StatusServiceUnavailable, "")
 		return
 	}
 	defer res.Body.Close()
 
+	if p.MaxResponseBodySize > 0 && res.ContentLength > p.MaxResponseBodySize {
+		l.Error("response body too large", "status", "502", "content_length", res.ContentLength, "max", p.MaxResponseBodySize)
+		rw.WriteHeader(http.StatusBadGateway)
+		rw.Write(responseTooLarge)
+		p.logAccess(ctx, req, http.StatusBadGateway, res.Request.URL.Host)
+		return
+	}
+
 	prepareResponseHeaders(res)
-	p.writeResponse(rw, res)
+	if p.CORS != nil {
+		p.CORS.setResponseHeaders(res.Header, req.Header.Get("Origin"))
+	}
+	compress := p.Compress && shouldCompress(req, res)
+	if compress {
+		prepareCompressedHeaders(res.Header)
+	}
+	p.writeResponse(rw, res, compress)
+	p.logAccess(ctx, req, res.StatusCode, res.Request.URL.Host)
+}
+
+// retries returns the number of times req may be retried after being sent
+// to a backend.
+func (p *ReverseProxy) retries(req *http.Request) int {
+	if p.RetryNonIdempotent || idempotentMethod(req.Method) {
+		return p.Retries
+	}
+	return 0
+}
+
+// idempotentMethod returns whether requests with the given method can be
+// safely repeated, as defined by RFC 7231.
+func idempotentMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func (p *ReverseProxy) writeRequestTooLarge(ctx context.Context, rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Connection", "close")
+	rw.WriteHeader(http.StatusRequestEntityTooLarge)
+	rw.Write(requestTooLarge)
+	p.logAccess(ctx, req, http.StatusRequestEntityTooLarge, "")
+}
+
+// logAccess records a proxied request in the proxy's metrics and logs it to
+// the access logger if they are set. The latency is measured from the
+// request start time in ctx if there is one.
+func (p *ReverseProxy) logAccess(ctx context.Context, req *http.Request, status int, backend string) {
+	start, hasStart := ctxhelper.StartTimeFromContext(ctx)
+	var latency time.Duration
+	if hasStart {
+		latency = time.Since(start)
+	}
+	p.Metrics.Observe(status, latency)
+	if p.AccessLogger == nil {
+		return
+	}
+	fields := []interface{}{
+		"request_id", req.Header.Get("X-Request-Id"),
+		"client_addr", req.RemoteAddr,
+		"host", req.Host,
+		"method", req.Method,
+		"path", req.URL.Path,
+		"status", status,
+		"backend", backend,
+	}
+	if hasStart {
+		fields = append(fields, "latency", latency)
+	}
+	p.AccessLogger.Info("request completed", fields...)
 }
 
 // ServeConn takes an inbound conn and proxies it to a backend.
@@ -141,7 +331,9 @@ func (p *ReverseProxy) ServeConn(ctx context.Context, dconn net.Conn) {
 	joinConns(uconn, dconn)
 }
 
-func (p *ReverseProxy) serveUpgrade(rw http.ResponseWriter, l log15.Logger, req *http.Request) {
+// serveUpgrade proxies a connection upgrade request (e.g. a WebSocket) and
+// returns the response status and backend once the connection is closed.
+func (p *ReverseProxy) serveUpgrade(rw http.ResponseWriter, l log15.Logger, req *http.Request) (int, string) {
 	transport := p.transport
 	if transport == nil {
 		panic("router: nil transport for proxy")
@@ -151,15 +343,16 @@ func (p *ReverseProxy) serveUpgrade(rw http.ResponseWriter, l log15.Logger, req
 	if err != nil {
 		rw.WriteHeader(http.StatusServiceUnavailable)
 		rw.Write(serviceUnavailable)
-		return
+		return http.StatusServiceUnavailable, ""
 	}
 	defer uconn.Close()
+	backend := req.URL.Host
 
 	prepareResponseHeaders(res)
 	if res.StatusCode != 101 {
 		res.Header.Set("Connection", "close")
-		p.writeResponse(rw, res)
-		return
+		p.writeResponse(rw, res, false)
+		return res.StatusCode, backend
 	}
 
 	dconn, bufrw, err := rw.(http.Hijacker).Hijack()
@@ -167,15 +360,18 @@ func (p *ReverseProxy) serveUpgrade(rw http.ResponseWriter, l log15.Logger, req
 		l.Error("error hijacking request", "err", err, "status", "503")
 		rw.WriteHeader(http.StatusServiceUnavailable)
 		rw.Write(serviceUnavailable)
-		return
+		return http.StatusServiceUnavailable, backend
 	}
 	defer dconn.Close()
 
 	if err := res.Write(dconn); err != nil {
 		l.Error("error proxying response to client", "err", err)
-		return
+		return res.StatusCode, backend
+	}
+	if joinConnsIdle(uconn, &streamConn{bufrw.Reader, dconn}, p.IdleTimeout) {
+		l.Info("closed idle upgraded connection", "backend", backend, "idle_timeout", p.IdleTimeout)
 	}
-	joinConns(uconn, &streamConn{bufrw.Reader, dconn})
+	return res.StatusCode, backend
 }
 
 func prepareResponseHeaders(res *http.Response) {
@@ -204,11 +400,38 @@ func prepareResponseHeaders(res *http.Response) {
 	}
 }
 
-func (p *ReverseProxy) writeResponse(rw http.ResponseWriter, res *http.Response) {
+func (p *ReverseProxy) writeResponse(rw http.ResponseWriter, res *http.Response, compress bool) {
 	copyHeader(rw.Header(), res.Header)
 
 	rw.WriteHeader(res.StatusCode)
-	p.copyResponse(rw, res.Body)
+	var dst io.Writer = rw
+	var gz *gzipResponseWriter
+	if compress {
+		gz = newGzipResponseWriter(rw)
+		defer gz.Close()
+		dst = gz
+	}
+	if p.MaxResponseBodySize <= 0 {
+		p.copyResponse(dst, res.Body)
+		return
+	}
+
+	// the response size isn't known up front, so copy up to the limit and
+	// close the client connection if there is more so the client doesn't
+	// mistake the truncated body for a complete response
+	p.copyResponse(dst, io.LimitReader(res.Body, p.MaxResponseBodySize))
+	if n, _ := res.Body.Read(make([]byte, 1)); n == 0 {
+		return
+	}
+	p.Logger.Error("response body too large, closing connection", "max", p.MaxResponseBodySize)
+	if gz != nil {
+		gz.Abandon()
+	}
+	if hj, ok := rw.(http.Hijacker); ok {
+		if conn, _, err := hj.Hijack(); err == nil {
+			conn.Close()
+		}
+	}
 }
 
 func isConnectionUpgrade(h http.Header) bool {
@@ -258,17 +481,53 @@ func closeWrite(conn net.Conn) {
 }
 
 func joinConns(uconn, dconn net.Conn) {
+	joinConnsIdle(uconn, dconn, 0)
+}
+
+// joinConnsIdle is like joinConns but closes both connections if no data is
+// copied in either direction for idleTimeout (unless it is zero). It returns
+// whether the connections were closed for being idle.
+func joinConnsIdle(uconn, dconn net.Conn, idleTimeout time.Duration) bool {
+	var src, dst io.Reader = dconn, uconn
+	var timedOut int32
+	if idleTimeout > 0 {
+		timer := time.AfterFunc(idleTimeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			uconn.Close()
+			dconn.Close()
+		})
+		defer timer.Stop()
+		src = &activityReader{dconn, timer, idleTimeout}
+		dst = &activityReader{uconn, timer, idleTimeout}
+	}
+
 	done := make(chan struct{})
 
 	go func() {
-		io.Copy(uconn, dconn)
+		io.Copy(uconn, src)
 		closeWrite(uconn)
 		done <- struct{}{}
 	}()
 
-	io.Copy(dconn, uconn)
+	io.Copy(dconn, dst)
 	closeWrite(dconn)
 	<-done
+	return atomic.LoadInt32(&timedOut) == 1
+}
+
+// activityReader resets timer each time data is read.
+type activityReader struct {
+	io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r *activityReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
 }
 
 func prepareRequest(req *http.Request) *http.Request {
@@ -353,3 +612,36 @@ func (m *maxLatencyWriter) flushLoop() {
 }
 
 func (m *maxLatencyWriter) stop() { m.done <- true }
+
+// maxBytesReader is like http.MaxBytesReader but records whether the limit
+// was exceeded so that the proxy can respond with a 413 status.
+type maxBytesReader struct {
+	io.ReadCloser
+	n int64
+
+	// exceeded is set atomically as the body is read by the transport
+	exceeded int32
+}
+
+func (r *maxBytesReader) Exceeded() bool {
+	return atomic.LoadInt32(&r.exceeded) == 1
+}
+
+var errRequestTooLarge = errors.New("router: request body too large")
+
+func (r *maxBytesReader) Read(p []byte) (int, error) {
+	if r.Exceeded() {
+		return 0, errRequestTooLarge
+	}
+	// read one more byte than allowed to detect bodies over the limit
+	if int64(len(p)) > r.n+1 {
+		p = p[:r.n+1]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if int64(n) > r.n {
+		atomic.StoreInt32(&r.exceeded, 1)
+		return int(r.n), errRequestTooLarge
+	}
+	r.n -= int64(n)
+	return n, err
+}
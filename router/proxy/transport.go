@@ -2,15 +2,17 @@ package proxy
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/base64"
 	"errors"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"time"
 
-	"github.com/flynn/flynn/pkg/random"
 	"golang.org/x/crypto/nacl/secretbox"
 	"golang.org/x/net/context"
 	"gopkg.in/inconshreveable/log15.v2"
@@ -24,11 +26,9 @@ var (
 	errNoBackends = errors.New("router: no backends available")
 	errCanceled   = errors.New("router: backend connection canceled")
 
-	httpTransport = &http.Transport{
-		Dial: customDial,
-		ResponseHeaderTimeout: 120 * time.Second,
-		TLSHandshakeTimeout:   10 * time.Second, // unused, but safer to leave default in place
-	}
+	// httpTransport is shared by routes without their own keep-alive
+	// configuration
+	httpTransport = newHTTPTransport(DefaultKeepAlive)
 
 	dialer backendDialer = &net.Dialer{
 		Timeout:   1 * time.Second,
@@ -44,11 +44,38 @@ type transport struct {
 
 	stickyCookieKey   *[32]byte
 	useStickySessions bool
+
+	// tracker, if set, records in-flight requests to each backend
+	tracker *BackendTracker
+
+	// health, if set, excludes unhealthy backends from new requests
+	health *HealthChecker
+
+	// http, if set, is used for requests instead of the shared
+	// httpTransport
+	http *http.Transport
+
+	// tls, if set, is used to connect to backends over TLS
+	tls *tls.Config
+
+	// balancing is the algorithm used to order backends, defaulting to
+	// RoundRobin
+	balancing LoadBalancing
+
+	// next is the number of requests ordered by rotating the backends
+	next uint64
+}
+
+func (t *transport) backendTransport() *http.Transport {
+	if t.http != nil {
+		return t.http
+	}
+	return httpTransport
 }
 
-func (t *transport) getOrderedBackends(stickyBackend string) []string {
-	backends := t.getBackends()
-	shuffle(backends)
+func (t *transport) getOrderedBackends(clientAddr, stickyBackend string) []string {
+	backends := t.health.filter(t.getBackends())
+	t.orderBackends(backends, clientAddr)
 
 	if stickyBackend != "" {
 		swapToFront(backends, stickyBackend)
@@ -72,7 +99,12 @@ func (t *transport) setStickyBackend(res *http.Response, originalStickyBackend s
 	}
 }
 
-func (t *transport) RoundTrip(ctx context.Context, req *http.Request, l log15.Logger) (*http.Response, error) {
+// RoundTrip sends req to the first backend which accepts a connection. If
+// retries is non-zero, a request which fails with a connection error after
+// being sent to a backend is also retried on up to retries other backends,
+// so the caller must only allow retries of requests which are safe to
+// repeat.
+func (t *transport) RoundTrip(ctx context.Context, req *http.Request, l log15.Logger, retries int) (*http.Response, error) {
 	// http.Transport closes the request body on a failed dial, issue #875
 	req.Body = &fakeCloseReadCloser{req.Body}
 	defer req.Body.(*fakeCloseReadCloser).RealClose()
@@ -80,27 +112,54 @@ func (t *transport) RoundTrip(ctx context.Context, req *http.Request, l log15.Lo
 	// hook up CloseNotify to cancel the request
 	req.Cancel = ctx.Done()
 
+	var body []byte
+	if retries > 0 {
+		var err error
+		body, err = bufferRetryBody(req)
+		if err == errRetryBodyTooLarge {
+			retries = 0
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if t.tls != nil {
+		req.URL.Scheme = "https"
+	}
+
 	stickyBackend := t.getStickyBackend(req)
-	backends := t.getOrderedBackends(stickyBackend)
+	backends := t.getOrderedBackends(req.RemoteAddr, stickyBackend)
+	var retried int
 	for i, backend := range backends {
+		if body != nil {
+			req.Body = &fakeCloseReadCloser{ioutil.NopCloser(bytes.NewReader(body))}
+		}
 		req.URL.Host = backend
-		res, err := httpTransport.RoundTrip(req)
+		done := t.tracker.acquire(backend)
+		res, err := t.backendTransport().RoundTrip(req)
 		if err == nil {
+			res.Body = &trackedBody{res.Body, done}
 			t.setStickyBackend(res, stickyBackend)
 			return res, nil
 		}
-		if _, ok := err.(dialErr); !ok {
+		done()
+		if _, ok := err.(dialErr); ok {
+			l.Error("retriable dial error", "backend", backend, "err", err, "attempt", i)
+			continue
+		}
+		if retried >= retries || !retriableError(ctx, err) {
 			l.Error("unretriable request error", "backend", backend, "err", err, "attempt", i)
 			return nil, err
 		}
-		l.Error("retriable dial error", "backend", backend, "err", err, "attempt", i)
+		retried++
+		l.Error("retrying request after error", "backend", backend, "err", err, "attempt", i, "retry", retried)
 	}
 	l.Error("request failed", "status", "503", "num_backends", len(backends))
 	return nil, errNoBackends
 }
 
 func (t *transport) Connect(ctx context.Context, l log15.Logger) (net.Conn, error) {
-	backends := t.getOrderedBackends("")
+	backends := t.getOrderedBackends("", "")
 	conn, _, err := dialTCP(ctx, l, backends)
 	if err != nil {
 		l.Error("connection failed", "num_backends", len(backends))
@@ -110,12 +169,20 @@ func (t *transport) Connect(ctx context.Context, l log15.Logger) (net.Conn, erro
 
 func (t *transport) UpgradeHTTP(req *http.Request, l log15.Logger) (*http.Response, net.Conn, error) {
 	stickyBackend := t.getStickyBackend(req)
-	backends := t.getOrderedBackends(stickyBackend)
+	backends := t.getOrderedBackends(req.RemoteAddr, stickyBackend)
 	upconn, addr, err := dialTCP(context.Background(), l, backends)
 	if err != nil {
 		l.Error("dial failed", "status", "503", "num_backends", len(backends))
 		return nil, nil, err
 	}
+	if t.tls != nil {
+		upconn, err = backendTLSConn(upconn, addr, t.tls)
+		if err != nil {
+			l.Error("TLS handshake failed", "status", "503", "backend", addr, "err", err)
+			return nil, nil, err
+		}
+	}
+	upconn = &trackedConn{upconn, t.tracker.acquire(addr)}
 	conn := &streamConn{bufio.NewReader(upconn), upconn}
 	req.URL.Host = addr
 
@@ -163,6 +230,43 @@ type dialErr struct {
 	error
 }
 
+// maxRetryBodySize is the largest request body which is buffered so that the
+// request can be retried, requests with larger bodies are not retried.
+const maxRetryBodySize = 1 << 20
+
+var errRetryBodyTooLarge = errors.New("router: request body too large to retry")
+
+// bufferRetryBody reads the body of req so it can be resent to another
+// backend, returning errRetryBodyTooLarge if the body is larger than
+// maxRetryBodySize or of unknown length.
+func bufferRetryBody(req *http.Request) ([]byte, error) {
+	switch {
+	case req.ContentLength == 0:
+		return nil, nil
+	case req.ContentLength < 0 || req.ContentLength > maxRetryBodySize:
+		return nil, errRetryBodyTooLarge
+	}
+	body := make([]byte, req.ContentLength)
+	if _, err := io.ReadFull(req.Body, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// retriableError returns whether a request which failed with err after being
+// sent to a backend may be retried on another one. Timeouts aren't retried
+// as the backend may still be processing the request, and nor are requests
+// which the client canceled.
+func retriableError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return false
+	}
+	return true
+}
+
 type fakeCloseReadCloser struct {
 	io.ReadCloser
 }
@@ -178,13 +282,6 @@ func (w *fakeCloseReadCloser) RealClose() error {
 	return w.ReadCloser.Close()
 }
 
-func shuffle(s []string) {
-	for i := len(s) - 1; i > 0; i-- {
-		j := random.Math.Intn(i + 1)
-		s[i], s[j] = s[j], s[i]
-	}
-}
-
 func swapToFront(ss []string, s string) {
 	for i := range ss {
 		if ss[i] == s {
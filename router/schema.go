@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/flynn/flynn/pkg/postgres"
+	"github.com/jackc/pgx"
 )
 
 var migrations *postgres.Migrations
@@ -210,8 +211,195 @@ CREATE TRIGGER notify_route_certificates_update
 	AFTER INSERT OR UPDATE OR DELETE ON route_certificates
 	FOR EACH ROW EXECUTE PROCEDURE notify_route_certificates_update()`,
 	)
+	migrations.Add(6,
+		// Recompute cert_sha256 using the same normalization as the data
+		// store (trimming leading and trailing spaces and newlines) for
+		// certificates inserted before it was applied consistently, merging
+		// any certificates which turn out to be duplicates into the oldest
+		// one and repointing their routes to it.
+		`CREATE TEMPORARY TABLE certificate_digests ON COMMIT DROP AS
+			SELECT id, cert_sha256, first_value(id) OVER (PARTITION BY cert_sha256 ORDER BY created_at, id) AS keep_id
+			FROM (
+				SELECT id, created_at, digest(regexp_replace(regexp_replace(cert, E'^[ \\n]+', '', ''), E'[ \\n]+$', '', ''), 'sha256') AS cert_sha256
+				FROM certificates WHERE deleted_at IS NULL
+			) AS c`,
+		`DO $$
+		DECLARE
+			dup RECORD;
+		BEGIN
+			FOR dup IN SELECT * FROM certificate_digests WHERE id <> keep_id LOOP
+				DELETE FROM route_certificates WHERE certificate_id = dup.id AND http_route_id IN (
+					SELECT http_route_id FROM route_certificates WHERE certificate_id = dup.keep_id
+				);
+				UPDATE route_certificates SET certificate_id = dup.keep_id WHERE certificate_id = dup.id;
+				UPDATE certificates SET deleted_at = now() WHERE id = dup.id;
+			END LOOP;
+		END $$`,
+		// Clear the digests being changed first so that swapping digests
+		// between rows doesn't violate the unique index.
+		`UPDATE certificates AS c SET cert_sha256 = digest(c.id::text, 'sha256')
+			FROM certificate_digests AS d
+			WHERE c.id = d.id AND d.id = d.keep_id AND c.cert_sha256 <> d.cert_sha256`,
+		`UPDATE certificates AS c SET
+				cert = regexp_replace(regexp_replace(c.cert, E'^[ \\n]+', '', ''), E'[ \\n]+$', '', ''),
+				key = regexp_replace(regexp_replace(c.key, E'^[ \\n]+', '', ''), E'[ \\n]+$', '', ''),
+				cert_sha256 = d.cert_sha256
+			FROM certificate_digests AS d
+			WHERE c.id = d.id AND d.id = d.keep_id`,
+	)
+	migrations.Add(7,
+		// Routes are soft deleted, so remove the associations of routes
+		// which have already been deleted and delete any certificates
+		// which were only used by those routes.
+		`CREATE TEMPORARY TABLE deleted_route_certificates ON COMMIT DROP AS
+			SELECT rc.http_route_id, rc.certificate_id FROM route_certificates AS rc
+			INNER JOIN http_routes AS r ON r.id = rc.http_route_id
+			WHERE r.deleted_at IS NOT NULL`,
+		`DELETE FROM route_certificates AS rc USING deleted_route_certificates AS d
+			WHERE rc.http_route_id = d.http_route_id AND rc.certificate_id = d.certificate_id`,
+		`UPDATE certificates AS c SET deleted_at = now()
+			WHERE c.id IN (SELECT certificate_id FROM deleted_route_certificates) AND c.deleted_at IS NULL
+			AND NOT EXISTS (SELECT 1 FROM route_certificates AS rc WHERE rc.certificate_id = c.id)`,
+	)
+	migrations.Add(8,
+		`ALTER TABLE http_routes ADD COLUMN access_log boolean NOT NULL DEFAULT FALSE`,
+	)
+	migrations.Add(9,
+		`ALTER TABLE http_routes ADD COLUMN idle_timeout_ms bigint NOT NULL DEFAULT 0`,
+	)
+	migrations.Add(10,
+		`ALTER TABLE http_routes ADD COLUMN max_request_body_size bigint NOT NULL DEFAULT 0`,
+		`ALTER TABLE http_routes ADD COLUMN max_response_body_size bigint NOT NULL DEFAULT 0`,
+	)
+	migrations.Add(11,
+		`ALTER TABLE http_routes ADD COLUMN health_check_path text NOT NULL DEFAULT ''`,
+		`ALTER TABLE http_routes ADD COLUMN health_check_interval_ms bigint NOT NULL DEFAULT 0`,
+		`ALTER TABLE http_routes ADD COLUMN health_check_unhealthy_threshold integer NOT NULL DEFAULT 0`,
+	)
+	migrations.Add(12,
+		`ALTER TABLE http_routes ADD COLUMN compress boolean NOT NULL DEFAULT false`,
+	)
+	migrations.Add(13,
+		// Allow storing only the certificate (and chain) for routes whose
+		// private key is managed outside of the router.
+		`ALTER TABLE certificates ALTER COLUMN key DROP NOT NULL`,
+		`UPDATE certificates SET key = NULL WHERE key = ''`,
+		`ALTER TABLE http_routes ADD COLUMN external_key boolean NOT NULL DEFAULT false`,
+	)
+	migrations.Add(14,
+		`ALTER TABLE http_routes ADD COLUMN aliases text[] NOT NULL DEFAULT '{}'`,
+		// Aliases are routed like domains, so must not be used by any
+		// other default route either as its domain or one of its aliases.
+		// The trigger is named so it runs after check_http_route_update
+		// has normalized the path.
+		`
+CREATE FUNCTION check_http_route_aliases() RETURNS TRIGGER AS $$
+BEGIN
+	IF NEW.deleted_at IS NOT NULL OR NEW.path <> '/' THEN
+		RETURN NEW;
+	END IF;
+	IF EXISTS (
+		SELECT 1 FROM http_routes
+		WHERE id <> NEW.id AND path = '/' AND deleted_at IS NULL
+		AND (domain = ANY(NEW.aliases) OR aliases && NEW.aliases OR NEW.domain = ANY(aliases))
+	) THEN
+		RAISE EXCEPTION 'domain or aliases of route for % conflict with another route', NEW.domain
+		USING ERRCODE = 'unique_violation';
+	END IF;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql`,
+		`
+CREATE TRIGGER check_http_route_update_aliases
+	BEFORE INSERT OR UPDATE ON http_routes
+	FOR EACH ROW
+	EXECUTE PROCEDURE check_http_route_aliases()`,
+	)
+	migrations.Add(15,
+		`ALTER TABLE http_routes ADD COLUMN retries integer NOT NULL DEFAULT 0`,
+		`ALTER TABLE http_routes ADD COLUMN retry_non_idempotent boolean NOT NULL DEFAULT false`,
+	)
+	migrations.Add(16,
+		`ALTER TABLE http_routes ADD COLUMN cors jsonb`,
+	)
+	migrations.Add(17,
+		// Consolidate the columns shared by HTTP and TCP routes into a
+		// routes table which both inherit from, so that all routes can be
+		// queried together and share the same constraints, with a type
+		// column to tell them apart. Protocol specific columns remain in
+		// the child tables, which is also where rows are stored (the
+		// routes table itself is always empty).
+		`
+CREATE TABLE routes (
+	id uuid NOT NULL,
+	type text NOT NULL,
+	parent_ref varchar(255) NOT NULL,
+	service varchar(255) NOT NULL,
+	leader boolean NOT NULL DEFAULT FALSE,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	updated_at timestamptz NOT NULL DEFAULT now(),
+	deleted_at timestamptz,
+	CONSTRAINT routes_type_check CHECK (type IN ('http', 'tcp')),
+	CONSTRAINT routes_service_check CHECK (service <> '')
+)`,
+		`ALTER TABLE http_routes ADD COLUMN type text NOT NULL DEFAULT 'http' CHECK (type = 'http')`,
+		`ALTER TABLE tcp_routes ADD COLUMN type text NOT NULL DEFAULT 'tcp' CHECK (type = 'tcp')`,
+		// A table can only inherit from a parent with check constraints if
+		// it already has constraints with the same names, which replace
+		// the equivalent constraints created along with each table.
+		`ALTER TABLE http_routes
+			DROP CONSTRAINT IF EXISTS http_routes_service_check,
+			ADD CONSTRAINT routes_service_check CHECK (service <> ''),
+			ADD CONSTRAINT routes_type_check CHECK (type IN ('http', 'tcp'))`,
+		`ALTER TABLE tcp_routes
+			DROP CONSTRAINT IF EXISTS tcp_routes_service_check,
+			ADD CONSTRAINT routes_service_check CHECK (service <> ''),
+			ADD CONSTRAINT routes_type_check CHECK (type IN ('http', 'tcp'))`,
+		`ALTER TABLE http_routes INHERIT routes`,
+		`ALTER TABLE tcp_routes INHERIT routes`,
+	)
+	migrations.Add(18,
+		`ALTER TABLE http_routes ADD COLUMN backend_max_idle_conns integer NOT NULL DEFAULT 0`,
+		`ALTER TABLE http_routes ADD COLUMN backend_idle_timeout_ms bigint NOT NULL DEFAULT 0`,
+	)
+	migrations.Add(19,
+		`ALTER TABLE http_routes ADD COLUMN request_timeout_ms bigint NOT NULL DEFAULT 0`,
+	)
+	migrations.Add(20,
+		`ALTER TABLE http_routes ADD COLUMN backend_tls jsonb`,
+	)
+	migrations.Add(21,
+		`ALTER TABLE http_routes ADD COLUMN backend_host text NOT NULL DEFAULT ''`,
+		`ALTER TABLE http_routes ADD COLUMN forwarded_host bool NOT NULL DEFAULT false`,
+	)
+	migrations.Add(22,
+		`ALTER TABLE http_routes ADD COLUMN load_balancing text NOT NULL DEFAULT ''`,
+	)
 }
 
 func migrateDB(db *postgres.DB) error {
 	return migrations.Migrate(db)
 }
+
+var preparedStatements = map[string]string{
+	"http_route_list":              sqlListHTTPRoutes,
+	"http_route_select":            sqlGetHTTPRoute,
+	"tcp_route_list":               sqlListTCPRoutes,
+	"tcp_route_select":             sqlGetTCPRoute,
+	"certificate_list":             sqlListCerts,
+	"certificate_select":           sqlGetCert,
+	"certificate_select_by_sha256": sqlSelectCert,
+	"certificate_route_list":       sqlListCertRoutes,
+}
+
+// prepareStatements prepares the frequently run route and certificate
+// queries on conn so they are only parsed and planned once per connection.
+// It must only be used once the schema has been migrated.
+func prepareStatements(conn *pgx.Conn) error {
+	for name, sql := range preparedStatements {
+		if _, err := conn.Prepare(name, sql); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -8,11 +8,13 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strconv"
 
 	"github.com/flynn/flynn/discoverd/client"
 	"github.com/flynn/flynn/pkg/keepalive"
 	"github.com/flynn/flynn/pkg/postgres"
 	"github.com/flynn/flynn/pkg/shutdown"
+	"github.com/flynn/flynn/router/proxy"
 	"github.com/flynn/flynn/router/types"
 	"gopkg.in/inconshreveable/log15.v2"
 )
@@ -71,6 +73,79 @@ var listenFunc = keepalive.ReusableListen
 func main() {
 	defer shutdown.Exit()
 
+	dbConf, err := postgres.ConfFromEnv()
+	if err != nil {
+		shutdown.Fatal(err)
+	}
+
+	httpPort := flag.String("http-port", "8080", "http listen port")
+	httpsPort := flag.String("https-port", "4433", "https listen port")
+	tcpIP := flag.String("tcp-ip", os.Getenv("LISTEN_IP"), "tcp router listen ip")
+	tcpRangeStart := flag.Int("tcp-range-start", 3000, "tcp port range start")
+	tcpRangeEnd := flag.Int("tcp-range-end", 3500, "tcp port range end")
+	certFile := flag.String("tls-cert", "", "TLS (SSL) cert file in pem format")
+	keyFile := flag.String("tls-key", "", "TLS (SSL) key file in pem format")
+	apiPort := flag.String("api-port", "", "api listen port")
+	schemaVersion := flag.Bool("schema-version", false, "print the applied schema migration version and exit")
+	migrateTo := flag.String("migrate-to", os.Getenv("MIGRATE_TO"), "apply schema migrations up to the given version and exit")
+	analyzeTLSMigration := flag.Bool("analyze-tls-migration", false, "print what the TLS object migration (5) would do without applying it and exit")
+	tlsMinVersion := flag.String("tls-min-version", os.Getenv("TLS_MIN_VERSION"), "minimum TLS version accepted by the https listener (1.0, 1.1 or 1.2, defaults to 1.2)")
+	tlsCiphers := flag.String("tls-ciphers", os.Getenv("TLS_CIPHERS"), "comma separated TLS cipher suites accepted by the https listener (defaults to ECDHE AES-GCM suites)")
+	defaultBackend := flag.String("default-backend", os.Getenv("DEFAULT_BACKEND"), "URL of a backend to proxy requests which don't match any route to")
+	notFoundPage := flag.String("not-found-page", os.Getenv("NOT_FOUND_PAGE"), "file to serve with a 404 status for requests which don't match any route")
+	drainTimeout := flag.Duration("drain-timeout", defaultDrainTimeout, "how long to wait for in-flight requests to a removed backend to finish")
+	backendMaxIdleConns := flag.Int("backend-max-idle-conns", proxy.DefaultKeepAlive.MaxIdleConnsPerBackend, "maximum idle connections kept open to each backend of routes which don't set their own (negative disables keep-alive)")
+	backendIdleTimeout := flag.Duration("backend-idle-timeout", proxy.DefaultKeepAlive.IdleConnTimeout, "how long idle connections to backends are kept open for routes which don't set their own")
+	dbMaxConns := flag.Int("db-max-conns", dbConf.MaxConnections, "maximum number of postgres connections (defaults to $FLYNN_POSTGRES_MAX_CONNS or 20)")
+	dbAcquireTimeout := flag.Duration("db-acquire-timeout", dbConf.AcquireTimeout, "how long to wait for a postgres connection when all are busy (defaults to $FLYNN_POSTGRES_ACQUIRE_TIMEOUT or 30s)")
+	dbMaxConnLifetime := flag.Duration("db-max-conn-lifetime", dbConf.MaxConnLifetime, "how long a postgres connection is used before it is replaced (defaults to $FLYNN_POSTGRES_MAX_CONN_LIFETIME or no limit)")
+	flag.Parse()
+
+	dbConf.MaxConnections = *dbMaxConns
+	dbConf.AcquireTimeout = *dbAcquireTimeout
+	dbConf.MaxConnLifetime = *dbMaxConnLifetime
+
+	proxy.SetDefaultKeepAlive(proxy.KeepAlive{
+		MaxIdleConnsPerBackend: *backendMaxIdleConns,
+		IdleConnTimeout:        *backendIdleTimeout,
+	})
+
+	if *schemaVersion {
+		db := postgres.Wait(dbConf, nil)
+		defer db.Close()
+		version, err := migrations.Version(db)
+		if err != nil {
+			shutdown.Fatal(err)
+		}
+		fmt.Println(version)
+		return
+	}
+
+	if *analyzeTLSMigration {
+		db := postgres.Wait(dbConf, nil)
+		defer db.Close()
+		analysis, err := analyzeTLSObjectMigration(db)
+		if err != nil {
+			shutdown.Fatal(err)
+		}
+		analysis.Print(os.Stdout)
+		return
+	}
+
+	if *migrateTo != "" {
+		id, err := strconv.Atoi(*migrateTo)
+		if err != nil {
+			shutdown.Fatalf("invalid migration version %q: %s", *migrateTo, err)
+		}
+		db := postgres.Wait(dbConf, nil)
+		defer db.Close()
+		if err := migrations.MigrateTo(db, id); err != nil {
+			shutdown.Fatal(err)
+		}
+		logger.Info("applied schema migrations", "version", id)
+		return
+	}
+
 	var cookieKey *[32]byte
 	if key := os.Getenv("COOKIE_KEY"); key != "" {
 		res, err := base64.StdEncoding.DecodeString(key)
@@ -88,16 +163,6 @@ func main() {
 		shutdown.Fatal("Missing random 32 byte base64-encoded COOKIE_KEY")
 	}
 
-	httpPort := flag.String("http-port", "8080", "http listen port")
-	httpsPort := flag.String("https-port", "4433", "https listen port")
-	tcpIP := flag.String("tcp-ip", os.Getenv("LISTEN_IP"), "tcp router listen ip")
-	tcpRangeStart := flag.Int("tcp-range-start", 3000, "tcp port range start")
-	tcpRangeEnd := flag.Int("tcp-range-end", 3500, "tcp port range end")
-	certFile := flag.String("tls-cert", "", "TLS (SSL) cert file in pem format")
-	keyFile := flag.String("tls-key", "", "TLS (SSL) key file in pem format")
-	apiPort := flag.String("api-port", "", "api listen port")
-	flag.Parse()
-
 	if *apiPort == "" {
 		*apiPort = os.Getenv("PORT")
 		if *apiPort == "" {
@@ -106,7 +171,6 @@ func main() {
 	}
 
 	keypair := tls.Certificate{}
-	var err error
 	if *certFile != "" {
 		if keypair, err = tls.LoadX509KeyPair(*certFile, *keyFile); err != nil {
 			shutdown.Fatal(err)
@@ -120,18 +184,37 @@ func main() {
 		}
 	}
 
+	tlsPolicy, err := parseTLSPolicy(*tlsMinVersion, *tlsCiphers)
+	if err != nil {
+		shutdown.Fatal(err)
+	}
+
+	defaultHandler, err := newDefaultHandler(*defaultBackend, *notFoundPage)
+	if err != nil {
+		shutdown.Fatal(err)
+	}
+
 	log := logger.New("fn", "main")
 
 	log.Info("connecting to postgres")
-	db := postgres.Wait(nil, nil)
+	db := postgres.Wait(dbConf, nil)
 
 	log.Info("running DB migrations")
 	if err := migrateDB(db); err != nil {
 		shutdown.Fatal(err)
 	}
 
+	// Reconnect, preparing statements now that schema is migrated
+	db.Close()
+	db = postgres.Wait(dbConf, prepareStatements)
+
 	shutdown.BeforeExit(func() { db.Close() })
 
+	// Listen for database migration, reset connpool on new migration
+	doneCh := make(chan struct{})
+	shutdown.BeforeExit(func() { close(doneCh) })
+	go postgres.ResetOnMigration(db, logger, doneCh)
+
 	httpAddr := net.JoinHostPort(os.Getenv("LISTEN_IP"), *httpPort)
 	httpsAddr := net.JoinHostPort(os.Getenv("LISTEN_IP"), *httpsPort)
 	r := Router{
@@ -143,12 +226,15 @@ func main() {
 			discoverd: discoverd.DefaultClient,
 		},
 		HTTP: &HTTPListener{
-			Addr:      httpAddr,
-			TLSAddr:   httpsAddr,
-			cookieKey: cookieKey,
-			keypair:   keypair,
-			ds:        NewPostgresDataStore("http", db.ConnPool),
-			discoverd: discoverd.DefaultClient,
+			Addr:           httpAddr,
+			TLSAddr:        httpsAddr,
+			DrainTimeout:   *drainTimeout,
+			TLSPolicy:      tlsPolicy,
+			DefaultHandler: defaultHandler,
+			cookieKey:      cookieKey,
+			keypair:        keypair,
+			ds:             NewPostgresDataStore("http", db.ConnPool),
+			discoverd:      discoverd.DefaultClient,
 		},
 	}
 
@@ -88,7 +88,16 @@ func (s *S) SetUpSuite(c *C) {
 	if err = migrateDB(db); err != nil {
 		c.Fatal(err)
 	}
-	s.pgx = db.ConnPool
+
+	// reconnect, preparing statements now that the schema is migrated
+	db.Close()
+	poolConfig := newPgxConnPoolConfig()
+	poolConfig.AfterConnect = prepareStatements
+	pgxpool, err = pgx.NewConnPool(poolConfig)
+	if err != nil {
+		c.Fatal(err)
+	}
+	s.pgx = pgxpool
 	s.pgx.Exec(sqlCreateTruncateTables)
 }
 
@@ -191,6 +200,10 @@ func discoverdSetLeader(c *C, dc discoverdClient, sc serviceCache, name, id stri
 }
 
 func discoverdRegister(c *C, dc discoverdClient, sc serviceCache, name, addr string) func() {
+	return discoverdUnregisterFunc(c, discoverdRegisterInstance(c, dc, sc, name, addr), sc)
+}
+
+func discoverdRegisterInstance(c *C, dc discoverdClient, sc serviceCache, name, addr string) discoverd.Heartbeater {
 	done := make(chan struct{})
 	go func() {
 		events, unwatch := sc.Watch(true)
@@ -209,7 +222,32 @@ func discoverdRegister(c *C, dc discoverdClient, sc serviceCache, name, addr str
 	case <-time.After(10 * time.Second):
 		c.Fatal("timed out waiting for discoverd registration")
 	}
-	return discoverdUnregisterFunc(c, hb, sc)
+	return hb
+}
+
+// discoverdDrain marks the instance registered by hb as draining and waits
+// for sc to see the change.
+func discoverdDrain(c *C, hb discoverd.Heartbeater, sc serviceCache) {
+	done := make(chan struct{})
+	started := make(chan struct{})
+	go func() {
+		events, unwatch := sc.Watch(false)
+		defer unwatch()
+		close(started)
+		for event := range events {
+			if event.Kind == discoverd.EventKindUpdate && event.Instance.Addr == hb.Addr() && event.Instance.Meta[discoverd.DrainingMetaKey] == "true" {
+				close(done)
+				return
+			}
+		}
+	}()
+	<-started
+	c.Assert(hb.SetMeta(map[string]string{discoverd.DrainingMetaKey: "true"}), IsNil)
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		c.Fatal("timed out waiting for discoverd update")
+	}
 }
 
 func discoverdUnregisterFunc(c *C, hb discoverd.Heartbeater, sc serviceCache) func() {
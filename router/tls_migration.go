@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/flynn/flynn/pkg/postgres"
+)
+
+// tlsObjectMigration is the ID of the migration which moves the TLS certs and
+// keys of HTTP routes into the certificates table.
+const tlsObjectMigration = 5
+
+// tlsMigrationAnalysis reports what the TLS object migration would do.
+type tlsMigrationAnalysis struct {
+	// Certificates is the number of distinct certificates which would be
+	// created.
+	Certificates int
+	// Routes is the number of routes which would be linked to a
+	// certificate.
+	Routes int
+	// InvalidCerts are the routes whose cert fails to parse, which are
+	// migrated as is but won't be usable for TLS connections.
+	InvalidCerts []invalidRouteCert
+}
+
+type invalidRouteCert struct {
+	RouteID string
+	Domain  string
+	Err     error
+}
+
+// analyzeTLSObjectMigration determines what the TLS object migration would do
+// to the routes in db without writing anything, so operators can check it
+// before applying it. It returns an error if the migration has already been
+// applied.
+func analyzeTLSObjectMigration(db *postgres.DB) (*tlsMigrationAnalysis, error) {
+	version, err := migrations.Version(db)
+	if err != nil {
+		return nil, err
+	}
+	if version >= tlsObjectMigration {
+		return nil, fmt.Errorf("migration %d has already been applied (schema version %d)", tlsObjectMigration, version)
+	}
+
+	rows, err := db.Query(`SELECT id, domain, tls_cert FROM http_routes WHERE tls_key IS NOT NULL ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	a := &tlsMigrationAnalysis{}
+	digests := make(map[[sha256.Size]byte]struct{})
+	for rows.Next() {
+		var id, domain string
+		var cert *string
+		if err := rows.Scan(&id, &domain, &cert); err != nil {
+			return nil, err
+		}
+		var data string
+		if cert != nil {
+			data = *cert
+		}
+		// the migration strips leading and trailing spaces and newlines
+		// (but not other whitespace) before computing the digest
+		data = strings.Trim(data, " \n")
+		digest := sha256.Sum256([]byte(data))
+		if _, ok := digests[digest]; !ok {
+			digests[digest] = struct{}{}
+			a.Certificates++
+		}
+		a.Routes++
+		if err := parseRouteCert(data); err != nil {
+			a.InvalidCerts = append(a.InvalidCerts, invalidRouteCert{RouteID: id, Domain: domain, Err: err})
+		}
+	}
+	return a, rows.Err()
+}
+
+func parseRouteCert(data string) error {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return errors.New("no PEM data found")
+	}
+	if block.Type != "CERTIFICATE" {
+		return fmt.Errorf("unexpected PEM block type %q", block.Type)
+	}
+	_, err := x509.ParseCertificate(block.Bytes)
+	return err
+}
+
+// Print writes a human readable report of the analysis to w.
+func (a *tlsMigrationAnalysis) Print(w io.Writer) {
+	fmt.Fprintf(w, "Migration %d would create %d certificates and link %d routes to them.\n", tlsObjectMigration, a.Certificates, a.Routes)
+	if len(a.InvalidCerts) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%d routes have certificates which fail to parse:\n", len(a.InvalidCerts))
+	for _, c := range a.InvalidCerts {
+		fmt.Fprintf(w, "  %s (%s): %s\n", c.RouteID, c.Domain, c.Err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// TLSPolicy restricts the TLS protocol versions and cipher suites which the
+// HTTPS listener negotiates with clients.
+type TLSPolicy struct {
+	MinVersion   uint16
+	CipherSuites []uint16
+}
+
+// defaultTLSPolicy only allows TLS 1.2 with forward secret AEAD cipher
+// suites.
+var defaultTLSPolicy = &TLSPolicy{
+	MinVersion: tls.VersionTLS12,
+	CipherSuites: []uint16{
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	},
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+}
+
+// tlsCipherSuites maps the names of the cipher suites which may be enabled
+// to their IDs, RC4 and 3DES suites are deliberately omitted.
+var tlsCipherSuites = map[string]uint16{
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+}
+
+// parseTLSPolicy returns a TLSPolicy with the given minimum version (e.g.
+// "1.2") and comma separated cipher suite names, either of which default to
+// those of defaultTLSPolicy if empty.
+func parseTLSPolicy(minVersion, cipherSuites string) (*TLSPolicy, error) {
+	policy := &TLSPolicy{
+		MinVersion:   defaultTLSPolicy.MinVersion,
+		CipherSuites: defaultTLSPolicy.CipherSuites,
+	}
+	if minVersion != "" {
+		v, ok := tlsVersions[minVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported TLS version %q, must be one of 1.0, 1.1 or 1.2", minVersion)
+		}
+		policy.MinVersion = v
+	}
+	if cipherSuites != "" {
+		names := strings.Split(cipherSuites, ",")
+		policy.CipherSuites = make([]uint16, 0, len(names))
+		for _, name := range names {
+			id, ok := tlsCipherSuites[strings.TrimSpace(name)]
+			if !ok {
+				return nil, fmt.Errorf("unsupported TLS cipher suite %q", name)
+			}
+			policy.CipherSuites = append(policy.CipherSuites, id)
+		}
+	}
+	return policy, nil
+}
+
+// apply restricts c to the versions and cipher suites allowed by the policy.
+func (p *TLSPolicy) apply(c *tls.Config) *tls.Config {
+	c.MinVersion = p.MinVersion
+	c.CipherSuites = p.CipherSuites
+	c.PreferServerCipherSuites = true
+	return c
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+
+	. "github.com/flynn/go-check"
+)
+
+func (s *S) TestParseTLSPolicy(c *C) {
+	policy, err := parseTLSPolicy("", "")
+	c.Assert(err, IsNil)
+	c.Assert(policy, DeepEquals, defaultTLSPolicy)
+
+	policy, err = parseTLSPolicy("1.1", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_RSA_WITH_AES_128_CBC_SHA")
+	c.Assert(err, IsNil)
+	c.Assert(policy.MinVersion, Equals, uint16(tls.VersionTLS11))
+	c.Assert(policy.CipherSuites, DeepEquals, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_128_CBC_SHA})
+
+	_, err = parseTLSPolicy("1.3", "")
+	c.Assert(err, ErrorMatches, `unsupported TLS version "1.3".*`)
+	_, err = parseTLSPolicy("", "TLS_RSA_WITH_RC4_128_SHA")
+	c.Assert(err, ErrorMatches, `unsupported TLS cipher suite "TLS_RSA_WITH_RC4_128_SHA"`)
+}
+
+func (s *S) TestTLSPolicyMinVersion(c *C) {
+	cert := tlsConfigForDomain("example.com")
+	pair, err := tls.X509KeyPair([]byte(cert.CACert), []byte(cert.PrivateKey))
+	c.Assert(err, IsNil)
+	policy, err := parseTLSPolicy("1.2", "")
+	c.Assert(err, IsNil)
+	l := &HTTPListener{
+		Addr:      "127.0.0.1:0",
+		TLSAddr:   "127.0.0.1:0",
+		TLSPolicy: policy,
+		keypair:   pair,
+		ds:        NewPostgresDataStore("http", s.pgx),
+		discoverd: s.discoverd,
+	}
+	c.Assert(l.Start(), IsNil)
+	defer l.Close()
+	addHTTPRoute(c, l)
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM([]byte(cert.CACert))
+	handshake := func(version uint16) error {
+		conn, err := tls.Dial("tcp", l.TLSAddr, &tls.Config{
+			ServerName: "example.com",
+			RootCAs:    pool,
+			MinVersion: version,
+			MaxVersion: version,
+		})
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	// TLS 1.0 and 1.1 handshakes are rejected
+	c.Assert(handshake(tls.VersionTLS10), ErrorMatches, ".*protocol version not supported")
+	c.Assert(handshake(tls.VersionTLS11), ErrorMatches, ".*protocol version not supported")
+	c.Assert(handshake(tls.VersionTLS12), IsNil)
+}
@@ -1,7 +1,12 @@
 package router
 
 import (
+	"crypto/x509"
 	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -21,6 +26,13 @@ type Certificate struct {
 	UpdatedAt time.Time `json:"updated_at,omitempty"`
 }
 
+// Load balancing algorithms for HTTP routes, see Route.LoadBalancing.
+const (
+	LoadBalancingRoundRobin = "round-robin"
+	LoadBalancingLeastConn  = "least-conn"
+	LoadBalancingIPHash     = "ip-hash"
+)
+
 // Route is a struct that combines the fields of HTTPRoute and TCPRoute
 // for easy JSON marshaling.
 type Route struct {
@@ -43,9 +55,19 @@ type Route struct {
 
 	// Domain is the domain name of this Route. It is only used for HTTP routes.
 	Domain string `json:"domain,omitempty"`
+	// Aliases are additional domain names which are routed to the same
+	// service, with the same certificate, as Domain (e.g. the other names
+	// in a certificate's subject alternative names). Path based routes on
+	// Domain also apply to its aliases. It is only used for default HTTP
+	// routes (those without a Path).
+	Aliases []string `json:"aliases,omitempty"`
 
 	// Certificate contains TLSCert and TLSKey
 	Certificate *Certificate `json:"certificate,omitempty"`
+	// CertSHA256 is the hex encoded SHA256 digest of the route's
+	// certificate, which can be used to check whether routes share a
+	// certificate or whether it has changed without comparing PEMs.
+	CertSHA256 string `json:"cert_sha256,omitempty"`
 
 	// Deprecated in favor of Certificate
 	LegacyTLSCert string `json:"tls_cert,omitempty"`
@@ -58,15 +80,365 @@ type Route struct {
 	// the TLS options and can only be set if a "default" route with the same domain
 	// and no Path already exists in the route table.
 	Path string `json:"path,omitempty"`
+	// AccessLog is whether the router logs each request to this route with
+	// the method, path, status, latency and backend. It is only used for
+	// HTTP routes.
+	AccessLog bool `json:"access_log,omitempty"`
+	// IdleTimeout is how long an upgraded (e.g. WebSocket) connection to
+	// this route may go without any data being sent in either direction
+	// before the router closes it. Zero means no limit. It is only used for
+	// HTTP routes.
+	IdleTimeout time.Duration `json:"idle_timeout,omitempty"`
+	// MaxRequestBodySize is the maximum size in bytes of request bodies
+	// sent to this route, larger requests are rejected with a 413 status.
+	// Zero means no limit. It is only used for HTTP routes.
+	MaxRequestBodySize int64 `json:"max_request_body_size,omitempty"`
+	// MaxResponseBodySize is the maximum size in bytes of response bodies
+	// returned from this route's backends, larger responses result in a 502
+	// status or are cut off if the size is not known up front. Zero means no
+	// limit. It is only used for HTTP routes.
+	MaxResponseBodySize int64 `json:"max_response_body_size,omitempty"`
+	// HealthCheck, if set, configures the router to check the health of
+	// this route's backends and to only send requests to healthy ones. It is
+	// only used for HTTP routes.
+	HealthCheck *HealthCheck `json:"health_check,omitempty"`
+	// Compress is whether the router gzip encodes responses from this
+	// route's backends for clients which accept it, if the backend didn't
+	// already encode them. It is only used for HTTP routes.
+	Compress bool `json:"compress,omitempty"`
+	// ExternalKey is whether the private key of this route's certificate is
+	// managed outside of the router (e.g. in an HSM), in which case only the
+	// certificate (and chain) is stored, without a key, and the router does
+	// not terminate TLS for the route with it. It is only used for HTTP
+	// routes.
+	ExternalKey bool `json:"external_key,omitempty"`
+	// Retries is the number of other backends a request is retried on if
+	// it fails with a connection error (e.g. the connection is reset) after
+	// being sent to a backend, rather than failing with a 503. Requests
+	// which fail to connect to a backend are always tried on the other
+	// backends. It is only used for HTTP routes.
+	Retries int `json:"retries,omitempty"`
+	// RetryNonIdempotent is whether requests with non-idempotent methods
+	// (e.g. POST), which may have been partly processed by the backend,
+	// are retried. It is only used for HTTP routes.
+	RetryNonIdempotent bool `json:"retry_non_idempotent,omitempty"`
+	// CORS, if set, configures the router to handle cross-origin requests
+	// to this route, including responding to preflight requests itself
+	// rather than passing them to the backends. It is only used for HTTP
+	// routes.
+	CORS *CORS `json:"cors,omitempty"`
+	// BackendMaxIdleConns is the maximum number of idle connections the
+	// router keeps open to each of this route's backends for reuse by
+	// later requests, a negative value disables keep-alive and zero uses
+	// the router's default. It is only used for HTTP routes.
+	BackendMaxIdleConns int `json:"backend_max_idle_conns,omitempty"`
+	// BackendIdleTimeout is how long an idle connection to one of this
+	// route's backends is kept open, zero using the router's default. It
+	// is only used for HTTP routes.
+	BackendIdleTimeout time.Duration `json:"backend_idle_timeout,omitempty"`
+	// RequestTimeout, if non-zero, is how long the router waits for a
+	// backend to start responding to a request before responding with a
+	// 504 status, separately from IdleTimeout. It is only used for HTTP
+	// routes.
+	RequestTimeout time.Duration `json:"request_timeout,omitempty"`
+	// BackendTLS, if set, makes the router connect to this route's
+	// backends over TLS rather than plaintext, so that requests are
+	// encrypted end-to-end. It is only used for HTTP routes.
+	BackendTLS *BackendTLS `json:"backend_tls,omitempty"`
+	// BackendHost, if set, replaces the Host header of requests sent to
+	// this route's backends, for backends which serve a single domain but
+	// are routed to from others. It is only used for HTTP routes.
+	BackendHost string `json:"backend_host,omitempty"`
+	// ForwardedHost is whether requests which have their Host header
+	// replaced by BackendHost are sent with the original host in the
+	// X-Forwarded-Host header (unless a previous proxy has set it).
+	ForwardedHost bool `json:"forwarded_host,omitempty"`
+	// LoadBalancing is the algorithm used to choose which of this route's
+	// backends each request is sent to, one of "round-robin" (the
+	// default), "least-conn" or "ip-hash". It is only used for HTTP
+	// routes.
+	LoadBalancing string `json:"load_balancing,omitempty"`
 
 	// Port is the TCP port to listen on for TCP Routes.
 	Port int32 `json:"port,omitempty"`
 }
 
+// HealthCheck configures periodic HTTP checks of a route's backends.
+type HealthCheck struct {
+	// Path is the path requested from each backend, a response with a
+	// status below 400 passes the check.
+	Path string `json:"path"`
+	// Interval is the time between checks of each backend, defaulting to
+	// 10s.
+	Interval time.Duration `json:"interval,omitempty"`
+	// UnhealthyThreshold is the number of consecutive failed checks after
+	// which a backend is sent no more requests, defaulting to 3. A single
+	// passing check makes the backend healthy again.
+	UnhealthyThreshold int `json:"unhealthy_threshold,omitempty"`
+}
+
+// CORS configures the Cross-Origin Resource Sharing headers the router adds
+// to responses from a route's backends.
+type CORS struct {
+	// AllowedOrigins are the origins (e.g. https://example.com) which may
+	// make cross-origin requests, or "*" to allow any origin.
+	AllowedOrigins []string `json:"allowed_origins"`
+	// AllowedMethods are the methods allowed in cross-origin requests,
+	// defaulting to GET, HEAD and POST.
+	AllowedMethods []string `json:"allowed_methods,omitempty"`
+	// AllowedHeaders are the request headers allowed in cross-origin
+	// requests in addition to the CORS safelisted headers.
+	AllowedHeaders []string `json:"allowed_headers,omitempty"`
+	// AllowCredentials is whether cross-origin requests may include
+	// credentials such as cookies. It can't be used with the "*" origin.
+	AllowCredentials bool `json:"allow_credentials,omitempty"`
+	// MaxAge is how long browsers may cache the result of a preflight
+	// request, zero leaves it to the browser.
+	MaxAge time.Duration `json:"max_age,omitempty"`
+}
+
+// BackendTLS configures the TLS connections the router makes to a route's
+// backends.
+type BackendTLS struct {
+	// Verify is whether the backends' certificates are verified, using
+	// CACert if set and the system roots otherwise. Connections to
+	// backends with untrusted certificates then fail rather than being
+	// made unverified.
+	Verify bool `json:"verify,omitempty"`
+	// CACert is the PEM encoded certificate of the CA, or CAs, which
+	// issued the backends' certificates. It is only used with Verify.
+	CACert string `json:"ca_cert,omitempty"`
+	// ServerName is sent to the backends using SNI, and is the name their
+	// certificates are verified against, defaulting to the backend's IP
+	// address.
+	ServerName string `json:"server_name,omitempty"`
+}
+
 func (r Route) FormattedID() string {
 	return r.Type + "/" + r.ID
 }
 
+// ValidationError is returned by Route.Validate for an invalid route, with
+// Field being the JSON name of the invalid field.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("router: invalid route %s: %s", e.Field, e.Message)
+}
+
+// Validate checks that the service name is a valid DNS name, that HTTP
+// routes have a valid domain and aliases (which may have a leading "*."
+// wildcard label) and that TLS certificates and keys are given together, or that only a
+// certificate is given if the route has an external key. It returns a
+// ValidationError for the first invalid field.
+func (r Route) Validate() error {
+	if r.Service == "" {
+		return ValidationError{Field: "service", Message: "must not be empty"}
+	}
+	if !validDNSName(r.Service) {
+		return ValidationError{Field: "service", Message: "must be a valid DNS name"}
+	}
+	switch r.Type {
+	case "http":
+		domain := r.Domain
+		if domain == "" {
+			return ValidationError{Field: "domain", Message: "must not be empty"}
+		}
+		if !validDomain(domain) {
+			return ValidationError{Field: "domain", Message: "must be a valid hostname or wildcard (e.g. *.example.com)"}
+		}
+		if err := r.validateAliases(); err != nil {
+			return err
+		}
+		if r.Retries < 0 {
+			return ValidationError{Field: "retries", Message: "must not be negative"}
+		}
+		if r.IdleTimeout < 0 {
+			return ValidationError{Field: "idle_timeout", Message: "must not be negative"}
+		}
+		if r.MaxRequestBodySize < 0 {
+			return ValidationError{Field: "max_request_body_size", Message: "must not be negative"}
+		}
+		if r.MaxResponseBodySize < 0 {
+			return ValidationError{Field: "max_response_body_size", Message: "must not be negative"}
+		}
+		if h := r.HealthCheck; h != nil {
+			if h.Interval < 0 {
+				return ValidationError{Field: "health_check", Message: "interval must not be negative"}
+			}
+			if h.UnhealthyThreshold < 0 {
+				return ValidationError{Field: "health_check", Message: "unhealthy threshold must not be negative"}
+			}
+		}
+		if r.BackendMaxIdleConns < 0 {
+			return ValidationError{Field: "backend_max_idle_conns", Message: "must not be negative"}
+		}
+		if err := r.validateCORS(); err != nil {
+			return err
+		}
+		if r.BackendIdleTimeout < 0 {
+			return ValidationError{Field: "backend_idle_timeout", Message: "must not be negative"}
+		}
+		if r.RequestTimeout < 0 {
+			return ValidationError{Field: "request_timeout", Message: "must not be negative"}
+		}
+		if err := r.validateBackendTLS(); err != nil {
+			return err
+		}
+		if err := r.validateBackendHost(); err != nil {
+			return err
+		}
+		switch r.LoadBalancing {
+		case "", LoadBalancingRoundRobin, LoadBalancingLeastConn, LoadBalancingIPHash:
+		default:
+			return ValidationError{Field: "load_balancing", Message: fmt.Sprintf("must be one of %q, %q or %q", LoadBalancingRoundRobin, LoadBalancingLeastConn, LoadBalancingIPHash)}
+		}
+		if c := r.Certificate; c != nil {
+			if err := r.validateKeyPair("certificate", c.Cert, c.Key); err != nil {
+				return err
+			}
+		}
+		if err := r.validateKeyPair("tls_cert", r.LegacyTLSCert, r.LegacyTLSKey); err != nil {
+			return err
+		}
+	case "tcp":
+		if r.Port < 0 || r.Port > 65535 {
+			return ValidationError{Field: "port", Message: "must be between 0 and 65535"}
+		}
+	default:
+		return ValidationError{Field: "type", Message: `must be "http" or "tcp"`}
+	}
+	return nil
+}
+
+// validateCORS checks that CORS settings have at least one origin, don't
+// allow credentials from any origin and have a non-negative max age.
+func (r Route) validateCORS() error {
+	c := r.CORS
+	if c == nil {
+		return nil
+	}
+	if len(c.AllowedOrigins) == 0 {
+		return ValidationError{Field: "cors", Message: "must have at least one allowed origin"}
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin == "" {
+			return ValidationError{Field: "cors", Message: "allowed origins must not be empty"}
+		}
+		if origin == "*" && c.AllowCredentials {
+			return ValidationError{Field: "cors", Message: `credentials can't be allowed for the "*" origin`}
+		}
+	}
+	if c.MaxAge < 0 {
+		return ValidationError{Field: "cors", Message: "max age must not be negative"}
+	}
+	return nil
+}
+
+// validateBackendTLS checks that a backend TLS CA certificate is only given
+// along with verification, and contains at least one PEM certificate.
+func (r Route) validateBackendTLS() error {
+	b := r.BackendTLS
+	if b == nil || b.CACert == "" {
+		return nil
+	}
+	if !b.Verify {
+		return ValidationError{Field: "backend_tls", Message: "ca_cert requires verify to be set"}
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM([]byte(b.CACert)) {
+		return ValidationError{Field: "backend_tls", Message: "ca_cert must contain a PEM encoded certificate"}
+	}
+	return nil
+}
+
+// validateBackendHost checks that a backend host is a hostname or IP address
+// with an optional port, and that forwarded_host is only set along with one.
+func (r Route) validateBackendHost() error {
+	if r.BackendHost == "" {
+		if r.ForwardedHost {
+			return ValidationError{Field: "forwarded_host", Message: "requires backend_host to be set"}
+		}
+		return nil
+	}
+	host := r.BackendHost
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return ValidationError{Field: "backend_host", Message: "must have a valid port"}
+		}
+		host = h
+	}
+	if net.ParseIP(host) == nil && !validDNSName(host) {
+		return ValidationError{Field: "backend_host", Message: "must be a valid hostname with an optional port"}
+	}
+	return nil
+}
+
+// validateAliases checks that aliases are only set on default routes, and
+// are valid, distinct domains other than the route's domain.
+func (r Route) validateAliases() error {
+	if len(r.Aliases) == 0 {
+		return nil
+	}
+	if r.Path != "" && r.Path != "/" {
+		return ValidationError{Field: "aliases", Message: "must only be set on routes without a path"}
+	}
+	seen := make(map[string]struct{}, len(r.Aliases)+1)
+	seen[strings.ToLower(r.Domain)] = struct{}{}
+	for _, alias := range r.Aliases {
+		if !validDomain(alias) {
+			return ValidationError{Field: "aliases", Message: fmt.Sprintf("%q must be a valid hostname or wildcard (e.g. *.example.com)", alias)}
+		}
+		if _, ok := seen[strings.ToLower(alias)]; ok {
+			return ValidationError{Field: "aliases", Message: fmt.Sprintf("%q must not duplicate the domain or another alias", alias)}
+		}
+		seen[strings.ToLower(alias)] = struct{}{}
+	}
+	return nil
+}
+
+// validDomain returns whether domain is a valid DNS name, optionally with a
+// leading "*." wildcard label.
+func validDomain(domain string) bool {
+	return validDNSName(strings.TrimPrefix(domain, "*."))
+}
+
+// validateKeyPair checks that a certificate and key are both given or both
+// omitted, unless the route has an external key in which case only a
+// certificate may be given.
+func (r Route) validateKeyPair(field, cert, key string) error {
+	switch {
+	case cert == "" && key != "":
+		return ValidationError{Field: field, Message: "must not have a key without a cert"}
+	case r.ExternalKey && key != "":
+		return ValidationError{Field: field, Message: "must not have a key when external_key is set"}
+	case !r.ExternalKey && cert != "" && key == "":
+		return ValidationError{Field: field, Message: "must have a key, unless external_key is set"}
+	}
+	return nil
+}
+
+// validDNSName returns whether name consists of dot separated labels of
+// letters, digits and hyphens, which do not start or end with a hyphen.
+func validDNSName(name string) bool {
+	if len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func (r Route) HTTPRoute() *HTTPRoute {
 	return &HTTPRoute{
 		ID:        r.ID,
@@ -77,11 +449,31 @@ func (r Route) HTTPRoute() *HTTPRoute {
 		UpdatedAt: r.UpdatedAt,
 
 		Domain:        r.Domain,
+		Aliases:       r.Aliases,
 		Certificate:   r.Certificate,
+		CertSHA256:    r.CertSHA256,
 		LegacyTLSCert: r.LegacyTLSCert,
 		LegacyTLSKey:  r.LegacyTLSKey,
 		Sticky:        r.Sticky,
 		Path:          r.Path,
+		AccessLog:     r.AccessLog,
+		IdleTimeout:   r.IdleTimeout,
+
+		MaxRequestBodySize:  r.MaxRequestBodySize,
+		MaxResponseBodySize: r.MaxResponseBodySize,
+		HealthCheck:         r.HealthCheck,
+		Compress:            r.Compress,
+		ExternalKey:         r.ExternalKey,
+		Retries:             r.Retries,
+		RetryNonIdempotent:  r.RetryNonIdempotent,
+		CORS:                r.CORS,
+		BackendMaxIdleConns: r.BackendMaxIdleConns,
+		BackendIdleTimeout:  r.BackendIdleTimeout,
+		RequestTimeout:      r.RequestTimeout,
+		BackendTLS:          r.BackendTLS,
+		BackendHost:         r.BackendHost,
+		ForwardedHost:       r.ForwardedHost,
+		LoadBalancing:       r.LoadBalancing,
 	}
 }
 
@@ -108,11 +500,31 @@ type HTTPRoute struct {
 	UpdatedAt time.Time
 
 	Domain        string
+	Aliases       []string
 	Certificate   *Certificate `json:"certificate,omitempty"`
+	CertSHA256    string       `json:"cert_sha256,omitempty"`
 	LegacyTLSCert string       `json:"tls_cert,omitempty"`
 	LegacyTLSKey  string       `json:"tls_key,omitempty"`
 	Sticky        bool
 	Path          string
+	AccessLog     bool
+	IdleTimeout   time.Duration
+
+	MaxRequestBodySize  int64
+	MaxResponseBodySize int64
+	HealthCheck         *HealthCheck
+	Compress            bool
+	ExternalKey         bool
+	Retries             int
+	RetryNonIdempotent  bool
+	CORS                *CORS
+	BackendMaxIdleConns int
+	BackendIdleTimeout  time.Duration
+	RequestTimeout      time.Duration
+	BackendTLS          *BackendTLS
+	BackendHost         string
+	ForwardedHost       bool
+	LoadBalancing       string
 }
 
 func (r HTTPRoute) FormattedID() string {
@@ -136,11 +548,31 @@ func (r HTTPRoute) ToRoute() *Route {
 
 		// http-specific fields
 		Domain:        r.Domain,
+		Aliases:       r.Aliases,
 		Certificate:   r.Certificate,
+		CertSHA256:    r.CertSHA256,
 		LegacyTLSCert: r.LegacyTLSCert,
 		LegacyTLSKey:  r.LegacyTLSKey,
 		Sticky:        r.Sticky,
 		Path:          r.Path,
+		AccessLog:     r.AccessLog,
+		IdleTimeout:   r.IdleTimeout,
+
+		MaxRequestBodySize:  r.MaxRequestBodySize,
+		MaxResponseBodySize: r.MaxResponseBodySize,
+		HealthCheck:         r.HealthCheck,
+		Compress:            r.Compress,
+		ExternalKey:         r.ExternalKey,
+		Retries:             r.Retries,
+		RetryNonIdempotent:  r.RetryNonIdempotent,
+		CORS:                r.CORS,
+		BackendMaxIdleConns: r.BackendMaxIdleConns,
+		BackendIdleTimeout:  r.BackendIdleTimeout,
+		RequestTimeout:      r.RequestTimeout,
+		BackendTLS:          r.BackendTLS,
+		BackendHost:         r.BackendHost,
+		ForwardedHost:       r.ForwardedHost,
+		LoadBalancing:       r.LoadBalancing,
 	}
 }
 
@@ -0,0 +1,259 @@
+package router
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouteValidate(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		route *Route
+		field string
+	}{
+		{
+			name:  "valid http",
+			route: HTTPRoute{Domain: "example.com", Service: "foo-web"}.ToRoute(),
+		},
+		{
+			name:  "valid wildcard",
+			route: HTTPRoute{Domain: "*.example.com", Service: "foo-web"}.ToRoute(),
+		},
+		{
+			name:  "valid mixed case and digits",
+			route: HTTPRoute{Domain: "Foo-1.example.com", Service: "foo.example.org"}.ToRoute(),
+		},
+		{
+			name:  "valid tcp",
+			route: TCPRoute{Service: "foo", Port: 5432}.ToRoute(),
+		},
+		{
+			name:  "valid cert",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", Certificate: &Certificate{Cert: "cert", Key: "key"}}.ToRoute(),
+		},
+		{
+			name:  "cert by ID",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", Certificate: &Certificate{ID: "1"}}.ToRoute(),
+		},
+		{
+			name:  "missing service",
+			route: HTTPRoute{Domain: "example.com"}.ToRoute(),
+			field: "service",
+		},
+		{
+			name:  "service with underscore",
+			route: TCPRoute{Service: "foo_web"}.ToRoute(),
+			field: "service",
+		},
+		{
+			name:  "missing domain",
+			route: HTTPRoute{Service: "foo"}.ToRoute(),
+			field: "domain",
+		},
+		{
+			name:  "empty label",
+			route: HTTPRoute{Domain: "foo..example.com", Service: "foo"}.ToRoute(),
+			field: "domain",
+		},
+		{
+			name:  "trailing dot",
+			route: HTTPRoute{Domain: "example.com.", Service: "foo"}.ToRoute(),
+			field: "domain",
+		},
+		{
+			name:  "leading hyphen",
+			route: HTTPRoute{Domain: "-foo.example.com", Service: "foo"}.ToRoute(),
+			field: "domain",
+		},
+		{
+			name:  "port",
+			route: HTTPRoute{Domain: "example.com:80", Service: "foo"}.ToRoute(),
+			field: "domain",
+		},
+		{
+			name:  "inner wildcard",
+			route: HTTPRoute{Domain: "foo.*.example.com", Service: "foo"}.ToRoute(),
+			field: "domain",
+		},
+		{
+			name:  "bare wildcard",
+			route: HTTPRoute{Domain: "*", Service: "foo"}.ToRoute(),
+			field: "domain",
+		},
+		{
+			name:  "long label",
+			route: HTTPRoute{Domain: strings.Repeat("a", 64) + ".com", Service: "foo"}.ToRoute(),
+			field: "domain",
+		},
+		{
+			name:  "valid aliases",
+			route: HTTPRoute{Domain: "example.com", Aliases: []string{"www.example.com", "*.example.org"}, Service: "foo"}.ToRoute(),
+		},
+		{
+			name:  "invalid alias",
+			route: HTTPRoute{Domain: "example.com", Aliases: []string{"foo..example.com"}, Service: "foo"}.ToRoute(),
+			field: "aliases",
+		},
+		{
+			name:  "alias duplicates domain",
+			route: HTTPRoute{Domain: "example.com", Aliases: []string{"EXAMPLE.com"}, Service: "foo"}.ToRoute(),
+			field: "aliases",
+		},
+		{
+			name:  "duplicate alias",
+			route: HTTPRoute{Domain: "example.com", Aliases: []string{"www.example.com", "www.example.com"}, Service: "foo"}.ToRoute(),
+			field: "aliases",
+		},
+		{
+			name:  "aliases on path route",
+			route: HTTPRoute{Domain: "example.com", Path: "/foo/", Aliases: []string{"www.example.com"}, Service: "foo"}.ToRoute(),
+			field: "aliases",
+		},
+		{
+			name:  "cert without key",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", Certificate: &Certificate{Cert: "cert"}}.ToRoute(),
+			field: "certificate",
+		},
+		{
+			name:  "external key cert without key",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", ExternalKey: true, Certificate: &Certificate{Cert: "cert"}}.ToRoute(),
+		},
+		{
+			name:  "external key legacy cert without key",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", ExternalKey: true, LegacyTLSCert: "cert"}.ToRoute(),
+		},
+		{
+			name:  "external key without cert",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", ExternalKey: true}.ToRoute(),
+		},
+		{
+			name:  "external key with key",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", ExternalKey: true, Certificate: &Certificate{Cert: "cert", Key: "key"}}.ToRoute(),
+			field: "certificate",
+		},
+		{
+			name:  "external key key without cert",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", ExternalKey: true, Certificate: &Certificate{Key: "key"}}.ToRoute(),
+			field: "certificate",
+		},
+		{
+			name:  "legacy key without cert",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", LegacyTLSKey: "key"}.ToRoute(),
+			field: "tls_cert",
+		},
+		{
+			name:  "negative retries",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", Retries: -1}.ToRoute(),
+			field: "retries",
+		},
+		{
+			name:  "cors without origins",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", CORS: &CORS{AllowedMethods: []string{"GET"}}}.ToRoute(),
+			field: "cors",
+		},
+		{
+			name:  "cors credentials for any origin",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", CORS: &CORS{AllowedOrigins: []string{"*"}, AllowCredentials: true}}.ToRoute(),
+			field: "cors",
+		},
+		{
+			name:  "cors negative max age",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", CORS: &CORS{AllowedOrigins: []string{"https://example.org"}, MaxAge: -time.Second}}.ToRoute(),
+			field: "cors",
+		},
+		{
+			name:  "negative backend idle timeout",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", BackendIdleTimeout: -time.Second}.ToRoute(),
+			field: "backend_idle_timeout",
+		},
+		{
+			name:  "negative request timeout",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", RequestTimeout: -time.Second}.ToRoute(),
+			field: "request_timeout",
+		},
+		{
+			name:  "backend tls without verification",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", BackendTLS: &BackendTLS{ServerName: "backend.example.com"}}.ToRoute(),
+		},
+		{
+			name:  "backend tls ca without verification",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", BackendTLS: &BackendTLS{CACert: "cert"}}.ToRoute(),
+			field: "backend_tls",
+		},
+		{
+			name:  "backend tls invalid ca",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", BackendTLS: &BackendTLS{Verify: true, CACert: "cert"}}.ToRoute(),
+			field: "backend_tls",
+		},
+		{
+			name:  "backend host with port",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", BackendHost: "backend.example.com:8080", ForwardedHost: true}.ToRoute(),
+		},
+		{
+			name:  "invalid backend host",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", BackendHost: "http://backend.example.com"}.ToRoute(),
+			field: "backend_host",
+		},
+		{
+			name:  "forwarded host without backend host",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", ForwardedHost: true}.ToRoute(),
+			field: "forwarded_host",
+		},
+		{
+			name:  "ip hash load balancing",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", LoadBalancing: LoadBalancingIPHash}.ToRoute(),
+		},
+		{
+			name:  "unknown load balancing",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", LoadBalancing: "random"}.ToRoute(),
+			field: "load_balancing",
+		},
+		{
+			name:  "negative idle timeout",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", IdleTimeout: -time.Second}.ToRoute(),
+			field: "idle_timeout",
+		},
+		{
+			name:  "negative max request body size",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", MaxRequestBodySize: -1}.ToRoute(),
+			field: "max_request_body_size",
+		},
+		{
+			name:  "negative max response body size",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", MaxResponseBodySize: -1}.ToRoute(),
+			field: "max_response_body_size",
+		},
+		{
+			name:  "negative health check interval",
+			route: HTTPRoute{Domain: "example.com", Service: "foo", HealthCheck: &HealthCheck{Path: "/", Interval: -time.Second}}.ToRoute(),
+			field: "health_check",
+		},
+		{
+			name:  "tcp port out of range",
+			route: TCPRoute{Service: "foo", Port: 70000}.ToRoute(),
+			field: "port",
+		},
+		{
+			name:  "unknown type",
+			route: &Route{Type: "udp", Service: "foo"},
+			field: "type",
+		},
+	} {
+		err := test.route.Validate()
+		if test.field == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, err)
+			}
+			continue
+		}
+		e, ok := err.(ValidationError)
+		if !ok {
+			t.Errorf("%s: expected ValidationError, got %v", test.name, err)
+			continue
+		}
+		if e.Field != test.field {
+			t.Errorf("%s: expected invalid field %q, got %q", test.name, test.field, e.Field)
+		}
+	}
+}
@@ -1251,7 +1251,8 @@ func (s *CLISuite) TestSlugReleaseGarbageCollection(t *c.C) {
 	newRelease := *lastRelease
 	newRelease.ID = ""
 	t.Assert(client.CreateRelease(&newRelease), c.IsNil)
-	t.Assert(client.DeployAppRelease(app.ID, newRelease.ID, timeoutCh), c.IsNil)
+	_, err = client.DeployAppRelease(app.ID, newRelease.ID, timeoutCh)
+	t.Assert(err, c.IsNil)
 
 	// wait for garbage collection
 	select {
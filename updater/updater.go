@@ -227,7 +227,7 @@ func deployApp(client controller.Client, app *ct.App, uri string, updateFn updat
 	}
 	timeoutCh := make(chan struct{})
 	time.AfterFunc(deployTimeout, func() { close(timeoutCh) })
-	if err := client.DeployAppRelease(app.ID, release.ID, timeoutCh); err != nil {
+	if _, err := client.DeployAppRelease(app.ID, release.ID, timeoutCh); err != nil {
 		log.Error("error deploying app", "err", err)
 		return err
 	}